@@ -1,31 +1,54 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"strconv"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/config"
+
+	"go.uber.org/zap/zapcore"
 )
 
 const (
 	endpointDefault       = "localhost:8080"
-	reportIntervalDefault = 2
-	poolIntervalDefault   = 1
+	reportIntervalDefault = 2 * time.Second
+	poolIntervalDefault   = 1 * time.Second
 	hashKeyDefault        = ""
 	rateLimitDefault      = 1
 	profilerDefault       = ""
 	cryptoKeyDefault      = ""
+	remoteConfigDefault   = 30 * time.Second
+	logLevelDefault       = "info"
 )
 
+// envFlags сопоставляет переменные окружения с флагами агента
+var envFlags = map[string]string{
+	"ADDRESS":                "a",
+	"REPORT_INTERVAL":        "r",
+	"POLL_INTERVAL":          "p",
+	"KEY":                    "k",
+	"RATE_LIMIT":             "l",
+	"CRYPTO_KEY":             "crypto-key",
+	"COLLECTORS":             "collectors",
+	"REMOTE_CONFIG_INTERVAL": "rc",
+	"LOG_LEVEL":              "log-level",
+}
+
 type AgentConfig struct {
-	Endpoint       string `json:"address"`
-	HashKey        string
-	Profiler       string
-	ReportInterval int `json:"report_interval"`
-	PoolInterval   int `json:"poll_interval"`
-	RateLimit      int
-	CryptoKey      string `json:"crypto_keys"`
+	Endpoint       string          `json:"address"`
+	HashKey        string          `json:"hash_key"`
+	Profiler       string          `json:"profiler"`
+	ReportInterval config.Duration `json:"report_interval"`
+	PoolInterval   config.Duration `json:"poll_interval"`
+	RateLimit      int             `json:"rate_limit"`
+	CryptoKey      string          `json:"crypto_key"`
+	Collectors     config.List     `json:"collectors"`
+	RemoteConfig   config.Duration `json:"remote_config_interval"`
+	LogLevel       string          `json:"log_level"`
+	PrintConfig    bool            `json:"-"`
 }
 
 func NewDefaultConfig() AgentConfig {
@@ -33,89 +56,96 @@ func NewDefaultConfig() AgentConfig {
 		Endpoint:       endpointDefault,
 		HashKey:        hashKeyDefault,
 		Profiler:       profilerDefault,
-		ReportInterval: reportIntervalDefault,
-		PoolInterval:   poolIntervalDefault,
+		ReportInterval: config.NewDuration(reportIntervalDefault),
+		PoolInterval:   config.NewDuration(poolIntervalDefault),
 		RateLimit:      rateLimitDefault,
 		CryptoKey:      cryptoKeyDefault,
+		Collectors:     agentconfig.Collectors(),
+		RemoteConfig:   config.NewDuration(remoteConfigDefault),
+		LogLevel:       logLevelDefault,
 	}
 	return c
 }
 
-func NewConfig() AgentConfig {
-	configPath := ""
-	flag.StringVar(&configPath, "c", getEnv("CONFIG", ""), "config path")
+// Settings возвращает настройки агента, которые можно менять без перезапуска
+func (c AgentConfig) Settings() agentconfig.Settings {
+	return agentconfig.Settings{
+		ReportInterval: c.ReportInterval,
+		PoolInterval:   c.PoolInterval,
+		RateLimit:      c.RateLimit,
+		Collectors:     c.Collectors,
+	}
+}
 
-	defaultConfig := NewDefaultConfig()
-	config, err := loadConfigFromFile(configPath)
+// NewConfig собирает и проверяет настройки агента
+func NewConfig() (AgentConfig, error) {
+	c, err := readConfig(flag.CommandLine, os.Args[1:])
 	if err != nil {
-		fmt.Println("config file read error", err)
-		config = &defaultConfig
+		return c, err
 	}
 
-	flag.StringVar(&config.Endpoint, "a", config.Endpoint, "server endpoint")
-	flag.IntVar(&config.ReportInterval, "r", config.ReportInterval, "report interval sec")
-	flag.IntVar(&config.PoolInterval, "p", config.PoolInterval, "metrics pool interval sec")
-	flag.StringVar(&config.HashKey, "k", config.HashKey, "hash key")
-	flag.IntVar(&config.RateLimit, "l", config.RateLimit, "rate limit")
-	flag.StringVar(&config.Profiler, "profile", config.Profiler, "profiler port")
-	flag.StringVar(&config.CryptoKey, "crypto-key", config.CryptoKey, "crypto key")
-
-	flag.Parse()
-
-	if endpointEnv := os.Getenv("ADDRESS"); endpointEnv != "" {
-		config.Endpoint = endpointEnv
+	if err = c.Validate(); err != nil {
+		return c, fmt.Errorf("invalid config:\n%w", err)
 	}
 
-	if reportIntervalEnv := os.Getenv("REPORT_INTERVAL"); reportIntervalEnv != "" {
-		interval, err := strconv.Atoi(reportIntervalEnv)
-		if err == nil {
-			config.ReportInterval = interval
-		}
-	}
+	return c, nil
+}
 
-	if poolIntervalEnv := os.Getenv("POLL_INTERVAL"); poolIntervalEnv != "" {
-		interval, err := strconv.Atoi(poolIntervalEnv)
-		if err == nil {
-			config.PoolInterval = interval
-		}
+// readConfig собирает настройки: значения по умолчанию, файл, переменные окружения, флаги.
+// Флаги регистрируются в fs, поэтому для повторного чтения нужен новый набор флагов
+func readConfig(fs *flag.FlagSet, args []string) (AgentConfig, error) {
+	c := NewDefaultConfig()
+
+	fs.StringVar(&c.Endpoint, "a", c.Endpoint, "server endpoint")
+	fs.Var(&c.ReportInterval, "r", "report interval, e.g. 10s")
+	fs.Var(&c.PoolInterval, "p", "metrics pool interval, e.g. 2s")
+	fs.StringVar(&c.HashKey, "k", c.HashKey, "hash key")
+	fs.IntVar(&c.RateLimit, "l", c.RateLimit, "rate limit")
+	fs.StringVar(&c.Profiler, "profile", c.Profiler, "profiler port")
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, "crypto key")
+	fs.Var(&c.Collectors, "collectors", "comma separated metric collectors")
+	fs.Var(&c.RemoteConfig, "rc", "remote config poll interval, 0 disables")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print effective config and exit")
+
+	err := config.Load(fs, args, &c, config.Options{
+		ConfigFlag: "c",
+		ConfigEnv:  "CONFIG",
+		Env:        envFlags,
+	})
+	return c, err
+}
+
+// Validate проверяет настройки агента
+func (c AgentConfig) Validate() error {
+	var errs []error
+	if c.Endpoint == "" {
+		errs = append(errs, errors.New("address: must not be empty"))
 	}
 
-	if hashKeyEnv := os.Getenv("KEY"); hashKeyEnv != "" {
-		config.HashKey = hashKeyEnv
+	if err := c.Settings().Validate(); err != nil {
+		errs = append(errs, err)
 	}
 
-	if rateLimitEnv := os.Getenv("RATE_LIMIT"); rateLimitEnv != "" {
-		rateLimit, err := strconv.Atoi(rateLimitEnv)
-		if err == nil {
-			config.RateLimit = rateLimit
+	if c.CryptoKey != "" {
+		if _, err := os.Stat(c.CryptoKey); err != nil {
+			errs = append(errs, fmt.Errorf("crypto_key: %w", err))
 		}
 	}
 
-	if cryptoKeyEnv := os.Getenv("CRYPTO_KEY"); cryptoKeyEnv != "" {
-		config.CryptoKey = cryptoKeyEnv
+	if c.RemoteConfig.Duration < 0 {
+		errs = append(errs, fmt.Errorf("remote_config_interval: must not be negative, got %s", c.RemoteConfig))
 	}
 
-	return *config
-}
-
-func loadConfigFromFile(filename string) (*AgentConfig, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
 	}
-	defer file.Close()
 
-	config := &AgentConfig{}
-	err = json.NewDecoder(file).Decode(config)
-	if err != nil {
-		return nil, err
-	}
-	return config, nil
+	return errors.Join(errs...)
 }
 
-func getEnv(key string, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
-	}
-	return fallback
+// Redacted возвращает копию настроек со скрытыми секретами для вывода
+func (c AgentConfig) Redacted() AgentConfig {
+	c.HashKey = config.Mask(c.HashKey)
+	return c
 }
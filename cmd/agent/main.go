@@ -10,6 +10,7 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	"ya-prac-project1/internal/config"
 	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/services"
 	"ya-prac-project1/internal/storage/inmemstorage"
@@ -39,7 +40,22 @@ func main() {
 		log.Fatalf("logger error: %s", err.Error())
 	}
 
-	c := NewConfig()
+	c, err := NewConfig()
+	if err != nil {
+		log.Fatalf("config error: %s", err.Error())
+	}
+
+	if c.PrintConfig {
+		if err = config.Print(os.Stdout, c.Redacted()); err != nil {
+			log.Fatalf("print config error: %s", err.Error())
+		}
+		return
+	}
+
+	if err = logger.SetLevel(c.LogLevel); err != nil {
+		log.Fatalf("logger error: %s", err.Error())
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	runGracefulShutdown(cancel)
 	RunProfiler(ctx, c.Profiler)
@@ -50,15 +66,24 @@ func main() {
 
 	storage := inmemstorage.NewStorage()
 	service := services.NewRuntimeService(storage)
+	service.SetCollectors(c.Settings().Collectors)
+	service.Run(gCtx, c.PoolInterval.Duration)
 
-	service.Run(gCtx, c.PoolInterval)
+	pool := newWorkerPool(gCtx, requestCh, requestDone)
+	pool.resize(c.RateLimit)
+	settings := newLiveSettings(c.Settings(), service, pool)
+	settings.setKeys(c.HashKey, c.CryptoKey)
 
-	for count := 0; count < c.RateLimit; count++ {
-		errGroup.Go(func() error {
-			worker(gCtx, requestCh, requestDone)
-			return nil
-		})
+	host, err := os.Hostname()
+	if err != nil {
+		logger.Get().Info("can't get hostname", zap.String("error", err.Error()))
 	}
+	remote := newRemoteConfig(c.Endpoint, host, c.Settings(), settings)
+	errGroup.Go(func() error {
+		remote.run(gCtx, c.RemoteConfig.Duration)
+		return nil
+	})
+	runReloadOnSignal(gCtx, newReloader(c, settings, remote).reload)
 
 	errGroup.Go(func() error {
 		// для первого запуска
@@ -69,13 +94,14 @@ func main() {
 				log.Printf("send request stopped")
 				return nil
 			case <-requestDone:
-				ticker := time.NewTicker(time.Duration(c.ReportInterval) * time.Second)
+				ticker := time.NewTicker(settings.get().ReportInterval.Duration)
 				select {
 				case <-gCtx.Done():
 					log.Printf("send request stopped")
 					return nil
 				case <-ticker.C:
-					service.RunSendRequest(requestCh, c.Endpoint, c.HashKey, c.CryptoKey)
+					hashKey, cryptoKey := settings.keys()
+					service.RunSendRequest(requestCh, c.Endpoint, hashKey, cryptoKey)
 				}
 			}
 		}
@@ -84,6 +110,7 @@ func main() {
 	if err := errGroup.Wait(); err != nil {
 		logger.Get().Info("agent error", zap.String("error", err.Error()))
 	}
+	pool.wait()
 	close(requestCh)
 	log.Printf("full stopped")
 }
@@ -110,6 +137,7 @@ func worker(ctx context.Context, requestCh chan *http.Request, requestDone chan
 				ticker := time.NewTicker(time.Duration(secDelta) * time.Second)
 				select {
 				case <-ticker.C:
+					rewindBody(req)
 					response, err = client.Do(req)
 					if response != nil {
 						response.Body.Close()
@@ -150,7 +178,28 @@ func needRetry(err error) bool {
 		return false
 	}
 
-	return strings.Contains(err.Error(), "connection refused")
+	// пачка помечена идентификатором, поэтому повтор после потерянного ответа не задвоит счетчики
+	for _, reason := range []string{"connection refused", "connection reset", "EOF"} {
+		if strings.Contains(err.Error(), reason) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewindBody восстанавливает тело запроса перед повторной отправкой
+func rewindBody(req *http.Request) {
+	if req.GetBody == nil {
+		return
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		log.Printf("can't rewind request body. Error: %s\n", err)
+		return
+	}
+	req.Body = body
 }
 
 func runGracefulShutdown(cancel context.CancelFunc) {
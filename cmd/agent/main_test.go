@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"syscall"
 	"testing"
 	"time"
+	"ya-prac-project1/internal/config"
 	"ya-prac-project1/internal/logger"
 
 	"github.com/stretchr/testify/assert"
@@ -36,10 +39,11 @@ func TestNewConfig(t *testing.T) {
 	os.Setenv("KEY", "test_key")
 	os.Setenv("RATE_LIMIT", "3")
 
-	c := NewConfig()
+	c, err := NewConfig()
+	assert.NoError(t, err)
 	assert.Equal(t, ":8081", c.Endpoint)
-	assert.Equal(t, 1, c.ReportInterval)
-	assert.Equal(t, 2, c.PoolInterval)
+	assert.Equal(t, time.Second, c.ReportInterval.Duration)
+	assert.Equal(t, 2*time.Second, c.PoolInterval.Duration)
 	assert.Equal(t, "test_key", c.HashKey)
 	assert.Equal(t, 3, c.RateLimit)
 }
@@ -82,12 +86,34 @@ func TestNeedRetry(t *testing.T) {
 
 	err = errors.New("connection refused")
 	assert.True(t, needRetry(err))
+
+	err = errors.New("read: connection reset by peer")
+	assert.True(t, needRetry(err))
+
+	err = errors.New("unexpected EOF")
+	assert.True(t, needRetry(err))
+}
+
+func TestRewindBody(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/updates/", bytes.NewBufferString("batch"))
+	if err != nil {
+		panic(err)
+	}
+
+	_, _ = io.ReadAll(req.Body)
+	rewindBody(req)
+
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, "batch", string(body))
 }
 
 func TestShowBuildInfo(t *testing.T) {
 	showBuildInfo()
 }
 
-func TestLoadConfigFromFile(t *testing.T) {
-	loadConfigFromFile("config.json")
+func TestLoadConfigFile(t *testing.T) {
+	c := NewDefaultConfig()
+	assert.NoError(t, config.LoadFile("config.json", &c))
+	assert.Equal(t, time.Second, c.ReportInterval.Duration)
+	assert.Equal(t, "/path/to/key.pem", c.CryptoKey)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// workerPool пул воркеров отправки запросов, размер которого можно менять во время работы
+type workerPool struct {
+	ctx         context.Context
+	requestCh   chan *http.Request
+	requestDone chan struct{}
+
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newWorkerPool(ctx context.Context, requestCh chan *http.Request, requestDone chan struct{}) *workerPool {
+	return &workerPool{
+		ctx:         ctx,
+		requestCh:   requestCh,
+		requestDone: requestDone,
+	}
+}
+
+// resize запускает или останавливает воркеры, чтобы их стало size
+func (p *workerPool) resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.cancels) < size {
+		ctx, cancel := context.WithCancel(p.ctx)
+		p.cancels = append(p.cancels, cancel)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			worker(ctx, p.requestCh, p.requestDone)
+		}()
+	}
+
+	for len(p.cancels) > size {
+		last := len(p.cancels) - 1
+		p.cancels[last]()
+		p.cancels = p.cancels[:last]
+	}
+}
+
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cancels)
+}
+
+// wait ожидает остановки всех воркеров
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
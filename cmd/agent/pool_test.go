@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolResize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool := newWorkerPool(ctx, make(chan *http.Request, 1), make(chan struct{}, 1))
+	pool.resize(3)
+	assert.Equal(t, 3, pool.size())
+
+	pool.resize(1)
+	assert.Equal(t, 1, pool.size())
+
+	cancel()
+	pool.wait()
+}
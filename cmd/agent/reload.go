@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"ya-prac-project1/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// reloader применяет к работающему агенту настройки, которые можно поменять без перезапуска
+type reloader struct {
+	current  AgentConfig
+	settings *liveSettings
+	remote   *remoteConfig
+}
+
+func newReloader(current AgentConfig, settings *liveSettings, remote *remoteConfig) *reloader {
+	return &reloader{
+		current:  current,
+		settings: settings,
+		remote:   remote,
+	}
+}
+
+// apply применяет новые настройки. Настройки, для которых нужен перезапуск, только логируются
+func (r *reloader) apply(ctx context.Context, next AgentConfig) error {
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	if err := logger.SetLevel(next.LogLevel); err != nil {
+		return err
+	}
+
+	r.settings.setKeys(next.HashKey, next.CryptoKey)
+	r.remote.setLocal(next.Settings())
+	if r.current.RemoteConfig.Duration > 0 {
+		r.remote.refresh(ctx)
+	} else if err := r.settings.apply(next.Settings()); err != nil {
+		return err
+	}
+
+	for _, name := range restartRequired(r.current, next) {
+		logger.Get().Info("setting changed, restart required", zap.String("setting", name))
+	}
+
+	next.Endpoint = r.current.Endpoint
+	next.Profiler = r.current.Profiler
+	next.RemoteConfig = r.current.RemoteConfig
+	r.current = next
+
+	logger.Get().Info("config reloaded")
+	return nil
+}
+
+// restartRequired возвращает названия измененных настроек, которые применяются только при запуске
+func restartRequired(current, next AgentConfig) []string {
+	names := []string{}
+	if current.Endpoint != next.Endpoint {
+		names = append(names, "address")
+	}
+	if current.Profiler != next.Profiler {
+		names = append(names, "profiler")
+	}
+	if current.RemoteConfig != next.RemoteConfig {
+		names = append(names, "remote_config_interval")
+	}
+	return names
+}
+
+// reload перечитывает настройки из файла, переменных окружения и флагов и применяет их
+func (r *reloader) reload(ctx context.Context) {
+	next, err := readConfig(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args[1:])
+	if err == nil {
+		err = r.apply(ctx, next)
+	}
+
+	if err != nil {
+		logger.Get().Info("config reload error", zap.String("error", err.Error()))
+	}
+}
+
+// runReloadOnSignal вызывает reload при получении SIGHUP
+func runReloadOnSignal(ctx context.Context, reload func(ctx context.Context)) {
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(s)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s:
+				logger.Get().Info("got SIGHUP, reload config")
+				reload(ctx)
+			}
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/config"
+	"ya-prac-project1/internal/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "")
+	t.Setenv("COLLECTORS", "")
+	require.NoError(t, os.Unsetenv("COLLECTORS"))
+
+	c, err := readConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-l", "4", "-collectors", "runtime"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, c.RateLimit)
+	assert.Equal(t, config.List{"runtime"}, c.Collectors)
+
+	_, err = readConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-unknown"})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	c := NewDefaultConfig()
+	assert.NoError(t, c.Validate())
+
+	wrong := c
+	wrong.Endpoint = ""
+	assert.Error(t, wrong.Validate())
+
+	wrong = c
+	wrong.ReportInterval = config.Seconds(0)
+	assert.Error(t, wrong.Validate())
+
+	wrong = c
+	wrong.CryptoKey = "not_exists.pem"
+	assert.Error(t, wrong.Validate())
+
+	wrong = c
+	wrong.LogLevel = "wrong"
+	assert.Error(t, wrong.Validate())
+}
+
+func TestReloaderApply(t *testing.T) {
+	_ = logger.Set()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	current := NewDefaultConfig()
+	current.RemoteConfig = config.Seconds(0)
+	pool := newWorkerPool(ctx, make(chan *http.Request, 1), make(chan struct{}, 1))
+	settings := newLiveSettings(current.Settings(), &fakeTuner{}, pool)
+	remote := newRemoteConfig(current.Endpoint, "", current.Settings(), settings)
+	r := newReloader(current, settings, remote)
+
+	next := current
+	next.Endpoint = "localhost:9090"
+	next.HashKey = "secret"
+	next.RateLimit = 3
+	require.NoError(t, r.apply(ctx, next))
+
+	hashKey, _ := settings.keys()
+	assert.Equal(t, "secret", hashKey)
+	assert.Equal(t, 3, settings.get().RateLimit)
+	assert.Equal(t, 3, pool.size())
+	assert.Equal(t, "localhost:8080", r.current.Endpoint)
+	assert.Equal(t, []string{"address"}, restartRequired(current, next))
+
+	wrong := next
+	wrong.PoolInterval = config.Seconds(0)
+	assert.Error(t, r.apply(ctx, wrong))
+	assert.Equal(t, time.Second, settings.get().PoolInterval.Duration)
+}
+
+func TestRunReloadOnSignal(t *testing.T) {
+	_ = logger.Set()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloaded := make(chan struct{}, 1)
+	runReloadOnSignal(ctx, func(context.Context) {
+		reloaded <- struct{}{}
+	})
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("reload was not called")
+	}
+}
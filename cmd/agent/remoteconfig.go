@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// remoteConfig опрашивает сервер и применяет полученные настройки агента.
+// Если сервер недоступен, применяются локальные настройки
+type remoteConfig struct {
+	endpoint string
+	host     string
+	settings *liveSettings
+	client   *http.Client
+
+	mu    sync.RWMutex
+	local agentconfig.Settings
+}
+
+func newRemoteConfig(endpoint, host string, local agentconfig.Settings, settings *liveSettings) *remoteConfig {
+	return &remoteConfig{
+		endpoint: endpoint,
+		host:     host,
+		local:    local,
+		settings: settings,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// setLocal меняет локальные настройки, поверх которых применяются настройки с сервера
+func (rc *remoteConfig) setLocal(local agentconfig.Settings) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.local = local
+}
+
+func (rc *remoteConfig) getLocal() agentconfig.Settings {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.local
+}
+
+// run опрашивает сервер с интервалом interval, пока не завершится контекст
+func (rc *remoteConfig) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rc.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			logger.Get().Info("remote config stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh получает настройки с сервера и применяет их
+func (rc *remoteConfig) refresh(ctx context.Context) {
+	local := rc.getLocal()
+	settings, err := rc.fetch(ctx, local)
+	if err != nil {
+		logger.Get().Info("can't get remote config, use local", zap.String("error", err.Error()))
+		settings = local
+	}
+
+	if err = rc.settings.apply(settings); err != nil {
+		logger.Get().Info("wrong remote config, use local", zap.String("error", err.Error()))
+		if err = rc.settings.apply(local); err != nil {
+			logger.Get().Info("wrong local config", zap.String("error", err.Error()))
+		}
+	}
+}
+
+func (rc *remoteConfig) fetch(ctx context.Context, local agentconfig.Settings) (agentconfig.Settings, error) {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     rc.endpoint,
+		Path:     "/agent-config",
+		RawQuery: url.Values{"host": []string{rc.host}}.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return agentconfig.Settings{}, err
+	}
+
+	response, err := rc.client.Do(req)
+	if err != nil {
+		return agentconfig.Settings{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return agentconfig.Settings{}, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	remote := agentconfig.Settings{}
+	if err = json.NewDecoder(response.Body).Decode(&remote); err != nil {
+		return agentconfig.Settings{}, err
+	}
+
+	return local.Override(remote), nil
+}
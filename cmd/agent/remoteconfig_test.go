@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/config"
+	"ya-prac-project1/internal/logger"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteConfigRefresh(t *testing.T) {
+	_ = logger.Set()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/agent-config", r.URL.Path)
+		assert.Equal(t, "host1", r.URL.Query().Get("host"))
+		w.Write([]byte(`{"report_interval": "10s", "collectors": ["runtime"]}`))
+	}))
+	defer s.Close()
+
+	local := agentconfig.Settings{ReportInterval: config.Seconds(2), PoolInterval: config.Seconds(1), RateLimit: 1, Collectors: agentconfig.Collectors()}
+	pool := newWorkerPool(ctx, make(chan *http.Request, 1), make(chan struct{}, 1))
+	settings := newLiveSettings(local, &fakeTuner{}, pool)
+
+	rc := newRemoteConfig(strings.TrimPrefix(s.URL, "http://"), "host1", local, settings)
+	rc.refresh(ctx)
+
+	expect := agentconfig.Settings{ReportInterval: config.Seconds(10), PoolInterval: config.Seconds(1), RateLimit: 1, Collectors: []string{"runtime"}}
+	assert.Equal(t, expect, settings.get())
+
+	s.Close()
+	rc.refresh(ctx)
+	assert.Equal(t, local, settings.get())
+}
+
+func TestRemoteConfigRefresh_invalid(t *testing.T) {
+	_ = logger.Set()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"rate_limit": -1}`))
+	}))
+	defer s.Close()
+
+	local := agentconfig.Settings{ReportInterval: config.Seconds(2), PoolInterval: config.Seconds(1), RateLimit: 1, Collectors: agentconfig.Collectors()}
+	pool := newWorkerPool(ctx, make(chan *http.Request, 1), make(chan struct{}, 1))
+	settings := newLiveSettings(local, &fakeTuner{}, pool)
+
+	newRemoteConfig(strings.TrimPrefix(s.URL, "http://"), "host1", local, settings).refresh(ctx)
+	assert.Equal(t, local, settings.get())
+}
+
+func TestRemoteConfigRun_disabled(t *testing.T) {
+	rc := newRemoteConfig("", "", agentconfig.Settings{}, nil)
+	rc.run(context.Background(), 0)
+}
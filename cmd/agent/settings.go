@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// runtimeTuner принимает настройки сбора метрик во время работы
+type runtimeTuner interface {
+	SetPoolInterval(poolInterval time.Duration)
+	SetCollectors(collectors []string)
+}
+
+// liveSettings хранит текущие настройки агента и применяет новые без перезапуска
+type liveSettings struct {
+	mu        sync.RWMutex
+	current   agentconfig.Settings
+	hashKey   string
+	cryptoKey string
+	service   runtimeTuner
+	pool      *workerPool
+}
+
+func newLiveSettings(initial agentconfig.Settings, service runtimeTuner, pool *workerPool) *liveSettings {
+	return &liveSettings{
+		current: initial,
+		service: service,
+		pool:    pool,
+	}
+}
+
+// get возвращает текущие настройки
+func (l *liveSettings) get() agentconfig.Settings {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current
+}
+
+// setKeys меняет ключ подписи и путь к публичному ключу шифрования
+func (l *liveSettings) setKeys(hashKey, cryptoKey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hashKey = hashKey
+	l.cryptoKey = cryptoKey
+}
+
+// keys возвращает ключ подписи и путь к публичному ключу шифрования
+func (l *liveSettings) keys() (string, string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.hashKey, l.cryptoKey
+}
+
+// apply применяет новые настройки. Невалидные настройки отбрасываются
+func (l *liveSettings) apply(settings agentconfig.Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current.Equal(settings) {
+		return nil
+	}
+
+	l.service.SetPoolInterval(settings.PoolInterval.Duration)
+	l.service.SetCollectors(settings.Collectors)
+	l.pool.resize(settings.RateLimit)
+	l.current = settings
+
+	logger.Get().Info(
+		"agent settings applied",
+		zap.Duration("report_interval", settings.ReportInterval.Duration),
+		zap.Duration("poll_interval", settings.PoolInterval.Duration),
+		zap.Int("rate_limit", settings.RateLimit),
+		zap.Strings("collectors", settings.Collectors),
+	)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/config"
+	"ya-prac-project1/internal/logger"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTuner struct {
+	poolInterval time.Duration
+	collectors   []string
+}
+
+func (f *fakeTuner) SetPoolInterval(poolInterval time.Duration) {
+	f.poolInterval = poolInterval
+}
+
+func (f *fakeTuner) SetCollectors(collectors []string) {
+	f.collectors = collectors
+}
+
+func TestLiveSettingsApply(t *testing.T) {
+	_ = logger.Set()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tuner := &fakeTuner{}
+	pool := newWorkerPool(ctx, make(chan *http.Request, 1), make(chan struct{}, 1))
+	initial := agentconfig.Settings{ReportInterval: config.Seconds(2), PoolInterval: config.Seconds(1), RateLimit: 1}
+	settings := newLiveSettings(initial, tuner, pool)
+
+	next := agentconfig.Settings{ReportInterval: config.Seconds(5), PoolInterval: config.Seconds(3), RateLimit: 2, Collectors: []string{agentconfig.CollectorRuntime}}
+	assert.NoError(t, settings.apply(next))
+	assert.Equal(t, next, settings.get())
+	assert.Equal(t, 3*time.Second, tuner.poolInterval)
+	assert.Equal(t, []string{agentconfig.CollectorRuntime}, tuner.collectors)
+	assert.Equal(t, 2, pool.size())
+
+	assert.Error(t, settings.apply(agentconfig.Settings{ReportInterval: config.Seconds(0), PoolInterval: config.Seconds(1), RateLimit: 1}))
+	assert.Equal(t, next, settings.get())
+}
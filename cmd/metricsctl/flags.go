@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+	"ya-prac-project1/internal/config"
+)
+
+const (
+	endpointDefault  = "localhost:8080"
+	hashKeyDefault   = ""
+	cryptoKeyDefault = ""
+	outputDefault    = outputTable
+	timeoutDefault   = 10 * time.Second
+)
+
+// envFlags сопоставляет переменные окружения с флагами клиента
+var envFlags = map[string]string{
+	"ADDRESS":    "a",
+	"KEY":        "k",
+	"CRYPTO_KEY": "crypto-key",
+}
+
+// CtlConfig представляет настройки клиента
+type CtlConfig struct {
+	Endpoint  string          `json:"address"`
+	HashKey   string          `json:"hash_key"`
+	CryptoKey string          `json:"crypto_key"`
+	Output    string          `json:"output"`
+	Timeout   config.Duration `json:"timeout"`
+}
+
+func NewDefaultConfig() CtlConfig {
+	return CtlConfig{
+		Endpoint:  endpointDefault,
+		HashKey:   hashKeyDefault,
+		CryptoKey: cryptoKeyDefault,
+		Output:    outputDefault,
+		Timeout:   config.NewDuration(timeoutDefault),
+	}
+}
+
+// readConfig собирает настройки клиента и возвращает их вместе с командой и ее аргументами
+func readConfig(fs *flag.FlagSet, args []string) (CtlConfig, []string, error) {
+	c := NewDefaultConfig()
+
+	fs.StringVar(&c.Endpoint, "a", c.Endpoint, "server endpoint")
+	fs.StringVar(&c.HashKey, "k", c.HashKey, "hash key")
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, "public crypto key")
+	fs.StringVar(&c.Output, "o", c.Output, "output format: table, json or csv")
+	fs.Var(&c.Timeout, "timeout", "request timeout, e.g. 10s")
+
+	err := config.Load(fs, args, &c, config.Options{
+		ConfigFlag: "c",
+		ConfigEnv:  "CONFIG",
+		Env:        envFlags,
+	})
+	if err != nil {
+		return c, nil, err
+	}
+
+	if err = c.Validate(); err != nil {
+		return c, nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	return c, fs.Args(), nil
+}
+
+// Validate проверяет настройки клиента
+func (c CtlConfig) Validate() error {
+	var errs []error
+	if c.Endpoint == "" {
+		errs = append(errs, errors.New("address: must not be empty"))
+	}
+
+	if !slices.Contains(outputFormats(), c.Output) {
+		errs = append(errs, fmt.Errorf("output: unknown format %q, known are %v", c.Output, outputFormats()))
+	}
+
+	if c.Timeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("timeout: must be positive, got %s", c.Timeout))
+	}
+
+	if c.CryptoKey != "" {
+		if _, err := os.Stat(c.CryptoKey); err != nil {
+			errs = append(errs, fmt.Errorf("crypto_key: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
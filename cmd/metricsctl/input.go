@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"ya-prac-project1/internal/metrics"
+)
+
+// readBatch читает пачку метрик: json массив в формате api сервера
+// или строки вида "тип имя значение". Пустые строки и строки с # пропускаются
+func readBatch(r io.Reader) ([]metrics.Metrics, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read batch: %w", err)
+	}
+
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		return readJSONBatch(data)
+	}
+
+	items := []metrics.Metrics{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want \"type name value\", got %q", line, text)
+		}
+
+		metric, err := newMetric(fields[0], fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, metric)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read batch: %w", err)
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("batch is empty")
+	}
+
+	return items, nil
+}
+
+func readJSONBatch(data []byte) ([]metrics.Metrics, error) {
+	items := []metrics.Metrics{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&items); err != nil {
+		return nil, fmt.Errorf("parse batch: %w", err)
+	}
+
+	for i, m := range items {
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("metric %d %q: %w", i, m.ID, err)
+		}
+		if m.ID == "" {
+			return nil, fmt.Errorf("metric %d: empty name", i)
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("batch is empty")
+	}
+
+	return items, nil
+}
+
+// newMetric создает метрику из строковых типа, имени и значения
+func newMetric(mType, name, value string) (metrics.Metrics, error) {
+	metric := metrics.Metrics{MType: mType, ID: name}
+	if err := metric.SetValue(value); err != nil {
+		return metric, fmt.Errorf("wrong %s value %q", mType, value)
+	}
+
+	return metric, metric.Validate()
+}
@@ -0,0 +1,219 @@
+// metricsctl — консольный клиент сервера метрик
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"ya-prac-project1/internal/client"
+	"ya-prac-project1/internal/metrics"
+)
+
+const usage = `usage: metricsctl [flags] <command> [args]
+
+commands:
+  get TYPE NAME                               print metric
+  list [-type TYPE]                           print all metrics
+  push TYPE NAME VALUE                        send metric
+  push -f FILE                                send batch from json or "type name value" lines, - reads stdin
+  delete TYPE NAME                            delete metric
+  watch [-interval 2s] [-count N] TYPE NAME   print metric value periodically
+
+flags:
+`
+
+func main() {
+	log.SetFlags(0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout)
+	stop()
+
+	if err != nil {
+		log.Fatalf("metricsctl: %s", err)
+	}
+}
+
+// run выполняет команду клиента
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("metricsctl", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	c, rest, err := readConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		fs.Usage()
+		return errors.New("command is required")
+	}
+
+	cmd := &command{
+		client: client.New(c.Endpoint, c.HashKey, c.CryptoKey, c.Timeout.Duration),
+		out:    newPrinter(c.Output, stdout),
+		stdin:  stdin,
+	}
+
+	name, cmdArgs := rest[0], rest[1:]
+	switch name {
+	case "get":
+		return cmd.get(ctx, cmdArgs)
+	case "list":
+		return cmd.list(ctx, cmdArgs)
+	case "push":
+		return cmd.push(ctx, cmdArgs)
+	case "delete":
+		return cmd.delete(ctx, cmdArgs)
+	case "watch":
+		return cmd.watch(ctx, cmdArgs)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// command содержит общее для всех команд окружение
+type command struct {
+	client *client.Client
+	out    *printer
+	stdin  io.Reader
+}
+
+func (c *command) get(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: get TYPE NAME")
+	}
+
+	metric, err := c.client.Get(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	return c.out.metric(metric)
+}
+
+func (c *command) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	mType := fs.String("type", "", "show only metrics of type")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	items, err := c.client.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *mType != "" {
+		filtered := items[:0]
+		for _, m := range items {
+			if m.MType == *mType {
+				filtered = append(filtered, m)
+			}
+		}
+		items = filtered
+	}
+
+	return c.out.metrics(items)
+}
+
+func (c *command) push(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	file := fs.String("f", "", "batch file, - reads stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		if fs.NArg() != 3 {
+			return errors.New("usage: push TYPE NAME VALUE or push -f FILE")
+		}
+
+		metric, err := newMetric(fs.Arg(0), fs.Arg(1), fs.Arg(2))
+		if err != nil {
+			return err
+		}
+
+		return c.client.Push(ctx, []metrics.Metrics{metric})
+	}
+
+	r := c.stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	items, err := readBatch(r)
+	if err != nil {
+		return err
+	}
+
+	return c.client.Push(ctx, items)
+}
+
+func (c *command) delete(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: delete TYPE NAME")
+	}
+
+	return c.client.Delete(ctx, args[0], args[1])
+}
+
+func (c *command) watch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", 2*time.Second, "poll interval")
+	count := fs.Int("count", 0, "number of polls, 0 watches until interrupted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return errors.New("usage: watch [-interval 2s] [-count N] TYPE NAME")
+	}
+
+	if *interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", *interval)
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+
+		metric, err := c.client.Get(ctx, fs.Arg(0), fs.Arg(1))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err = c.out.watch(time.Now(), metric); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/handlers"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) string {
+	logger.Set()
+	h := handlers.New(services.NewMetricSaverService(inmemstorage.NewStorage()), nil, "secret", "")
+	h.Mount()
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func runCtl(t *testing.T, endpoint string, stdin string, args ...string) (string, error) {
+	out := bytes.NewBuffer(nil)
+	args = append([]string{"-a", endpoint, "-k", "secret"}, args...)
+	err := run(context.Background(), args, strings.NewReader(stdin), out)
+	return out.String(), err
+}
+
+func TestRun(t *testing.T) {
+	endpoint := newTestServer(t)
+
+	_, err := runCtl(t, endpoint, "", "push", "gauge", "Alloc", "1.5")
+	require.NoError(t, err)
+
+	batch := "# пачка метрик\ncounter PollCount 2\n\ncounter PollCount 3\ngauge Sys 10\n"
+	_, err = runCtl(t, endpoint, batch, "push", "-f", "-")
+	require.NoError(t, err)
+
+	out, err := runCtl(t, endpoint, "", "get", "counter", "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, "TYPE     NAME       VALUE\ncounter  PollCount  5\n", out)
+
+	out, err = runCtl(t, endpoint, "", "-o", "csv", "list")
+	require.NoError(t, err)
+	assert.Equal(t, "type,name,value\ncounter,PollCount,5\ngauge,Alloc,1.5\ngauge,Sys,10\n", out)
+
+	out, err = runCtl(t, endpoint, "", "-o", "json", "list", "-type", "gauge")
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"Sys","type":"gauge","value":10}]`, out)
+
+	_, err = runCtl(t, endpoint, "", "delete", "gauge", "Alloc")
+	require.NoError(t, err)
+
+	_, err = runCtl(t, endpoint, "", "get", "gauge", "Alloc")
+	assert.ErrorContains(t, err, "not found")
+
+	out, err = runCtl(t, endpoint, "", "-o", "csv", "watch", "-interval", "10ms", "-count", "2", "gauge", "Sys")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "time,type,name,value", lines[0])
+	assert.True(t, strings.HasSuffix(lines[2], ",gauge,Sys,10"))
+}
+
+func TestRun_pushFile(t *testing.T) {
+	endpoint := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id":"Alloc","type":"gauge","value":2}]`), 0666))
+
+	_, err := runCtl(t, endpoint, "", "push", "-f", path)
+	require.NoError(t, err)
+
+	out, err := runCtl(t, endpoint, "", "-o", "json", "get", "gauge", "Alloc")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"Alloc","type":"gauge","value":2}`, out)
+}
+
+func TestRun_wrong(t *testing.T) {
+	endpoint := newTestServer(t)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command", args: []string{}},
+		{name: "unknown command", args: []string{"unknown"}},
+		{name: "wrong output", args: []string{"-o", "xml", "list"}},
+		{name: "get args", args: []string{"get", "gauge"}},
+		{name: "push type", args: []string{"push", "histogram", "Alloc", "1"}},
+		{name: "push value", args: []string{"push", "counter", "PollCount", "1.5"}},
+		{name: "watch interval", args: []string{"watch", "-interval", "0s", "gauge", "Alloc"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := runCtl(t, endpoint, "", test.args...)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	items, err := readBatch(strings.NewReader("gauge Alloc 1\n  counter PollCount 2  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1"),
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "2"),
+	}, items)
+
+	_, err = readBatch(strings.NewReader("gauge Alloc\n"))
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = readBatch(strings.NewReader("gauge Alloc 1\ncounter PollCount x\n"))
+	assert.ErrorContains(t, err, "line 2")
+
+	_, err = readBatch(strings.NewReader(`[{"id":"Alloc","type":"histogram"}]`))
+	assert.Error(t, err)
+
+	_, err = readBatch(strings.NewReader(`[{"id":"Alloc","type":"gauge","unknown":1}]`))
+	assert.Error(t, err)
+
+	_, err = readBatch(strings.NewReader("# пусто\n"))
+	assert.Error(t, err)
+}
+
+func TestPrinterWatch(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	p := newPrinter(outputJSON, out)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, p.watch(ts, metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")))
+	assert.JSONEq(t, `{"time":"2024-01-02T03:04:05Z","id":"Alloc","type":"gauge","value":1}`, out.String())
+
+	out.Reset()
+	p = newPrinter(outputTable, out)
+	require.NoError(t, p.watch(ts, metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")))
+	assert.Equal(t, "2024-01-02T03:04:05Z  gauge  Alloc  1\n", out.String())
+}
+
+func TestReadConfig(t *testing.T) {
+	t.Setenv("ADDRESS", "example.com:8080")
+	c, rest, err := readConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-o", "csv", "list", "-type", "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:8080", c.Endpoint)
+	assert.Equal(t, outputCSV, c.Output)
+	assert.Equal(t, []string{"list", "-type", "gauge"}, rest)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"ya-prac-project1/internal/metrics"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+func outputFormats() []string {
+	return []string{outputTable, outputJSON, outputCSV}
+}
+
+// printer выводит метрики в выбранном формате
+type printer struct {
+	format string
+	w      io.Writer
+	// header выведен ли заголовок при наблюдении за метрикой
+	header bool
+}
+
+func newPrinter(format string, w io.Writer) *printer {
+	return &printer{format: format, w: w}
+}
+
+// metrics выводит список метрик, отсортированный по типу и имени
+func (p *printer) metrics(ms []metrics.Metrics) error {
+	ms = slices.Clone(ms)
+	slices.SortFunc(ms, func(a, b metrics.Metrics) int {
+		if c := strings.Compare(a.MType, b.MType); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	switch p.format {
+	case outputJSON:
+		return p.json(ms)
+	case outputCSV:
+		rows := [][]string{{"type", "name", "value"}}
+		for _, m := range ms {
+			rows = append(rows, []string{m.MType, m.ID, m.GetValue()})
+		}
+		return p.csv(rows)
+	default:
+		tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tNAME\tVALUE")
+		for _, m := range ms {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", m.MType, m.ID, m.GetValue())
+		}
+		return tw.Flush()
+	}
+}
+
+// metric выводит одну метрику
+func (p *printer) metric(m metrics.Metrics) error {
+	if p.format == outputJSON {
+		return p.json(m)
+	}
+	return p.metrics([]metrics.Metrics{m})
+}
+
+// watch выводит значение метрики, полученное в момент t. В json каждое значение выводится отдельной строкой
+func (p *printer) watch(t time.Time, m metrics.Metrics) error {
+	ts := t.Format(time.RFC3339)
+	switch p.format {
+	case outputJSON:
+		return json.NewEncoder(p.w).Encode(struct {
+			Time string `json:"time"`
+			metrics.Metrics
+		}{Time: ts, Metrics: m})
+	case outputCSV:
+		rows := [][]string{}
+		if !p.header {
+			rows = append(rows, []string{"time", "type", "name", "value"})
+		}
+		p.header = true
+		return p.csv(append(rows, []string{ts, m.MType, m.ID, m.GetValue()}))
+	default:
+		_, err := fmt.Fprintf(p.w, "%s  %s  %s  %s\n", ts, m.MType, m.ID, m.GetValue())
+		return err
+	}
+}
+
+func (p *printer) json(v any) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(p.w, string(data))
+	return err
+}
+
+func (p *printer) csv(rows [][]string) error {
+	w := csv.NewWriter(p.w)
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
@@ -1,126 +1,440 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
-	"strconv"
+	"time"
+	"ya-prac-project1/internal/config"
+	"ya-prac-project1/internal/forwarder"
+	"ya-prac-project1/internal/graphite"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/influx"
+	"ya-prac-project1/internal/remotewrite"
+	"ya-prac-project1/internal/statsd"
+	"ya-prac-project1/internal/storage/cachestorage"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	endpointDefault           = "localhost:8080"
+	storeIntervalDefault      = 300 * time.Second
+	storeFileDefault          = "store_metrics"
+	restoreFlagDefault        = true
+	baseDSNDefault            = ""
+	hashKeyDefault            = ""
+	profilerDefault           = ""
+	cryptoKeyDefault          = ""
+	agentConfigDefault        = ""
+	logLevelDefault           = "info"
+	storageDefault            = ""
+	boltFileDefault           = "metrics.db"
+	cacheDefault              = ""
+	cacheFlushIntervalDefault = time.Second
+	cacheMaxPendingDefault    = 1000
+	dbMaxConnsDefault         = 10
+	dbConnLifetimeDefault     = 30 * time.Minute
+	historyTiersDefault       = ""
+	historyCompactDefault     = time.Minute
+	clusterDefault            = false
+	clusterNodeIDDefault      = ""
+	clusterHeartbeatDefault   = 5 * time.Second
+	forwardAddressDefault     = ""
+	forwardModeDefault        = forwarder.ModeBatch
+	forwardIntervalDefault    = forwarder.DefaultInterval
+	forwardHashKeyDefault     = ""
+	forwardCryptoKeyDefault   = ""
+	forwardSourceDefault      = ""
+	forwardBufferDirDefault   = "forward_buffer"
+	forwardBufferMaxDefault   = 10000
+	remoteWriteURLDefault     = ""
+	remoteWritePeriodDefault  = remotewrite.DefaultInterval
+	remoteWriteBatchDefault   = remotewrite.DefaultBatchSize
+	remoteWriteQueueDefault   = remotewrite.DefaultQueueSize
+	graphiteAddressDefault    = ""
+	graphitePickleDefault     = ""
+	graphiteMappingDefault    = ""
+	statsdAddressDefault      = ""
+	statsdIntervalDefault     = statsd.DefaultFlushInterval
+	statsdPercentilesDefault  = "50,90,95,99"
+	influxCountersDefault     = ""
 )
 
+// Виды репозиториев метрик. Если вид не задан, он выбирается по database_dsn и store_file
 const (
-	endpointDefault      = "localhost:8080"
-	storeIntervalDefault = 300
-	storeFileDefault     = "store_metrics"
-	restoreFlagDefault   = true
-	baseDSNDefault       = ""
-	hashKeyDefault       = ""
-	profilerDefault      = ""
-	cryptoKeyDefault     = ""
+	storageMemory   = "memory"
+	storageFile     = "file"
+	storageBolt     = "bolt"
+	storagePostgres = "postgres"
 )
 
+// envFlags сопоставляет переменные окружения с флагами сервера
+var envFlags = map[string]string{
+	"ADDRESS":                    "a",
+	"STORE_INTERVAL":             "i",
+	"FILE_STORAGE_PATH":          "f",
+	"RESTORE":                    "r",
+	"DATABASE_DSN":               "d",
+	"KEY":                        "k",
+	"CRYPTO_KEY":                 "crypto-key",
+	"AGENT_CONFIG":               "agent-config",
+	"LOG_LEVEL":                  "log-level",
+	"STORAGE":                    "storage",
+	"BOLT_FILE":                  "bolt-file",
+	"CACHE":                      "cache",
+	"CACHE_FLUSH_INTERVAL":       "cache-flush-interval",
+	"CACHE_MAX_PENDING":          "cache-max-pending",
+	"DATABASE_MAX_CONNS":         "db-max-conns",
+	"DATABASE_CONN_MAX_LIFETIME": "db-conn-lifetime",
+	"HISTORY_TIERS":              "history-tiers",
+	"HISTORY_COMPACT_INTERVAL":   "history-compact-interval",
+	"CLUSTER":                    "cluster",
+	"CLUSTER_NODE_ID":            "cluster-node-id",
+	"CLUSTER_HEARTBEAT":          "cluster-heartbeat",
+	"FORWARD_ADDRESS":            "forward-address",
+	"FORWARD_MODE":               "forward-mode",
+	"FORWARD_INTERVAL":           "forward-interval",
+	"FORWARD_KEY":                "forward-key",
+	"FORWARD_CRYPTO_KEY":         "forward-crypto-key",
+	"FORWARD_SOURCE":             "forward-source",
+	"FORWARD_BUFFER_DIR":         "forward-buffer-dir",
+	"FORWARD_BUFFER_MAX":         "forward-buffer-max",
+	"REMOTE_WRITE_URL":           "remote-write-url",
+	"REMOTE_WRITE_INTERVAL":      "remote-write-interval",
+	"REMOTE_WRITE_BATCH_SIZE":    "remote-write-batch-size",
+	"REMOTE_WRITE_QUEUE_SIZE":    "remote-write-queue-size",
+	"GRAPHITE_ADDRESS":           "graphite-address",
+	"GRAPHITE_PICKLE_ADDRESS":    "graphite-pickle-address",
+	"GRAPHITE_MAPPING":           "graphite-mapping",
+	"STATSD_ADDRESS":             "statsd-address",
+	"STATSD_FLUSH_INTERVAL":      "statsd-flush-interval",
+	"STATSD_PERCENTILES":         "statsd-percentiles",
+	"INFLUX_COUNTERS":            "influx-counters",
+}
+
 type ServerConfig struct {
-	Endpoint      string
-	StoreFile     string
-	BaseDNS       string
-	HashKey       string
-	Profiler      string
-	Restore       bool
-	StoreInterval int
-	CryptoKey     string
+	Endpoint           string          `json:"address"`
+	StoreFile          string          `json:"store_file"`
+	BaseDNS            string          `json:"database_dsn"`
+	HashKey            string          `json:"hash_key"`
+	Profiler           string          `json:"profiler"`
+	Restore            bool            `json:"restore"`
+	StoreInterval      config.Duration `json:"store_interval"`
+	CryptoKey          string          `json:"crypto_key"`
+	AgentConfig        string          `json:"agent_config"`
+	LogLevel           string          `json:"log_level"`
+	Storage            string          `json:"storage"`
+	BoltFile           string          `json:"bolt_file"`
+	Cache              string          `json:"cache"`
+	CacheFlushInterval config.Duration `json:"cache_flush_interval"`
+	CacheMaxPending    int             `json:"cache_max_pending"`
+	DBMaxConns         int             `json:"database_max_conns"`
+	DBConnLifetime     config.Duration `json:"database_conn_max_lifetime"`
+	HistoryTiers       string          `json:"history_tiers"`
+	HistoryCompact     config.Duration `json:"history_compact_interval"`
+	Cluster            bool            `json:"cluster"`
+	ClusterNodeID      string          `json:"cluster_node_id"`
+	ClusterHeartbeat   config.Duration `json:"cluster_heartbeat"`
+	ForwardAddress     string          `json:"forward_address"`
+	ForwardMode        string          `json:"forward_mode"`
+	ForwardInterval    config.Duration `json:"forward_interval"`
+	ForwardHashKey     string          `json:"forward_hash_key"`
+	ForwardCryptoKey   string          `json:"forward_crypto_key"`
+	ForwardSource      string          `json:"forward_source"`
+	ForwardBufferDir   string          `json:"forward_buffer_dir"`
+	ForwardBufferMax   int             `json:"forward_buffer_max"`
+	RemoteWriteURL     string          `json:"remote_write_url"`
+	RemoteWritePeriod  config.Duration `json:"remote_write_interval"`
+	RemoteWriteBatch   int             `json:"remote_write_batch_size"`
+	RemoteWriteQueue   int             `json:"remote_write_queue_size"`
+	GraphiteAddress    string          `json:"graphite_address"`
+	GraphitePickle     string          `json:"graphite_pickle_address"`
+	GraphiteMapping    string          `json:"graphite_mapping"`
+	StatsdAddress      string          `json:"statsd_address"`
+	StatsdInterval     config.Duration `json:"statsd_flush_interval"`
+	StatsdPercentiles  string          `json:"statsd_percentiles"`
+	InfluxCounters     string          `json:"influx_counters"`
+	PrintConfig        bool            `json:"-"`
+	Migrate            string          `json:"-"`
 }
 
 func NewDefaultConfig() ServerConfig {
 	c := ServerConfig{
-		Endpoint:      endpointDefault,
-		StoreFile:     storeFileDefault,
-		BaseDNS:       baseDSNDefault,
-		HashKey:       hashKeyDefault,
-		Profiler:      profilerDefault,
-		Restore:       restoreFlagDefault,
-		StoreInterval: storeIntervalDefault,
-		CryptoKey:     cryptoKeyDefault,
+		Endpoint:           endpointDefault,
+		StoreFile:          storeFileDefault,
+		BaseDNS:            baseDSNDefault,
+		HashKey:            hashKeyDefault,
+		Profiler:           profilerDefault,
+		Restore:            restoreFlagDefault,
+		StoreInterval:      config.NewDuration(storeIntervalDefault),
+		CryptoKey:          cryptoKeyDefault,
+		AgentConfig:        agentConfigDefault,
+		LogLevel:           logLevelDefault,
+		Storage:            storageDefault,
+		BoltFile:           boltFileDefault,
+		Cache:              cacheDefault,
+		CacheFlushInterval: config.NewDuration(cacheFlushIntervalDefault),
+		CacheMaxPending:    cacheMaxPendingDefault,
+		DBMaxConns:         dbMaxConnsDefault,
+		DBConnLifetime:     config.NewDuration(dbConnLifetimeDefault),
+		HistoryTiers:       historyTiersDefault,
+		HistoryCompact:     config.NewDuration(historyCompactDefault),
+		Cluster:            clusterDefault,
+		ClusterNodeID:      clusterNodeIDDefault,
+		ClusterHeartbeat:   config.NewDuration(clusterHeartbeatDefault),
+		ForwardAddress:     forwardAddressDefault,
+		ForwardMode:        forwardModeDefault,
+		ForwardInterval:    config.NewDuration(forwardIntervalDefault),
+		ForwardHashKey:     forwardHashKeyDefault,
+		ForwardCryptoKey:   forwardCryptoKeyDefault,
+		ForwardSource:      forwardSourceDefault,
+		ForwardBufferDir:   forwardBufferDirDefault,
+		ForwardBufferMax:   forwardBufferMaxDefault,
+		RemoteWriteURL:     remoteWriteURLDefault,
+		RemoteWritePeriod:  config.NewDuration(remoteWritePeriodDefault),
+		RemoteWriteBatch:   remoteWriteBatchDefault,
+		RemoteWriteQueue:   remoteWriteQueueDefault,
+		GraphiteAddress:    graphiteAddressDefault,
+		GraphitePickle:     graphitePickleDefault,
+		GraphiteMapping:    graphiteMappingDefault,
+		StatsdAddress:      statsdAddressDefault,
+		StatsdInterval:     config.NewDuration(statsdIntervalDefault),
+		StatsdPercentiles:  statsdPercentilesDefault,
+		InfluxCounters:     influxCountersDefault,
 	}
 	return c
 }
 
-func NewConfig() ServerConfig {
+// NewConfig собирает и проверяет настройки сервера
+func NewConfig() (ServerConfig, error) {
+	c, err := readConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return c, err
+	}
 
-	configPath := ""
-	flag.StringVar(&configPath, "c", getEnv("CONFIG", ""), "config path")
+	if err = c.Validate(); err != nil {
+		return c, fmt.Errorf("invalid config:\n%w", err)
+	}
 
-	defaultConfig := NewDefaultConfig()
-	config, err := loadConfigFromFile(configPath)
-	if err != nil {
-		fmt.Println("config file read error", err)
-		config = &defaultConfig
+	return c, nil
+}
+
+// readConfig собирает настройки: значения по умолчанию, файл, переменные окружения, флаги.
+// Флаги регистрируются в fs, поэтому для повторного чтения нужен новый набор флагов
+func readConfig(fs *flag.FlagSet, args []string) (ServerConfig, error) {
+	c := NewDefaultConfig()
+
+	fs.StringVar(&c.Endpoint, "a", c.Endpoint, "server endpoint")
+	fs.Var(&c.StoreInterval, "i", "store interval, e.g. 300s, 0 writes every update")
+	fs.StringVar(&c.StoreFile, "f", c.StoreFile, "store file")
+	fs.BoolVar(&c.Restore, "r", c.Restore, "restore metrics")
+	fs.StringVar(&c.BaseDNS, "d", c.BaseDNS, "data base dsn")
+	fs.StringVar(&c.HashKey, "k", c.HashKey, "hash key")
+	fs.StringVar(&c.Profiler, "p", c.Profiler, "profiler port")
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, "crypto key")
+	fs.StringVar(&c.AgentConfig, "agent-config", c.AgentConfig, "agents config path")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "metrics storage: memory, file, bolt or postgres, empty selects by -d and -f")
+	fs.StringVar(&c.BoltFile, "bolt-file", c.BoltFile, "embedded database file for bolt storage")
+	fs.StringVar(&c.Cache, "cache", c.Cache, "cache in front of storage: write-through or write-behind, empty disables")
+	fs.Var(&c.CacheFlushInterval, "cache-flush-interval", "write-behind cache flush interval, e.g. 1s")
+	fs.IntVar(&c.CacheMaxPending, "cache-max-pending", c.CacheMaxPending, "pending writes that trigger write-behind cache flush")
+	fs.IntVar(&c.DBMaxConns, "db-max-conns", c.DBMaxConns, "max open database connections, 0 is unlimited")
+	fs.Var(&c.DBConnLifetime, "db-conn-lifetime", "database connection lifetime, e.g. 30m, 0 is unlimited")
+	fs.StringVar(&c.HistoryTiers, "history-tiers", c.HistoryTiers, "history retention tiers, e.g. raw:24h,1m:720h,1h:8760h, empty disables history")
+	fs.Var(&c.HistoryCompact, "history-compact-interval", "history rollup and retention interval, e.g. 1m")
+	fs.BoolVar(&c.Cluster, "cluster", c.Cluster, "coordinate several servers through the postgres database")
+	fs.StringVar(&c.ClusterNodeID, "cluster-node-id", c.ClusterNodeID, "cluster node id, empty generates one from the host name")
+	fs.Var(&c.ClusterHeartbeat, "cluster-heartbeat", "cluster heartbeat and leader check interval, e.g. 5s")
+	fs.StringVar(&c.ForwardAddress, "forward-address", c.ForwardAddress, "upstream server to forward metrics to, empty disables forwarding")
+	fs.StringVar(&c.ForwardMode, "forward-mode", c.ForwardMode, "forwarding mode: batch forwards every accepted batch, snapshot forwards periodic snapshots")
+	fs.Var(&c.ForwardInterval, "forward-interval", "forwarding snapshot and retry interval, e.g. 10s")
+	fs.StringVar(&c.ForwardHashKey, "forward-key", c.ForwardHashKey, "upstream hash key")
+	fs.StringVar(&c.ForwardCryptoKey, "forward-crypto-key", c.ForwardCryptoKey, "upstream public crypto key")
+	fs.StringVar(&c.ForwardSource, "forward-source", c.ForwardSource, "source label of forwarded metrics, empty uses the host name")
+	fs.StringVar(&c.ForwardBufferDir, "forward-buffer-dir", c.ForwardBufferDir, "directory for batches waiting for the upstream")
+	fs.IntVar(&c.ForwardBufferMax, "forward-buffer-max", c.ForwardBufferMax, "max buffered batches, the oldest are dropped, 0 is unlimited")
+	fs.StringVar(&c.RemoteWriteURL, "remote-write-url", c.RemoteWriteURL, "prometheus remote_write receiver url, empty disables export")
+	fs.Var(&c.RemoteWritePeriod, "remote-write-interval", "prometheus remote_write export interval, e.g. 15s")
+	fs.IntVar(&c.RemoteWriteBatch, "remote-write-batch-size", c.RemoteWriteBatch, "max samples in one remote_write request")
+	fs.IntVar(&c.RemoteWriteQueue, "remote-write-queue-size", c.RemoteWriteQueue, "max samples waiting for the remote_write receiver, the oldest are dropped")
+	fs.StringVar(&c.GraphiteAddress, "graphite-address", c.GraphiteAddress, "graphite plaintext listen address for tcp and udp, empty disables it")
+	fs.StringVar(&c.GraphitePickle, "graphite-pickle-address", c.GraphitePickle, "graphite pickle listen address, empty disables it")
+	fs.StringVar(&c.GraphiteMapping, "graphite-mapping", c.GraphiteMapping, "path to json file with graphite path mapping rules")
+	fs.StringVar(&c.StatsdAddress, "statsd-address", c.StatsdAddress, "statsd udp listen address, empty disables it")
+	fs.Var(&c.StatsdInterval, "statsd-flush-interval", "statsd aggregation flush interval, e.g. 10s")
+	fs.StringVar(&c.StatsdPercentiles, "statsd-percentiles", c.StatsdPercentiles, "comma separated statsd timer percentiles, e.g. 50,90,99")
+	fs.StringVar(&c.InfluxCounters, "influx-counters", c.InfluxCounters, "comma separated influx metric name patterns stored as cumulative counters besides *_total, e.g. net_bytes_*")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print effective config and exit")
+	fs.StringVar(&c.Migrate, "migrate", "", "apply database migrations and exit: up, down or schema version")
+
+	err := config.Load(fs, args, &c, config.Options{
+		ConfigFlag: "c",
+		ConfigEnv:  "CONFIG",
+		Env:        envFlags,
+	})
+	return c, err
+}
+
+// Validate проверяет настройки сервера и возвращает все найденные ошибки
+func (c ServerConfig) Validate() error {
+	var errs []error
+	if c.Endpoint == "" {
+		errs = append(errs, errors.New("address: must not be empty"))
 	}
 
-	flag.StringVar(&config.Endpoint, "a", config.Endpoint, "server endpoint")
-	flag.IntVar(&config.StoreInterval, "i", config.StoreInterval, "store interval")
-	flag.StringVar(&config.StoreFile, "f", config.StoreFile, "store file")
-	flag.BoolVar(&config.Restore, "r", config.Restore, "restore metrics")
-	flag.StringVar(&config.BaseDNS, "d", config.BaseDNS, "data base dsn")
-	flag.StringVar(&config.HashKey, "k", config.HashKey, "hash key")
-	flag.StringVar(&config.Profiler, "p", config.Profiler, "profiler port")
-	flag.StringVar(&config.CryptoKey, "crypto-key", config.CryptoKey, "crypto key")
-	flag.Parse()
+	if c.StoreInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("store_interval: must not be negative, got %s", c.StoreInterval))
+	}
 
-	if endpointEnv := os.Getenv("ADDRESS"); endpointEnv != "" {
-		config.Endpoint = endpointEnv
+	switch c.Storage {
+	case "", storageMemory, storageFile:
+	case storageBolt:
+		if c.BoltFile == "" {
+			errs = append(errs, errors.New("bolt_file: must not be empty for bolt storage"))
+		}
+	case storagePostgres:
+		if c.BaseDNS == "" {
+			errs = append(errs, errors.New("database_dsn: must not be empty for postgres storage"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage: want memory, file, bolt or postgres, got %q", c.Storage))
 	}
 
-	if storeIntervalEnv := os.Getenv("STORE_INTERVAL"); storeIntervalEnv != "" {
-		i, err := strconv.Atoi(storeIntervalEnv)
-		if err == nil {
-			config.StoreInterval = i
+	switch c.Cache {
+	case "", cachestorage.DurabilityWriteThrough:
+	case cachestorage.DurabilityWriteBehind:
+		if c.CacheFlushInterval.Duration <= 0 && c.CacheMaxPending <= 0 {
+			errs = append(errs, errors.New("cache: write-behind needs cache_flush_interval or cache_max_pending"))
 		}
+	default:
+		errs = append(errs, fmt.Errorf("cache: want write-through or write-behind, got %q", c.Cache))
+	}
+
+	if c.CacheFlushInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("cache_flush_interval: must not be negative, got %s", c.CacheFlushInterval))
+	}
+
+	if c.DBMaxConns < 0 {
+		errs = append(errs, fmt.Errorf("database_max_conns: must not be negative, got %d", c.DBMaxConns))
 	}
 
-	if storeFileEnv := os.Getenv("FILE_STORAGE_PATH"); storeFileEnv != "" {
-		config.StoreFile = storeFileEnv
+	if c.DBConnLifetime.Duration < 0 {
+		errs = append(errs, fmt.Errorf("database_conn_max_lifetime: must not be negative, got %s", c.DBConnLifetime))
 	}
 
-	if restoreEnv := os.Getenv("RESTORE"); restoreEnv != "" {
-		restore, err := strconv.ParseBool(restoreEnv)
-		if err == nil {
-			config.Restore = restore
+	if c.HistoryTiers != "" {
+		if _, err := history.ParseTiers(c.HistoryTiers); err != nil {
+			errs = append(errs, fmt.Errorf("history_tiers: %w", err))
+		}
+		if c.HistoryCompact.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("history_compact_interval: must be positive, got %s", c.HistoryCompact))
 		}
 	}
 
-	if baseDSNEnv := os.Getenv("DATABASE_DSN"); baseDSNEnv != "" {
-		config.BaseDNS = baseDSNEnv
+	if c.Cluster {
+		if c.BaseDNS == "" || (c.Storage != "" && c.Storage != storagePostgres) {
+			errs = append(errs, errors.New("cluster: requires postgres storage"))
+		}
+		if c.ClusterHeartbeat.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("cluster_heartbeat: must be positive, got %s", c.ClusterHeartbeat))
+		}
 	}
 
-	if hashKeyEnv := os.Getenv("KEY"); hashKeyEnv != "" {
-		config.HashKey = hashKeyEnv
+	if c.ForwardAddress != "" {
+		if c.ForwardMode != forwarder.ModeBatch && c.ForwardMode != forwarder.ModeSnapshot {
+			errs = append(errs, fmt.Errorf("forward_mode: want batch or snapshot, got %q", c.ForwardMode))
+		}
+		if c.ForwardInterval.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("forward_interval: must be positive, got %s", c.ForwardInterval))
+		}
+		if c.ForwardBufferDir == "" {
+			errs = append(errs, errors.New("forward_buffer_dir: must not be empty"))
+		}
+		if c.ForwardBufferMax < 0 {
+			errs = append(errs, fmt.Errorf("forward_buffer_max: must not be negative, got %d", c.ForwardBufferMax))
+		}
+		if c.ForwardCryptoKey != "" {
+			if _, err := os.Stat(c.ForwardCryptoKey); err != nil {
+				errs = append(errs, fmt.Errorf("forward_crypto_key: %w", err))
+			}
+		}
 	}
 
-	if cryptoKeyEnv := os.Getenv("CRYPTO_KEY"); cryptoKeyEnv != "" {
-		config.CryptoKey = cryptoKeyEnv
+	if c.RemoteWriteURL != "" {
+		if u, err := url.Parse(c.RemoteWriteURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("remote_write_url: want http or https url, got %q", c.RemoteWriteURL))
+		}
+		if c.RemoteWritePeriod.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("remote_write_interval: must be positive, got %s", c.RemoteWritePeriod))
+		}
+		if c.RemoteWriteBatch <= 0 {
+			errs = append(errs, fmt.Errorf("remote_write_batch_size: must be positive, got %d", c.RemoteWriteBatch))
+		}
+		if c.RemoteWriteQueue < c.RemoteWriteBatch {
+			errs = append(errs, fmt.Errorf("remote_write_queue_size: must not be less than remote_write_batch_size, got %d", c.RemoteWriteQueue))
+		}
 	}
 
-	return *config
-}
+	if c.GraphiteMapping != "" {
+		if _, err := graphite.LoadMapper(c.GraphiteMapping); err != nil {
+			errs = append(errs, fmt.Errorf("graphite_mapping: %w", err))
+		}
+	}
 
-func loadConfigFromFile(filename string) (*ServerConfig, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	if c.StatsdAddress != "" {
+		if c.StatsdInterval.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("statsd_flush_interval: must be positive, got %s", c.StatsdInterval))
+		}
+		if _, err := statsd.ParsePercentiles(c.StatsdPercentiles); err != nil {
+			errs = append(errs, fmt.Errorf("statsd_percentiles: %w", err))
+		}
 	}
-	defer file.Close()
 
-	config := &ServerConfig{}
-	err = json.NewDecoder(file).Decode(config)
-	if err != nil {
-		return nil, err
+	if _, err := influx.ParseCounters(c.InfluxCounters); err != nil {
+		errs = append(errs, fmt.Errorf("influx_counters: %w", err))
+	}
+
+	if c.CryptoKey != "" {
+		if _, err := os.Stat(c.CryptoKey); err != nil {
+			errs = append(errs, fmt.Errorf("crypto_key: %w", err))
+		}
+	}
+
+	if c.AgentConfig != "" {
+		if _, err := os.Stat(c.AgentConfig); err != nil {
+			errs = append(errs, fmt.Errorf("agent_config: %w", err))
+		}
+	}
+
+	if c.Migrate != "" {
+		if c.BaseDNS == "" {
+			errs = append(errs, errors.New("migrate: database_dsn is required"))
+		}
+		if _, err := migrationTarget(c.Migrate, 0); err != nil {
+			errs = append(errs, fmt.Errorf("migrate: %w", err))
+		}
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
 	}
-	return config, nil
+
+	return errors.Join(errs...)
 }
 
-func getEnv(key string, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// Redacted возвращает копию настроек со скрытыми секретами для вывода
+func (c ServerConfig) Redacted() ServerConfig {
+	c.HashKey = config.Mask(c.HashKey)
+	c.ForwardHashKey = config.Mask(c.ForwardHashKey)
+	if u, err := url.Parse(c.RemoteWriteURL); err == nil && u.User != nil {
+		c.RemoteWriteURL = u.Redacted()
+	}
+	if u, err := url.Parse(c.BaseDNS); err == nil && u.Scheme != "" {
+		c.BaseDNS = u.Redacted()
 	}
-	return fallback
+	return c
 }
@@ -3,15 +3,30 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/client"
+	"ya-prac-project1/internal/cluster"
+	"ya-prac-project1/internal/config"
+	"ya-prac-project1/internal/forwarder"
+	"ya-prac-project1/internal/graphite"
 	"ya-prac-project1/internal/handlers"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/influx"
 	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/remotewrite"
 	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/statsd"
+	"ya-prac-project1/internal/storage/boltstorage"
+	"ya-prac-project1/internal/storage/cachestorage"
 	"ya-prac-project1/internal/storage/databasestorage"
 	"ya-prac-project1/internal/storage/filestorage"
 	"ya-prac-project1/internal/storage/inmemstorage"
@@ -22,6 +37,9 @@ import (
 	_ "net/http/pprof"
 )
 
+// forwardTimeout время ожидания ответа вышестоящего сервера
+const forwardTimeout = 10 * time.Second
+
 var (
 	buildVersion string
 	buildDate    string
@@ -30,50 +48,230 @@ var (
 
 func main() {
 	showBuildInfo()
-	config := NewConfig()
-	if err := run(config); err != nil {
+	c, err := NewConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if c.PrintConfig {
+		if err = config.Print(os.Stdout, c.Redacted()); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if c.Migrate != "" {
+		if err = runMigrate(context.Background(), c, os.Stdout); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if err := run(c); err != nil {
 		log.Fatal(err.Error())
 	}
 }
 
-func run(config ServerConfig) error {
-	err := logger.Set()
-	if err != nil {
+func run(config ServerConfig) (err error) {
+	if err = logger.Set(); err != nil {
+		return err
+	}
+
+	if err = logger.SetLevel(config.LogLevel); err != nil {
 		return err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	runGracefulShutdown(cancel)
 	RunProfiler(ctx, config.Profiler)
 
-	store, err := getStorage(ctx, config, getSQLConnect(config))
+	db := getSQLConnect(config)
+	store, err := getStorage(ctx, config, db)
 	if err != nil {
 		return err
 	}
+	// store заменяется обертками, закрывается последняя из них вместе с обернутыми репозиториями
+	defer func() {
+		err = errors.Join(err, store.Close())
+	}()
+
+	// история хранится рядом с метриками, поэтому выбирается до обертки кэшем
+	hist, err := getHistory(config, store)
+	if err != nil {
+		return err
+	}
+
+	cl, err := getCluster(config, db)
+	if err != nil {
+		return err
+	}
+	if cl != nil {
+		backend, ok := store.(cluster.Backend)
+		if !ok {
+			return fmt.Errorf("cluster: storage %T is not shared", store)
+		}
+		store = cl.Storage(backend)
+	}
+
+	var cache *cachestorage.Storage
+	if config.Cache != "" {
+		cache, err = cachestorage.New(ctx, store, cachestorage.Options{
+			Durability:    config.Cache,
+			FlushInterval: config.CacheFlushInterval.Duration,
+			MaxPending:    config.CacheMaxPending,
+		})
+		if err != nil {
+			return err
+		}
+
+		// кеш перечитывает метрики, которые изменили другие экземпляры
+		if cl != nil {
+			cl.OnInvalidate(cache.Invalidate)
+		}
+		store = cache
+	}
+
+	// workers фоновые задачи, которые запускаются вместе с сервером и останавливаются вместе с ним
+	var workers []func(ctx context.Context)
 
 	metricService := services.NewMetricSaverService(store)
+	if hist != nil {
+		metricService.SetHistory(hist)
 
-	h := handlers.New(metricService, getSQLConnect(config), config.HashKey, config.CryptoKey)
+		// свертку и удаление истории в кластере выполняет только лидер
+		if cl != nil {
+			cl.Go(func(ctx context.Context) { hist.Run(ctx, config.HistoryCompact.Duration) })
+		} else {
+			workers = append(workers, func(ctx context.Context) { hist.Run(ctx, config.HistoryCompact.Duration) })
+		}
+	}
+
+	// снимки в кластере по очереди отправляют разные лидеры, поэтому их состояние хранится в общей базе
+	var fwdState forwarder.State
+	if cl != nil {
+		fwdState = cl.ForwardState(config.ForwardAddress)
+	}
+	fwd, err := getForwarder(config, fwdState)
+	if err != nil {
+		return err
+	}
+	if fwd != nil {
+		list := func(ctx context.Context) ([]metrics.Metrics, error) {
+			return metricService.GetMetrics(ctx)
+		}
+
+		switch {
+		case fwd.Mode() == forwarder.ModeBatch:
+			metricService.SetForwarder(fwd)
+			workers = append(workers, func(ctx context.Context) { fwd.Run(ctx, nil) })
+		case cl != nil:
+			// снимки общего репозитория в кластере отправляет только лидер
+			cl.Go(func(ctx context.Context) { fwd.Run(ctx, list) })
+		default:
+			workers = append(workers, func(ctx context.Context) { fwd.Run(ctx, list) })
+		}
+	}
+
+	exporter, err := getRemoteWrite(config)
+	if err != nil {
+		return err
+	}
+	if exporter != nil {
+		list := func(ctx context.Context) ([]metrics.Metrics, error) {
+			return metricService.GetMetrics(ctx)
+		}
+
+		// в кластере общий репозиторий выгружает только лидер, иначе ряды задвоятся
+		if cl != nil {
+			cl.Go(func(ctx context.Context) { exporter.Run(ctx, list) })
+		} else {
+			workers = append(workers, func(ctx context.Context) { exporter.Run(ctx, list) })
+		}
+	}
+
+	h := handlers.New(metricService, db, config.HashKey, config.CryptoKey)
+	if config.AgentConfig != "" {
+		agentConfig, err := agentconfig.Load(config.AgentConfig)
+		if err != nil {
+			return err
+		}
+		h.SetAgentConfig(agentConfig)
+	}
+	if cl != nil {
+		h.SetCluster(cl)
+	}
+	if cache != nil {
+		h.SetCache(cache)
+	}
+	counters, err := influx.ParseCounters(config.InfluxCounters)
+	if err != nil {
+		return fmt.Errorf("influx_counters: %w", err)
+	}
+	h.SetInfluxCounters(counters)
 	h.Mount()
 
+	// слушатели открываются последними, чтобы не остаться открытыми при ошибке настройки
+	graphiteServer, err := getGraphite(config, metricService)
+	if err != nil {
+		return err
+	}
+
+	statsdServer, err := getStatsd(config, metricService)
+	if err != nil {
+		if graphiteServer != nil {
+			graphiteServer.Close()
+		}
+		return err
+	}
+
+	runReloadOnSignal(ctx, newLiveConfig(config, h, store).reload)
+
 	srv := &http.Server{
 		Addr:    config.Endpoint,
 		Handler: h,
 	}
+	srv.RegisterOnShutdown(h.CloseStreams)
 
 	g, gCtx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		fmt.Printf("Start server on: %s\n", config.Endpoint)
-		if err = srv.ListenAndServe(); err != nil {
+		if err := srv.ListenAndServe(); err != nil {
 			return err
 		}
 		return nil
 	})
 
+	if graphiteServer != nil {
+		g.Go(func() error {
+			return graphiteServer.Run(gCtx)
+		})
+	}
+
+	if statsdServer != nil {
+		g.Go(func() error {
+			return statsdServer.Run(gCtx)
+		})
+	}
+
+	if cl != nil {
+		g.Go(func() error {
+			cl.Run(gCtx)
+			return nil
+		})
+	}
+
+	for _, worker := range workers {
+		g.Go(func() error {
+			worker(gCtx)
+			return nil
+		})
+	}
+
 	g.Go(func() error {
 		<-gCtx.Done()
 		fmt.Printf("Stop server on: %s\n", config.Endpoint)
-		if err = srv.Shutdown(context.Background()); err != nil {
+		if err := srv.Shutdown(context.Background()); err != nil {
 			fmt.Printf("Start server on: %s\n", config.Endpoint)
 			return err
 		}
@@ -89,16 +287,154 @@ func run(config ServerConfig) error {
 }
 
 func getStorage(ctx context.Context, config ServerConfig, db *sql.DB) (services.SaveStorage, error) {
+	switch config.Storage {
+	case storageMemory:
+		return inmemstorage.NewStorage(), nil
+	case storageFile:
+		return filestorage.NewStorage(ctx, config.StoreFile, config.Restore, config.StoreInterval.Duration)
+	case storageBolt:
+		return boltstorage.NewStorage(config.BoltFile)
+	case storagePostgres:
+		return databasestorage.NewStorage(db)
+	}
+
 	if config.BaseDNS != "" {
 		return databasestorage.NewStorage(db)
 	} else if config.StoreFile != "" {
-		return filestorage.NewStorage(ctx, config.StoreFile, config.Restore, int64(config.StoreInterval))
+		return filestorage.NewStorage(ctx, config.StoreFile, config.Restore, config.StoreInterval.Duration)
 	}
 
 	store := inmemstorage.NewStorage()
 	return store, nil
 }
 
+// getHistory создает историю метрик в том же хранилище, что и репозиторий: в Postgres
+// и встроенной базе — в их таблицах, для остальных репозиториев — в памяти.
+// Если уровни истории не заданы, возвращает nil
+func getHistory(config ServerConfig, store services.SaveStorage) (*history.History, error) {
+	if config.HistoryTiers == "" {
+		return nil, nil
+	}
+
+	tiers, err := history.ParseTiers(config.HistoryTiers)
+	if err != nil {
+		return nil, err
+	}
+
+	var historyStore history.Store
+	switch s := store.(type) {
+	case *databasestorage.Storage:
+		historyStore = s.History()
+	case *boltstorage.Storage:
+		historyStore = s.History()
+	default:
+		historyStore = history.NewMemoryStore()
+	}
+
+	return history.New(historyStore, tiers)
+}
+
+// getCluster создает экземпляр кластера, если сервер работает в кластере
+func getCluster(config ServerConfig, db *sql.DB) (*cluster.Cluster, error) {
+	if !config.Cluster {
+		return nil, nil
+	}
+
+	return cluster.New(db, cluster.Options{
+		NodeID:    config.ClusterNodeID,
+		Address:   config.Endpoint,
+		Heartbeat: config.ClusterHeartbeat.Duration,
+	})
+}
+
+// getForwarder создает пересылку метрик на вышестоящий сервер, если он задан. Запросы
+// подписываются и шифруются ключами вышестоящего сервера, метрики помечаются именем источника
+func getForwarder(config ServerConfig, state forwarder.State) (*forwarder.Forwarder, error) {
+	if config.ForwardAddress == "" {
+		return nil, nil
+	}
+
+	source := config.ForwardSource
+	if source == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("forward_source: %w", err)
+		}
+		source = hostname
+	}
+
+	upstream := client.New(config.ForwardAddress, config.ForwardHashKey, config.ForwardCryptoKey, forwardTimeout)
+	return forwarder.New(upstream, forwarder.Options{
+		Mode:        config.ForwardMode,
+		Interval:    config.ForwardInterval.Duration,
+		Source:      source,
+		BufferDir:   config.ForwardBufferDir,
+		MaxBuffered: config.ForwardBufferMax,
+		State:       state,
+	})
+}
+
+// getRemoteWrite создает выгрузку метрик в приемник Prometheus remote_write, если он задан
+func getRemoteWrite(config ServerConfig) (*remotewrite.Exporter, error) {
+	if config.RemoteWriteURL == "" {
+		return nil, nil
+	}
+
+	return remotewrite.New(remotewrite.Options{
+		URL:       config.RemoteWriteURL,
+		Interval:  config.RemoteWritePeriod.Duration,
+		BatchSize: config.RemoteWriteBatch,
+		QueueSize: config.RemoteWriteQueue,
+	})
+}
+
+// getGraphite открывает прием метрик Graphite, если задан адрес приема
+func getGraphite(config ServerConfig, saver graphite.Saver) (*graphite.Server, error) {
+	if config.GraphiteAddress == "" && config.GraphitePickle == "" {
+		return nil, nil
+	}
+
+	var mapper *graphite.Mapper
+	if config.GraphiteMapping != "" {
+		var err error
+		if mapper, err = graphite.LoadMapper(config.GraphiteMapping); err != nil {
+			return nil, fmt.Errorf("graphite_mapping: %w", err)
+		}
+	}
+
+	s := graphite.New(saver, graphite.Options{
+		Address:       config.GraphiteAddress,
+		PickleAddress: config.GraphitePickle,
+		Mapper:        mapper,
+	})
+	if err := s.Listen(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// getStatsd открывает прием метрик StatsD, если задан адрес приема
+func getStatsd(config ServerConfig, saver statsd.Saver) (*statsd.Server, error) {
+	if config.StatsdAddress == "" {
+		return nil, nil
+	}
+
+	percentiles, err := statsd.ParsePercentiles(config.StatsdPercentiles)
+	if err != nil {
+		return nil, fmt.Errorf("statsd_percentiles: %w", err)
+	}
+
+	s := statsd.New(saver, statsd.Options{
+		Address:       config.StatsdAddress,
+		FlushInterval: config.StatsdInterval.Duration,
+		Percentiles:   percentiles,
+	})
+	if err := s.Listen(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 func getSQLConnect(config ServerConfig) *sql.DB {
 	if config.BaseDNS == "" {
 		return nil
@@ -109,6 +445,11 @@ func getSQLConnect(config ServerConfig) *sql.DB {
 		return nil
 	}
 
+	// простаивающих соединений столько же, сколько открытых, чтобы пачки не открывали соединения заново
+	db.SetMaxOpenConns(config.DBMaxConns)
+	db.SetMaxIdleConns(config.DBMaxConns)
+	db.SetConnMaxLifetime(config.DBConnLifetime.Duration)
+
 	return db
 }
 
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/storage/databasestorage"
+)
+
+// runMigrate приводит схему базы к версии из флага -migrate и печатает результат
+func runMigrate(ctx context.Context, config ServerConfig, out io.Writer) error {
+	if err := logger.Set(); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("pgx", config.BaseDNS)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := databasestorage.SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	target, err := migrationTarget(config.Migrate, current)
+	if err != nil {
+		return err
+	}
+
+	if err = databasestorage.Migrate(ctx, db, target); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "schema version: %d -> %d\n", current, target)
+	return nil
+}
+
+// migrationTarget переводит значение флага -migrate в версию схемы:
+// up — последняя версия, down — на одну меньше текущей, число — заданная версия
+func migrationTarget(arg string, current int) (int, error) {
+	switch arg {
+	case "up":
+		return databasestorage.LatestVersion()
+	case "down":
+		return max(current-1, 0), nil
+	}
+
+	version, err := strconv.Atoi(arg)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("want up, down or schema version, got %q", arg)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"ya-prac-project1/internal/storage/databasestorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationTarget(t *testing.T) {
+	latest, err := databasestorage.LatestVersion()
+	require.NoError(t, err)
+
+	tests := []struct {
+		arg     string
+		current int
+		expect  int
+		wantErr bool
+	}{
+		{arg: "up", current: 0, expect: latest},
+		{arg: "down", current: 2, expect: 1},
+		{arg: "down", current: 0, expect: 0},
+		{arg: "1", current: 2, expect: 1},
+		{arg: "-1", wantErr: true},
+		{arg: "latest", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			target, err := migrationTarget(tt.arg, tt.current)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, target)
+		})
+	}
+}
+
+func TestValidate_migrate(t *testing.T) {
+	c := NewDefaultConfig()
+	c.Migrate = "up"
+	assert.ErrorContains(t, c.Validate(), "database_dsn is required")
+
+	c.BaseDNS = "postgres://localhost/metrics"
+	c.Migrate = "sideways"
+	assert.ErrorContains(t, c.Validate(), "want up, down or schema version")
+
+	c.Migrate = "down"
+	assert.NoError(t, c.Validate())
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/handlers"
+	"ya-prac-project1/internal/influx"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/services"
+
+	"go.uber.org/zap"
+)
+
+// dumpIntervalSetter репозиторий, у которого можно поменять интервал сброса метрик
+type dumpIntervalSetter interface {
+	SetDumpInterval(interval time.Duration)
+}
+
+// liveConfig применяет к работающему серверу настройки, которые можно поменять без перезапуска
+type liveConfig struct {
+	current ServerConfig
+	handler *handlers.ServerHandler
+	store   services.SaveStorage
+}
+
+func newLiveConfig(current ServerConfig, handler *handlers.ServerHandler, store services.SaveStorage) *liveConfig {
+	return &liveConfig{
+		current: current,
+		handler: handler,
+		store:   store,
+	}
+}
+
+// apply применяет новые настройки. Настройки, для которых нужен перезапуск, только логируются
+func (l *liveConfig) apply(next ServerConfig) error {
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	var agentConfig *agentconfig.Source
+	if next.AgentConfig != "" {
+		var err error
+		agentConfig, err = agentconfig.Load(next.AgentConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := logger.SetLevel(next.LogLevel); err != nil {
+		return err
+	}
+
+	counters, err := influx.ParseCounters(next.InfluxCounters)
+	if err != nil {
+		return err
+	}
+
+	l.handler.SetKeys(next.HashKey, next.CryptoKey)
+	l.handler.SetAgentConfig(agentConfig)
+	l.handler.SetInfluxCounters(counters)
+
+	if dumper, ok := l.store.(dumpIntervalSetter); ok {
+		dumper.SetDumpInterval(next.StoreInterval.Duration)
+	}
+
+	for _, name := range restartRequired(l.current, next) {
+		logger.Get().Info("setting changed, restart required", zap.String("setting", name))
+	}
+
+	next.Endpoint = l.current.Endpoint
+	next.StoreFile = l.current.StoreFile
+	next.BaseDNS = l.current.BaseDNS
+	next.Profiler = l.current.Profiler
+	next.Restore = l.current.Restore
+	next.Storage = l.current.Storage
+	next.BoltFile = l.current.BoltFile
+	next.Cache = l.current.Cache
+	next.CacheFlushInterval = l.current.CacheFlushInterval
+	next.CacheMaxPending = l.current.CacheMaxPending
+	next.HistoryTiers = l.current.HistoryTiers
+	next.HistoryCompact = l.current.HistoryCompact
+	next.Cluster = l.current.Cluster
+	next.ClusterNodeID = l.current.ClusterNodeID
+	next.ClusterHeartbeat = l.current.ClusterHeartbeat
+	next.ForwardAddress = l.current.ForwardAddress
+	next.ForwardMode = l.current.ForwardMode
+	next.ForwardInterval = l.current.ForwardInterval
+	next.ForwardHashKey = l.current.ForwardHashKey
+	next.ForwardCryptoKey = l.current.ForwardCryptoKey
+	next.ForwardSource = l.current.ForwardSource
+	next.ForwardBufferDir = l.current.ForwardBufferDir
+	next.ForwardBufferMax = l.current.ForwardBufferMax
+	next.RemoteWriteURL = l.current.RemoteWriteURL
+	next.RemoteWritePeriod = l.current.RemoteWritePeriod
+	next.RemoteWriteBatch = l.current.RemoteWriteBatch
+	next.RemoteWriteQueue = l.current.RemoteWriteQueue
+	next.GraphiteAddress = l.current.GraphiteAddress
+	next.GraphitePickle = l.current.GraphitePickle
+	next.GraphiteMapping = l.current.GraphiteMapping
+	next.StatsdAddress = l.current.StatsdAddress
+	next.StatsdInterval = l.current.StatsdInterval
+	next.StatsdPercentiles = l.current.StatsdPercentiles
+	l.current = next
+
+	logger.Get().Info("config reloaded")
+	return nil
+}
+
+// restartRequired возвращает названия измененных настроек, которые применяются только при запуске
+func restartRequired(current, next ServerConfig) []string {
+	names := []string{}
+	if current.Endpoint != next.Endpoint {
+		names = append(names, "address")
+	}
+	if current.StoreFile != next.StoreFile {
+		names = append(names, "store_file")
+	}
+	if current.BaseDNS != next.BaseDNS {
+		names = append(names, "database_dsn")
+	}
+	if current.Profiler != next.Profiler {
+		names = append(names, "profiler")
+	}
+	if current.Restore != next.Restore {
+		names = append(names, "restore")
+	}
+	if current.Storage != next.Storage {
+		names = append(names, "storage")
+	}
+	if current.BoltFile != next.BoltFile {
+		names = append(names, "bolt_file")
+	}
+	if current.Cache != next.Cache {
+		names = append(names, "cache")
+	}
+	if current.CacheFlushInterval != next.CacheFlushInterval {
+		names = append(names, "cache_flush_interval")
+	}
+	if current.CacheMaxPending != next.CacheMaxPending {
+		names = append(names, "cache_max_pending")
+	}
+	if current.HistoryTiers != next.HistoryTiers {
+		names = append(names, "history_tiers")
+	}
+	if current.HistoryCompact != next.HistoryCompact {
+		names = append(names, "history_compact_interval")
+	}
+	if current.Cluster != next.Cluster {
+		names = append(names, "cluster")
+	}
+	if current.ClusterNodeID != next.ClusterNodeID {
+		names = append(names, "cluster_node_id")
+	}
+	if current.ClusterHeartbeat != next.ClusterHeartbeat {
+		names = append(names, "cluster_heartbeat")
+	}
+	if current.ForwardAddress != next.ForwardAddress {
+		names = append(names, "forward_address")
+	}
+	if current.ForwardMode != next.ForwardMode {
+		names = append(names, "forward_mode")
+	}
+	if current.ForwardInterval != next.ForwardInterval {
+		names = append(names, "forward_interval")
+	}
+	if current.ForwardHashKey != next.ForwardHashKey {
+		names = append(names, "forward_hash_key")
+	}
+	if current.ForwardCryptoKey != next.ForwardCryptoKey {
+		names = append(names, "forward_crypto_key")
+	}
+	if current.ForwardSource != next.ForwardSource {
+		names = append(names, "forward_source")
+	}
+	if current.ForwardBufferDir != next.ForwardBufferDir {
+		names = append(names, "forward_buffer_dir")
+	}
+	if current.ForwardBufferMax != next.ForwardBufferMax {
+		names = append(names, "forward_buffer_max")
+	}
+	if current.RemoteWriteURL != next.RemoteWriteURL {
+		names = append(names, "remote_write_url")
+	}
+	if current.RemoteWritePeriod != next.RemoteWritePeriod {
+		names = append(names, "remote_write_interval")
+	}
+	if current.RemoteWriteBatch != next.RemoteWriteBatch {
+		names = append(names, "remote_write_batch_size")
+	}
+	if current.RemoteWriteQueue != next.RemoteWriteQueue {
+		names = append(names, "remote_write_queue_size")
+	}
+	if current.GraphiteAddress != next.GraphiteAddress {
+		names = append(names, "graphite_address")
+	}
+	if current.GraphitePickle != next.GraphitePickle {
+		names = append(names, "graphite_pickle_address")
+	}
+	if current.GraphiteMapping != next.GraphiteMapping {
+		names = append(names, "graphite_mapping")
+	}
+	if current.StatsdAddress != next.StatsdAddress {
+		names = append(names, "statsd_address")
+	}
+	if current.StatsdInterval != next.StatsdInterval {
+		names = append(names, "statsd_flush_interval")
+	}
+	if current.StatsdPercentiles != next.StatsdPercentiles {
+		names = append(names, "statsd_percentiles")
+	}
+	return names
+}
+
+// reload перечитывает настройки из файла, переменных окружения и флагов и применяет их
+func (l *liveConfig) reload() {
+	next, err := readConfig(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args[1:])
+	if err == nil {
+		err = l.apply(next)
+	}
+
+	if err != nil {
+		logger.Get().Info("config reload error", zap.String("error", err.Error()))
+	}
+}
+
+// runReloadOnSignal вызывает reload при получении SIGHUP
+func runReloadOnSignal(ctx context.Context, reload func()) {
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(s)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s:
+				logger.Get().Info("got SIGHUP, reload config")
+				reload()
+			}
+		}
+	}()
+}
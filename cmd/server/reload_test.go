@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/config"
+	"ya-prac-project1/internal/handlers"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	t.Setenv("KEY", "")
+	t.Setenv("LOG_LEVEL", "")
+
+	c, err := readConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-k", "new_key", "-log-level", "debug"})
+	require.NoError(t, err)
+	assert.Equal(t, "new_key", c.HashKey)
+	assert.Equal(t, "debug", c.LogLevel)
+
+	_, err = readConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-unknown"})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	c := NewDefaultConfig()
+	assert.NoError(t, c.Validate())
+
+	wrong := c
+	wrong.Endpoint = ""
+	assert.Error(t, wrong.Validate())
+
+	wrong = c
+	wrong.StoreInterval = config.Seconds(-1)
+	assert.Error(t, wrong.Validate())
+
+	wrong = c
+	wrong.CryptoKey = "not_exists.pem"
+	assert.Error(t, wrong.Validate())
+
+	wrong = c
+	wrong.LogLevel = "wrong"
+	assert.Error(t, wrong.Validate())
+}
+
+func TestLiveConfigApply(t *testing.T) {
+	_ = logger.Set()
+	store := inmemstorage.NewStorage()
+	h := handlers.New(services.NewMetricSaverService(store), nil, "", "")
+	h.Mount()
+
+	current := NewDefaultConfig()
+	live := newLiveConfig(current, h, store)
+
+	agents := filepath.Join(t.TempDir(), "agents.json")
+	require.NoError(t, os.WriteFile(agents, []byte(`{"default": {"report_interval": 10}}`), 0666))
+
+	next := current
+	next.Endpoint = "localhost:9090"
+	next.HashKey = "secret"
+	next.AgentConfig = agents
+	next.LogLevel = "warn"
+	require.NoError(t, live.apply(next))
+
+	assert.Equal(t, "localhost:8080", live.current.Endpoint)
+	assert.Equal(t, "secret", live.current.HashKey)
+	assert.Equal(t, "warn", logger.Level())
+
+	req, _ := http.NewRequest(http.MethodGet, "/agent-config", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("HashSHA256"))
+
+	wrong := next
+	wrong.AgentConfig = filepath.Join(t.TempDir(), "not_exists.json")
+	assert.Error(t, live.apply(wrong))
+	assert.Equal(t, "secret", live.current.HashKey)
+
+	require.NoError(t, logger.SetLevel("info"))
+}
+
+func TestRestartRequired(t *testing.T) {
+	current := NewDefaultConfig()
+	next := current
+	next.HashKey = "secret"
+	assert.Empty(t, restartRequired(current, next))
+
+	next.Endpoint = ":9090"
+	next.BaseDNS = "dsn"
+	assert.Equal(t, []string{"address", "database_dsn"}, restartRequired(current, next))
+}
+
+func TestRunReloadOnSignal(t *testing.T) {
+	_ = logger.Set()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloaded := make(chan struct{}, 1)
+	runReloadOnSignal(ctx, func() {
+		reloaded <- struct{}{}
+	})
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("reload was not called")
+	}
+}
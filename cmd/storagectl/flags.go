@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"ya-prac-project1/internal/config"
+)
+
+const (
+	hashKeyDefault   = ""
+	cryptoKeyDefault = ""
+	timeoutDefault   = time.Minute
+)
+
+// envFlags сопоставляет переменные окружения с флагами утилиты
+var envFlags = map[string]string{
+	"KEY":        "k",
+	"CRYPTO_KEY": "crypto-key",
+}
+
+// CtlConfig представляет настройки утилиты. Ключи и таймаут используются для репозиториев,
+// доступных через запущенный сервер
+type CtlConfig struct {
+	HashKey   string          `json:"hash_key"`
+	CryptoKey string          `json:"crypto_key"`
+	Timeout   config.Duration `json:"timeout"`
+}
+
+func NewDefaultConfig() CtlConfig {
+	return CtlConfig{
+		HashKey:   hashKeyDefault,
+		CryptoKey: cryptoKeyDefault,
+		Timeout:   config.NewDuration(timeoutDefault),
+	}
+}
+
+// readConfig собирает настройки утилиты и возвращает их вместе с командой и ее аргументами
+func readConfig(fs *flag.FlagSet, args []string) (CtlConfig, []string, error) {
+	c := NewDefaultConfig()
+
+	fs.StringVar(&c.HashKey, "k", c.HashKey, "server hash key")
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, "server public crypto key")
+	fs.Var(&c.Timeout, "timeout", "server request timeout, e.g. 1m")
+
+	err := config.Load(fs, args, &c, config.Options{
+		ConfigFlag: "c",
+		ConfigEnv:  "CONFIG",
+		Env:        envFlags,
+	})
+	if err != nil {
+		return c, nil, err
+	}
+
+	if err = c.Validate(); err != nil {
+		return c, nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	return c, fs.Args(), nil
+}
+
+// Validate проверяет настройки утилиты
+func (c CtlConfig) Validate() error {
+	var errs []error
+	if c.Timeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("timeout: must be positive, got %s", c.Timeout))
+	}
+
+	if c.CryptoKey != "" {
+		if _, err := os.Stat(c.CryptoKey); err != nil {
+			errs = append(errs, fmt.Errorf("crypto_key: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
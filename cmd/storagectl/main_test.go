@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"ya-prac-project1/internal/handlers"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/filestorage"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.Set()
+	os.Exit(m.Run())
+}
+
+func runCtl(t *testing.T, stdin string, args ...string) (string, error) {
+	out := bytes.NewBuffer(nil)
+	err := run(context.Background(), args, strings.NewReader(stdin), out)
+	return out.String(), err
+}
+
+// newFileStore создает файловый репозиторий с метриками ms и возвращает его адрес для утилиты
+func newFileStore(t *testing.T, ms ...metrics.Metrics) string {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s, err := filestorage.NewStorage(context.Background(), path, false, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.UpsertMetrics(context.Background(), ms))
+	require.NoError(t, s.Close())
+
+	return "file:" + path
+}
+
+func newTestServer(t *testing.T, ms ...metrics.Metrics) string {
+	store := inmemstorage.NewStorage()
+	store.SetMetrics(ms)
+	h := handlers.New(services.NewMetricSaverService(store), nil, "secret", "")
+	h.Mount()
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func testMetrics() []metrics.Metrics {
+	return []metrics.Metrics{
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "42"),
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+	}
+}
+
+func TestStoreKind(t *testing.T) {
+	tests := []struct {
+		spec string
+		kind string
+		addr string
+		err  string
+	}{
+		{spec: "http://localhost:8080", kind: kindServer, addr: "http://localhost:8080"},
+		{spec: "postgres://user@localhost/metrics", kind: kindPostgres, addr: "postgres://user@localhost/metrics"},
+		{spec: "file:/tmp/metrics.json", kind: kindFile, addr: "/tmp/metrics.json"},
+		{spec: "bolt:metrics.db", kind: kindBolt, addr: "metrics.db"},
+		{spec: "snapshot:backup.ndjson.gz", kind: kindSnapshot, addr: "backup.ndjson.gz"},
+		{spec: "metrics.json", err: "want http://"},
+		{spec: "file:", err: "want http://"},
+		{spec: "sqlite:metrics.db", err: `unknown kind "sqlite"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			kind, addr, err := storeKind(tt.spec)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.kind, kind)
+			assert.Equal(t, tt.addr, addr)
+		})
+	}
+}
+
+// TestRun_exportFileReadOnly проверяет, что выгрузка файлового репозитория не переписывает
+// снимок и журналы сервера
+func TestRun_exportFileReadOnly(t *testing.T) {
+	source := newFileStore(t, testMetrics()...)
+	dir := filepath.Dir(strings.TrimPrefix(source, "file:"))
+	files := func() map[string]string {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		contents := make(map[string]string, len(entries))
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			require.NoError(t, err)
+			contents[e.Name()] = string(data)
+		}
+		return contents
+	}
+	before := files()
+
+	_, err := runCtl(t, "", "export", source, filepath.Join(t.TempDir(), "backup.ndjson"))
+	require.NoError(t, err)
+	_, err = runCtl(t, "", "verify", source, source)
+	require.NoError(t, err)
+
+	assert.Equal(t, before, files())
+}
+
+func TestRun_migrate(t *testing.T) {
+	source := newFileStore(t, testMetrics()...)
+	target := "bolt:" + filepath.Join(t.TempDir(), "metrics.db")
+	backup := filepath.Join(t.TempDir(), "backup.ndjson.gz")
+
+	out, err := runCtl(t, "", "export", source, backup)
+	require.NoError(t, err)
+	assert.Equal(t, "exported 2 metrics from file to "+backup+"\n", out)
+
+	out, err = runCtl(t, "", "import", backup, target)
+	require.NoError(t, err)
+	assert.Equal(t, "imported 2 metrics into bolt, replaced 0\n", out)
+
+	out, err = runCtl(t, "", "verify", source, target)
+	require.NoError(t, err)
+	assert.Equal(t, "stores match, 2 metrics\n", out)
+
+	// повторная загрузка прибавила бы счетчики
+	_, err = runCtl(t, "", "import", backup, target)
+	assert.ErrorContains(t, err, "bolt already has 2 of 2 imported metrics")
+
+	out, err = runCtl(t, "", "import", "-replace", backup, target)
+	require.NoError(t, err)
+	assert.Equal(t, "imported 2 metrics into bolt, replaced 2\n", out)
+
+	_, err = runCtl(t, "", "verify", "snapshot:"+backup, target)
+	require.NoError(t, err)
+}
+
+func TestRun_server(t *testing.T) {
+	source := newTestServer(t, testMetrics()...)
+	target := newTestServer(t)
+
+	snapshot, err := runCtl(t, "", "-k", "secret", "export", source, "-")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(snapshot, `{"format":"metrics-snapshot","version":1,`))
+
+	_, err = runCtl(t, snapshot, "-k", "secret", "import", "-", target)
+	require.NoError(t, err)
+
+	out, err := runCtl(t, "", "-k", "secret", "verify", source, target)
+	require.NoError(t, err)
+	assert.Equal(t, "stores match, 2 metrics\n", out)
+}
+
+func TestRun_verifyDiff(t *testing.T) {
+	source := newFileStore(t, testMetrics()...)
+	target := newFileStore(t, metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "40"))
+
+	out, err := runCtl(t, "", "verify", source, target)
+	assert.EqualError(t, err, "stores differ in 2 metrics")
+	assert.Equal(t, "~ counter PollCount 42 -> 40\n- gauge Alloc 1.5\n", out)
+}
+
+func TestRun_errors(t *testing.T) {
+	_, err := runCtl(t, "")
+	assert.EqualError(t, err, "command is required")
+
+	_, err = runCtl(t, "", "copy")
+	assert.EqualError(t, err, `unknown command "copy"`)
+
+	_, err = runCtl(t, "", "export", "file:metrics.json")
+	assert.EqualError(t, err, "usage: export [-gzip] STORE FILE")
+
+	_, err = runCtl(t, "not a snapshot", "import", "-", "bolt:"+filepath.Join(t.TempDir(), "metrics.db"))
+	assert.ErrorContains(t, err, "read snapshot: line 1")
+
+	backup := filepath.Join(t.TempDir(), "backup.ndjson")
+	_, err = runCtl(t, "", "export", newFileStore(t, testMetrics()...), backup)
+	require.NoError(t, err)
+	_, err = runCtl(t, "", "import", "-replace", backup, "snapshot:"+backup)
+	assert.ErrorIs(t, err, errReadOnly)
+}
+
+func TestServerStore_delete(t *testing.T) {
+	c := NewDefaultConfig()
+	c.HashKey = "secret"
+	s, _, err := openStore(context.Background(), newTestServer(t, testMetrics()...), c, false)
+	require.NoError(t, err)
+
+	key := storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"}
+	require.NoError(t, s.Delete(context.Background(), key))
+	assert.ErrorIs(t, s.Delete(context.Background(), key), storage.ErrNotFound)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"ya-prac-project1/internal/client"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/boltstorage"
+	"ya-prac-project1/internal/storage/databasestorage"
+	"ya-prac-project1/internal/storage/filestorage"
+	"ya-prac-project1/internal/storage/snapshot"
+)
+
+// Виды репозиториев
+const (
+	kindServer   = "server"
+	kindPostgres = "postgres"
+	kindFile     = "file"
+	kindBolt     = "bolt"
+	kindSnapshot = "snapshot"
+)
+
+// store репозиторий, из которого выгружаются и в который загружаются метрики
+type store interface {
+	List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error)
+	UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error
+	Delete(ctx context.Context, key storage.Key) error
+	Close() error
+}
+
+// storeKind возвращает вид репозитория и его адрес по строке вида
+// http://host:port, postgres://..., file:PATH, bolt:PATH или snapshot:PATH
+func storeKind(spec string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return kindServer, spec, nil
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		return kindPostgres, spec, nil
+	}
+
+	kind, path, ok := strings.Cut(spec, ":")
+	if !ok || path == "" {
+		return "", "", fmt.Errorf("store %q: want http://, postgres://, file:, bolt: or snapshot:", spec)
+	}
+
+	switch kind {
+	case kindFile, kindBolt, kindSnapshot:
+		return kind, path, nil
+	}
+
+	return "", "", fmt.Errorf("store %q: unknown kind %q", spec, kind)
+}
+
+// openStore открывает репозиторий по строке spec. Если write не установлен, репозиторий
+// только читается: файловый репозиторий открывается без записи снимка и сжатия журналов
+func openStore(ctx context.Context, spec string, c CtlConfig, write bool) (store, string, error) {
+	kind, addr, err := storeKind(spec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var s store
+	switch kind {
+	case kindServer:
+		s = &serverStore{client: client.New(addr, c.HashKey, c.CryptoKey, c.Timeout.Duration)}
+	case kindPostgres:
+		s, err = openDatabase(addr)
+	case kindFile:
+		if !write {
+			s, err = filestorage.Open(addr)
+			break
+		}
+		// синхронный режим: каждое изменение сбрасывается на диск до возврата
+		s, err = filestorage.NewStorage(ctx, addr, true, 0)
+	case kindBolt:
+		s, err = boltstorage.NewStorage(addr)
+	case kindSnapshot:
+		s = &snapshotStore{path: addr}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("open %s store: %w", kind, err)
+	}
+
+	return s, kind, nil
+}
+
+// openDatabase подключается к базе и приводит ее схему к последней версии
+func openDatabase(dsn string) (*databasestorage.Storage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := databasestorage.NewStorage(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// serverStore репозиторий запущенного сервера, доступный через его api
+type serverStore struct {
+	client *client.Client
+}
+
+func (s *serverStore) List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	items, err := s.client.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := items[:0]
+	for _, m := range items {
+		if filter.Match(m) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (s *serverStore) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	return s.client.Push(ctx, ms)
+}
+
+func (s *serverStore) Delete(ctx context.Context, key storage.Key) error {
+	err := s.client.Delete(ctx, key.MType, key.ID)
+	if errors.Is(err, client.ErrNotFound) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+func (s *serverStore) Close() error {
+	return nil
+}
+
+// errReadOnly возвращается при попытке изменить снимок как репозиторий
+var errReadOnly = errors.New("snapshot store is read-only")
+
+// snapshotStore снимок, открытый как репозиторий только для чтения
+type snapshotStore struct {
+	path string
+}
+
+func (s *snapshotStore) List(_ context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	_, items, err := readSnapshotFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := items[:0]
+	for _, m := range items {
+		if filter.Match(m) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (s *snapshotStore) UpsertMetrics(context.Context, []metrics.Metrics) error {
+	return errReadOnly
+}
+
+func (s *snapshotStore) Delete(context.Context, storage.Key) error {
+	return errReadOnly
+}
+
+func (s *snapshotStore) Close() error {
+	return nil
+}
+
+// readSnapshotFile читает снимок из файла
+func readSnapshotFile(path string) (snapshot.Header, []metrics.Metrics, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return snapshot.Header{}, nil, err
+	}
+	defer file.Close()
+
+	return snapshot.Read(file)
+}
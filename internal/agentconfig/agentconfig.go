@@ -0,0 +1,108 @@
+// Package agentconfig предоставляет настройки агента, которые сервер раздает агентам
+package agentconfig
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"ya-prac-project1/internal/config"
+)
+
+const (
+	// CollectorRuntime — сборщик метрик рантайма go
+	CollectorRuntime = "runtime"
+	// CollectorSystem — сборщик метрик системы: процессоры и память
+	CollectorSystem = "system"
+)
+
+// Collectors возвращает все известные сборщики метрик
+func Collectors() []string {
+	return []string{CollectorRuntime, CollectorSystem}
+}
+
+// Settings представляет настройки агента, которые можно применить без перезапуска
+type Settings struct {
+	ReportInterval config.Duration `json:"report_interval"`
+	PoolInterval   config.Duration `json:"poll_interval"`
+	RateLimit      int             `json:"rate_limit,omitempty"`
+	Collectors     []string        `json:"collectors,omitempty"`
+}
+
+// Validate проверяет настройки и возвращает все найденные ошибки
+func (s Settings) Validate() error {
+	var errs []error
+	if s.ReportInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("report_interval: must be positive, got %s", s.ReportInterval))
+	}
+
+	if s.PoolInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("poll_interval: must be positive, got %s", s.PoolInterval))
+	}
+
+	if s.RateLimit <= 0 {
+		errs = append(errs, fmt.Errorf("rate_limit: must be positive, got %d", s.RateLimit))
+	}
+
+	for _, collector := range s.Collectors {
+		if !slices.Contains(Collectors(), collector) {
+			errs = append(errs, fmt.Errorf("collectors: unknown collector %q, known are %v", collector, Collectors()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Override возвращает копию настроек, в которой заданные в o поля заменены
+func (s Settings) Override(o Settings) Settings {
+	if o.ReportInterval.Duration != 0 {
+		s.ReportInterval = o.ReportInterval
+	}
+
+	if o.PoolInterval.Duration != 0 {
+		s.PoolInterval = o.PoolInterval
+	}
+
+	if o.RateLimit != 0 {
+		s.RateLimit = o.RateLimit
+	}
+
+	if o.Collectors != nil {
+		s.Collectors = slices.Clone(o.Collectors)
+	}
+
+	return s
+}
+
+// Equal сравнивает настройки
+func (s Settings) Equal(o Settings) bool {
+	return s.ReportInterval == o.ReportInterval &&
+		s.PoolInterval == o.PoolInterval &&
+		s.RateLimit == o.RateLimit &&
+		slices.Equal(s.Collectors, o.Collectors)
+}
+
+// Source представляет набор настроек агентов: общие и переопределенные для отдельных хостов
+type Source struct {
+	Default Settings            `json:"default"`
+	Hosts   map[string]Settings `json:"hosts,omitempty"`
+}
+
+// Load читает набор настроек агентов из json файла
+func Load(path string) (*Source, error) {
+	source := &Source{}
+	if err := config.LoadFile(path, source); err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// Get возвращает настройки для агента на хосте host
+func (s *Source) Get(host string) Settings {
+	settings := Settings{}.Override(s.Default)
+	if hostSettings, ok := s.Hosts[host]; ok {
+		settings = settings.Override(hostSettings)
+	}
+
+	return settings
+}
@@ -0,0 +1,68 @@
+package agentconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"ya-prac-project1/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	s := Settings{ReportInterval: config.Seconds(2), PoolInterval: config.Seconds(1), RateLimit: 1, Collectors: Collectors()}
+	assert.NoError(t, s.Validate())
+
+	wrong := s
+	wrong.ReportInterval = config.Seconds(0)
+	assert.Error(t, wrong.Validate())
+
+	wrong = s
+	wrong.PoolInterval = config.Seconds(-1)
+	assert.Error(t, wrong.Validate())
+
+	wrong = s
+	wrong.RateLimit = 0
+	assert.Error(t, wrong.Validate())
+
+	wrong = s
+	wrong.Collectors = []string{"unknown"}
+	assert.Error(t, wrong.Validate())
+}
+
+func TestOverride(t *testing.T) {
+	s := Settings{ReportInterval: config.Seconds(2), PoolInterval: config.Seconds(1), RateLimit: 1, Collectors: Collectors()}
+
+	actual := s.Override(Settings{ReportInterval: config.Seconds(10), Collectors: []string{}})
+	expect := Settings{ReportInterval: config.Seconds(10), PoolInterval: config.Seconds(1), RateLimit: 1, Collectors: []string{}}
+	assert.Equal(t, expect, actual)
+	assert.True(t, expect.Equal(actual))
+	assert.False(t, s.Equal(actual))
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.json")
+	data := `{
+		// общие настройки
+		"default": {"report_interval": "10s", "poll_interval": 2, "rate_limit": 1},
+		"hosts": {"host1": {"report_interval": "5s", "collectors": ["runtime"]}},
+	}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0666))
+
+	source, err := Load(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, Settings{ReportInterval: config.Seconds(10), PoolInterval: config.Seconds(2), RateLimit: 1}, source.Get("host2"))
+	assert.Equal(t, Settings{ReportInterval: config.Seconds(5), PoolInterval: config.Seconds(2), RateLimit: 1, Collectors: []string{"runtime"}}, source.Get("host1"))
+}
+
+func TestLoad_wrong(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "not_exists.json"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "agents.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0666))
+	_, err = Load(path)
+	assert.Error(t, err)
+}
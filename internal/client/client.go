@@ -0,0 +1,224 @@
+// Package client предоставляет клиент http api сервера метрик.
+//
+// Запросы подписываются и шифруются так же, как это делает агент, поэтому клиент
+// работает и с сервером, у которого заданы ключ подписи и ключ шифрования
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	random "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"ya-prac-project1/internal/metrics"
+)
+
+const (
+	// BatchIDHeader заголовок с уникальным идентификатором пачки метрик, сервер по нему отбрасывает повторы
+	BatchIDHeader = "X-Batch-ID"
+	// HashHeader заголовок с подписью тела запроса
+	HashHeader = "HashSHA256"
+)
+
+// ErrNotFound возвращается, если сервер не нашел метрику
+var ErrNotFound = errors.New("metric not found")
+
+// Client представляет клиент сервера метрик
+type Client struct {
+	endpoint  string
+	hashKey   string
+	cryptoKey string
+	http      *http.Client
+}
+
+// New создает клиент. endpoint может быть адресом host:port или url с протоколом,
+// cryptoKey — путь к публичному ключу шифрования
+func New(endpoint, hashKey, cryptoKey string, timeout time.Duration) *Client {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+
+	return &Client{
+		endpoint:  strings.TrimRight(endpoint, "/"),
+		hashKey:   hashKey,
+		cryptoKey: cryptoKey,
+		http:      &http.Client{Timeout: timeout},
+	}
+}
+
+// Get возвращает метрику по типу и имени
+func (c *Client) Get(ctx context.Context, mType, name string) (metrics.Metrics, error) {
+	metric := metrics.Metrics{MType: mType, ID: name}
+	body, err := c.do(ctx, http.MethodPost, "/value/", metric, nil)
+	if err != nil {
+		return metric, err
+	}
+
+	if err = json.Unmarshal(body, &metric); err != nil {
+		return metric, fmt.Errorf("decode metric: %w", err)
+	}
+
+	return metric, nil
+}
+
+// List возвращает все метрики сервера
+func (c *Client) List(ctx context.Context) ([]metrics.Metrics, error) {
+	body, err := c.do(ctx, http.MethodGet, "/values/", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []metrics.Metrics{}
+	if err = json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("decode metrics: %w", err)
+	}
+
+	return items, nil
+}
+
+// Push отправляет пачку метрик. Пачка помечается идентификатором, поэтому повторная
+// отправка после потерянного ответа не задвоит счетчики
+func (c *Client) Push(ctx context.Context, ms []metrics.Metrics) error {
+	return c.PushBatch(ctx, NewBatchID(), ms)
+}
+
+// PushBatch отправляет пачку метрик с заданным идентификатором. Повторы с тем же id
+// сервер отбрасывает, поэтому пачку можно переотправлять, пока она не будет принята
+func (c *Client) PushBatch(ctx context.Context, id string, ms []metrics.Metrics) error {
+	_, err := c.do(ctx, http.MethodPost, "/updates/", ms, map[string]string{BatchIDHeader: id})
+	return err
+}
+
+// Delete удаляет метрику по типу и имени
+func (c *Client) Delete(ctx context.Context, mType, name string) error {
+	path := fmt.Sprintf("/value/%s/%s", url.PathEscape(mType), url.PathEscape(name))
+	_, err := c.do(ctx, http.MethodDelete, path, nil, nil)
+	return err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, payload any, headers map[string]string) ([]byte, error) {
+	req, err := c.newRequest(ctx, method, path, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	response, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSpace(string(body)))
+	default:
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+// newRequest собирает запрос: тело в json сжимается, шифруется и подписывается
+func (c *Client) newRequest(ctx context.Context, method, path string, payload any) (*http.Request, error) {
+	if payload == nil {
+		return http.NewRequestWithContext(ctx, method, c.endpoint+path, nil)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err = gw.Write(data); err != nil {
+		return nil, fmt.Errorf("compress request: %w", err)
+	}
+	if err = gw.Close(); err != nil {
+		return nil, fmt.Errorf("compress request: %w", err)
+	}
+
+	body := buf.Bytes()
+	if c.cryptoKey != "" {
+		if body, err = Encrypt(body, c.cryptoKey); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.hashKey != "" {
+		req.Header.Set(HashHeader, Sign(body, c.hashKey))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	return req, nil
+}
+
+// NewBatchID генерирует уникальный идентификатор пачки метрик
+func NewBatchID() string {
+	b := make([]byte, 16)
+	if _, err := random.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Sign возвращает подпись тела запроса ключом key
+func Sign(body []byte, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Encrypt шифрует тело запроса публичным ключом из файла cryptoKey
+func Encrypt(body []byte, cryptoKey string) ([]byte, error) {
+	pubKeyBytes, err := os.ReadFile(cryptoKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't read public key: %w", err)
+	}
+
+	block, _ := pem.Decode(pubKeyBytes)
+	if block == nil || block.Type != "RSA PUBLIC KEY" {
+		return nil, errors.New("failed to decode PEM block containing public key")
+	}
+
+	publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse public key: %w", err)
+	}
+
+	encrypted, err := rsa.EncryptPKCS1v15(random.Reader, publicKey, body)
+	if err != nil {
+		return nil, fmt.Errorf("can't encrypt message: %w", err)
+	}
+
+	return encrypted, nil
+}
@@ -0,0 +1,116 @@
+package client_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/client"
+	"ya-prac-project1/internal/handlers"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeys создает пару ключей шифрования и возвращает пути к публичному и приватному ключам
+func writeKeys(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	public := filepath.Join(dir, "public.pem")
+	private := filepath.Join(dir, "private.pem")
+
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(public, publicPEM, 0600))
+	require.NoError(t, os.WriteFile(private, privatePEM, 0600))
+
+	return public, private
+}
+
+func newTestServer(t *testing.T, hashKey, cryptoKey string) *httptest.Server {
+	logger.Set()
+	h := handlers.New(services.NewMetricSaverService(inmemstorage.NewStorage()), nil, hashKey, cryptoKey)
+	h.Mount()
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	public, private := writeKeys(t)
+	server := newTestServer(t, "secret", private)
+	c := client.New(server.URL, "secret", public, time.Second)
+	ctx := context.Background()
+
+	err := c.Push(ctx, []metrics.Metrics{
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "2"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.Push(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "3")}))
+
+	metric, err := c.Get(ctx, metrics.MetricTypeCounter, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, "5", metric.GetValue())
+
+	items, err := c.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	require.NoError(t, c.Delete(ctx, metrics.MetricTypeGauge, "Alloc"))
+	_, err = c.Get(ctx, metrics.MetricTypeGauge, "Alloc")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	assert.ErrorIs(t, c.Delete(ctx, metrics.MetricTypeGauge, "Alloc"), client.ErrNotFound)
+}
+
+func TestClient_PushBatch(t *testing.T) {
+	server := newTestServer(t, "", "")
+	c := client.New(server.URL, "", "", time.Second)
+	ctx := context.Background()
+
+	// повтор пачки с тем же идентификатором не задваивает счетчик
+	batch := []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "2")}
+	require.NoError(t, c.PushBatch(ctx, "batch-1", batch))
+	require.NoError(t, c.PushBatch(ctx, "batch-1", batch))
+
+	metric, err := c.Get(ctx, metrics.MetricTypeCounter, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, "2", metric.GetValue())
+}
+
+func TestClient_wrongKey(t *testing.T) {
+	server := newTestServer(t, "secret", "")
+	c := client.New(server.URL, "wrong", "", time.Second)
+
+	err := c.Push(context.Background(), []metrics.Metrics{metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")})
+	assert.ErrorContains(t, err, "400")
+}
+
+func TestEncrypt(t *testing.T) {
+	public, _ := writeKeys(t)
+	encrypted, err := client.Encrypt([]byte("message"), public)
+	require.NoError(t, err)
+	assert.NotEqual(t, []byte("message"), encrypted)
+
+	_, err = client.Encrypt([]byte("message"), filepath.Join(t.TempDir(), "not_exists.pem"))
+	assert.Error(t, err)
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, client.Sign([]byte("body"), "key"), client.Sign([]byte("body"), "key"))
+	assert.NotEqual(t, client.Sign([]byte("body"), "key"), client.Sign([]byte("body"), "other"))
+	assert.Len(t, client.NewBatchID(), 32)
+}
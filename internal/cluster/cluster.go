@@ -0,0 +1,490 @@
+// Package cluster координирует несколько экземпляров сервера, работающих с одной базой Postgres.
+//
+// Каждый экземпляр периодически отмечается в таблице cluster_nodes, по ней строится список
+// участников. Лидер выбирается через сессионную advisory lock: экземпляр, который ее взял,
+// держит отдельное соединение и запускает задачи, которые должны выполняться в одном
+// экземпляре (Cluster.Go), например свертку и удаление старой истории. Если соединение
+// лидера рвется, блокировку забирает другой экземпляр.
+//
+// Изменения метрик рассылаются через LISTEN/NOTIFY: Storage после записи отправляет ключи
+// измененных метрик, остальные экземпляры перечитывают их в свой кеш
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+)
+
+const (
+	// Channel канал уведомлений об изменении метрик
+	Channel = "metrics_changed"
+	// DefaultLockID ключ advisory lock лидера по умолчанию
+	DefaultLockID int64 = 0x6d6574726963
+	// DefaultHeartbeat интервал отметки экземпляра и проверки лидерства по умолчанию
+	DefaultHeartbeat = 5 * time.Second
+
+	// maxPayload предел размера уведомления, Postgres принимает не больше 8000 байтов
+	maxPayload = 7900
+	// staleHeartbeats число пропущенных отметок, после которого экземпляр считается выбывшим
+	staleHeartbeats = 3
+)
+
+// Options настройки экземпляра
+type Options struct {
+	// NodeID идентификатор экземпляра, по умолчанию имя хоста со случайным суффиксом
+	NodeID string
+	// Address адрес, на котором экземпляр принимает запросы
+	Address string
+	// Heartbeat интервал отметки экземпляра и проверки лидерства
+	Heartbeat time.Duration
+	// LockID ключ advisory lock лидера
+	LockID int64
+}
+
+// Member участник кластера
+type Member struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Leader    bool      `json:"leader"`
+}
+
+// Status состояние кластера с точки зрения экземпляра
+type Status struct {
+	// Self идентификатор экземпляра
+	Self string `json:"self"`
+	// Leader идентификатор лидера, пустой, если лидер не выбран
+	Leader  string   `json:"leader"`
+	Members []Member `json:"members"`
+}
+
+// InvalidateFunc перечитывает метрики keys, пустой список означает все метрики
+type InvalidateFunc func(ctx context.Context, keys []storage.Key) error
+
+// Cluster экземпляр сервера в кластере
+type Cluster struct {
+	db        *sql.DB
+	opts      Options
+	startedAt time.Time
+	leader    atomic.Bool
+
+	// mu защищает задачи лидера и обработчик уведомлений
+	mu         sync.Mutex
+	jobs       []func(ctx context.Context)
+	leaderCtx  context.Context
+	invalidate InvalidateFunc
+
+	// lock соединение, в сессии которого взята блокировка лидера, используется только в Run
+	lock     *sql.Conn
+	stopJobs context.CancelFunc
+	jobsWG   sync.WaitGroup
+}
+
+// New создает экземпляр кластера поверх базы db. Таблица cluster_nodes создается миграциями
+// databasestorage
+func New(db *sql.DB, opts Options) (*Cluster, error) {
+	if db == nil {
+		return nil, errors.New("cluster requires database connection")
+	}
+
+	if opts.NodeID == "" {
+		id, err := newNodeID()
+		if err != nil {
+			return nil, err
+		}
+		opts.NodeID = id
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = DefaultHeartbeat
+	}
+	if opts.LockID == 0 {
+		opts.LockID = DefaultLockID
+	}
+
+	return &Cluster{db: db, opts: opts, startedAt: time.Now()}, nil
+}
+
+// newNodeID возвращает имя хоста со случайным суффиксом, чтобы экземпляры на одном хосте различались
+func newNodeID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err = rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return host + "-" + hex.EncodeToString(suffix), nil
+}
+
+// ID возвращает идентификатор экземпляра
+func (c *Cluster) ID() string {
+	return c.opts.NodeID
+}
+
+// IsLeader сообщает, является ли экземпляр лидером
+func (c *Cluster) IsLeader() bool {
+	return c.leader.Load()
+}
+
+// Go регистрирует задачу, которая выполняется только на лидере. Задача запускается, когда
+// экземпляр становится лидером, и должна завершиться при отмене ctx — потере лидерства
+func (c *Cluster) Go(job func(ctx context.Context)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.jobs = append(c.jobs, job)
+	if c.leaderCtx != nil {
+		c.startJob(c.leaderCtx, job)
+	}
+}
+
+// startJob запускает задачу лидера. Вызывается под c.mu
+func (c *Cluster) startJob(ctx context.Context, job func(ctx context.Context)) {
+	c.jobsWG.Add(1)
+	go func() {
+		defer c.jobsWG.Done()
+		job(ctx)
+	}()
+}
+
+// OnInvalidate задает обработчик изменений метрик, сделанных другими экземплярами
+func (c *Cluster) OnInvalidate(fn InvalidateFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate = fn
+}
+
+// Run отмечает экземпляр, участвует в выборе лидера и слушает уведомления до отмены ctx.
+// При завершении экземпляр снимает блокировку лидера и удаляет свою запись
+func (c *Cluster) Run(ctx context.Context) {
+	listenDone := make(chan struct{})
+	go func() {
+		defer close(listenDone)
+		c.listen(ctx)
+	}()
+
+	ticker := time.NewTicker(c.opts.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		if err := c.step(ctx); err != nil && ctx.Err() == nil {
+			logger.Get().Info("cluster heartbeat error", zap.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			c.resign()
+			if _, err := c.db.ExecContext(context.Background(), getDeleteNodeSQL(), c.opts.NodeID); err != nil {
+				logger.Get().Info("cluster leave error", zap.String("error", err.Error()))
+			}
+			<-listenDone
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// step проверяет соединение лидера или пытается взять блокировку и обновляет запись экземпляра
+func (c *Cluster) step(ctx context.Context) error {
+	if c.lock != nil {
+		if err := c.lock.PingContext(ctx); err != nil {
+			logger.Get().Info("cluster leader connection lost", zap.String("error", err.Error()))
+			c.resign()
+		}
+	}
+
+	if c.lock == nil {
+		if err := c.tryLead(ctx); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.db.ExecContext(ctx, getUpsertNodeSQL(), c.opts.NodeID, c.opts.Address, c.startedAt, c.IsLeader())
+	if err != nil {
+		return err
+	}
+
+	if c.IsLeader() {
+		_, err = c.db.ExecContext(ctx, getDeleteStaleNodesSQL(), c.staleAfter().Seconds())
+	}
+	return err
+}
+
+// tryLead пытается взять блокировку лидера в отдельном соединении и запускает задачи лидера
+func (c *Cluster) tryLead(ctx context.Context) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var locked bool
+	if err = conn.QueryRowContext(ctx, getTryLockSQL(), c.opts.LockID).Scan(&locked); err != nil || !locked {
+		conn.Close()
+		return err
+	}
+
+	c.lock = conn
+	c.leader.Store(true)
+	logger.Get().Info("cluster leader elected", zap.String("node", c.opts.NodeID))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	leaderCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	c.leaderCtx, c.stopJobs = leaderCtx, stop
+	for _, job := range c.jobs {
+		c.startJob(leaderCtx, job)
+	}
+
+	return nil
+}
+
+// resign останавливает задачи лидера и снимает блокировку. Если снять ее не удалось, соединение
+// закрывается: блокировка сессии снимается вместе с ней
+func (c *Cluster) resign() {
+	if c.lock == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.stopJobs()
+	c.leaderCtx, c.stopJobs = nil, nil
+	c.mu.Unlock()
+	c.jobsWG.Wait()
+	c.leader.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Heartbeat)
+	defer cancel()
+
+	if _, err := c.lock.ExecContext(ctx, getUnlockSQL(), c.opts.LockID); err != nil {
+		c.lock.Raw(func(driverConn any) error {
+			if conn, ok := driverConn.(*stdlib.Conn); ok {
+				return conn.Close()
+			}
+			return nil
+		})
+	}
+	c.lock.Close()
+	c.lock = nil
+
+	logger.Get().Info("cluster leadership released", zap.String("node", c.opts.NodeID))
+}
+
+// staleAfter возвращает время без отметки, после которого экземпляр считается выбывшим
+func (c *Cluster) staleAfter() time.Duration {
+	return staleHeartbeats * c.opts.Heartbeat
+}
+
+// Status возвращает участников кластера, которые отмечались недавно
+func (c *Cluster) Status(ctx context.Context) (Status, error) {
+	rows, err := c.db.QueryContext(ctx, getSelectNodesSQL(), c.staleAfter().Seconds())
+	if err != nil {
+		return Status{}, err
+	}
+	defer rows.Close()
+
+	status := Status{Self: c.opts.NodeID, Members: []Member{}}
+	for rows.Next() {
+		m := Member{}
+		if err = rows.Scan(&m.ID, &m.Address, &m.StartedAt, &m.LastSeen, &m.Leader); err != nil {
+			return Status{}, err
+		}
+		if m.Leader {
+			status.Leader = m.ID
+		}
+		status.Members = append(status.Members, m)
+	}
+
+	return status, rows.Err()
+}
+
+// notification уведомление об изменении метрик
+type notification struct {
+	// Node экземпляр, который изменил метрики, свои уведомления он пропускает
+	Node string `json:"node"`
+	// All требует перечитать все метрики, если ключи не поместились в уведомление
+	All  bool          `json:"all,omitempty"`
+	Keys []notifiedKey `json:"keys,omitempty"`
+}
+
+type notifiedKey struct {
+	MType string `json:"type"`
+	ID    string `json:"id"`
+}
+
+// encodeNotification кодирует уведомление об изменении keys. Слишком длинный список ключей
+// заменяется требованием перечитать все метрики
+func encodeNotification(node string, keys []storage.Key) (string, error) {
+	n := notification{Node: node, Keys: make([]notifiedKey, 0, len(keys))}
+	for _, key := range keys {
+		n.Keys = append(n.Keys, notifiedKey{MType: key.MType, ID: key.ID})
+	}
+
+	payload, err := json.Marshal(n)
+	if err != nil || len(payload) <= maxPayload {
+		return string(payload), err
+	}
+
+	payload, err = json.Marshal(notification{Node: node, All: true})
+	return string(payload), err
+}
+
+func decodeNotification(payload string) (notification, error) {
+	n := notification{}
+	err := json.Unmarshal([]byte(payload), &n)
+	return n, err
+}
+
+// Publish оповещает другие экземпляры об изменении метрик keys
+func (c *Cluster) Publish(ctx context.Context, keys []storage.Key) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	payload, err := encodeNotification(c.opts.NodeID, keys)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.ExecContext(ctx, getNotifySQL(), Channel, payload)
+	return err
+}
+
+// listen слушает уведомления до отмены ctx. После обрыва соединения экземпляр подписывается
+// заново и перечитывает все метрики: уведомления за время обрыва потеряны
+func (c *Cluster) listen(ctx context.Context) {
+	resync := false
+	for {
+		err := c.listenConn(ctx, resync)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Get().Info("cluster listen error", zap.String("error", err.Error()))
+
+		resync = true
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.opts.Heartbeat):
+		}
+	}
+}
+
+// listenConn подписывается на Channel в отдельном соединении и обрабатывает уведомления,
+// пока соединение работает
+func (c *Cluster) listenConn(ctx context.Context, resync bool) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		sc, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("cluster requires pgx driver")
+		}
+		// соединение с подпиской не возвращается в пул
+		defer sc.Close()
+
+		pc := sc.Conn()
+		if _, err := pc.Exec(ctx, "LISTEN "+Channel); err != nil {
+			return err
+		}
+
+		if resync {
+			c.handle(ctx, notification{All: true})
+		}
+
+		for {
+			n, err := pc.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+
+			msg, err := decodeNotification(n.Payload)
+			if err != nil {
+				logger.Get().Info("cluster notification error", zap.String("error", err.Error()))
+				continue
+			}
+			c.handle(ctx, msg)
+		}
+	})
+}
+
+// handle передает обработчику изменения из уведомления другого экземпляра
+func (c *Cluster) handle(ctx context.Context, n notification) {
+	if n.Node == c.opts.NodeID {
+		return
+	}
+
+	c.mu.Lock()
+	invalidate := c.invalidate
+	c.mu.Unlock()
+	if invalidate == nil {
+		return
+	}
+
+	var keys []storage.Key
+	if !n.All {
+		if len(n.Keys) == 0 {
+			return
+		}
+		keys = make([]storage.Key, 0, len(n.Keys))
+		for _, key := range n.Keys {
+			keys = append(keys, storage.Key{MType: key.MType, ID: key.ID})
+		}
+	}
+
+	if err := invalidate(ctx, keys); err != nil {
+		logger.Get().Info("cluster invalidate error", zap.String("error", err.Error()))
+	}
+}
+
+func getTryLockSQL() string {
+	return "SELECT pg_try_advisory_lock($1)"
+}
+
+func getUnlockSQL() string {
+	return "SELECT pg_advisory_unlock($1)"
+}
+
+func getNotifySQL() string {
+	return "SELECT pg_notify($1, $2)"
+}
+
+func getUpsertNodeSQL() string {
+	return `INSERT INTO cluster_nodes (id, address, started_at, last_seen, leader) VALUES ($1, $2, $3, now(), $4)
+	ON CONFLICT (id) DO UPDATE SET address = EXCLUDED.address, last_seen = now(), leader = EXCLUDED.leader`
+}
+
+func getSelectNodesSQL() string {
+	return `SELECT id, address, started_at, last_seen, leader FROM cluster_nodes
+	WHERE last_seen > now() - make_interval(secs => $1)
+	ORDER BY id`
+}
+
+func getDeleteStaleNodesSQL() string {
+	return "DELETE FROM cluster_nodes WHERE last_seen <= now() - make_interval(secs => $1)"
+}
+
+func getDeleteNodeSQL() string {
+	return "DELETE FROM cluster_nodes WHERE id = $1"
+}
@@ -0,0 +1,205 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/databasestorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, Options{})
+	assert.Error(t, err)
+
+	c, err := New(&sql.DB{}, Options{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, c.ID())
+	assert.Equal(t, DefaultHeartbeat, c.opts.Heartbeat)
+	assert.Equal(t, DefaultLockID, c.opts.LockID)
+	assert.False(t, c.IsLeader())
+
+	other, err := New(&sql.DB{}, Options{})
+	require.NoError(t, err)
+	assert.NotEqual(t, c.ID(), other.ID())
+}
+
+func TestNotification(t *testing.T) {
+	keys := []storage.Key{{MType: metrics.MetricTypeGauge, ID: "Alloc"}, {MType: metrics.MetricTypeCounter, ID: "PollCount"}}
+	payload, err := encodeNotification("node1", keys)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"node":"node1","keys":[{"type":"gauge","id":"Alloc"},{"type":"counter","id":"PollCount"}]}`, payload)
+
+	n, err := decodeNotification(payload)
+	require.NoError(t, err)
+	assert.Equal(t, "node1", n.Node)
+	assert.False(t, n.All)
+	assert.Len(t, n.Keys, 2)
+
+	// ключи, которые не помещаются в уведомление, заменяются требованием перечитать все
+	many := make([]storage.Key, 0, 500)
+	for i := 0; i < 500; i++ {
+		many = append(many, storage.Key{MType: metrics.MetricTypeGauge, ID: fmt.Sprintf("Metric%d", i)})
+	}
+	payload, err = encodeNotification("node1", many)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"node":"node1","all":true}`, payload)
+
+	_, err = decodeNotification("not json")
+	assert.Error(t, err)
+}
+
+func TestHandle(t *testing.T) {
+	c, err := New(&sql.DB{}, Options{NodeID: "self"})
+	require.NoError(t, err)
+
+	var calls [][]storage.Key
+	c.OnInvalidate(func(_ context.Context, keys []storage.Key) error {
+		calls = append(calls, keys)
+		return nil
+	})
+
+	ctx := context.Background()
+	c.handle(ctx, notification{Node: "self", Keys: []notifiedKey{{MType: "gauge", ID: "Alloc"}}})
+	c.handle(ctx, notification{Node: "other"})
+	assert.Empty(t, calls)
+
+	c.handle(ctx, notification{Node: "other", Keys: []notifiedKey{{MType: "gauge", ID: "Alloc"}}})
+	c.handle(ctx, notification{Node: "other", All: true})
+	assert.Equal(t, [][]storage.Key{{{MType: "gauge", ID: "Alloc"}}, nil}, calls)
+}
+
+func TestGetSQL(t *testing.T) {
+	assert.Contains(t, getTryLockSQL(), "pg_try_advisory_lock")
+	assert.Contains(t, getUnlockSQL(), "pg_advisory_unlock")
+	assert.Contains(t, getNotifySQL(), "pg_notify")
+	assert.Contains(t, getUpsertNodeSQL(), "ON CONFLICT (id) DO UPDATE")
+	assert.True(t, strings.HasPrefix(getSelectNodesSQL(), "SELECT id, address"))
+	getDeleteStaleNodesSQL()
+	getDeleteNodeSQL()
+	assert.Contains(t, getInsertForwardSentSQL(), "ON CONFLICT (target, key)")
+	getSelectForwardSentSQL()
+	getDeleteForwardSentSQL()
+}
+
+// newTestDB подключается к базе из TEST_DATABASE_DSN и применяет миграции, без нее тест пропускается
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	logger.Set()
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, databasestorage.MigrateUp(context.Background(), db))
+	return db
+}
+
+// TestCluster проверяет выбор лидера, передачу лидерства и рассылку изменений между экземплярами
+func TestCluster(t *testing.T) {
+	db := newTestDB(t)
+	opts := Options{Heartbeat: 100 * time.Millisecond, LockID: time.Now().UnixNano()}
+
+	first, err := New(db, Options{NodeID: "test-first", Address: ":8081", Heartbeat: opts.Heartbeat, LockID: opts.LockID})
+	require.NoError(t, err)
+	second, err := New(db, Options{NodeID: "test-second", Address: ":8082", Heartbeat: opts.Heartbeat, LockID: opts.LockID})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	invalidated := []storage.Key{}
+	second.OnInvalidate(func(_ context.Context, keys []storage.Key) error {
+		mu.Lock()
+		defer mu.Unlock()
+		invalidated = append(invalidated, keys...)
+		return nil
+	})
+
+	jobs := make(chan string, 2)
+	for _, c := range []*Cluster{first, second} {
+		c.Go(func(ctx context.Context) {
+			jobs <- c.ID()
+			<-ctx.Done()
+		})
+	}
+
+	ctx := context.Background()
+	firstCtx, stopFirst := context.WithCancel(ctx)
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(firstCtx)
+		close(firstDone)
+	}()
+	require.Equal(t, "test-first", <-jobs)
+
+	secondCtx, stopSecond := context.WithCancel(ctx)
+	secondDone := make(chan struct{})
+	go func() {
+		second.Run(secondCtx)
+		close(secondDone)
+	}()
+	defer func() {
+		stopSecond()
+		<-secondDone
+	}()
+
+	require.Eventually(t, func() bool {
+		status, err := second.Status(ctx)
+		return err == nil && status.Leader == "test-first" && len(status.Members) >= 2
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.False(t, second.IsLeader())
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, first.Publish(ctx, []storage.Key{{MType: metrics.MetricTypeGauge, ID: "Alloc"}}))
+		mu.Lock()
+		defer mu.Unlock()
+		return len(invalidated) > 0
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// после остановки лидера блокировку забирает второй экземпляр
+	stopFirst()
+	<-firstDone
+	assert.Equal(t, "test-second", <-jobs)
+	assert.True(t, second.IsLeader())
+}
+
+// TestForwardState проверяет, что экземпляры видят снимок пересылки, сохраненный другим экземпляром
+func TestForwardState(t *testing.T) {
+	db := newTestDB(t)
+	first, err := New(db, Options{NodeID: "test-first"})
+	require.NoError(t, err)
+	second, err := New(db, Options{NodeID: "test-second"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	target := fmt.Sprintf("http://upstream-%d", time.Now().UnixNano())
+	require.NoError(t, first.ForwardState(target).SaveSent(ctx, map[string]int64{"counter_a": 10, "counter_b": 3}))
+
+	sent, err := second.ForwardState(target).LoadSent(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"counter_a": 10, "counter_b": 3}, sent)
+
+	// снимок заменяется целиком, другие вышестоящие серверы его не видят
+	require.NoError(t, second.ForwardState(target).SaveSent(ctx, map[string]int64{"counter_a": 15}))
+	sent, err = first.ForwardState(target).LoadSent(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"counter_a": 15}, sent)
+
+	sent, err = first.ForwardState(target + "/other").LoadSent(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, sent)
+
+	require.NoError(t, first.ForwardState(target).SaveSent(ctx, nil))
+}
@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ForwardState хранит в базе значения счетчиков из последнего снимка, отправленного на вышестоящий
+// сервер. Снимки общего репозитория отправляет только лидер, и новый лидер продолжает от значений,
+// отправленных прежним, а не от своего локального состояния. Пачка, которая осталась в очереди
+// прежнего лидера, уходит, когда он снова станет лидером, и повторно не считается
+type ForwardState struct {
+	db     *sql.DB
+	target string
+}
+
+// ForwardState возвращает хранилище снимков пересылки на вышестоящий сервер target
+func (c *Cluster) ForwardState(target string) *ForwardState {
+	return &ForwardState{db: c.db, target: target}
+}
+
+// LoadSent возвращает значения счетчиков из последнего снимка
+func (s *ForwardState) LoadSent(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, getSelectForwardSentSQL(), s.target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sent := map[string]int64{}
+	for rows.Next() {
+		var key string
+		var delta int64
+		if err = rows.Scan(&key, &delta); err != nil {
+			return nil, err
+		}
+		sent[key] = delta
+	}
+
+	return sent, rows.Err()
+}
+
+// SaveSent заменяет значения последнего снимка в одной транзакции
+func (s *ForwardState) SaveSent(ctx context.Context, sent map[string]int64) error {
+	keys := make([]string, 0, len(sent))
+	deltas := make([]int64, 0, len(sent))
+	for key, delta := range sent {
+		keys = append(keys, key)
+		deltas = append(deltas, delta)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, getDeleteForwardSentSQL(), s.target); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if len(keys) > 0 {
+		if _, err = tx.ExecContext(ctx, getInsertForwardSentSQL(), s.target, keys, deltas); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func getSelectForwardSentSQL() string {
+	return "SELECT key, delta FROM forward_sent WHERE target = $1"
+}
+
+func getDeleteForwardSentSQL() string {
+	return "DELETE FROM forward_sent WHERE target = $1"
+}
+
+func getInsertForwardSentSQL() string {
+	return `INSERT INTO forward_sent (target, key, delta)
+	SELECT $1, unnest($2::text[]), unnest($3::bigint[])
+	ON CONFLICT (target, key) DO UPDATE SET delta = EXCLUDED.delta`
+}
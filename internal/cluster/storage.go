@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"context"
+	"slices"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// Backend общий для экземпляров репозиторий метрик
+type Backend interface {
+	Get(ctx context.Context, key storage.Key) (metrics.Metrics, error)
+	List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error)
+	UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error
+	Delete(ctx context.Context, key storage.Key) error
+	Close() error
+	UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error)
+	UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error)
+}
+
+// Storage репозиторий, который после каждого изменения оповещает другие экземпляры.
+// Ошибка оповещения не отменяет изменение: кеши других экземпляров обновятся при следующем
+type Storage struct {
+	Backend
+	cluster *Cluster
+}
+
+// Storage возвращает репозиторий backend, изменения которого рассылаются другим экземплярам
+func (c *Cluster) Storage(backend Backend) *Storage {
+	return &Storage{Backend: backend, cluster: c}
+}
+
+// UpsertMetrics применяет метрики в репозитории и рассылает их ключи
+func (s *Storage) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	if err := s.Backend.UpsertMetrics(ctx, ms); err != nil {
+		return err
+	}
+
+	s.publishMetrics(ctx, ms)
+	return nil
+}
+
+// UpsertBatch применяет пачку метрик в репозитории и, если она применена, рассылает ее ключи
+func (s *Storage) UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	applied, err := s.Backend.UpsertBatch(ctx, id, ms)
+	if err != nil || !applied {
+		return applied, err
+	}
+
+	s.publishMetrics(ctx, ms)
+	return true, nil
+}
+
+// UpsertTotals применяет метрики и итоги счетчиков в репозитории и рассылает их ключи
+func (s *Storage) UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	increments, err := s.Backend.UpsertTotals(ctx, ms, totals)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishMetrics(ctx, append(slices.Clip(ms), increments...))
+	return increments, nil
+}
+
+// publishMetrics рассылает ключи метрик без повторов
+func (s *Storage) publishMetrics(ctx context.Context, ms []metrics.Metrics) {
+	seen := make(map[storage.Key]struct{}, len(ms))
+	keys := make([]storage.Key, 0, len(ms))
+	for _, m := range ms {
+		key := storage.KeyOf(m)
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	s.publish(ctx, keys)
+}
+
+// Delete удаляет метрику из репозитория и рассылает ее ключ
+func (s *Storage) Delete(ctx context.Context, key storage.Key) error {
+	if err := s.Backend.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	s.publish(ctx, []storage.Key{key})
+	return nil
+}
+
+func (s *Storage) publish(ctx context.Context, keys []storage.Key) {
+	// изменение уже применено, поэтому оповещение отправляется, даже если запрос отменен
+	if err := s.cluster.Publish(context.WithoutCancel(ctx), keys); err != nil {
+		logger.Get().Info("cluster publish error", zap.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,172 @@
+// Package config предоставляет общий порядок чтения настроек сервера и агента.
+//
+// Настройки собираются по возрастанию приоритета: значения по умолчанию, json файл, переменные окружения, флаги.
+// Файл может содержать комментарии // и /* */, а также висячие запятые
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Options описывает, откуда читать настройки
+type Options struct {
+	// ConfigFlag имя флага с путем к файлу настроек
+	ConfigFlag string
+	// ConfigEnv имя переменной окружения с путем к файлу настроек
+	ConfigEnv string
+	// Env сопоставляет переменные окружения с именами флагов
+	Env map[string]string
+}
+
+// Load заполняет target настройками. target должен содержать значения по умолчанию,
+// а флаги в fs должны быть привязаны к его полям
+func Load(fs *flag.FlagSet, args []string, target any, opts Options) error {
+	configPath := fs.String(opts.ConfigFlag, "", "config path")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// значения флагов запоминаются, чтобы применить их поверх файла и окружения
+	setFlags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv(opts.ConfigEnv)
+	}
+
+	if path != "" {
+		if err := LoadFile(path, target); err != nil {
+			return err
+		}
+	}
+
+	envNames := make([]string, 0, len(opts.Env))
+	for name := range opts.Env {
+		envNames = append(envNames, name)
+	}
+	slices.Sort(envNames)
+
+	for _, name := range envNames {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if err := fs.Set(opts.Env[name], value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+
+	for name, value := range setFlags {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("flag -%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadFile читает настройки из json файла поверх уже заполненных полей target.
+// Неизвестные ключи считаются ошибкой
+func LoadFile(path string, target any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(StripComments(data)))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(target); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// StripComments убирает из json комментарии и висячие запятые, не трогая содержимое строк
+func StripComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	// comma позиция последней запятой в out, которую нужно убрать, если за ней идет закрывающая скобка
+	comma := -1
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+			continue
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i+1 < len(data) && !(data[i] == '*' && data[i+1] == '/') {
+				i++
+			}
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			out = append(out, c)
+			continue
+		case c == ',':
+			comma = len(out)
+			out = append(out, c)
+			continue
+		}
+
+		if comma >= 0 && (c == '}' || c == ']') {
+			out = append(out[:comma], out[comma+1:]...)
+		}
+		comma = -1
+
+		out = append(out, c)
+		if c == '"' {
+			inString = true
+		}
+	}
+
+	return out
+}
+
+// Print выводит настройки в формате json
+func Print(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// Mask скрывает секретное значение при выводе настроек
+func Mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return strings.Repeat("*", 8)
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Address  string   `json:"address"`
+	Interval Duration `json:"interval"`
+	Restore  bool     `json:"restore"`
+	Secret   string   `json:"secret"`
+}
+
+func newTestFlags(c *testConfig) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&c.Address, "a", c.Address, "address")
+	fs.Var(&c.Interval, "i", "interval")
+	fs.BoolVar(&c.Restore, "r", c.Restore, "restore")
+	fs.StringVar(&c.Secret, "k", c.Secret, "secret")
+	return fs
+}
+
+func writeConfig(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0666))
+	return path
+}
+
+func TestLoad_precedence(t *testing.T) {
+	path := writeConfig(t, `{
+		// адрес из файла
+		"address": "file:8080",
+		"interval": "20s", /* интервал */
+		"restore": true,
+		"secret": "file_secret",
+	}`)
+	t.Setenv("TEST_CONFIG", path)
+	t.Setenv("TEST_ADDRESS", "env:8080")
+	t.Setenv("TEST_INTERVAL", "30")
+
+	c := testConfig{Address: "default:8080", Interval: Seconds(10)}
+	opts := Options{
+		ConfigFlag: "c",
+		ConfigEnv:  "TEST_CONFIG",
+		Env:        map[string]string{"TEST_ADDRESS": "a", "TEST_INTERVAL": "i", "TEST_SECRET": "k"},
+	}
+	err := Load(newTestFlags(&c), []string{"-i", "40s"}, &c, opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, "env:8080", c.Address)
+	assert.Equal(t, 40*time.Second, c.Interval.Duration)
+	assert.True(t, c.Restore)
+	assert.Equal(t, "file_secret", c.Secret)
+}
+
+func TestLoad_defaults(t *testing.T) {
+	c := testConfig{Address: "default:8080", Interval: Seconds(10)}
+	err := Load(newTestFlags(&c), []string{}, &c, Options{ConfigFlag: "c", ConfigEnv: "TEST_CONFIG_NOT_SET"})
+	require.NoError(t, err)
+	assert.Equal(t, testConfig{Address: "default:8080", Interval: Seconds(10)}, c)
+}
+
+func TestLoad_errors(t *testing.T) {
+	opts := Options{ConfigFlag: "c", Env: map[string]string{"TEST_INTERVAL": "i"}}
+
+	c := testConfig{}
+	err := Load(newTestFlags(&c), []string{"-c", writeConfig(t, `{"unknown": 1}`)}, &c, opts)
+	assert.ErrorContains(t, err, "unknown")
+
+	c = testConfig{}
+	err = Load(newTestFlags(&c), []string{"-c", filepath.Join(t.TempDir(), "not_exists.json")}, &c, opts)
+	assert.Error(t, err)
+
+	c = testConfig{}
+	t.Setenv("TEST_INTERVAL", "ten")
+	err = Load(newTestFlags(&c), []string{}, &c, opts)
+	assert.ErrorContains(t, err, "TEST_INTERVAL")
+
+	c = testConfig{}
+	err = Load(newTestFlags(&c), []string{"-i", "ten"}, &c, opts)
+	assert.Error(t, err)
+}
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		expect string
+	}{
+		{name: "line", data: "{\"a\": 1 // comment\n}", expect: "{\"a\": 1 \n}"},
+		{name: "block", data: `{/* comment */"a": 1}`, expect: `{"a": 1}`},
+		{name: "string", data: `{"a": "http://host/*x*/", "b": "\"//"}`, expect: `{"a": "http://host/*x*/", "b": "\"//"}`},
+		{name: "trailing comma", data: `{"a": [1, 2,], "b": 1,}`, expect: `{"a": [1, 2], "b": 1}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, string(StripComments([]byte(test.data))))
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, Print(buf, testConfig{Address: "localhost", Interval: Seconds(1), Secret: Mask("secret")}))
+	assert.JSONEq(t, `{"address": "localhost", "interval": "1s", "restore": false, "secret": "********"}`, buf.String())
+	assert.Equal(t, "", Mask(""))
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration представляет интервал в настройках. Читается из строки в формате time.ParseDuration ("10s", "1m30s")
+// или из целого числа секунд, как было принято в старых версиях настроек
+type Duration struct {
+	time.Duration
+}
+
+// NewDuration создает интервал
+func NewDuration(d time.Duration) Duration {
+	return Duration{Duration: d}
+}
+
+// Seconds создает интервал из целого числа секунд
+func Seconds(sec int) Duration {
+	return NewDuration(time.Duration(sec) * time.Second)
+}
+
+// ParseDuration разбирает интервал из строки. Число без единиц измерения считается секундами
+func ParseDuration(value string) (Duration, error) {
+	value = strings.TrimSpace(value)
+	if sec, err := strconv.Atoi(value); err == nil {
+		return Seconds(sec), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return Duration{}, fmt.Errorf("invalid duration %q, use a number of seconds or a value like \"10s\"", value)
+	}
+
+	return NewDuration(d), nil
+}
+
+// Set разбирает интервал, используется для флагов
+func (d *Duration) Set(value string) error {
+	parsed, err := ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+	return nil
+}
+
+// String возвращает интервал в формате time.Duration
+func (d Duration) String() string {
+	return d.Duration.String()
+}
+
+// MarshalJSON записывает интервал строкой
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON читает интервал из строки или числа секунд
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return fmt.Errorf("invalid duration %v, use a whole number of seconds or a value like \"1.5s\"", v)
+		}
+		*d = Seconds(int(v))
+		return nil
+	case string:
+		return d.Set(v)
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+}
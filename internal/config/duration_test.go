@@ -0,0 +1,53 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value  string
+		expect time.Duration
+		err    bool
+	}{
+		{value: "10", expect: 10 * time.Second},
+		{value: "10s", expect: 10 * time.Second},
+		{value: "1m30s", expect: 90 * time.Second},
+		{value: "500ms", expect: 500 * time.Millisecond},
+		{value: "ten", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			d, err := ParseDuration(test.value)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, d.Duration)
+		})
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var v struct {
+		A Duration `json:"a"`
+		B Duration `json:"b"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"a": 5, "b": "2m"}`), &v))
+	assert.Equal(t, 5*time.Second, v.A.Duration)
+	assert.Equal(t, 2*time.Minute, v.B.Duration)
+
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a": "5s", "b": "2m0s"}`, string(data))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"a": 1.5}`), &v))
+	assert.Error(t, json.Unmarshal([]byte(`{"a": true}`), &v))
+}
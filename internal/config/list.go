@@ -0,0 +1,24 @@
+package config
+
+import "strings"
+
+// List представляет список строк в настройках. Во флагах и переменных окружения задается через запятую
+type List []string
+
+// Set разбирает список, используется для флагов
+func (l *List) Set(value string) error {
+	items := List{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	*l = items
+	return nil
+}
+
+// String возвращает элементы списка через запятую
+func (l List) String() string {
+	return strings.Join(l, ",")
+}
@@ -0,0 +1,17 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	l := List{}
+	assert.NoError(t, l.Set("runtime, system,,"))
+	assert.Equal(t, List{"runtime", "system"}, l)
+	assert.Equal(t, "runtime,system", l.String())
+
+	assert.NoError(t, l.Set(""))
+	assert.Equal(t, List{}, l)
+}
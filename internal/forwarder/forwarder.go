@@ -0,0 +1,276 @@
+// Package forwarder пересылает метрики на вышестоящий сервер.
+//
+// В режиме ModeBatch пересылается каждая принятая пачка, в режиме ModeSnapshot — периодический
+// снимок всех метрик, в котором счетчики заменены приростом с прошлого снимка. Метрики
+// отправляются так же, как их отправляет агент, и помечаются меткой SourceLabel с именем
+// источника. Пачки сначала пишутся в очередь на диске, поэтому не теряются, пока вышестоящий
+// сервер недоступен, и отправляются по порядку с постоянным идентификатором
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"ya-prac-project1/internal/client"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ModeBatch пересылка каждой принятой пачки
+	ModeBatch = "batch"
+	// ModeSnapshot пересылка периодических снимков
+	ModeSnapshot = "snapshot"
+	// SourceLabel метка с именем источника пересланных метрик
+	SourceLabel = "source"
+	// DefaultInterval интервал снимков и повторов по умолчанию
+	DefaultInterval = 10 * time.Second
+)
+
+// snapshotFile файл с последними отправленными значениями счетчиков
+const snapshotFile = "snapshot.json"
+
+// Pusher отправляет пачку метрик на вышестоящий сервер
+type Pusher interface {
+	PushBatch(ctx context.Context, id string, ms []metrics.Metrics) error
+}
+
+// State хранит последние значения счетчиков, отправленные в снимке
+type State interface {
+	LoadSent(ctx context.Context) (map[string]int64, error)
+	SaveSent(ctx context.Context, sent map[string]int64) error
+}
+
+// ListFunc возвращает все метрики сервера для снимка
+type ListFunc func(ctx context.Context) ([]metrics.Metrics, error)
+
+// Options настройки пересылки
+type Options struct {
+	// Mode режим пересылки: ModeBatch или ModeSnapshot
+	Mode string
+	// Interval интервал снимков и повторной отправки после ошибки
+	Interval time.Duration
+	// Source значение метки SourceLabel
+	Source string
+	// BufferDir каталог очереди пачек
+	BufferDir string
+	// MaxBuffered наибольшее число пачек в очереди, 0 — без ограничения
+	MaxBuffered int
+	// State хранилище значений счетчиков из последнего снимка, по умолчанию файл в BufferDir.
+	// Экземпляры кластера, которые по очереди отправляют снимки общего репозитория, должны
+	// использовать общее хранилище, иначе новый лидер повторно отправит прирост прежнего
+	State State
+}
+
+// Forwarder пересылает метрики на вышестоящий сервер
+type Forwarder struct {
+	pusher Pusher
+	opts   Options
+	queue  *queue
+	wake   chan struct{}
+}
+
+// New создает пересылку через pusher. Очередь пачек открывается в каталоге opts.BufferDir
+func New(pusher Pusher, opts Options) (*Forwarder, error) {
+	if pusher == nil {
+		return nil, errors.New("forwarder: pusher is required")
+	}
+	if opts.Mode == "" {
+		opts.Mode = ModeBatch
+	}
+	if opts.Mode != ModeBatch && opts.Mode != ModeSnapshot {
+		return nil, fmt.Errorf("forwarder: unknown mode %q", opts.Mode)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.BufferDir == "" {
+		return nil, errors.New("forwarder: buffer dir is required")
+	}
+
+	if opts.State == nil {
+		opts.State = fileState{path: filepath.Join(opts.BufferDir, snapshotFile)}
+	}
+
+	q, err := newQueue(opts.BufferDir, opts.MaxBuffered)
+	if err != nil {
+		return nil, fmt.Errorf("forwarder: open buffer: %w", err)
+	}
+
+	return &Forwarder{
+		pusher: pusher,
+		opts:   opts,
+		queue:  q,
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Mode возвращает режим пересылки
+func (f *Forwarder) Mode() string {
+	return f.opts.Mode
+}
+
+// Forward ставит принятую пачку в очередь на пересылку. В режиме снимков ничего не делает
+func (f *Forwarder) Forward(_ context.Context, ms []metrics.Metrics) error {
+	if f.opts.Mode != ModeBatch || len(ms) == 0 {
+		return nil
+	}
+
+	if err := f.queue.push(batch{ID: client.NewBatchID(), Metrics: f.label(ms)}); err != nil {
+		return fmt.Errorf("forward buffer: %w", err)
+	}
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// label возвращает копии метрик с меткой источника. Метрики, которые уже пришли
+// с меткой источника от нижестоящего сервера, сохраняют ее
+func (f *Forwarder) label(ms []metrics.Metrics) []metrics.Metrics {
+	labeled := make([]metrics.Metrics, 0, len(ms))
+	for _, m := range ms {
+		m = m.Clone()
+		if _, labels, err := metrics.ParseID(m.ID); err != nil || labels[SourceLabel] == "" {
+			m.ID = metrics.WithLabel(m.ID, SourceLabel, f.opts.Source)
+		}
+		labeled = append(labeled, m)
+	}
+	return labeled
+}
+
+// Run отправляет очередь, пока не отменен ctx. В режиме снимков каждые Interval ставит
+// в очередь снимок метрик из list. После ошибки отправка повторяется через Interval
+func (f *Forwarder) Run(ctx context.Context, list ListFunc) {
+	ticker := time.NewTicker(f.opts.Interval)
+	defer ticker.Stop()
+
+	f.drain(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.wake:
+		case <-ticker.C:
+			if f.opts.Mode == ModeSnapshot && list != nil {
+				if err := f.snapshot(ctx, list); err != nil {
+					logger.Get().Info("forward snapshot error", zap.String("error", err.Error()))
+				}
+			}
+		}
+
+		f.drain(ctx)
+	}
+}
+
+// drain отправляет пачки из очереди по порядку до первой ошибки
+func (f *Forwarder) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		seq, b, ok, err := f.queue.peek()
+		if err != nil {
+			logger.Get().Info("forward buffer read error", zap.String("error", err.Error()))
+			return
+		}
+		if !ok {
+			return
+		}
+
+		if err = f.pusher.PushBatch(ctx, b.ID, b.Metrics); err != nil {
+			logger.Get().Info("forward error", zap.String("batch", b.ID), zap.Int("buffered", f.queue.len()), zap.String("error", err.Error()))
+			return
+		}
+
+		if err = f.queue.remove(seq); err != nil {
+			logger.Get().Info("forward buffer remove error", zap.String("error", err.Error()))
+			return
+		}
+	}
+}
+
+// snapshot ставит в очередь снимок метрик. Счетчики отправляются приростом с прошлого
+// снимка, а после сброса счетчика — текущим значением. Счетчики без прироста пропускаются.
+// Прошлый снимок читается из State каждый раз: в кластере его мог отправить прежний лидер
+func (f *Forwarder) snapshot(ctx context.Context, list ListFunc) error {
+	items, err := list(ctx)
+	if err != nil {
+		return err
+	}
+
+	last, err := f.opts.State.LoadSent(ctx)
+	if err != nil {
+		return fmt.Errorf("load snapshot state: %w", err)
+	}
+
+	sent := make(map[string]int64, len(last))
+	ms := make([]metrics.Metrics, 0, len(items))
+	for _, m := range items {
+		if m.MType != metrics.MetricTypeCounter || m.Delta == nil {
+			ms = append(ms, m)
+			continue
+		}
+
+		key := m.GetKey()
+		current := *m.Delta
+		sent[key] = current
+
+		prev, ok := last[key]
+		delta := current
+		if ok && current >= prev {
+			delta = current - prev
+		}
+		if ok && delta == 0 {
+			continue
+		}
+
+		m = m.Clone()
+		m.Delta = &delta
+		ms = append(ms, m)
+	}
+
+	if len(ms) > 0 {
+		if err = f.queue.push(batch{ID: client.NewBatchID(), Metrics: f.label(ms)}); err != nil {
+			return fmt.Errorf("forward buffer: %w", err)
+		}
+	}
+
+	// состояние сохраняется после пачки: при сбое между ними прирост отправится повторно,
+	// но не потеряется
+	return f.opts.State.SaveSent(ctx, sent)
+}
+
+// fileState хранит отправленные значения счетчиков в файле snapshotFile каталога очереди
+type fileState struct {
+	path string
+}
+
+// LoadSent читает отправленные значения, до первого снимка файла нет
+func (s fileState) LoadSent(context.Context) (map[string]int64, error) {
+	sent := map[string]int64{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sent, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sent, json.Unmarshal(data, &sent)
+}
+
+// SaveSent атомарно заменяет файл отправленных значений
+func (s fileState) SaveSent(_ context.Context, sent map[string]int64) error {
+	data, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(s.path, data)
+}
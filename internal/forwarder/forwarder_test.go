@@ -0,0 +1,281 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/client"
+	"ya-prac-project1/internal/handlers"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePusher запоминает отправленные пачки, пока down — возвращает ошибку
+type fakePusher struct {
+	mu      sync.Mutex
+	down    bool
+	batches []batch
+}
+
+func (p *fakePusher) PushBatch(_ context.Context, id string, ms []metrics.Metrics) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.down {
+		return errors.New("upstream is down")
+	}
+	p.batches = append(p.batches, batch{ID: id, Metrics: ms})
+	return nil
+}
+
+func (p *fakePusher) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+}
+
+func (p *fakePusher) sent() []batch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]batch{}, p.batches...)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, Options{BufferDir: t.TempDir()})
+	assert.Error(t, err)
+
+	_, err = New(&fakePusher{}, Options{})
+	assert.Error(t, err)
+
+	_, err = New(&fakePusher{}, Options{Mode: "wrong", BufferDir: t.TempDir()})
+	assert.Error(t, err)
+
+	f, err := New(&fakePusher{}, Options{BufferDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.Equal(t, ModeBatch, f.Mode())
+	assert.Equal(t, DefaultInterval, f.opts.Interval)
+}
+
+func TestForward(t *testing.T) {
+	logger.Set()
+	dir := t.TempDir()
+	pusher := &fakePusher{down: true}
+	f, err := New(pusher, Options{Source: "edge", BufferDir: dir, MaxBuffered: 2})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, f.Forward(ctx, []metrics.Metrics{metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")}))
+	require.NoError(t, f.Forward(ctx, []metrics.Metrics{metrics.NewMetric(`Alloc{source="leaf"}`, metrics.MetricTypeGauge, "2")}))
+	require.NoError(t, f.Forward(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "3")}))
+	require.NoError(t, f.Forward(ctx, nil))
+
+	// вышестоящий недоступен: пачки остаются в очереди, самая старая вытеснена
+	f.drain(ctx)
+	assert.Empty(t, pusher.sent())
+	assert.Equal(t, 2, f.queue.len())
+
+	// очередь переживает перезапуск
+	f, err = New(pusher, Options{Source: "edge", BufferDir: dir, MaxBuffered: 2})
+	require.NoError(t, err)
+	pusher.setDown(false)
+	f.drain(ctx)
+
+	sent := pusher.sent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, `Alloc{source="leaf"}`, sent[0].Metrics[0].ID)
+	assert.Equal(t, `PollCount{source="edge"}`, sent[1].Metrics[0].ID)
+	assert.NotEqual(t, sent[0].ID, sent[1].ID)
+	assert.Equal(t, 0, f.queue.len())
+}
+
+func TestForward_retry(t *testing.T) {
+	logger.Set()
+	pusher := &fakePusher{down: true}
+	f, err := New(pusher, Options{Source: "edge", BufferDir: t.TempDir()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, f.Forward(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "3")}))
+	f.drain(ctx)
+	f.drain(ctx)
+	pusher.setDown(false)
+	f.drain(ctx)
+
+	// после ошибок пачка уходит один раз и с тем же идентификатором
+	require.Len(t, pusher.sent(), 1)
+}
+
+func TestForward_snapshotMode(t *testing.T) {
+	f, err := New(&fakePusher{}, Options{Mode: ModeSnapshot, BufferDir: t.TempDir()})
+	require.NoError(t, err)
+
+	require.NoError(t, f.Forward(context.Background(), []metrics.Metrics{metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")}))
+	assert.Equal(t, 0, f.queue.len())
+}
+
+func TestSnapshot(t *testing.T) {
+	logger.Set()
+	dir := t.TempDir()
+	pusher := &fakePusher{}
+	f, err := New(pusher, Options{Mode: ModeSnapshot, Source: "edge", BufferDir: dir})
+	require.NoError(t, err)
+
+	items := []metrics.Metrics{
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "10"),
+	}
+	list := func(context.Context) ([]metrics.Metrics, error) { return items, nil }
+
+	ctx := context.Background()
+	require.NoError(t, f.snapshot(ctx, list))
+
+	items[1] = metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "15")
+	// состояние снимка переживает перезапуск
+	f, err = New(pusher, Options{Mode: ModeSnapshot, Source: "edge", BufferDir: dir})
+	require.NoError(t, err)
+	require.NoError(t, f.snapshot(ctx, list))
+
+	// счетчик без прироста не отправляется, после сброса отправляется текущее значение
+	require.NoError(t, f.snapshot(ctx, list))
+	items[1] = metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "4")
+	require.NoError(t, f.snapshot(ctx, list))
+
+	f.drain(ctx)
+	sent := pusher.sent()
+	require.Len(t, sent, 4)
+
+	values := func(b batch) map[string]string {
+		result := map[string]string{}
+		for _, m := range b.Metrics {
+			result[m.ID] = m.GetValue()
+		}
+		return result
+	}
+	assert.Equal(t, map[string]string{`Alloc{source="edge"}`: "1.5", `PollCount{source="edge"}`: "10"}, values(sent[0]))
+	assert.Equal(t, map[string]string{`Alloc{source="edge"}`: "1.5", `PollCount{source="edge"}`: "5"}, values(sent[1]))
+	assert.Equal(t, map[string]string{`Alloc{source="edge"}`: "1.5"}, values(sent[2]))
+	assert.Equal(t, map[string]string{`Alloc{source="edge"}`: "1.5", `PollCount{source="edge"}`: "4"}, values(sent[3]))
+
+	failing := func(context.Context) ([]metrics.Metrics, error) { return nil, errors.New("list error") }
+	assert.Error(t, f.snapshot(ctx, failing))
+}
+
+// sharedState общее для нескольких пересылок состояние снимков, как в базе кластера
+type sharedState struct {
+	mu   sync.Mutex
+	sent map[string]int64
+}
+
+func (s *sharedState) LoadSent(context.Context) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.sent), nil
+}
+
+func (s *sharedState) SaveSent(_ context.Context, sent map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = maps.Clone(sent)
+	return nil
+}
+
+// TestSnapshot_failover передает отправку снимков от одного экземпляра другому: новый лидер
+// продолжает от значений, отправленных прежним, и не повторяет его прирост
+func TestSnapshot_failover(t *testing.T) {
+	logger.Set()
+	pusher := &fakePusher{}
+	state := &sharedState{}
+	first, err := New(pusher, Options{Mode: ModeSnapshot, Source: "edge", BufferDir: t.TempDir(), State: state})
+	require.NoError(t, err)
+	second, err := New(pusher, Options{Mode: ModeSnapshot, Source: "edge", BufferDir: t.TempDir(), State: state})
+	require.NoError(t, err)
+
+	items := []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "10")}
+	list := func(context.Context) ([]metrics.Metrics, error) { return items, nil }
+
+	ctx := context.Background()
+	require.NoError(t, first.snapshot(ctx, list))
+	first.drain(ctx)
+
+	items[0] = metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "15")
+	require.NoError(t, second.snapshot(ctx, list))
+	second.drain(ctx)
+
+	// лидерство вернулось: прирост второго не отправляется повторно
+	items[0] = metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "18")
+	require.NoError(t, first.snapshot(ctx, list))
+	first.drain(ctx)
+
+	var total int64
+	for _, b := range pusher.sent() {
+		require.Len(t, b.Metrics, 1)
+		total += *b.Metrics[0].Delta
+	}
+	assert.Len(t, pusher.sent(), 3)
+	assert.Equal(t, int64(18), total)
+}
+
+func TestQueue_brokenFiles(t *testing.T) {
+	logger.Set()
+	dir := t.TempDir()
+	q, err := newQueue(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, q.push(batch{ID: "first"}))
+	require.NoError(t, q.push(batch{ID: "second"}))
+	require.NoError(t, os.WriteFile(q.path(1), []byte("not json"), 0600))
+	require.NoError(t, os.WriteFile(q.path(2)+".tmp123", []byte("{}"), 0600))
+
+	q, err = newQueue(dir, 0)
+	require.NoError(t, err)
+	seq, b, ok, err := q.peek()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), seq)
+	assert.Equal(t, "second", b.ID)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// TestRun пересылает пачки на настоящий сервер с подписью запросов
+func TestRun(t *testing.T) {
+	logger.Set()
+	upstream := services.NewMetricSaverService(inmemstorage.NewStorage())
+	h := handlers.New(upstream, nil, "upstream-key", "")
+	h.Mount()
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	f, err := New(client.New(server.URL, "upstream-key", "", time.Second), Options{
+		Source: "edge", BufferDir: t.TempDir(), Interval: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx, nil)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.NoError(t, f.Forward(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "3")}))
+	require.Eventually(t, func() bool {
+		m, err := upstream.GetMetric(ctx, metrics.MetricTypeCounter, `PollCount{source="edge"}`)
+		return err == nil && m.GetValue() == "3"
+	}, 5*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,180 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// batch пачка метрик в очереди. Идентификатор назначается при постановке в очередь
+// и не меняется при повторах, поэтому вышестоящий сервер не применит пачку дважды
+type batch struct {
+	ID      string            `json:"id"`
+	Metrics []metrics.Metrics `json:"metrics"`
+}
+
+// queue очередь пачек на диске: каждая пачка — отдельный файл с порядковым номером в имени,
+// поэтому очередь переживает перезапуск сервера и недоступность вышестоящего
+type queue struct {
+	dir string
+	max int
+
+	mu    sync.Mutex
+	seq   uint64
+	files []uint64
+}
+
+// newQueue открывает очередь в каталоге dir. Если max больше нуля, очередь хранит
+// не больше max пачек, при переполнении отбрасываются самые старые
+func newQueue(dir string, max int) (*queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &queue{dir: dir, max: max}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.Contains(name, ".tmp") {
+			// недописанная пачка осталась от аварийной остановки
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		q.files = append(q.files, seq)
+	}
+
+	slices.Sort(q.files)
+	if len(q.files) > 0 {
+		q.seq = q.files[len(q.files)-1]
+	}
+
+	return q, nil
+}
+
+func (q *queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+// push записывает пачку в конец очереди
+func (q *queue) push(b batch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	if err = writeFile(q.path(q.seq), data); err != nil {
+		return err
+	}
+	q.files = append(q.files, q.seq)
+
+	for q.max > 0 && len(q.files) > q.max {
+		dropped := q.files[0]
+		q.files = q.files[1:]
+		if err = os.Remove(q.path(dropped)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		logger.Get().Info("forward buffer is full, oldest batch dropped", zap.Uint64("batch", dropped))
+	}
+
+	return nil
+}
+
+// peek возвращает самую старую пачку и ее номер. Испорченные файлы удаляются из очереди
+func (q *queue) peek() (uint64, batch, bool, error) {
+	for {
+		q.mu.Lock()
+		if len(q.files) == 0 {
+			q.mu.Unlock()
+			return 0, batch{}, false, nil
+		}
+		seq := q.files[0]
+		q.mu.Unlock()
+
+		data, err := os.ReadFile(q.path(seq))
+		if errors.Is(err, os.ErrNotExist) {
+			// пачку вытеснили, пока она читалась
+			q.remove(seq)
+			continue
+		}
+		if err != nil {
+			return 0, batch{}, false, err
+		}
+
+		var b batch
+		if err = json.Unmarshal(data, &b); err != nil {
+			logger.Get().Info("forward buffer: broken batch dropped", zap.Uint64("batch", seq), zap.String("error", err.Error()))
+			q.remove(seq)
+			continue
+		}
+
+		return seq, b, true, nil
+	}
+}
+
+// remove удаляет пачку из очереди
+func (q *queue) remove(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i := slices.Index(q.files, seq); i >= 0 {
+		q.files = slices.Delete(q.files, i, i+1)
+	}
+
+	if err := os.Remove(q.path(seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// len возвращает число пачек в очереди
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.files)
+}
+
+// writeFile пишет данные во временный файл и атомарно переименовывает его в path
+func writeFile(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,427 @@
+// Package graphite принимает метрики по протоколам Graphite.
+//
+// Текстовый протокол — строки "path value timestamp" по TCP и UDP, путь может нести теги
+// в виде path;tag=value. Протокол pickle — по отдельному TCP адресу. Пути превращаются
+// в метрики по правилам Mapper, теги становятся метками. Принятые метрики копятся и
+// сохраняются пачками раз в FlushInterval или по достижении BatchSize
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultFlushInterval интервал сохранения принятых метрик по умолчанию
+	DefaultFlushInterval = time.Second
+	// DefaultBatchSize число метрик, после которого пачка сохраняется сразу
+	DefaultBatchSize = 1000
+)
+
+// maxLineSize наибольшая длина строки текстового протокола и датаграммы UDP
+const maxLineSize = 64 * 1024
+
+// Saver сохраняет принятые метрики
+type Saver interface {
+	SaveMetrics(ctx context.Context, ms []metrics.Metrics) error
+}
+
+// Options настройки приема
+type Options struct {
+	// Address адрес текстового протокола, слушается по TCP и UDP. Пустой адрес отключает протокол
+	Address string
+	// PickleAddress адрес протокола pickle по TCP. Пустой адрес отключает протокол
+	PickleAddress string
+	// Mapper правила сопоставления путей метрикам, nil сохраняет пути как gauge
+	Mapper *Mapper
+	// FlushInterval интервал сохранения принятых метрик
+	FlushInterval time.Duration
+	// BatchSize число метрик, после которого пачка сохраняется сразу
+	BatchSize int
+}
+
+// sample отсчет Graphite
+type sample struct {
+	path      string
+	value     float64
+	timestamp float64
+}
+
+// Server принимает метрики Graphite
+type Server struct {
+	saver Saver
+	opts  Options
+
+	tcp    net.Listener
+	udp    net.PacketConn
+	pickle net.Listener
+
+	in chan metrics.Metrics
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// New создает прием метрик, сохраняемых через saver
+func New(saver Saver, opts Options) *Server {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	return &Server{
+		saver: saver,
+		opts:  opts,
+		in:    make(chan metrics.Metrics, opts.BatchSize),
+		conns: map[net.Conn]struct{}{},
+	}
+}
+
+// Listen открывает адреса приема
+func (s *Server) Listen() error {
+	var err error
+	if s.opts.Address != "" {
+		if s.tcp, err = net.Listen("tcp", s.opts.Address); err != nil {
+			return fmt.Errorf("graphite: %w", err)
+		}
+		// UDP слушается на том же порту, что и TCP, даже если порт был выбран системой
+		if s.udp, err = net.ListenPacket("udp", s.tcp.Addr().String()); err != nil {
+			s.tcp.Close()
+			return fmt.Errorf("graphite: %w", err)
+		}
+	}
+
+	if s.opts.PickleAddress != "" {
+		if s.pickle, err = net.Listen("tcp", s.opts.PickleAddress); err != nil {
+			s.closeListeners()
+			return fmt.Errorf("graphite pickle: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Addr возвращает адрес текстового протокола, nil, если протокол отключен
+func (s *Server) Addr() net.Addr {
+	if s.tcp == nil {
+		return nil
+	}
+	return s.tcp.Addr()
+}
+
+// PickleAddr возвращает адрес протокола pickle, nil, если протокол отключен
+func (s *Server) PickleAddr() net.Addr {
+	if s.pickle == nil {
+		return nil
+	}
+	return s.pickle.Addr()
+}
+
+// Run принимает метрики, пока не отменен ctx. После отмены закрывает соединения
+// и сохраняет уже принятые метрики
+func (s *Server) Run(ctx context.Context) error {
+	if s.tcp == nil && s.pickle == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		s.flushLoop(ctx)
+		close(flushed)
+	}()
+
+	var wg sync.WaitGroup
+	if s.tcp != nil {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.accept(s.tcp, &wg, s.readLines)
+		}()
+		go func() {
+			defer wg.Done()
+			s.readPackets()
+		}()
+	}
+	if s.pickle != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.accept(s.pickle, &wg, s.readPickles)
+		}()
+	}
+
+	<-ctx.Done()
+	s.closeListeners()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	wg.Wait()
+	close(s.in)
+	<-flushed
+	return nil
+}
+
+// Close закрывает слушатели сервера, который открыт Listen, но не запущен Run
+func (s *Server) Close() {
+	s.closeListeners()
+}
+
+func (s *Server) closeListeners() {
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.pickle != nil {
+		s.pickle.Close()
+	}
+}
+
+// accept принимает соединения и читает каждое в отдельной горутине
+func (s *Server) accept(l net.Listener, wg *sync.WaitGroup, read func(io.Reader)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Get().Info("graphite accept error", zap.String("error", err.Error()))
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			read(conn)
+		}()
+	}
+}
+
+// readLines читает строки текстового протокола
+func (s *Server) readLines(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for scanner.Scan() {
+		s.handleLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Get().Info("graphite read error", zap.String("error", err.Error()))
+	}
+}
+
+// readPackets читает датаграммы UDP, в каждой может быть несколько строк
+func (s *Server) readPackets() {
+	buf := make([]byte, maxLineSize)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Get().Info("graphite udp read error", zap.String("error", err.Error()))
+			}
+			return
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.handleLine(line)
+		}
+	}
+}
+
+// readPickles читает сообщения pickle: длину в четырех байтах и сам pickle
+func (s *Server) readPickles(r io.Reader) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Get().Info("graphite pickle read error", zap.String("error", err.Error()))
+			}
+			return
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size > maxPickleSize {
+			logger.Get().Info("graphite pickle is too large", zap.Uint32("size", size))
+			return
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			logger.Get().Info("graphite pickle read error", zap.String("error", err.Error()))
+			return
+		}
+
+		v, err := decodePickle(payload)
+		var samples []sample
+		if err == nil {
+			samples, err = pickleSamples(v)
+		}
+		if err != nil {
+			logger.Get().Info("graphite pickle error", zap.String("error", err.Error()))
+			continue
+		}
+
+		for _, smp := range samples {
+			s.handleSample(smp)
+		}
+	}
+}
+
+func (s *Server) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	smp, err := parseLine(line)
+	if err != nil {
+		logger.Get().Info("graphite line error", zap.String("line", line), zap.String("error", err.Error()))
+		return
+	}
+	s.handleSample(smp)
+}
+
+func (s *Server) handleSample(smp sample) {
+	m, err := s.metric(smp)
+	if err != nil {
+		logger.Get().Info("graphite metric error", zap.String("path", smp.path), zap.String("error", err.Error()))
+		return
+	}
+	s.in <- m
+}
+
+// metric превращает отсчет в метрику по правилам сопоставления. Теги пути дополняют метки правила
+func (s *Server) metric(smp sample) (metrics.Metrics, error) {
+	path, tags, err := splitTags(smp.path)
+	if err != nil {
+		return metrics.Metrics{}, err
+	}
+
+	name, labels, mType := s.opts.Mapper.Map(path)
+	for key, value := range tags {
+		if labels == nil {
+			labels = make(map[string]string, len(tags))
+		}
+		if _, ok := labels[key]; !ok {
+			labels[key] = value
+		}
+	}
+
+	m := metrics.Metrics{ID: metrics.FormatID(name, labels), MType: mType}
+	if mType == metrics.MetricTypeCounter {
+		delta := int64(math.Round(smp.value))
+		m.Delta = &delta
+	} else {
+		value := smp.value
+		m.Value = &value
+	}
+	return m, nil
+}
+
+// flushLoop сохраняет принятые метрики пачками, пока канал приема не закрыт
+func (s *Server) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]metrics.Metrics, 0, s.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// метрики уже приняты, поэтому сохраняются и после отмены ctx
+		if err := s.saver.SaveMetrics(context.WithoutCancel(ctx), batch); err != nil {
+			logger.Get().Info("graphite save error", zap.Int("metrics", len(batch)), zap.String("error", err.Error()))
+		}
+		batch = make([]metrics.Metrics, 0, s.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case m, ok := <-s.in:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, m)
+			if len(batch) >= s.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// parseLine разбирает строку "path value [timestamp]"
+func parseLine(line string) (sample, error) {
+	parts := strings.Fields(line)
+	if len(parts) != 2 && len(parts) != 3 {
+		return sample{}, errors.New("want path value timestamp")
+	}
+
+	smp := sample{path: parts[0]}
+	var err error
+	if smp.value, err = strconv.ParseFloat(parts[1], 64); err != nil || math.IsNaN(smp.value) || math.IsInf(smp.value, 0) {
+		return sample{}, fmt.Errorf("invalid value %q", parts[1])
+	}
+
+	if len(parts) == 3 {
+		if smp.timestamp, err = strconv.ParseFloat(parts[2], 64); err != nil {
+			return sample{}, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+	}
+
+	return smp, nil
+}
+
+// splitTags отделяет от пути теги вида path;tag=value;tag2=value2
+func splitTags(path string) (string, map[string]string, error) {
+	path, rest, ok := strings.Cut(path, ";")
+	if path == "" {
+		return "", nil, errors.New("empty path")
+	}
+	if !ok {
+		return path, nil, nil
+	}
+
+	tags := map[string]string{}
+	for _, tag := range strings.Split(rest, ";") {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" || value == "" {
+			return "", nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[key] = value
+	}
+	return path, tags, nil
+}
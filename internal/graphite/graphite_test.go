@@ -0,0 +1,194 @@
+package graphite
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSaver запоминает сохраненные метрики
+type fakeSaver struct {
+	mu sync.Mutex
+	ms []metrics.Metrics
+}
+
+func (s *fakeSaver) SaveMetrics(_ context.Context, ms []metrics.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ms = append(s.ms, ms...)
+	return nil
+}
+
+func (s *fakeSaver) ids() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.ms))
+	for _, m := range s.ms {
+		ids = append(ids, m.ID+"="+m.GetValue())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestParseLine(t *testing.T) {
+	smp, err := parseLine("servers.web1.cpu 12.5 1700000000")
+	require.NoError(t, err)
+	assert.Equal(t, sample{path: "servers.web1.cpu", value: 12.5, timestamp: 1700000000}, smp)
+
+	smp, err = parseLine("jobs.done -3")
+	require.NoError(t, err)
+	assert.Equal(t, sample{path: "jobs.done", value: -3}, smp)
+
+	for _, line := range []string{"cpu", "cpu x 1", "cpu 1 now", "cpu 1 2 3", "cpu NaN 1"} {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	path, tags, err := splitTags("disk.used;host=web1;dc=eu")
+	require.NoError(t, err)
+	assert.Equal(t, "disk.used", path)
+	assert.Equal(t, map[string]string{"host": "web1", "dc": "eu"}, tags)
+
+	for _, p := range []string{";host=a", "disk;host", "disk;=a", "disk;host="} {
+		_, _, err := splitTags(p)
+		assert.Error(t, err, p)
+	}
+}
+
+func TestMapper(t *testing.T) {
+	m, err := NewMapper([]Rule{
+		{Match: "servers.*.cpu.*", Name: "cpu_${2}", Labels: map[string]string{"host": "$1"}},
+		{Match: "jobs.*.done", Name: "jobs_done", Type: metrics.MetricTypeCounter, Labels: map[string]string{"job": "$1"}},
+		{Match: "jobs.*"},
+	})
+	require.NoError(t, err)
+
+	name, labels, mType := m.Map("servers.web1.cpu.idle")
+	assert.Equal(t, "cpu_idle", name)
+	assert.Equal(t, map[string]string{"host": "web1"}, labels)
+	assert.Equal(t, metrics.MetricTypeGauge, mType)
+
+	name, labels, mType = m.Map("jobs.backup.done")
+	assert.Equal(t, "jobs_done", name)
+	assert.Equal(t, map[string]string{"job": "backup"}, labels)
+	assert.Equal(t, metrics.MetricTypeCounter, mType)
+
+	name, labels, mType = m.Map("jobs.queue")
+	assert.Equal(t, "jobs.queue", name)
+	assert.Nil(t, labels)
+	assert.Equal(t, metrics.MetricTypeGauge, mType)
+
+	// звездочка не переходит через точку
+	name, _, _ = m.Map("servers.web1.eu.cpu.idle")
+	assert.Equal(t, "servers.web1.eu.cpu.idle", name)
+
+	var nilMapper *Mapper
+	name, labels, mType = nilMapper.Map("a.b")
+	assert.Equal(t, "a.b", name)
+	assert.Nil(t, labels)
+	assert.Equal(t, metrics.MetricTypeGauge, mType)
+
+	_, err = NewMapper([]Rule{{Match: ""}, {Match: "a", Type: "histogram"}})
+	assert.ErrorContains(t, err, "rule 0")
+	assert.ErrorContains(t, err, "rule 1")
+}
+
+func TestServer(t *testing.T) {
+	require.NoError(t, logger.Set())
+
+	mapper, err := NewMapper([]Rule{
+		{Match: "jobs.*.done", Name: "jobs_done", Type: metrics.MetricTypeCounter, Labels: map[string]string{"job": "$1"}},
+	})
+	require.NoError(t, err)
+
+	saver := &fakeSaver{}
+	s := New(saver, Options{Address: "127.0.0.1:0", PickleAddress: "127.0.0.1:0", Mapper: mapper, FlushInterval: 10 * time.Millisecond})
+	require.NoError(t, s.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("jobs.backup.done 2.6 1700000000\nbroken line\ndisk.used;host=web1 0.5 1700000000\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	udp, err := net.Dial("udp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = udp.Write([]byte("mem.free 128 1700000000\n"))
+	require.NoError(t, err)
+	require.NoError(t, udp.Close())
+
+	payload := []byte("(lp0\n(Va.b\np1\n(I1700000000\nF1.5\ntp2\ntp3\na(Vc.d\np4\n(I1700000001\nI2\ntp5\ntp6\na.")
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	pickle, err := net.Dial("tcp", s.PickleAddr().String())
+	require.NoError(t, err)
+	_, err = pickle.Write(append(frame, payload...))
+	require.NoError(t, err)
+
+	want := []string{
+		"a.b=1.5",
+		"c.d=2",
+		`disk.used{host="web1"}=0.5`,
+		`jobs_done{job="backup"}=3`,
+		"mem.free=128",
+	}
+	assert.Eventually(t, func() bool {
+		return len(saver.ids()) == len(want)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, want, saver.ids())
+
+	// открытое соединение не мешает остановке
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop")
+	}
+	pickle.Close()
+}
+
+func TestServer_flushOnShutdown(t *testing.T) {
+	require.NoError(t, logger.Set())
+
+	saver := &fakeSaver{}
+	s := New(saver, Options{Address: "127.0.0.1:0", FlushInterval: time.Hour})
+	require.NoError(t, s.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	// метрика принята, но до остановки интервал сохранения не наступит
+	s.handleLine("cpu 1 1700000000")
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"cpu=1"}, saver.ids())
+}
+
+func TestServer_Close(t *testing.T) {
+	s := New(&fakeSaver{}, Options{Address: "127.0.0.1:0"})
+	require.NoError(t, s.Listen())
+	addr := s.Addr().String()
+	s.Close()
+
+	// порт освобожден, его можно занять снова
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	l.Close()
+}
@@ -0,0 +1,97 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"ya-prac-project1/internal/config"
+	"ya-prac-project1/internal/metrics"
+)
+
+// Rule правило, по которому путь Graphite превращается в метрику
+type Rule struct {
+	// Match шаблон пути: компоненты разделены точками, * совпадает с частью одного компонента
+	Match string `json:"match"`
+	// Name имя метрики, $1, ${2} подставляют части пути, совпавшие со звездочками.
+	// Пустое имя оставляет путь как есть
+	Name string `json:"name,omitempty"`
+	// Type тип метрики: gauge или counter, по умолчанию gauge. Значения counter прибавляются к счетчику
+	Type string `json:"type,omitempty"`
+	// Labels метки метрики, в значениях работают те же подстановки, что и в Name
+	Labels map[string]string `json:"labels,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Mapper сопоставляет пути Graphite метрикам по первому подходящему правилу.
+// Путь, которому не подошло ни одно правило, становится gauge с именем, равным пути
+type Mapper struct {
+	rules []Rule
+}
+
+// NewMapper проверяет правила и создает сопоставление
+func NewMapper(rules []Rule) (*Mapper, error) {
+	var errs []error
+	for i := range rules {
+		r := &rules[i]
+		if r.Match == "" {
+			errs = append(errs, fmt.Errorf("rule %d: match must not be empty", i))
+			continue
+		}
+
+		switch r.Type {
+		case "":
+			r.Type = metrics.MetricTypeGauge
+		case metrics.MetricTypeGauge, metrics.MetricTypeCounter:
+		default:
+			errs = append(errs, fmt.Errorf("rule %d: type: want gauge or counter, got %q", i, r.Type))
+		}
+
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(r.Match), `\*`, `([^.]*)`)
+		r.re = regexp.MustCompile("^" + pattern + "$")
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &Mapper{rules: rules}, nil
+}
+
+// LoadMapper читает правила из json файла со списком правил
+func LoadMapper(path string) (*Mapper, error) {
+	rules := []Rule{}
+	if err := config.LoadFile(path, &rules); err != nil {
+		return nil, err
+	}
+	return NewMapper(rules)
+}
+
+// Map возвращает имя, метки и тип метрики для пути
+func (m *Mapper) Map(path string) (string, map[string]string, string) {
+	if m != nil {
+		for _, r := range m.rules {
+			match := r.re.FindStringSubmatchIndex(path)
+			if match == nil {
+				continue
+			}
+
+			name := path
+			if r.Name != "" {
+				name = string(r.re.ExpandString(nil, r.Name, path, match))
+			}
+
+			var labels map[string]string
+			if len(r.Labels) > 0 {
+				labels = make(map[string]string, len(r.Labels))
+				for key, value := range r.Labels {
+					labels[key] = string(r.re.ExpandString(nil, value, path, match))
+				}
+			}
+
+			return name, labels, r.Type
+		}
+	}
+
+	return path, nil, metrics.MetricTypeGauge
+}
@@ -0,0 +1,523 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Протокол pickle в Graphite: сообщение — четыре байта длины в big endian и python pickle
+// списка [(path, (timestamp, value)), ...]. Разбирается только подмножество pickle, которым
+// carbon и клиенты кодируют такие списки: списки, кортежи, строки и числа. Остальные
+// коды операций, в том числе создающие объекты, считаются ошибкой
+
+// maxPickleSize наибольший размер одного сообщения pickle
+const maxPickleSize = 1 << 20
+
+// errPickle возвращается, если сообщение не удалось разобрать
+var errPickle = errors.New("malformed pickle")
+
+// pickleTuple кортеж. Список, в отличие от кортежа, хранится по указателю: APPEND меняет его на месте
+type pickleTuple []any
+
+// Коды операций pickle
+const (
+	opMark           = '('
+	opStop           = '.'
+	opPop            = '0'
+	opPopMark        = '1'
+	opDup            = '2'
+	opFloat          = 'F'
+	opInt            = 'I'
+	opBinInt         = 'J'
+	opBinInt1        = 'K'
+	opLong           = 'L'
+	opBinInt2        = 'M'
+	opNone           = 'N'
+	opString         = 'S'
+	opBinString      = 'T'
+	opShortBinString = 'U'
+	opUnicode        = 'V'
+	opBinUnicode     = 'X'
+	opAppend         = 'a'
+	opAppends        = 'e'
+	opGet            = 'g'
+	opBinGet         = 'h'
+	opLongBinGet     = 'j'
+	opList           = 'l'
+	opEmptyList      = ']'
+	opPut            = 'p'
+	opBinPut         = 'q'
+	opLongBinPut     = 'r'
+	opTuple          = 't'
+	opEmptyTuple     = ')'
+	opBinFloat       = 'G'
+	opBinBytes       = 'B'
+	opShortBinBytes  = 'C'
+	opProto          = 0x80
+	opTuple1         = 0x85
+	opTuple2         = 0x86
+	opTuple3         = 0x87
+	opNewTrue        = 0x88
+	opNewFalse       = 0x89
+	opLong1          = 0x8a
+	opShortBinUni    = 0x8c
+	opMemoize        = 0x94
+	opFrame          = 0x95
+)
+
+// pickleDecoder стековая машина pickle
+type pickleDecoder struct {
+	data  []byte
+	stack []any
+	marks []int
+	memo  map[int]any
+}
+
+// decodePickle разбирает pickle и возвращает значение на вершине стека
+func decodePickle(data []byte) (any, error) {
+	d := &pickleDecoder{data: data, memo: map[int]any{}}
+	for {
+		op, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if op == opStop {
+			if len(d.stack) != 1 {
+				return nil, errPickle
+			}
+			return d.stack[0], nil
+		}
+		if err = d.step(op); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *pickleDecoder) step(op byte) error {
+	switch op {
+	case opProto:
+		_, err := d.bytes(1)
+		return err
+	case opFrame:
+		_, err := d.bytes(8)
+		return err
+	case opMark:
+		d.marks = append(d.marks, len(d.stack))
+	case opPop:
+		_, err := d.pop()
+		return err
+	case opPopMark:
+		_, err := d.popMark()
+		return err
+	case opDup:
+		top, err := d.top()
+		if err != nil {
+			return err
+		}
+		d.push(top)
+	case opNone:
+		d.push(nil)
+	case opNewTrue:
+		d.push(true)
+	case opNewFalse:
+		d.push(false)
+	case opInt:
+		line, err := d.line()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "00":
+			d.push(false)
+		case "01":
+			d.push(true)
+		default:
+			v, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return errPickle
+			}
+			d.push(v)
+		}
+	case opLong:
+		line, err := d.line()
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseInt(strings.TrimSuffix(line, "L"), 10, 64)
+		if err != nil {
+			return errPickle
+		}
+		d.push(v)
+	case opBinInt:
+		b, err := d.bytes(4)
+		if err != nil {
+			return err
+		}
+		d.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case opBinInt1:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		d.push(int64(b[0]))
+	case opBinInt2:
+		b, err := d.bytes(2)
+		if err != nil {
+			return err
+		}
+		d.push(int64(binary.LittleEndian.Uint16(b)))
+	case opLong1:
+		n, err := d.byte()
+		if err != nil {
+			return err
+		}
+		b, err := d.bytes(int(n))
+		if err != nil {
+			return err
+		}
+		v, err := decodeLong(b)
+		if err != nil {
+			return err
+		}
+		d.push(v)
+	case opFloat:
+		line, err := d.line()
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return errPickle
+		}
+		d.push(v)
+	case opBinFloat:
+		b, err := d.bytes(8)
+		if err != nil {
+			return err
+		}
+		d.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case opString:
+		line, err := d.line()
+		if err != nil {
+			return err
+		}
+		v, err := strconv.Unquote(line)
+		if err != nil {
+			if len(line) < 2 || line[0] != '\'' || line[len(line)-1] != '\'' {
+				return errPickle
+			}
+			v = line[1 : len(line)-1]
+		}
+		d.push(v)
+	case opUnicode:
+		line, err := d.line()
+		if err != nil {
+			return err
+		}
+		d.push(line)
+	case opShortBinString, opShortBinBytes, opShortBinUni:
+		n, err := d.byte()
+		if err != nil {
+			return err
+		}
+		return d.pushString(int(n))
+	case opBinString, opBinBytes, opBinUnicode:
+		b, err := d.bytes(4)
+		if err != nil {
+			return err
+		}
+		return d.pushString(int(binary.LittleEndian.Uint32(b)))
+	case opEmptyList:
+		d.push(&[]any{})
+	case opEmptyTuple:
+		d.push(pickleTuple{})
+	case opList:
+		items, err := d.popMark()
+		if err != nil {
+			return err
+		}
+		d.push(&items)
+	case opTuple:
+		items, err := d.popMark()
+		if err != nil {
+			return err
+		}
+		d.push(pickleTuple(items))
+	case opTuple1, opTuple2, opTuple3:
+		n := int(op-opTuple1) + 1
+		if len(d.stack) < n || (len(d.marks) > 0 && d.marks[len(d.marks)-1] > len(d.stack)-n) {
+			return errPickle
+		}
+		items := append(pickleTuple{}, d.stack[len(d.stack)-n:]...)
+		d.stack = d.stack[:len(d.stack)-n]
+		d.push(items)
+	case opAppend:
+		item, err := d.pop()
+		if err != nil {
+			return err
+		}
+		list, err := d.list()
+		if err != nil {
+			return err
+		}
+		*list = append(*list, item)
+	case opAppends:
+		items, err := d.popMark()
+		if err != nil {
+			return err
+		}
+		list, err := d.list()
+		if err != nil {
+			return err
+		}
+		*list = append(*list, items...)
+	case opPut:
+		line, err := d.line()
+		if err != nil {
+			return err
+		}
+		idx, err := strconv.Atoi(line)
+		if err != nil {
+			return errPickle
+		}
+		return d.put(idx)
+	case opBinPut:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		return d.put(int(b[0]))
+	case opLongBinPut:
+		b, err := d.bytes(4)
+		if err != nil {
+			return err
+		}
+		return d.put(int(binary.LittleEndian.Uint32(b)))
+	case opMemoize:
+		return d.put(len(d.memo))
+	case opGet:
+		line, err := d.line()
+		if err != nil {
+			return err
+		}
+		idx, err := strconv.Atoi(line)
+		if err != nil {
+			return errPickle
+		}
+		return d.get(idx)
+	case opBinGet:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		return d.get(int(b[0]))
+	case opLongBinGet:
+		b, err := d.bytes(4)
+		if err != nil {
+			return err
+		}
+		return d.get(int(binary.LittleEndian.Uint32(b)))
+	default:
+		return fmt.Errorf("%w: unsupported opcode 0x%02x", errPickle, op)
+	}
+	return nil
+}
+
+func (d *pickleDecoder) byte() (byte, error) {
+	b, err := d.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *pickleDecoder) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, errPickle
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *pickleDecoder) line() (string, error) {
+	i := bytes.IndexByte(d.data, '\n')
+	if i < 0 {
+		return "", errPickle
+	}
+	line := string(d.data[:i])
+	d.data = d.data[i+1:]
+	return line, nil
+}
+
+func (d *pickleDecoder) pushString(n int) error {
+	b, err := d.bytes(n)
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(b) {
+		return errPickle
+	}
+	d.push(string(b))
+	return nil
+}
+
+func (d *pickleDecoder) push(v any) {
+	d.stack = append(d.stack, v)
+}
+
+func (d *pickleDecoder) pop() (any, error) {
+	v, err := d.top()
+	if err != nil {
+		return nil, err
+	}
+	d.stack = d.stack[:len(d.stack)-1]
+	return v, nil
+}
+
+func (d *pickleDecoder) top() (any, error) {
+	if len(d.stack) == 0 || (len(d.marks) > 0 && d.marks[len(d.marks)-1] == len(d.stack)) {
+		return nil, errPickle
+	}
+	return d.stack[len(d.stack)-1], nil
+}
+
+// popMark снимает со стека значения до последней отметки
+func (d *pickleDecoder) popMark() ([]any, error) {
+	if len(d.marks) == 0 {
+		return nil, errPickle
+	}
+	mark := d.marks[len(d.marks)-1]
+	d.marks = d.marks[:len(d.marks)-1]
+	if mark > len(d.stack) {
+		return nil, errPickle
+	}
+
+	items := append([]any{}, d.stack[mark:]...)
+	d.stack = d.stack[:mark]
+	return items, nil
+}
+
+func (d *pickleDecoder) list() (*[]any, error) {
+	top, err := d.top()
+	if err != nil {
+		return nil, err
+	}
+	list, ok := top.(*[]any)
+	if !ok {
+		return nil, errPickle
+	}
+	return list, nil
+}
+
+func (d *pickleDecoder) put(idx int) error {
+	top, err := d.top()
+	if err != nil {
+		return err
+	}
+	d.memo[idx] = top
+	return nil
+}
+
+func (d *pickleDecoder) get(idx int) error {
+	v, ok := d.memo[idx]
+	if !ok {
+		return errPickle
+	}
+	d.push(v)
+	return nil
+}
+
+// decodeLong разбирает целое в дополнительном коде little endian, не длиннее 8 байт
+func decodeLong(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if len(b) > 8 {
+		return 0, fmt.Errorf("%w: integer overflows int64", errPickle)
+	}
+
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	if b[len(b)-1]&0x80 != 0 && len(b) < 8 {
+		v |= ^uint64(0) << (8 * len(b))
+	}
+	return int64(v), nil
+}
+
+// pickleSamples возвращает отсчеты из списка [(path, (timestamp, value)), ...]
+func pickleSamples(v any) ([]sample, error) {
+	items, ok := asSlice(v)
+	if !ok {
+		return nil, fmt.Errorf("%w: want list of samples", errPickle)
+	}
+
+	samples := make([]sample, 0, len(items))
+	for _, item := range items {
+		pair, ok := asSlice(item)
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("%w: want (path, (timestamp, value))", errPickle)
+		}
+		point, ok := asSlice(pair[1])
+		if !ok || len(point) != 2 {
+			return nil, fmt.Errorf("%w: want (timestamp, value)", errPickle)
+		}
+
+		path, ok := pair[0].(string)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("%w: path must be a string", errPickle)
+		}
+		timestamp, err := asFloat(point[0])
+		if err != nil {
+			return nil, err
+		}
+		value, err := asFloat(point[1])
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, sample{path: path, value: value, timestamp: timestamp})
+	}
+	return samples, nil
+}
+
+func asSlice(v any) ([]any, bool) {
+	switch v := v.(type) {
+	case *[]any:
+		return *v, true
+	case pickleTuple:
+		return v, true
+	}
+	return nil, false
+}
+
+// asFloat возвращает число из отсчета, NaN и бесконечности отклоняются так же, как в текстовом протоколе
+func asFloat(v any) (float64, error) {
+	var f float64
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		f = v
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(v, 64); err != nil {
+			return 0, fmt.Errorf("%w: invalid number %q", errPickle, v)
+		}
+	default:
+		return 0, fmt.Errorf("%w: want number, got %T", errPickle, v)
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%w: number %v is not finite", errPickle, f)
+	}
+	return f, nil
+}
@@ -0,0 +1,80 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickleSamples(t *testing.T) {
+	want := []sample{
+		{path: "a.b", value: 1.5, timestamp: 1700000000},
+		{path: "c.d", value: 2, timestamp: 1700000001},
+	}
+
+	// pickle.dumps([("a.b", (1700000000, 1.5)), ("c.d", (1700000001, 2))], protocol=...)
+	for name, data := range map[string]string{
+		"protocol 0": "(lp0\n(Va.b\np1\n(I1700000000\nF1.5\ntp2\ntp3\na(Vc.d\np4\n(I1700000001\nI2\ntp5\ntp6\na.",
+		"protocol 2": "\x80\x02]q\x00(X\x03\x00\x00\x00a.bq\x01J\x00\xf1SeG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03" +
+			"X\x03\x00\x00\x00c.dq\x04J\x01\xf1SeK\x02\x86q\x05\x86q\x06e.",
+		"protocol 4": "\x80\x04\x95.\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x03a.b\x94J\x00\xf1SeG?\xf8\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94" +
+			"\x8c\x03c.d\x94J\x01\xf1SeK\x02\x86\x94\x86\x94e.",
+	} {
+		t.Run(name, func(t *testing.T) {
+			v, err := decodePickle([]byte(data))
+			require.NoError(t, err)
+			samples, err := pickleSamples(v)
+			require.NoError(t, err)
+			assert.Equal(t, want, samples)
+		})
+	}
+}
+
+func TestDecodePickle_errors(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty":     "",
+		"no stop":   "(lp0\n",
+		"object":    "\x80\x02cos\nsystem\nq\x00.",
+		"bad memo":  "\x80\x02h\x05.",
+		"truncated": "\x80\x02X\x10\x00\x00\x00abc",
+		// pickle.dumps([("x", (1, 2**70))], protocol=2)
+		"big int": "\x80\x02]q\x00X\x01\x00\x00\x00xq\x01K\x01\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@\x86q\x02\x86q\x03a.",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodePickle([]byte(data))
+			assert.ErrorIs(t, err, errPickle)
+		})
+	}
+
+	v, err := decodePickle([]byte("\x80\x02]q\x00X\x01\x00\x00\x00xq\x01a."))
+	require.NoError(t, err)
+	_, err = pickleSamples(v)
+	assert.ErrorIs(t, err, errPickle)
+}
+
+func TestPickleSamples_notFinite(t *testing.T) {
+	for name, data := range map[string]string{
+		"nan":      "(lp0\n(S'a.b'\np1\n(I1\nFnan\ntp2\ntp3\na.",
+		"inf":      "(lp0\n(S'a.b'\np1\n(I1\nFinf\ntp2\ntp3\na.",
+		"-inf":     "(lp0\n(S'a.b'\np1\n(I1\nF-inf\ntp2\ntp3\na.",
+		"inf time": "(lp0\n(S'a.b'\np1\n(Finf\nI1\ntp2\ntp3\na.",
+		// pickle.dumps([("a.b", (1, float("nan")))], protocol=2)
+		"binfloat nan": "\x80\x02]q\x00X\x03\x00\x00\x00a.bq\x01K\x01G\x7f\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03a.",
+	} {
+		t.Run(name, func(t *testing.T) {
+			v, err := decodePickle([]byte(data))
+			require.NoError(t, err)
+			_, err = pickleSamples(v)
+			assert.ErrorIs(t, err, errPickle)
+		})
+	}
+}
+
+func TestDecodeLong(t *testing.T) {
+	for b, want := range map[string]int64{"": 0, "\x01": 1, "\xff": -1, "\x00\x80": -32768, "\x00\x01": 256} {
+		got, err := decodeLong([]byte(b))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
@@ -151,3 +151,32 @@ func ExampleServerHandler_UpdateBatchMetrics_second() {
 	// 20
 	// 30
 }
+
+func ExampleServerHandler_UpdateBatchMetrics_duplicate() {
+	storage := inmemstorage.NewStorage()
+	service := services.NewMetricSaverService(storage)
+	logger.Set()
+	h := handlers.New(service, nil, "", "")
+	h.Mount()
+
+	for i := 0; i < 2; i++ {
+		body := bytes.NewReader([]byte(`[{"id": "testcounter","type": "counter","delta": 5}]`))
+		req, _ := http.NewRequest(http.MethodPost, "/updates/", body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Batch-ID", "batch-1")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		fmt.Printf("%d duplicate=%s\n", rr.Code, rr.Header().Get("X-Batch-Duplicate"))
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/value/counter/testcounter", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	answer, _ := io.ReadAll(rr.Body)
+	fmt.Println(string(answer))
+
+	// Output:
+	// 200 duplicate=
+	// 200 duplicate=true
+	// 5
+}
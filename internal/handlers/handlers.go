@@ -9,7 +9,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/cluster"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/influx"
 	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/cachestorage"
 
 	"github.com/go-chi/chi/v5"
 
@@ -18,19 +27,60 @@ import (
 
 // MetricService представляет интерфейс сервиса работы с метриками
 type MetricService interface {
-	GetMetric(metricType, name string) (metrics.Metrics, error)
-	GetMetrics() []metrics.Metrics
-	SaveMetric(m metrics.Metrics) error
-	SaveMetrics(ms []metrics.Metrics) error
+	GetMetric(ctx context.Context, metricType, name string) (metrics.Metrics, error)
+	GetMetrics(ctx context.Context) ([]metrics.Metrics, error)
+	ListMetrics(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error)
+	SaveMetric(ctx context.Context, m metrics.Metrics) error
+	SaveMetrics(ctx context.Context, ms []metrics.Metrics) error
+	SaveMetricsBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error)
+	SaveMetricTotals(ctx context.Context, ms, totals []metrics.Metrics) error
+	DeleteMetric(ctx context.Context, metricType, name string) error
+	MetricHistory(ctx context.Context, metricType, name string, from, to time.Time, step time.Duration) (history.Result, error)
+	Subscribe(filter services.UpdateFilter, buffer int) *services.Subscription
 }
 
+// ClusterStatus представляет интерфейс источника состояния кластера экземпляров сервера
+type ClusterStatus interface {
+	Status(ctx context.Context) (cluster.Status, error)
+}
+
+// CacheStats представляет интерфейс источника состояния очереди записи кеша
+type CacheStats interface {
+	Stats() cachestorage.Stats
+}
+
+// cacheStatsResponse ответ /cache
+type cacheStatsResponse struct {
+	PendingWrites       int     `json:"pending_writes"`
+	Flushes             uint64  `json:"flushes"`
+	FlushErrors         uint64  `json:"flush_errors"`
+	FlushLatencySeconds float64 `json:"flush_latency_seconds"`
+}
+
+// batchIDHeader заголовок с уникальным идентификатором пачки метрик, по нему отбрасываются повторы
+const batchIDHeader = "X-Batch-ID"
+
 // ServerHandler представляет структуру сервера
 type ServerHandler struct {
 	metricService MetricService
 	database      *sql.DB
 	handler       *chi.Mux
-	hashKey       string
-	cryptoKey     string
+
+	// mu защищает настройки, которые можно поменять во время работы сервера
+	mu          sync.RWMutex
+	hashKey     string
+	cryptoKey   string
+	agentConfig *agentconfig.Source
+	cluster     ClusterStatus
+	cache       CacheStats
+	// streamHeartbeat интервал событий heartbeat в /stream
+	streamHeartbeat time.Duration
+	// influxCounters правило, по которому целые поля /write сохраняются накопительными счетчиками
+	influxCounters influx.Counters
+
+	// streamsDone закрывается при остановке сервера и завершает потоки /stream
+	streamsDone  chan struct{}
+	closeStreams sync.Once
 }
 
 // New создает новый экземпляр сервера
@@ -40,45 +90,81 @@ func New(metricService MetricService, db *sql.DB, hashKey string, cryptoKey stri
 	s.database = db
 	s.hashKey = hashKey
 	s.cryptoKey = cryptoKey
+	s.streamHeartbeat = streamHeartbeatDefault
+	s.streamsDone = make(chan struct{})
 	return s
 }
 
+// SetAgentConfig задает настройки, которые сервер раздает агентам
+func (s *ServerHandler) SetAgentConfig(source *agentconfig.Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agentConfig = source
+}
+
+// SetInfluxCounters задает правило, по которому целые поля /write сохраняются накопительными
+// счетчиками, остальные поля сохраняются gauge
+func (s *ServerHandler) SetInfluxCounters(counters influx.Counters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.influxCounters = counters
+}
+
+// SetCluster задает источник состояния кластера для /cluster
+func (s *ServerHandler) SetCluster(c ClusterStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cluster = c
+}
+
+// SetCache задает источник состояния очереди записи кеша для /cache
+func (s *ServerHandler) SetCache(c CacheStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = c
+}
+
+// SetKeys меняет ключ подписи и путь к приватному ключу шифрования
+func (s *ServerHandler) SetKeys(hashKey string, cryptoKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashKey = hashKey
+	s.cryptoKey = cryptoKey
+}
+
+func (s *ServerHandler) getKeys() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hashKey, s.cryptoKey
+}
+
 // UpdateMetrics обновляет метрики в привязаном сервисе метрик
 func (s *ServerHandler) UpdateMetrics(w http.ResponseWriter, r *http.Request) {
+	metric := metrics.Metrics{}
 	if hasJSONHeader(r) {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		metric := metrics.Metrics{}
 		if err = json.Unmarshal(body, &metric); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-		err = s.metricService.SaveMetric(metric)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
 	} else {
-		mType := chi.URLParam(r, "metric_type")
-		mName := chi.URLParam(r, "metric_name")
-		mValue := chi.URLParam(r, "metric_value")
-		metric := metrics.Metrics{
-			MType: mType,
-			ID:    mName,
-		}
-		err := metric.SetValue(mValue)
+		metric.MType = chi.URLParam(r, "metric_type")
+		metric.ID = chi.URLParam(r, "metric_name")
+		err := metric.SetValue(chi.URLParam(r, "metric_value"))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	}
 
-		err = s.metricService.SaveMetric(metric)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	if err := s.metricService.SaveMetric(r.Context(), metric); err != nil {
+		writeError(w, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -90,18 +176,24 @@ func (s *ServerHandler) UpdateBatchMetrics(w http.ResponseWriter, r *http.Reques
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		metrics := []metrics.Metrics{}
 		if err = json.Unmarshal(body, &metrics); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		err = s.metricService.SaveMetrics(metrics)
+		applied, err := s.metricService.SaveMetricsBatch(r.Context(), r.Header.Get(batchIDHeader), metrics)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, err)
 			return
 		}
+
+		if !applied {
+			w.Header().Set("X-Batch-Duplicate", "true")
+		}
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -113,22 +205,25 @@ func (s *ServerHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		metric := metrics.Metrics{}
 		if err = json.Unmarshal(body, &metric); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		metric, err = s.metricService.GetMetric(metric.MType, metric.ID)
 
+		metric, err = s.metricService.GetMetric(r.Context(), metric.MType, metric.ID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeError(w, err)
 			return
 		}
 
 		body, err = json.Marshal(metric)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(body)
@@ -136,16 +231,20 @@ func (s *ServerHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 		mType := chi.URLParam(r, "metric_type")
 		mName := chi.URLParam(r, "metric_name")
 		if mType != "" && mName != "" {
-			metric, err := s.metricService.GetMetric(mType, mName)
+			metric, err := s.metricService.GetMetric(r.Context(), mType, mName)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusNotFound)
+				writeError(w, err)
 				return
 			}
 			w.Write([]byte(metric.GetValue()))
 		} else {
-			w.Header().Set("Content-Type", "text/html")
+			metrics, err := s.metricService.GetMetrics(r.Context())
+			if err != nil {
+				writeError(w, err)
+				return
+			}
 
-			metrics := s.metricService.GetMetrics()
+			w.Header().Set("Content-Type", "text/html")
 			rows := make([]string, 0)
 			for _, metric := range metrics {
 				row := fmt.Sprintf("%s: %s", metric.ID, metric.GetValue())
@@ -157,6 +256,58 @@ func (s *ServerHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ListMetrics отдает метрики в формате json. Параметры type и prefix ограничивают выборку
+func (s *ServerHandler) ListMetrics(w http.ResponseWriter, r *http.Request) {
+	filter := storage.Filter{
+		MType:  r.URL.Query().Get("type"),
+		Prefix: r.URL.Query().Get("prefix"),
+	}
+
+	items, err := s.metricService.ListMetrics(r.Context(), filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	body, err := json.Marshal(items)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// DeleteMetric удаляет метрику из сервиса метрик
+func (s *ServerHandler) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	err := s.metricService.DeleteMetric(r.Context(), chi.URLParam(r, "metric_type"), chi.URLParam(r, "metric_name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// errorStatus возвращает http статус ответа для ошибки сервиса метрик
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, history.ErrDisabled):
+		return http.StatusNotFound
+	case errors.Is(err, metrics.ErrWrongType), errors.Is(err, metrics.ErrNoValue), errors.Is(err, metrics.ErrNotFinite):
+		return http.StatusBadRequest
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
 // Ping тестовый роут на проверку подключения к бд
 func (s *ServerHandler) Ping(w http.ResponseWriter, r *http.Request) {
 	var err error
@@ -165,13 +316,89 @@ func (s *ServerHandler) Ping(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err == nil {
-		err = s.database.PingContext(context.Background())
+		err = s.database.PingContext(r.Context())
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetAgentConfig отдает агенту его настройки. Хост агента передается в параметре host
+func (s *ServerHandler) GetAgentConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	agentConfig := s.agentConfig
+	s.mu.RUnlock()
+
+	if agentConfig == nil {
+		http.Error(w, "agent config is not set", http.StatusNotFound)
+		return
 	}
 
+	body, err := json.Marshal(agentConfig.Get(r.URL.Query().Get("host")))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// GetCluster отдает участников кластера и текущего лидера
+func (s *ServerHandler) GetCluster(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	c := s.cluster
+	s.mu.RUnlock()
+
+	if c == nil {
+		http.Error(w, "cluster is disabled", http.StatusNotFound)
+		return
+	}
+
+	status, err := c.Status(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// GetCache отдает состояние очереди записи кеша: число изменений в очереди, число сбросов
+// и ошибок сброса, длительность последнего сброса
+func (s *ServerHandler) GetCache(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	c := s.cache
+	s.mu.RUnlock()
+
+	if c == nil {
+		http.Error(w, "cache is disabled", http.StatusNotFound)
+		return
+	}
+
+	stats := c.Stats()
+	body, err := json.Marshal(cacheStatsResponse{
+		PendingWrites:       stats.Pending,
+		Flushes:             stats.Flushes,
+		FlushErrors:         stats.FlushErrors,
+		FlushLatencySeconds: stats.LastFlush.Seconds(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
 }
 
 // getMetricPage выводит страницу со всеми имеющимися метриками
@@ -191,22 +418,32 @@ func (s *ServerHandler) Mount() {
 	router := chi.NewRouter()
 	router.Route("/", func(r chi.Router) {
 		r.Get("/ping", s.Ping)
+		r.Get("/agent-config", s.GetAgentConfig)
+		r.Get("/cluster", s.GetCluster)
+		r.Get("/cache", s.GetCache)
 		r.Get("/", s.GetMetrics)
+		r.Get("/values/", s.ListMetrics)
 		r.Get("/value/{metric_type}/{metric_name}", s.GetMetrics)
+		r.Delete("/value/{metric_type}/{metric_name}", s.DeleteMetric)
+		r.Get("/history/{metric_type}/{metric_name}", s.GetMetricHistory)
 		r.Post("/update/{metric_type}/{metric_name}/{metric_value}", s.UpdateMetrics)
 		r.Post("/update/", s.UpdateMetrics)
 		r.Post("/value/", s.GetMetrics)
 		r.Post("/updates/", s.UpdateBatchMetrics)
+		r.Post("/write", s.WriteInflux)
+		r.Post("/v1/metrics", s.WriteOTLP)
+		r.Get("/stream", s.Stream)
 	})
 	s.handler = router
 }
 
 // ServeHTTP обрабатывает входящий запрос
 func (s *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h := logMiddleware(cryptoKeyMiddleware(zipMiddleware(s.handler), s.cryptoKey))
+	hashKey, cryptoKey := s.getKeys()
+	h := logMiddleware(cryptoKeyMiddleware(zipMiddleware(s.handler), cryptoKey))
 
-	if s.hashKey != "" {
-		h = hashKeyMiddleware(h, s.hashKey)
+	if hashKey != "" {
+		h = hashKeyMiddleware(h, hashKey)
 	}
 	h.ServeHTTP(w, r)
 }
@@ -1,17 +1,31 @@
 package handlers_test
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/cluster"
+	"ya-prac-project1/internal/config"
 	"ya-prac-project1/internal/handlers"
 	mock "ya-prac-project1/internal/handlers/mocks"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/influx"
 	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/services"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/cachestorage"
+	"ya-prac-project1/internal/storage/inmemstorage"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -24,17 +38,25 @@ func TestUpdateMetrics(t *testing.T) {
 
 	value := new(float64)
 	*value = 20
-	store.EXPECT().SaveMetrics(gomock.Any()).Return(nil).AnyTimes()
-	store.EXPECT().SaveMetric(gomock.Any()).Return(nil).AnyTimes()
-	store.EXPECT().GetMetric("gauge", "testname").Return(metrics.Metrics{ID: "testname", MType: "gauge", Value: value}, nil).AnyTimes()
-	store.EXPECT().GetMetric("gauge", "test_name").Return(metrics.Metrics{ID: "test_name", MType: "gauge", Value: value}, nil).AnyTimes()
-	store.EXPECT().GetMetrics().Return([]metrics.Metrics{
+	store.EXPECT().SaveMetric(gomock.Any(), metrics.Metrics{ID: "testname", MType: "histogram"}).Return(metrics.ErrWrongType).AnyTimes()
+	store.EXPECT().SaveMetrics(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().SaveMetricsBatch(gomock.Any(), gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	store.EXPECT().SaveMetric(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().GetMetric(gomock.Any(), "gauge", "testname").Return(metrics.Metrics{ID: "testname", MType: "gauge", Value: value}, nil).AnyTimes()
+	store.EXPECT().GetMetric(gomock.Any(), "gauge", "test_name").Return(metrics.Metrics{ID: "test_name", MType: "gauge", Value: value}, nil).AnyTimes()
+	store.EXPECT().DeleteMetric(gomock.Any(), "gauge", "testname").Return(nil).AnyTimes()
+	store.EXPECT().DeleteMetric(gomock.Any(), "gauge", "unknown").Return(storage.ErrNotFound).AnyTimes()
+	store.EXPECT().GetMetric(gomock.Any(), "gauge", "unknown").Return(metrics.Metrics{}, storage.ErrNotFound).AnyTimes()
+	store.EXPECT().ListMetrics(gomock.Any(), storage.Filter{}).Return([]metrics.Metrics{{MType: "gauge", ID: "testname", Value: value}}, nil).AnyTimes()
+	store.EXPECT().ListMetrics(gomock.Any(), storage.Filter{MType: "counter"}).Return(nil, errors.New("connection refused")).AnyTimes()
+	store.EXPECT().ListMetrics(gomock.Any(), storage.Filter{MType: "gauge"}).Return(nil, context.Canceled).AnyTimes()
+	store.EXPECT().GetMetrics(gomock.Any()).Return([]metrics.Metrics{
 		{
 			MType: "gauge",
 			ID:    "testname",
 			Value: value,
 		},
-	}).AnyTimes()
+	}, nil).AnyTimes()
 
 	h := handlers.New(store, nil, "", "")
 
@@ -81,6 +103,55 @@ func TestUpdateMetrics(t *testing.T) {
 			checkValue: true,
 			result:     `<!DOCTYPE html><html><head><title>Report</title></head><body><div>testname: 20</div></body></html>`,
 		},
+		{
+			code:       200,
+			method:     http.MethodGet,
+			path:       "/values/",
+			checkValue: true,
+			result:     `[{"value":20,"id":"testname","type":"gauge"}]`,
+		},
+		{
+			code:       500,
+			method:     http.MethodGet,
+			path:       "/values/?type=counter",
+			checkValue: false,
+			result:     "",
+		},
+		{
+			code:       503,
+			method:     http.MethodGet,
+			path:       "/values/?type=gauge",
+			checkValue: false,
+			result:     "",
+		},
+		{
+			code:       404,
+			method:     http.MethodGet,
+			path:       "/value/gauge/unknown",
+			checkValue: false,
+			result:     "",
+		},
+		{
+			code:       400,
+			method:     http.MethodPost,
+			path:       "/update/histogram/testname/1",
+			checkValue: false,
+			result:     "",
+		},
+		{
+			code:       200,
+			method:     http.MethodDelete,
+			path:       "/value/gauge/testname",
+			checkValue: false,
+			result:     "",
+		},
+		{
+			code:       404,
+			method:     http.MethodDelete,
+			path:       "/value/gauge/unknown",
+			checkValue: false,
+			result:     "",
+		},
 		{
 			code:       200,
 			method:     http.MethodPost,
@@ -150,22 +221,452 @@ func TestUpdateMetrics(t *testing.T) {
 	}
 }
 
+func TestUpdateMetrics_noValue(t *testing.T) {
+	h := handlers.New(services.NewMetricSaverService(inmemstorage.NewStorage()), nil, "", "")
+	h.Mount()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, mType := range []string{"counter", "gauge"} {
+		t.Run(mType, func(t *testing.T) {
+			require.Equal(t, http.StatusOK, do(http.MethodPost, "/update/"+mType+"/m/5", "").Code)
+
+			// метрика без значения своего типа не затирает сохраненную
+			body := `{"id":"m","type":"` + mType + `"}`
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/update/", body).Code)
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/updates/", "["+body+"]").Code)
+
+			rr := do(http.MethodGet, "/value/"+mType+"/m", "")
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "5", rr.Body.String())
+		})
+	}
+}
+
+func TestUpdateMetrics_notFinite(t *testing.T) {
+	logger.Set()
+	h := handlers.New(services.NewMetricSaverService(inmemstorage.NewStorage()), nil, "", "")
+	h.Mount()
+
+	for _, value := range []string{"NaN", "Inf", "-Inf"} {
+		req, _ := http.NewRequest(http.MethodPost, "/update/gauge/m/"+value, nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, value)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/value/", bytes.NewBufferString(`{"id":"m","type":"gauge"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestGetAgentConfig(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
+	store := mock.NewMockMetricService(ctrl)
+
+	h := handlers.New(store, nil, "", "")
+	h.Mount()
+
+	req, _ := http.NewRequest(http.MethodGet, "/agent-config?host=host1", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	h.SetAgentConfig(&agentconfig.Source{
+		Default: agentconfig.Settings{ReportInterval: config.Seconds(10), PoolInterval: config.Seconds(2), RateLimit: 1},
+		Hosts: map[string]agentconfig.Settings{
+			"host1": {ReportInterval: config.Seconds(5)},
+		},
+	})
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"report_interval":"5s","poll_interval":"2s","rate_limit":1}`, rr.Body.String())
+}
+
+func TestGetCluster(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
+	h := handlers.New(mock.NewMockMetricService(ctrl), nil, "", "")
+	h.Mount()
+
+	req, _ := http.NewRequest(http.MethodGet, "/cluster", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	seen := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	status := mock.NewMockClusterStatus(ctrl)
+	status.EXPECT().Status(gomock.Any()).Return(cluster.Status{
+		Self:   "node2",
+		Leader: "node1",
+		Members: []cluster.Member{
+			{ID: "node1", Address: ":8081", StartedAt: seen, LastSeen: seen, Leader: true},
+			{ID: "node2", Address: ":8082", StartedAt: seen, LastSeen: seen},
+		},
+	}, nil).Times(1)
+	status.EXPECT().Status(gomock.Any()).Return(cluster.Status{}, errors.New("connection refused")).Times(1)
+	h.SetCluster(status)
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"self":"node2","leader":"node1","members":[
+		{"id":"node1","address":":8081","started_at":"2024-01-10T12:00:00Z","last_seen":"2024-01-10T12:00:00Z","leader":true},
+		{"id":"node2","address":":8082","started_at":"2024-01-10T12:00:00Z","last_seen":"2024-01-10T12:00:00Z","leader":false}
+	]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestGetCache(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
+	h := handlers.New(mock.NewMockMetricService(ctrl), nil, "", "")
+	h.Mount()
+
+	req, _ := http.NewRequest(http.MethodGet, "/cache", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	stats := mock.NewMockCacheStats(ctrl)
+	stats.EXPECT().Stats().Return(cachestorage.Stats{Pending: 3, Flushes: 10, FlushErrors: 1, LastFlush: 250 * time.Millisecond})
+	h.SetCache(stats)
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"pending_writes":3,"flushes":10,"flush_errors":1,"flush_latency_seconds":0.25}`, rr.Body.String())
+}
+
+func TestGetMetricHistory(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
+	store := mock.NewMockMetricService(ctrl)
+
+	from, to := time.Date(2024, 1, 10, 11, 0, 0, 0, time.UTC), time.Unix(1704888000, 0)
+	store.EXPECT().MetricHistory(gomock.Any(), "gauge", "Alloc", from, to, 30*time.Minute).Return(history.Result{
+		Resolution: 30 * time.Minute,
+		Points:     []history.Point{{T: from, Min: 1, Max: 3, Sum: 6, Count: 3}},
+	}, nil).Times(1)
+	store.EXPECT().MetricHistory(gomock.Any(), "gauge", "Disabled", gomock.Any(), gomock.Any(), time.Duration(0)).
+		Return(history.Result{}, history.ErrDisabled).Times(1)
+
+	h := handlers.New(store, nil, "", "")
+	h.Mount()
+
+	tests := []struct {
+		name string
+		path string
+		code int
+		body string
+	}{
+		{
+			name: "range",
+			path: "/history/gauge/Alloc?from=2024-01-10T11:00:00Z&to=1704888000&step=30m",
+			code: http.StatusOK,
+			body: `{"id":"Alloc","type":"gauge","resolution":"30m0s","points":[{"t":"2024-01-10T11:00:00Z","min":1,"max":3,"avg":2,"sum":6,"count":3}]}`,
+		},
+		{name: "disabled", path: "/history/gauge/Disabled", code: http.StatusNotFound},
+		{name: "bad from", path: "/history/gauge/Alloc?from=yesterday", code: http.StatusBadRequest},
+		{name: "bad step", path: "/history/gauge/Alloc?step=-1m", code: http.StatusBadRequest},
+		{name: "empty range", path: "/history/gauge/Alloc?from=1704888000&to=1704888000", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.code, rr.Code)
+			if tt.body != "" {
+				assert.JSONEq(t, tt.body, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWriteInflux(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
+	store := mock.NewMockMetricService(ctrl)
+
+	gauge, counter := metrics.NewMetric(`cpu_usage{host="a"}`, "gauge", "0.5"), metrics.NewMetric(`net_bytes{host="a"}`, "counter", "100")
+	users := metrics.NewMetric("system_n_users", "gauge", "3")
+	// счетчики передаются накопленными итогами, в приращения их переводит репозиторий.
+	// Целые поля, не выбранные правилом, сохраняются gauge
+	store.EXPECT().SaveMetricTotals(gomock.Any(), []metrics.Metrics{gauge, users}, []metrics.Metrics{counter}).Return(nil)
+	store.EXPECT().SaveMetricTotals(gomock.Any(), []metrics.Metrics(nil), []metrics.Metrics{metrics.NewMetric(`net_bytes{host="a"}`, "counter", "120")}).Return(nil)
+	store.EXPECT().SaveMetricTotals(gomock.Any(), []metrics.Metrics{metrics.NewMetric("mem", "gauge", "1")}, []metrics.Metrics(nil)).Return(nil)
+	store.EXPECT().SaveMetricTotals(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+	store.EXPECT().SaveMetricTotals(gomock.Any(), []metrics.Metrics{metrics.NewMetric("zipped", "gauge", "2")}, []metrics.Metrics(nil)).Return(nil)
+
+	h := handlers.New(store, nil, "", "")
+	counters, err := influx.ParseCounters("net_bytes")
+	require.NoError(t, err)
+	h.SetInfluxCounters(counters)
+	h.Mount()
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+		resp string
+	}{
+		{
+			name: "points",
+			path: "/write?precision=s",
+			body: "# comment\ncpu,host=a usage=0.5,state=\"idle\" 1704888000\nnet,host=a bytes=100i\nsystem n_users=3i\n",
+			code: http.StatusNoContent,
+		},
+		{name: "cumulative counter", path: "/write", body: "net,host=a bytes=120i", code: http.StatusNoContent},
+		{
+			name: "partial write",
+			path: "/write",
+			body: "mem value=1\nmem\nmem value=abc\n",
+			code: http.StatusBadRequest,
+			resp: `{"code":"invalid","message":"partial write: 2 lines rejected","errors":[` +
+				`{"line":2,"message":"missing fields"},{"line":3,"message":"field \"value\": invalid value \"abc\""}]}`,
+		},
+		{name: "bad precision", path: "/write?precision=d", body: "mem value=1", code: http.StatusBadRequest},
+		{name: "storage error", path: "/write", body: "mem value=1", code: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.code, rr.Code)
+			if tt.resp != "" {
+				assert.JSONEq(t, tt.resp, rr.Body.String())
+			}
+		})
+	}
+
+	// Telegraf сжимает тело запроса
+	t.Run("gzip", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write([]byte("zipped value=2"))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+
+		req, _ := http.NewRequest(http.MethodPost, "/write", &buf)
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+	})
+}
+
+// TestWriteInflux_sources проверяет, что итоги одного ряда от двух источников не затирают друг друга
+func TestWriteInflux_sources(t *testing.T) {
+	logger.Set()
+	service := services.NewMetricSaverService(inmemstorage.NewStorage())
+	h := handlers.New(service, nil, "", "")
+	h.Mount()
+
+	write := func(remoteAddr, path, body string) {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	// итоги двух источников приходят вперемешку, время точек не учитывается
+	write("10.0.0.1:5000", "/write", "net,host=a bytes_total=100i 1704888000000000000")
+	write("10.0.0.2:5000", "/write", "net,host=a bytes_total=1000i 1704888000000000000")
+	write("10.0.0.1:5001", "/write", "net,host=a bytes_total=150i 1704887000000000000")
+	write("10.0.0.2:5001", "/write", "net,host=a bytes_total=1010i")
+	write("10.0.0.3:5000", "/write?source=edge", "net,host=a bytes_total=7i")
+	write("10.0.0.3:5001", "/write?source=edge", "net,host=a bytes_total=9i")
+
+	ctx := context.Background()
+	for id, want := range map[string]string{
+		`net_bytes_total{host="a",source="10.0.0.1"}`: "150",
+		`net_bytes_total{host="a",source="10.0.0.2"}`: "1010",
+		`net_bytes_total{host="a",source="edge"}`:     "9",
+	} {
+		m, err := service.GetMetric(ctx, metrics.MetricTypeCounter, id)
+		require.NoError(t, err, id)
+		assert.Equal(t, want, m.GetValue(), id)
+	}
+}
+func TestWriteOTLP(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
+	store := mock.NewMockMetricService(ctrl)
+
+	cpu := []metrics.Metrics{metrics.NewMetric(`cpu{service.name="api"}`, "gauge", "0.5")}
+	// накопительная сумма передается итогом, в приращение ее переводит репозиторий
+	store.EXPECT().SaveMetricTotals(gomock.Any(), cpu, []metrics.Metrics{metrics.NewMetric(`requests{service.name="api"}`, "counter", "10")}).Return(nil)
+	store.EXPECT().SaveMetricTotals(gomock.Any(), cpu, []metrics.Metrics{metrics.NewMetric(`requests{service.name="api"}`, "counter", "15")}).Return(nil)
+	store.EXPECT().SaveMetricTotals(gomock.Any(), []metrics.Metrics{}, []metrics.Metrics{}).Return(nil)
+	store.EXPECT().SaveMetricTotals(gomock.Any(), []metrics.Metrics{metrics.NewMetric("mem", "gauge", "2")}, []metrics.Metrics{}).Return(nil)
+	store.EXPECT().SaveMetricTotals(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+
+	h := handlers.New(store, nil, "", "")
+	h.Mount()
+
+	request := func(total int) string {
+		return fmt.Sprintf(`{"resourceMetrics": [{
+			"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+			"scopeMetrics": [{"metrics": [
+				{"name": "requests", "sum": {"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": [{"asInt": "%d"}]}},
+				{"name": "cpu", "gauge": {"dataPoints": [{"asDouble": 0.5}]}}
+			]}]
+		}]}`, total)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+		resp        string
+	}{
+		{name: "json", contentType: "application/json", body: request(10), code: http.StatusOK, resp: `{}`},
+		{name: "cumulative sum", contentType: "application/json", body: request(15), code: http.StatusOK, resp: `{}`},
+		{
+			name:        "partial success",
+			contentType: "application/json",
+			body:        `{"resourceMetrics": [{"scopeMetrics": [{"metrics": [{"name": "lag", "sum": {"dataPoints": [{"asInt": "1"}]}}]}]}]}`,
+			code:        http.StatusOK,
+			resp:        `{"partialSuccess": {"rejectedDataPoints": "1", "errorMessage": "metric \"lag\": unspecified aggregation temporality"}}`,
+		},
+		{
+			name:        "protobuf",
+			contentType: "application/x-protobuf",
+			// resource_metrics { scope_metrics { metrics { name: "mem" gauge { data_points { as_double: 2 } } } } }
+			body: "\x0a\x16\x12\x14\x12\x12\x0a\x03mem\x2a\x0b\x0a\x09\x21\x00\x00\x00\x00\x00\x00\x00\x40",
+			code: http.StatusOK,
+		},
+		{name: "malformed", contentType: "application/json", body: `{"resourceMetrics": 1}`, code: http.StatusBadRequest},
+		{name: "unsupported type", contentType: "text/plain", body: "mem 1", code: http.StatusUnsupportedMediaType},
+		{name: "storage error", contentType: "application/json", body: request(20), code: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.code, rr.Code)
+			if tt.resp != "" {
+				assert.JSONEq(t, tt.resp, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+	logger.Set()
+	service := services.NewMetricSaverService(inmemstorage.NewStorage())
+	h := handlers.New(service, nil, "", "")
+	h.SetStreamHeartbeat(20 * time.Millisecond)
+	h.Mount()
+
+	for _, query := range []string{"type=histogram", "name=cpu_[", "label=host"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stream?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// клиент по умолчанию просит gzip, события должны доходить и через сжатие
+	resp, err := http.Get(srv.URL + "/stream?type=gauge&name=cpu_*&label=host=web1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() (string, string) {
+		var event, data string
+		for events.Scan() {
+			line := events.Text()
+			if line == "" {
+				return event, data
+			}
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				event = v
+			}
+			if v, ok := strings.CutPrefix(line, "data: "); ok {
+				data = v
+			}
+		}
+		return "", ""
+	}
+
+	event, _ := next()
+	require.Equal(t, "heartbeat", event)
+
+	require.NoError(t, service.SaveMetrics(context.Background(), []metrics.Metrics{
+		metrics.NewMetric(`cpu_usage{host="web1"}`, "counter", "1"),
+		metrics.NewMetric(`cpu_usage{host="web2"}`, "gauge", "0.1"),
+		metrics.NewMetric(`mem{host="web1"}`, "gauge", "0.2"),
+		metrics.NewMetric(`cpu_usage{host="web1"}`, "gauge", "0.5"),
+	}))
+
+	for {
+		event, data := next()
+		if event == "heartbeat" {
+			continue
+		}
+		require.Equal(t, "metric", event)
+		assert.JSONEq(t, `{"id":"cpu_usage{host=\"web1\"}","type":"gauge","value":0.5}`, data)
+		break
+	}
+
+	// остановка сервера закрывает поток
+	h.CloseStreams()
+	for event != "" {
+		event, _ = next()
+	}
+}
+
 func TestGzipCompression(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	store := mock.NewMockMetricService(ctrl)
 
 	value := new(float64)
 	*value = 20
-	store.EXPECT().SaveMetrics(gomock.Any()).Return(nil).AnyTimes()
-	store.EXPECT().SaveMetric(gomock.Any()).Return(nil).AnyTimes()
-	store.EXPECT().GetMetric("gauge", "testname").Return(metrics.Metrics{ID: "testname", MType: "gauge", Value: value}, nil).AnyTimes()
-	store.EXPECT().GetMetrics().Return([]metrics.Metrics{
+	store.EXPECT().SaveMetrics(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().SaveMetric(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	store.EXPECT().GetMetric(gomock.Any(), "gauge", "testname").Return(metrics.Metrics{ID: "testname", MType: "gauge", Value: value}, nil).AnyTimes()
+	store.EXPECT().GetMetrics(gomock.Any()).Return([]metrics.Metrics{
 		{
 			MType: "gauge",
 			ID:    "testname",
 			Value: value,
 		},
-	}).AnyTimes()
+	}, nil).AnyTimes()
 
 	h := handlers.New(store, nil, "", "")
 
@@ -27,3 +27,8 @@ func (hw HashResponseWriter) Write(b []byte) (int, error) {
 
 	return hw.ResponseWriter.Write(b)
 }
+
+// Unwrap открывает http.ResponseController исходный ResponseWriter
+func (hw HashResponseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
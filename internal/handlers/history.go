@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"ya-prac-project1/internal/history"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// historyRangeDefault диапазон истории, если from не задан
+const historyRangeDefault = time.Hour
+
+// historyPoint точка истории в ответе
+type historyPoint struct {
+	T     time.Time `json:"t"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Sum   float64   `json:"sum"`
+	Count int64     `json:"count"`
+}
+
+// historyResponse ответ на запрос истории
+type historyResponse struct {
+	ID         string         `json:"id"`
+	MType      string         `json:"type"`
+	Resolution string         `json:"resolution"`
+	Points     []historyPoint `json:"points"`
+}
+
+// GetMetricHistory отдает историю метрики в формате json. Параметры from и to задают диапазон
+// в RFC 3339 или секундах Unix, по умолчанию — последний час, step — минимальный шаг точек
+func (s *ServerHandler) GetMetricHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	to, err := parseHistoryTime(query.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("to: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseHistoryTime(query.Get("from"), to.Add(-historyRangeDefault))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("from: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	var step time.Duration
+	if v := query.Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil || step < 0 {
+			http.Error(w, fmt.Sprintf("step: invalid duration %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mType, mName := chi.URLParam(r, "metric_type"), chi.URLParam(r, "metric_name")
+	result, err := s.metricService.MetricHistory(r.Context(), mType, mName, from, to, step)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := historyResponse{
+		ID:         mName,
+		MType:      mType,
+		Resolution: "raw",
+		Points:     make([]historyPoint, 0, len(result.Points)),
+	}
+	if result.Resolution != history.Raw {
+		response.Resolution = result.Resolution.String()
+	}
+	for _, p := range result.Points {
+		response.Points = append(response.Points, historyPoint{
+			T: p.T.UTC(), Min: p.Min, Max: p.Max, Avg: p.Avg(), Sum: p.Sum, Count: p.Count,
+		})
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// parseHistoryTime разбирает время в RFC 3339 или секундах Unix, пустая строка дает def
+func parseHistoryTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"ya-prac-project1/internal/influx"
+	"ya-prac-project1/internal/metrics"
+)
+
+// influxLineError ошибка строки в ответе /write
+type influxLineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// influxErrorResponse ответ /write с ошибками в формате InfluxDB
+type influxErrorResponse struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Errors  []influxLineError `json:"errors,omitempty"`
+}
+
+// WriteInflux принимает метрики в формате InfluxDB line protocol. Параметр precision задает
+// единицу времени меток, сами метки времени не сохраняются. Целые поля сохраняются накопительными
+// счетчиками, только если их выбирает правило SetInfluxCounters, остальные поля — gauge. Счетчики
+// получают метку influx.SourceLabel с параметром source или адресом клиента, чтобы итоги одного ряда
+// от разных источников не затирали друг друга. Строки без ошибок сохраняются, даже если в других
+// строках есть ошибки, ошибки возвращаются по каждой строке с кодом 400
+func (s *ServerHandler) WriteInflux(w http.ResponseWriter, r *http.Request) {
+	precision, err := influx.ParsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		writeInfluxError(w, influxErrorResponse{Code: "invalid", Message: err.Error()})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.RLock()
+	counters := s.influxCounters
+	s.mu.RUnlock()
+
+	source := influxSource(r)
+	points, lineErrs := influx.Parse(string(body), precision)
+	var ms, totals []metrics.Metrics
+	for _, p := range points {
+		converted, err := p.Metrics(counters)
+		if err != nil {
+			lineErrs = append(lineErrs, influx.LineError{Line: p.Line, Err: err})
+			continue
+		}
+		// счетчики содержат накопленный итог, в приращения их переводит репозиторий
+		for _, m := range converted {
+			if m.MType == metrics.MetricTypeCounter {
+				totals = append(totals, withSource(m, source))
+			} else {
+				ms = append(ms, m)
+			}
+		}
+	}
+
+	if err = s.metricService.SaveMetricTotals(r.Context(), ms, totals); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(lineErrs) > 0 {
+		response := influxErrorResponse{
+			Code:    "invalid",
+			Message: fmt.Sprintf("partial write: %d lines rejected", len(lineErrs)),
+		}
+		for _, e := range lineErrs {
+			response.Errors = append(response.Errors, influxLineError{Line: e.Line, Message: e.Err.Error()})
+		}
+		writeInfluxError(w, response)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// influxSource возвращает источник точек: параметр source или адрес клиента без порта
+func influxSource(r *http.Request) string {
+	if source := r.URL.Query().Get("source"); source != "" {
+		return source
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withSource добавляет к счетчику метку источника, если источник известен и метки нет в тегах
+func withSource(m metrics.Metrics, source string) metrics.Metrics {
+	if source == "" {
+		return m
+	}
+	if _, labels, err := metrics.ParseID(m.ID); err == nil && labels[influx.SourceLabel] != "" {
+		return m
+	}
+
+	m.ID = metrics.WithLabel(m.ID, influx.SourceLabel, source)
+	return m
+}
+
+func writeInfluxError(w http.ResponseWriter, response influxErrorResponse) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(body)
+}
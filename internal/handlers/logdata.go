@@ -25,3 +25,8 @@ func (r *LogResponse) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 	r.Data.Status = statusCode
 }
+
+// Unwrap открывает http.ResponseController исходный ResponseWriter
+func (r *LogResponse) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -66,7 +66,8 @@ func zipMiddleware(h http.Handler) http.Handler {
 		contentType := r.Header.Get("Content-Type")
 		contentEncoding := r.Header.Get("Content-Encoding")
 		sendGzip := strings.Contains(contentEncoding, "gzip")
-		if sendGzip && (contentType == "application/json" || contentType == "text/html") {
+		if sendGzip && (contentType == "application/json" || contentType == "text/html" ||
+			strings.HasPrefix(contentType, "text/plain") || contentType == "application/x-protobuf") {
 			cr, err := newZipReader(r.Body)
 			if err != nil {
 				logger.Get().Info("reader create error", zap.String("error", err.Error()))
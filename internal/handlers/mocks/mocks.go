@@ -5,8 +5,15 @@
 package mock
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
+	cluster "ya-prac-project1/internal/cluster"
+	history "ya-prac-project1/internal/history"
 	metrics "ya-prac-project1/internal/metrics"
+	services "ya-prac-project1/internal/services"
+	storage "ya-prac-project1/internal/storage"
+	cachestorage "ya-prac-project1/internal/storage/cachestorage"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -34,59 +41,222 @@ func (m *MockMetricService) EXPECT() *MockMetricServiceMockRecorder {
 	return m.recorder
 }
 
+// DeleteMetric mocks base method.
+func (m *MockMetricService) DeleteMetric(ctx context.Context, metricType, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetric", ctx, metricType, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMetric indicates an expected call of DeleteMetric.
+func (mr *MockMetricServiceMockRecorder) DeleteMetric(ctx, metricType, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetric", reflect.TypeOf((*MockMetricService)(nil).DeleteMetric), ctx, metricType, name)
+}
+
 // GetMetric mocks base method.
-func (m *MockMetricService) GetMetric(metricType, name string) (metrics.Metrics, error) {
+func (m *MockMetricService) GetMetric(ctx context.Context, metricType, name string) (metrics.Metrics, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetMetric", metricType, name)
+	ret := m.ctrl.Call(m, "GetMetric", ctx, metricType, name)
 	ret0, _ := ret[0].(metrics.Metrics)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetMetric indicates an expected call of GetMetric.
-func (mr *MockMetricServiceMockRecorder) GetMetric(metricType, name interface{}) *gomock.Call {
+func (mr *MockMetricServiceMockRecorder) GetMetric(ctx, metricType, name interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetric", reflect.TypeOf((*MockMetricService)(nil).GetMetric), metricType, name)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetric", reflect.TypeOf((*MockMetricService)(nil).GetMetric), ctx, metricType, name)
 }
 
 // GetMetrics mocks base method.
-func (m *MockMetricService) GetMetrics() []metrics.Metrics {
+func (m *MockMetricService) GetMetrics(ctx context.Context) ([]metrics.Metrics, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetMetrics")
+	ret := m.ctrl.Call(m, "GetMetrics", ctx)
 	ret0, _ := ret[0].([]metrics.Metrics)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetMetrics indicates an expected call of GetMetrics.
-func (mr *MockMetricServiceMockRecorder) GetMetrics() *gomock.Call {
+func (mr *MockMetricServiceMockRecorder) GetMetrics(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockMetricService)(nil).GetMetrics))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockMetricService)(nil).GetMetrics), ctx)
+}
+
+// ListMetrics mocks base method.
+func (m *MockMetricService) ListMetrics(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListMetrics", ctx, filter)
+	ret0, _ := ret[0].([]metrics.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListMetrics indicates an expected call of ListMetrics.
+func (mr *MockMetricServiceMockRecorder) ListMetrics(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListMetrics", reflect.TypeOf((*MockMetricService)(nil).ListMetrics), ctx, filter)
+}
+
+// MetricHistory mocks base method.
+func (m *MockMetricService) MetricHistory(ctx context.Context, metricType, name string, from, to time.Time, step time.Duration) (history.Result, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MetricHistory", ctx, metricType, name, from, to, step)
+	ret0, _ := ret[0].(history.Result)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MetricHistory indicates an expected call of MetricHistory.
+func (mr *MockMetricServiceMockRecorder) MetricHistory(ctx, metricType, name, from, to, step interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MetricHistory", reflect.TypeOf((*MockMetricService)(nil).MetricHistory), ctx, metricType, name, from, to, step)
 }
 
 // SaveMetric mocks base method.
-func (m_2 *MockMetricService) SaveMetric(m metrics.Metrics) error {
+func (m_2 *MockMetricService) SaveMetric(ctx context.Context, m metrics.Metrics) error {
 	m_2.ctrl.T.Helper()
-	ret := m_2.ctrl.Call(m_2, "SaveMetric", m)
+	ret := m_2.ctrl.Call(m_2, "SaveMetric", ctx, m)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveMetric indicates an expected call of SaveMetric.
-func (mr *MockMetricServiceMockRecorder) SaveMetric(m interface{}) *gomock.Call {
+func (mr *MockMetricServiceMockRecorder) SaveMetric(ctx, m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetric", reflect.TypeOf((*MockMetricService)(nil).SaveMetric), ctx, m)
+}
+
+// SaveMetricTotals mocks base method.
+func (m *MockMetricService) SaveMetricTotals(ctx context.Context, ms, totals []metrics.Metrics) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveMetricTotals", ctx, ms, totals)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveMetricTotals indicates an expected call of SaveMetricTotals.
+func (mr *MockMetricServiceMockRecorder) SaveMetricTotals(ctx, ms, totals interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetric", reflect.TypeOf((*MockMetricService)(nil).SaveMetric), m)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetricTotals", reflect.TypeOf((*MockMetricService)(nil).SaveMetricTotals), ctx, ms, totals)
 }
 
 // SaveMetrics mocks base method.
-func (m *MockMetricService) SaveMetrics(ms []metrics.Metrics) error {
+func (m *MockMetricService) SaveMetrics(ctx context.Context, ms []metrics.Metrics) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveMetrics", ms)
+	ret := m.ctrl.Call(m, "SaveMetrics", ctx, ms)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveMetrics indicates an expected call of SaveMetrics.
-func (mr *MockMetricServiceMockRecorder) SaveMetrics(ms interface{}) *gomock.Call {
+func (mr *MockMetricServiceMockRecorder) SaveMetrics(ctx, ms interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetrics", reflect.TypeOf((*MockMetricService)(nil).SaveMetrics), ctx, ms)
+}
+
+// SaveMetricsBatch mocks base method.
+func (m *MockMetricService) SaveMetricsBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveMetricsBatch", ctx, id, ms)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveMetricsBatch indicates an expected call of SaveMetricsBatch.
+func (mr *MockMetricServiceMockRecorder) SaveMetricsBatch(ctx, id, ms interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetricsBatch", reflect.TypeOf((*MockMetricService)(nil).SaveMetricsBatch), ctx, id, ms)
+}
+
+// Subscribe mocks base method.
+func (m *MockMetricService) Subscribe(filter services.UpdateFilter, buffer int) *services.Subscription {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Subscribe", filter, buffer)
+	ret0, _ := ret[0].(*services.Subscription)
+	return ret0
+}
+
+// Subscribe indicates an expected call of Subscribe.
+func (mr *MockMetricServiceMockRecorder) Subscribe(filter, buffer interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockMetricService)(nil).Subscribe), filter, buffer)
+}
+
+// MockClusterStatus is a mock of ClusterStatus interface.
+type MockClusterStatus struct {
+	ctrl     *gomock.Controller
+	recorder *MockClusterStatusMockRecorder
+}
+
+// MockClusterStatusMockRecorder is the mock recorder for MockClusterStatus.
+type MockClusterStatusMockRecorder struct {
+	mock *MockClusterStatus
+}
+
+// NewMockClusterStatus creates a new mock instance.
+func NewMockClusterStatus(ctrl *gomock.Controller) *MockClusterStatus {
+	mock := &MockClusterStatus{ctrl: ctrl}
+	mock.recorder = &MockClusterStatusMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockClusterStatus) EXPECT() *MockClusterStatusMockRecorder {
+	return m.recorder
+}
+
+// Status mocks base method.
+func (m *MockClusterStatus) Status(ctx context.Context) (cluster.Status, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status", ctx)
+	ret0, _ := ret[0].(cluster.Status)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Status indicates an expected call of Status.
+func (mr *MockClusterStatusMockRecorder) Status(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockClusterStatus)(nil).Status), ctx)
+}
+
+// MockCacheStats is a mock of CacheStats interface.
+type MockCacheStats struct {
+	ctrl     *gomock.Controller
+	recorder *MockCacheStatsMockRecorder
+}
+
+// MockCacheStatsMockRecorder is the mock recorder for MockCacheStats.
+type MockCacheStatsMockRecorder struct {
+	mock *MockCacheStats
+}
+
+// NewMockCacheStats creates a new mock instance.
+func NewMockCacheStats(ctrl *gomock.Controller) *MockCacheStats {
+	mock := &MockCacheStats{ctrl: ctrl}
+	mock.recorder = &MockCacheStatsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCacheStats) EXPECT() *MockCacheStatsMockRecorder {
+	return m.recorder
+}
+
+// Stats mocks base method.
+func (m *MockCacheStats) Stats() cachestorage.Stats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(cachestorage.Stats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockCacheStatsMockRecorder) Stats() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetrics", reflect.TypeOf((*MockMetricService)(nil).SaveMetrics), ms)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockCacheStats)(nil).Stats))
 }
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"ya-prac-project1/internal/otlp"
+)
+
+// Типы содержимого запросов OTLP/HTTP
+const (
+	otlpProtobuf = "application/x-protobuf"
+	otlpJSON     = "application/json"
+)
+
+// WriteOTLP принимает метрики OpenTelemetry в формате OTLP/HTTP: protobuf или JSON, в той же
+// кодировке отдается ответ. Точки, которые нельзя сохранить, отклоняются, остальные сохраняются,
+// число отклоненных точек возвращается в partial_success
+func (s *ServerHandler) WriteOTLP(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlpProtobuf && contentType != otlpJSON {
+		http.Error(w, "want application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var request otlp.ExportRequest
+	if contentType == otlpProtobuf {
+		request, err = otlp.Unmarshal(body)
+	} else {
+		request, err = otlp.UnmarshalJSON(body)
+	}
+	if err != nil {
+		writeOTLP(w, contentType, http.StatusBadRequest, otlp.Status{Code: otlp.CodeInvalidArgument, Message: err.Error()})
+		return
+	}
+
+	result := request.Metrics()
+	if err = s.metricService.SaveMetricTotals(r.Context(), result.Metrics, result.Totals); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := otlp.Response{}
+	if result.Rejected > 0 {
+		response.PartialSuccess = &otlp.PartialSuccess{RejectedDataPoints: result.Rejected, ErrorMessage: result.Err.Error()}
+	}
+	writeOTLP(w, contentType, http.StatusOK, response)
+}
+
+// otlpMessage ответ OTLP, который кодируется и в protobuf, и в JSON
+type otlpMessage interface {
+	Marshal() []byte
+}
+
+func writeOTLP(w http.ResponseWriter, contentType string, code int, message otlpMessage) {
+	body := message.Marshal()
+	if contentType == otlpJSON {
+		var err error
+		if body, err = json.Marshal(message); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(body)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/services"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// streamHeartbeatDefault интервал событий heartbeat, по ним клиент и прокси видят, что поток жив
+	streamHeartbeatDefault = 15 * time.Second
+	// streamBuffer число обновлений, которые ждут отправки клиенту, остальные отбрасываются
+	streamBuffer = 256
+	// streamWriteTimeout время на отправку события, клиент, который не принимает данные, отключается
+	streamWriteTimeout = 10 * time.Second
+)
+
+// streamDropped событие о потерянных обновлениях
+type streamDropped struct {
+	Dropped uint64 `json:"dropped"`
+}
+
+// streamHeartbeat событие heartbeat
+type streamHeartbeat struct {
+	Time time.Time `json:"time"`
+}
+
+// SetStreamHeartbeat задает интервал событий heartbeat в /stream
+func (s *ServerHandler) SetStreamHeartbeat(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streamHeartbeat = d
+}
+
+// CloseStreams завершает открытые потоки /stream, иначе они не дают серверу остановиться
+func (s *ServerHandler) CloseStreams() {
+	s.closeStreams.Do(func() { close(s.streamsDone) })
+}
+
+// Stream отдает сохраненные обновления метрик в формате Server-Sent Events. Параметры type,
+// name (шаблон имени без меток, например cpu_*) и label=key=value отбирают метрики. Каждое
+// обновление — событие metric с метрикой в JSON. Если клиент не успевает забирать обновления,
+// лишние отбрасываются и приходит событие dropped с их числом. Раз в интервал приходит heartbeat
+func (s *ServerHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	filter, err := streamFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := s.metricService.Subscribe(filter, streamBuffer)
+	defer sub.Close()
+
+	s.mu.RLock()
+	interval := s.streamHeartbeat
+	s.mu.RUnlock()
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(event string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	var reported uint64
+	err = send("heartbeat", streamHeartbeat{Time: time.Now().UTC()})
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		case m, ok := <-sub.Updates():
+			if !ok {
+				return
+			}
+			err = send("metric", m)
+		case t := <-heartbeat.C:
+			err = send("heartbeat", streamHeartbeat{Time: t.UTC()})
+		}
+
+		if dropped := sub.Dropped(); err == nil && dropped > reported {
+			err = send("dropped", streamDropped{Dropped: dropped - reported})
+			reported = dropped
+		}
+	}
+
+	logger.Get().Info("stream closed", zap.String("error", err.Error()))
+}
+
+// streamFilter разбирает параметры отбора обновлений
+func streamFilter(query url.Values) (services.UpdateFilter, error) {
+	filter := services.UpdateFilter{
+		MType: query.Get("type"),
+		Name:  query.Get("name"),
+	}
+
+	for _, label := range query["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return filter, fmt.Errorf("label: want key=value, got %q", label)
+		}
+		if filter.Labels == nil {
+			filter.Labels = map[string]string{}
+		}
+		filter.Labels[key] = value
+	}
+
+	return filter, filter.Validate()
+}
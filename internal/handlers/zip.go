@@ -37,6 +37,19 @@ func (zipW *zipWriter) Close() error {
 	return zipW.zw.Close()
 }
 
+// FlushError отправляет клиенту уже сжатые данные, нужен для потоковых ответов
+func (zipW *zipWriter) FlushError() error {
+	if err := zipW.zw.Flush(); err != nil {
+		return err
+	}
+	return http.NewResponseController(zipW.w).Flush()
+}
+
+// Unwrap открывает http.ResponseController исходный ResponseWriter
+func (zipW *zipWriter) Unwrap() http.ResponseWriter {
+	return zipW.w
+}
+
 type zipReader struct {
 	r  io.ReadCloser
 	zr *gzip.Reader
@@ -0,0 +1,347 @@
+// Package history хранит историю значений метрик с понижением разрешения.
+//
+// История делится на уровни Tier: сырые отсчеты и свертки с шагом Resolution, у каждого
+// уровня свой срок хранения. Фоновая задача History.Run периодически сворачивает
+// законченные интервалы более подробного уровня в следующий (min, max, sum и count
+// на интервал) и удаляет данные старше срока хранения. Запрос History.Query сам выбирает
+// самый подробный уровень, который еще хранит начало запрошенного диапазона.
+//
+// Где хранить уровни, решает Store: в памяти, во встроенной базе или в Postgres
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// Raw разрешение уровня сырых отсчетов
+const Raw time.Duration = 0
+
+// ErrDisabled возвращается при запросе истории, если она не ведется
+var ErrDisabled = errors.New("history is disabled")
+
+// Tier уровень истории
+type Tier struct {
+	// Resolution шаг свертки, Raw для сырых отсчетов
+	Resolution time.Duration
+	// Retention срок хранения
+	Retention time.Duration
+}
+
+// String возвращает уровень в формате ParseTiers
+func (t Tier) String() string {
+	if t.Resolution == Raw {
+		return "raw:" + t.Retention.String()
+	}
+	return t.Resolution.String() + ":" + t.Retention.String()
+}
+
+// ParseTiers разбирает уровни из строки вида "raw:24h,1m:720h,1h:8760h". Первым должен идти
+// уровень сырых отсчетов, шаги сверток — быть целыми секундами, возрастать и делить друг друга,
+// сроки хранения — возрастать
+func ParseTiers(s string) ([]Tier, error) {
+	tiers := []Tier{}
+	for _, part := range strings.Split(s, ",") {
+		resolution, retention, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("tier %q: want RESOLUTION:RETENTION", part)
+		}
+
+		tier := Tier{}
+		if resolution != "raw" {
+			d, err := time.ParseDuration(resolution)
+			if err != nil {
+				return nil, fmt.Errorf("tier %q: %w", part, err)
+			}
+			tier.Resolution = d
+		}
+
+		d, err := time.ParseDuration(retention)
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: %w", part, err)
+		}
+		tier.Retention = d
+
+		tiers = append(tiers, tier)
+	}
+
+	return tiers, validateTiers(tiers)
+}
+
+func validateTiers(tiers []Tier) error {
+	if len(tiers) == 0 || tiers[0].Resolution != Raw {
+		return errors.New("first tier must be raw")
+	}
+
+	for i, tier := range tiers {
+		if tier.Retention <= 0 {
+			return fmt.Errorf("tier %s: retention must be positive", tier)
+		}
+		if i == 0 {
+			continue
+		}
+
+		if tier.Resolution%time.Second != 0 {
+			return fmt.Errorf("tier %s: resolution must be a whole number of seconds", tier)
+		}
+
+		prev := tiers[i-1]
+		if tier.Resolution <= prev.Resolution {
+			return fmt.Errorf("tier %s: resolution must be greater than %s", tier, prev.Resolution)
+		}
+		if prev.Resolution != Raw && tier.Resolution%prev.Resolution != 0 {
+			return fmt.Errorf("tier %s: resolution must be a multiple of %s", tier, prev.Resolution)
+		}
+		if tier.Retention <= prev.Retention {
+			return fmt.Errorf("tier %s: retention must be greater than %s", tier, prev.Retention)
+		}
+	}
+
+	return nil
+}
+
+// Sample сырой отсчет метрики
+type Sample struct {
+	Key storage.Key
+	T   time.Time
+	V   float64
+}
+
+// Point значение метрики за интервал, начинающийся в T. Сырой отсчет — интервал из одного значения
+type Point struct {
+	T     time.Time
+	Min   float64
+	Max   float64
+	Sum   float64
+	Count int64
+}
+
+// Avg возвращает среднее значение за интервал
+func (p Point) Avg() float64 {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Sum / float64(p.Count)
+}
+
+// Merge добавляет к интервалу значения q
+func (p *Point) Merge(q Point) {
+	if p.Count == 0 {
+		t := p.T
+		*p = q
+		p.T = t
+		return
+	}
+
+	p.Min = min(p.Min, q.Min)
+	p.Max = max(p.Max, q.Max)
+	p.Sum += q.Sum
+	p.Count += q.Count
+}
+
+// RawPoint возвращает сырой отсчет как интервал из одного значения
+func RawPoint(t time.Time, v float64) Point {
+	return Point{T: t, Min: v, Max: v, Sum: v, Count: 1}
+}
+
+// Bucket возвращает начало интервала с шагом resolution, в который попадает t.
+// Интервалы отсчитываются от начала эпохи Unix, как в Postgres
+func Bucket(t time.Time, resolution time.Duration) time.Time {
+	ms, step := t.UnixMilli(), resolution.Milliseconds()
+	if step <= 0 {
+		return time.UnixMilli(ms)
+	}
+
+	rem := ms % step
+	if rem < 0 {
+		rem += step
+	}
+	return time.UnixMilli(ms - rem)
+}
+
+// Downsample сворачивает упорядоченные по времени точки в интервалы с шагом resolution
+func Downsample(points []Point, resolution time.Duration) []Point {
+	out := []Point{}
+	for _, p := range points {
+		bucket := Bucket(p.T, resolution)
+		if len(out) == 0 || !out[len(out)-1].T.Equal(bucket) {
+			out = append(out, Point{T: bucket})
+		}
+		out[len(out)-1].Merge(p)
+	}
+
+	return out
+}
+
+// Store хранилище уровней истории
+type Store interface {
+	// Append сохраняет сырые отсчеты
+	Append(ctx context.Context, samples []Sample) error
+	// Read возвращает точки уровня resolution с T в [from, to), упорядоченные по времени
+	Read(ctx context.Context, resolution time.Duration, key storage.Key, from, to time.Time) ([]Point, error)
+	// Rollup сворачивает точки уровня src с T в [from, to) в интервалы уровня dst.
+	// Границы выровнены по dst, повторная свертка интервала заменяет прежнюю
+	Rollup(ctx context.Context, src, dst time.Duration, from, to time.Time) error
+	// DropBefore удаляет точки уровня resolution старше before
+	DropBefore(ctx context.Context, resolution time.Duration, before time.Time) error
+}
+
+// Result ответ на запрос истории
+type Result struct {
+	// Resolution шаг точек, Raw для сырых отсчетов
+	Resolution time.Duration
+	Points     []Point
+}
+
+// History ведет историю метрик по уровням
+type History struct {
+	store Store
+	tiers []Tier
+	now   func() time.Time
+
+	// mu защищает rolled: для каждого уровня сверток — конец уже свернутого диапазона
+	mu     sync.Mutex
+	rolled map[time.Duration]time.Time
+}
+
+// New создает историю с уровнями tiers поверх store
+func New(store Store, tiers []Tier) (*History, error) {
+	if err := validateTiers(tiers); err != nil {
+		return nil, err
+	}
+
+	return &History{
+		store:  store,
+		tiers:  tiers,
+		now:    time.Now,
+		rolled: make(map[time.Duration]time.Time),
+	}, nil
+}
+
+// Tiers возвращает уровни истории
+func (h *History) Tiers() []Tier {
+	return slices.Clone(h.tiers)
+}
+
+// Record сохраняет значения метрик ms как сырые отсчеты с меткой времени t
+func (h *History) Record(ctx context.Context, t time.Time, ms []metrics.Metrics) error {
+	samples := make([]Sample, 0, len(ms))
+	for _, m := range ms {
+		switch {
+		case m.MType == metrics.MetricTypeGauge && m.Value != nil:
+			samples = append(samples, Sample{Key: storage.KeyOf(m), T: t, V: *m.Value})
+		case m.MType == metrics.MetricTypeCounter && m.Delta != nil:
+			samples = append(samples, Sample{Key: storage.KeyOf(m), T: t, V: float64(*m.Delta)})
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	return h.store.Append(ctx, samples)
+}
+
+// Query возвращает историю метрики key за [from, to). Уровень выбирается самый подробный
+// из тех, что еще хранят from, и не грубее step: в свертки не попадает последний незаконченный
+// интервал, поэтому более грубый уровень не используется. Если шаг уровня меньше step, точки
+// сворачиваются до step
+func (h *History) Query(ctx context.Context, key storage.Key, from, to time.Time, step time.Duration) (Result, error) {
+	tier := h.pickTier(from, step)
+
+	points, err := h.store.Read(ctx, tier.Resolution, key, from, to)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Resolution: tier.Resolution, Points: points}
+	if step > tier.Resolution {
+		result.Resolution = step
+		result.Points = Downsample(points, step)
+	}
+
+	return result, nil
+}
+
+// pickTier выбирает уровень для запроса с начала from с шагом step
+func (h *History) pickTier(from time.Time, step time.Duration) Tier {
+	age := h.now().Sub(from)
+	for _, tier := range h.tiers {
+		if tier.Retention >= age && (step <= 0 || step >= tier.Resolution) {
+			return tier
+		}
+	}
+
+	// диапазон старше всех сроков хранения или шаг мельче подходящих уровней —
+	// используется самый подходящий по сроку уровень
+	for _, tier := range h.tiers {
+		if tier.Retention >= age {
+			return tier
+		}
+	}
+
+	return h.tiers[len(h.tiers)-1]
+}
+
+// Compact сворачивает законченные интервалы каждого уровня в следующий и удаляет
+// точки старше срока хранения
+func (h *History) Compact(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	for i := 1; i < len(h.tiers); i++ {
+		src, dst := h.tiers[i-1], h.tiers[i]
+
+		// после запуска сворачивается все, что еще хранит предыдущий уровень
+		from, ok := h.rolled[dst.Resolution]
+		if !ok {
+			from = Bucket(now.Add(-src.Retention), dst.Resolution)
+		}
+		to := Bucket(now, dst.Resolution)
+		if !to.After(from) {
+			continue
+		}
+
+		if err := h.store.Rollup(ctx, src.Resolution, dst.Resolution, from, to); err != nil {
+			return fmt.Errorf("rollup %s: %w", dst, err)
+		}
+		h.rolled[dst.Resolution] = to
+	}
+
+	for _, tier := range h.tiers {
+		if err := h.store.DropBefore(ctx, tier.Resolution, now.Add(-tier.Retention)); err != nil {
+			return fmt.Errorf("retention %s: %w", tier, err)
+		}
+	}
+
+	return nil
+}
+
+// Run запускает Compact каждые interval до отмены ctx
+func (h *History) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := h.Compact(ctx); err != nil {
+			logger.Get().Info("history compaction error", zap.String("error", err.Error()))
+		}
+	}
+}
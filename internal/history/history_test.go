@@ -0,0 +1,167 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var alloc = storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("raw:24h, 1m:720h,1h:8760h")
+	require.NoError(t, err)
+	assert.Equal(t, []Tier{
+		{Resolution: Raw, Retention: 24 * time.Hour},
+		{Resolution: time.Minute, Retention: 720 * time.Hour},
+		{Resolution: time.Hour, Retention: 8760 * time.Hour},
+	}, tiers)
+	assert.Equal(t, "1m0s:720h0m0s", tiers[1].String())
+
+	for _, s := range []string{
+		"",
+		"1m:24h",
+		"raw",
+		"raw:forever",
+		"raw:24h,1m:1h",
+		"raw:24h,1m:720h,1m:8760h",
+		"raw:24h,1m:720h,90s:8760h",
+		"raw:0s",
+		"raw:24h,1500ms:48h",
+	} {
+		_, err = ParseTiers(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	assert.Equal(t, time.UnixMilli(60000), Bucket(time.UnixMilli(119999), time.Minute))
+	assert.Equal(t, time.UnixMilli(-60000), Bucket(time.UnixMilli(-1), time.Minute))
+	assert.Equal(t, time.UnixMilli(1500), Bucket(time.UnixMilli(1500), Raw))
+}
+
+func TestDownsample(t *testing.T) {
+	points := []Point{
+		RawPoint(time.UnixMilli(0), 1),
+		RawPoint(time.UnixMilli(30000), 3),
+		RawPoint(time.UnixMilli(60000), 10),
+		{T: time.UnixMilli(180000), Min: -1, Max: 5, Sum: 8, Count: 4},
+	}
+
+	assert.Equal(t, []Point{
+		{T: time.UnixMilli(0), Min: 1, Max: 3, Sum: 4, Count: 2},
+		{T: time.UnixMilli(60000), Min: 10, Max: 10, Sum: 10, Count: 1},
+		{T: time.UnixMilli(180000), Min: -1, Max: 5, Sum: 8, Count: 4},
+	}, Downsample(points, time.Minute))
+	assert.Equal(t, 2.0, Downsample(points, time.Minute)[0].Avg())
+	assert.Empty(t, Downsample(nil, time.Minute))
+}
+
+// newTestHistory возвращает историю в памяти с уровнями raw:1h,1m:24h,1h:720h и часами, которые
+// переводит тест
+func newTestHistory(t *testing.T, now *time.Time) *History {
+	tiers, err := ParseTiers("raw:1h,1m:24h,1h:720h")
+	require.NoError(t, err)
+
+	h, err := New(NewMemoryStore(), tiers)
+	require.NoError(t, err)
+	h.now = func() time.Time { return *now }
+	return h
+}
+
+func TestHistory_pickTier(t *testing.T) {
+	now := time.UnixMilli(1704888000000)
+	h := newTestHistory(t, &now)
+
+	tests := []struct {
+		name string
+		from time.Time
+		step time.Duration
+		want time.Duration
+	}{
+		{name: "recent", from: now.Add(-30 * time.Minute), want: Raw},
+		{name: "recent with step", from: now.Add(-30 * time.Minute), step: 5 * time.Minute, want: Raw},
+		{name: "recent with coarse step", from: now.Add(-30 * time.Minute), step: 2 * time.Hour, want: Raw},
+		{name: "old with coarse step", from: now.Add(-12 * time.Hour), step: 2 * time.Hour, want: time.Minute},
+		{name: "older than raw", from: now.Add(-2 * time.Hour), want: time.Minute},
+		{name: "step finer than tier", from: now.Add(-48 * time.Hour), step: time.Second, want: time.Hour},
+		{name: "beyond retention", from: now.Add(-1000 * time.Hour), want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, h.pickTier(tt.from, tt.step).Resolution)
+		})
+	}
+}
+
+func TestHistory_compactAndQuery(t *testing.T) {
+	ctx := context.Background()
+	start := time.UnixMilli(1704888000000)
+	now := start
+	h := newTestHistory(t, &now)
+
+	// два часа отсчетов раз в 10 секунд: значение — номер минуты
+	for i := 0; i < 720; i++ {
+		now = start.Add(time.Duration(i) * 10 * time.Second)
+		v := float64(i / 6)
+		require.NoError(t, h.Record(ctx, now, []metrics.Metrics{{ID: alloc.ID, MType: alloc.MType, Value: &v}}))
+		if i%60 == 0 {
+			require.NoError(t, h.Compact(ctx))
+		}
+	}
+	now = start.Add(2 * time.Hour)
+	require.NoError(t, h.Compact(ctx))
+
+	// сырые отсчеты первого часа удалены, запрос с его начала читает минутные свертки
+	result, err := h.Query(ctx, alloc, start, now, 0)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, result.Resolution)
+	require.Len(t, result.Points, 120)
+	assert.Equal(t, Point{T: start, Min: 0, Max: 0, Sum: 0, Count: 6}, result.Points[0])
+	assert.Equal(t, Point{T: start.Add(119 * time.Minute), Min: 119, Max: 119, Sum: 6 * 119, Count: 6}, result.Points[119])
+
+	// запрос с шагом свертывает минутные точки
+	result, err = h.Query(ctx, alloc, start, now, 30*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, result.Resolution)
+	require.Len(t, result.Points, 4)
+	assert.Equal(t, int64(180), result.Points[0].Count)
+	assert.Equal(t, 14.5, result.Points[0].Avg())
+
+	// последние полчаса еще хранятся сырыми
+	result, err = h.Query(ctx, alloc, now.Add(-30*time.Minute), now, 0)
+	require.NoError(t, err)
+	assert.Equal(t, Raw, result.Resolution)
+	assert.Len(t, result.Points, 180)
+
+	// часовые свертки строятся из минутных
+	result, err = h.Query(ctx, alloc, start, now, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, result.Resolution)
+	assert.Equal(t, []Point{
+		{T: start, Min: 0, Max: 59, Sum: 6 * 59 * 60 / 2, Count: 360},
+		{T: start.Add(time.Hour), Min: 60, Max: 119, Sum: 6 * (60 + 119) * 60 / 2, Count: 360},
+	}, result.Points)
+}
+
+func TestHistory_recordCounter(t *testing.T) {
+	ctx := context.Background()
+	now := time.UnixMilli(1704888000000)
+	h := newTestHistory(t, &now)
+
+	err := h.Record(ctx, now.Add(-time.Second), []metrics.Metrics{
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "42"),
+		{ID: "Broken", MType: metrics.MetricTypeGauge},
+	})
+	require.NoError(t, err)
+
+	result, err := h.Query(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"}, now.Add(-time.Minute), now, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{RawPoint(now.Add(-time.Second), 42)}, result.Points)
+}
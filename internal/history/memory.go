@@ -0,0 +1,155 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/tsdb"
+)
+
+// MemoryStore хранит уровни истории в сжатых рядах tsdb. Свертка хранится четырьмя рядами:
+// min, max, sum и count. Данные удаляются по сроку хранения целыми чанками, поэтому
+// в памяти может оставаться до чанка отсчетов ряда старше срока
+type MemoryStore struct {
+	raw *tsdb.DB
+
+	mu      sync.Mutex
+	rollups map[time.Duration]*rollupSeries
+}
+
+// rollupSeries ряды одного уровня сверток
+type rollupSeries struct {
+	min, max, sum, count *tsdb.DB
+}
+
+func newRollupSeries() *rollupSeries {
+	return &rollupSeries{min: tsdb.New(), max: tsdb.New(), sum: tsdb.New(), count: tsdb.New()}
+}
+
+// NewMemoryStore создает пустое хранилище истории в памяти
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		raw:     tsdb.New(),
+		rollups: make(map[time.Duration]*rollupSeries),
+	}
+}
+
+func (s *MemoryStore) tier(resolution time.Duration) *rollupSeries {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rollups[resolution]
+	if !ok {
+		r = newRollupSeries()
+		s.rollups[resolution] = r
+	}
+	return r
+}
+
+// Append сохраняет сырые отсчеты. Отсчет не новее последнего отсчета ряда отбрасывается
+func (s *MemoryStore) Append(_ context.Context, samples []Sample) error {
+	for _, sample := range samples {
+		err := s.raw.Append(sample.Key, sample.T.UnixMilli(), sample.V)
+		if err != nil && !errors.Is(err, tsdb.ErrOutOfOrder) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read возвращает точки уровня resolution с T в [from, to)
+func (s *MemoryStore) Read(_ context.Context, resolution time.Duration, key storage.Key, from, to time.Time) ([]Point, error) {
+	mint, maxt := from.UnixMilli(), to.UnixMilli()-1
+
+	if resolution == Raw {
+		points := []Point{}
+		it := s.raw.Iterator(key, mint, maxt)
+		for it.Next() {
+			t, v := it.At()
+			points = append(points, RawPoint(time.UnixMilli(t), v))
+		}
+		return points, it.Err()
+	}
+
+	r := s.tier(resolution)
+	its := []tsdb.Iterator{
+		r.min.Iterator(key, mint, maxt),
+		r.max.Iterator(key, mint, maxt),
+		r.sum.Iterator(key, mint, maxt),
+		r.count.Iterator(key, mint, maxt),
+	}
+
+	// ряды свертки пишутся вместе, поэтому отсчеты идут с одинаковыми метками времени
+	points := []Point{}
+	for its[0].Next() && its[1].Next() && its[2].Next() && its[3].Next() {
+		t, minV := its[0].At()
+		_, maxV := its[1].At()
+		_, sum := its[2].At()
+		_, count := its[3].At()
+		points = append(points, Point{T: time.UnixMilli(t), Min: minV, Max: maxV, Sum: sum, Count: int64(count)})
+	}
+
+	for _, it := range its {
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return points, nil
+}
+
+// Rollup сворачивает точки уровня src в уровень dst. Интервалы, которые уже свернуты,
+// не пересчитываются, потому что ряды tsdb дописываются только по возрастанию времени
+func (s *MemoryStore) Rollup(ctx context.Context, src, dst time.Duration, from, to time.Time) error {
+	var keys []storage.Key
+	if src == Raw {
+		keys = s.raw.Select(tsdb.Selector{})
+	} else {
+		keys = s.tier(src).count.Select(tsdb.Selector{})
+	}
+
+	r := s.tier(dst)
+	for _, key := range keys {
+		points, err := s.Read(ctx, src, key, from, to)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range Downsample(points, dst) {
+			t := p.T.UnixMilli()
+			err = errors.Join(
+				r.min.Append(key, t, p.Min),
+				r.max.Append(key, t, p.Max),
+				r.sum.Append(key, t, p.Sum),
+				r.count.Append(key, t, float64(p.Count)),
+			)
+			if errors.Is(err, tsdb.ErrOutOfOrder) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DropBefore удаляет чанки уровня resolution, все точки которых старше before
+func (s *MemoryStore) DropBefore(_ context.Context, resolution time.Duration, before time.Time) error {
+	mint := before.UnixMilli()
+	if resolution == Raw {
+		s.raw.DropBefore(mint)
+		return nil
+	}
+
+	r := s.tier(resolution)
+	for _, db := range []*tsdb.DB{r.min, r.max, r.sum, r.count} {
+		db.DropBefore(mint)
+	}
+
+	return nil
+}
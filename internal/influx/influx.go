@@ -0,0 +1,368 @@
+// Package influx разбирает метрики в формате InfluxDB line protocol.
+//
+// Строка имеет вид measurement[,tag=value...] field=value[,field=value...] [timestamp].
+// Поля становятся gauge: целое поле Telegraf может быть и накопленным итогом, и текущим значением,
+// например числом процессов. Счетчиками становятся только целые поля, выбранные правилом Counters:
+// по окончанию имени CounterSuffix или по списку шаблонов. Значение такого счетчика — накопленный итог.
+// Теги становятся метками метрики.
+//
+// Время точки разбирается, но при сохранении не используется: сервер хранит последнее значение
+// метрики, точки применяются в порядке строк. Итог счетчика переводится в приращение по прошлому
+// итогу того же ряда, поэтому источники, которые пишут один ряд, различаются меткой SourceLabel
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"ya-prac-project1/internal/metrics"
+)
+
+// FieldKind тип значения поля
+type FieldKind int
+
+const (
+	// Float дробное число: 1.5, 1e3
+	Float FieldKind = iota
+	// Integer целое со знаком: 5i
+	Integer
+	// Unsigned целое без знака: 5u
+	Unsigned
+	// String строка в кавычках
+	String
+	// Boolean t, true, f, false в любом регистре
+	Boolean
+)
+
+// ValueField поле, значение которого хранится в метрике с именем измерения без суффикса
+const ValueField = "value"
+
+// SourceLabel метка источника накопительного счетчика: итоги разных источников одного ряда
+// хранятся отдельно и не затирают друг друга
+const SourceLabel = "source"
+
+// Field поле строки
+type Field struct {
+	Key   string
+	Kind  FieldKind
+	Float float64
+	Int   int64
+	Uint  uint64
+	Str   string
+	Bool  bool
+}
+
+// Point разобранная строка
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	// Time время точки, нулевое, если оно не передано. При сохранении не используется
+	Time time.Time
+	// Line номер строки в теле запроса
+	Line int
+}
+
+// LineError ошибка разбора строки
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e LineError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnsupported возвращается для полей, которые нельзя сохранить метрикой
+var ErrUnsupported = errors.New("unsupported field")
+
+// ParsePrecision возвращает единицу времени меток: ns, us, ms, s, m или h. Пустая строка — наносекунды
+func ParsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ", "µs":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown precision %q", s)
+}
+
+// Parse разбирает строки тела запроса. Пустые строки и комментарии пропускаются,
+// ошибки возвращаются для каждой строки отдельно, строки без ошибок разбираются
+func Parse(body string, precision time.Duration) ([]Point, []LineError) {
+	points := []Point{}
+	var errs []LineError
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		p, err := ParseLine(line, precision)
+		if err != nil {
+			errs = append(errs, LineError{Line: i + 1, Err: err})
+			continue
+		}
+		p.Line = i + 1
+		points = append(points, p)
+	}
+	return points, errs
+}
+
+// ParseLine разбирает одну строку
+func ParseLine(line string, precision time.Duration) (Point, error) {
+	p := Point{}
+
+	key, rest, err := cutUnescaped(line, ' ', false)
+	if err != nil {
+		return p, err
+	}
+	fields, timestamp, err := cutUnescaped(strings.TrimLeft(rest, " "), ' ', true)
+	if err != nil {
+		return p, err
+	}
+
+	parts, err := splitUnescaped(key, ',', false)
+	if err != nil {
+		return p, err
+	}
+	p.Measurement = unescape(parts[0])
+	if p.Measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+
+	for _, tag := range parts[1:] {
+		k, v, err := cutUnescaped(tag, '=', false)
+		if err != nil || k == "" || v == "" {
+			return p, fmt.Errorf("invalid tag %q", tag)
+		}
+		if p.Tags == nil {
+			p.Tags = map[string]string{}
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	if fields == "" {
+		return p, errors.New("missing fields")
+	}
+	parts, err = splitUnescaped(fields, ',', true)
+	if err != nil {
+		return p, err
+	}
+	for _, part := range parts {
+		k, v, err := cutUnescaped(part, '=', false)
+		if err != nil || k == "" || v == "" {
+			return p, fmt.Errorf("invalid field %q", part)
+		}
+
+		field, err := parseField(unescape(k), v)
+		if err != nil {
+			return p, err
+		}
+		p.Fields = append(p.Fields, field)
+	}
+
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		p.Time = time.Unix(0, 0).Add(time.Duration(ts) * precision)
+	}
+
+	return p, nil
+}
+
+func parseField(key, v string) (Field, error) {
+	f := Field{Key: key}
+	var err error
+
+	switch last := v[len(v)-1]; {
+	case v[0] == '"':
+		if len(v) < 2 || last != '"' {
+			return f, fmt.Errorf("field %q: unterminated string", key)
+		}
+		f.Kind, f.Str = String, strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1:len(v)-1])
+	case last == 'i':
+		f.Kind = Integer
+		f.Int, err = strconv.ParseInt(v[:len(v)-1], 10, 64)
+	case last == 'u':
+		f.Kind = Unsigned
+		f.Uint, err = strconv.ParseUint(v[:len(v)-1], 10, 64)
+	default:
+		switch v {
+		case "t", "T", "true", "True", "TRUE":
+			f.Kind, f.Bool = Boolean, true
+		case "f", "F", "false", "False", "FALSE":
+			f.Kind, f.Bool = Boolean, false
+		default:
+			f.Kind = Float
+			f.Float, err = strconv.ParseFloat(v, 64)
+			if err == nil && (math.IsNaN(f.Float) || math.IsInf(f.Float, 0)) {
+				err = errors.New("not a finite number")
+			}
+		}
+	}
+
+	if err != nil {
+		return f, fmt.Errorf("field %q: invalid value %q", key, v)
+	}
+	return f, nil
+}
+
+// CounterSuffix окончание имени метрики, по которому целое поле считается накопительным счетчиком
+const CounterSuffix = "_total"
+
+// Counters правило выбора накопительных счетчиков: целое поле становится счетчиком, если имя
+// метрики measurement_field оканчивается на CounterSuffix или подходит под один из шаблонов
+type Counters struct {
+	patterns []string
+}
+
+// ParseCounters разбирает список шаблонов имен через запятую в синтаксисе path.Match,
+// например net_bytes_*,diskio_*
+func ParseCounters(s string) (Counters, error) {
+	var c Counters
+	for _, pattern := range strings.Split(s, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Counters{}, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		c.patterns = append(c.patterns, pattern)
+	}
+	return c, nil
+}
+
+// Match сообщает, считается ли целое поле с именем метрики name накопительным счетчиком
+func (c Counters) Match(name string) bool {
+	if strings.HasSuffix(name, CounterSuffix) {
+		return true
+	}
+	for _, pattern := range c.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Metrics возвращает метрики точки. Имя метрики — measurement_field или measurement для поля
+// value, теги становятся метками. Целые поля, выбранные counters, становятся счетчиками
+// с накопленным значением, остальные поля — gauge. Строковые поля не сохраняются
+func (p Point) Metrics(counters Counters) ([]metrics.Metrics, error) {
+	ms := make([]metrics.Metrics, 0, len(p.Fields))
+	for _, f := range p.Fields {
+		name := p.Measurement
+		if f.Key != ValueField {
+			name += "_" + f.Key
+		}
+		id := metrics.FormatID(name, p.Tags)
+
+		m := metrics.Metrics{ID: id, MType: metrics.MetricTypeGauge}
+		switch f.Kind {
+		case Float:
+			m.Value = &f.Float
+		case Boolean:
+			value := 0.0
+			if f.Bool {
+				value = 1
+			}
+			m.Value = &value
+		case Integer:
+			if counters.Match(name) {
+				m.MType, m.Delta = metrics.MetricTypeCounter, &f.Int
+				break
+			}
+			value := float64(f.Int)
+			m.Value = &value
+		case Unsigned:
+			if !counters.Match(name) {
+				value := float64(f.Uint)
+				m.Value = &value
+				break
+			}
+			if f.Uint > math.MaxInt64 {
+				return nil, fmt.Errorf("field %q: %w: value overflows counter", f.Key, ErrUnsupported)
+			}
+			delta := int64(f.Uint)
+			m.MType, m.Delta = metrics.MetricTypeCounter, &delta
+		case String:
+			continue
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// cutUnescaped делит s по первому неэкранированному sep. Внутри кавычек sep пропускается,
+// если quoted
+func cutUnescaped(s string, sep byte, quoted bool) (string, string, error) {
+	i, err := indexUnescaped(s, sep, quoted)
+	if err != nil {
+		return "", "", err
+	}
+	if i < 0 {
+		return s, "", nil
+	}
+	return s[:i], s[i+1:], nil
+}
+
+func splitUnescaped(s string, sep byte, quoted bool) ([]string, error) {
+	parts := []string{}
+	for {
+		i, err := indexUnescaped(s, sep, quoted)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			return append(parts, s), nil
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+func indexUnescaped(s string, sep byte, quoted bool) (int, error) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quoted && c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			return i, nil
+		}
+	}
+	if inQuotes {
+		return -1, errors.New("unterminated string")
+	}
+	return -1, nil
+}
+
+// unescape снимает экранирование с запятых, пробелов, знаков равенства и обратной косой черты
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\\`, `\`).Replace(s)
+}
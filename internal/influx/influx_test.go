@@ -0,0 +1,123 @@
+package influx
+
+import (
+	"testing"
+	"time"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	p, err := ParseLine(`disk\ io,host=web\,1,path=C:\\data used=1.5,total=10i,free=3u,ok=T,msg="a \"b\", c=d" 1704888000000`, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "disk io", p.Measurement)
+	assert.Equal(t, map[string]string{"host": "web,1", "path": `C:\data`}, p.Tags)
+	assert.Equal(t, []Field{
+		{Key: "used", Kind: Float, Float: 1.5},
+		{Key: "total", Kind: Integer, Int: 10},
+		{Key: "free", Kind: Unsigned, Uint: 3},
+		{Key: "ok", Kind: Boolean, Bool: true},
+		{Key: "msg", Kind: String, Str: `a "b", c=d`},
+	}, p.Fields)
+	assert.True(t, p.Time.Equal(time.UnixMilli(1704888000000)))
+
+	p, err = ParseLine("cpu value=-2e3", time.Nanosecond)
+	require.NoError(t, err)
+	assert.Nil(t, p.Tags)
+	assert.True(t, p.Time.IsZero())
+	assert.Equal(t, -2000.0, p.Fields[0].Float)
+}
+
+func TestParseLine_errors(t *testing.T) {
+	for _, line := range []string{
+		"cpu",
+		",host=a value=1",
+		"cpu,host value=1",
+		"cpu,host= value=1",
+		"cpu value",
+		"cpu =1",
+		"cpu value=1x",
+		"cpu value=NaN",
+		"cpu value=1.5i",
+		`cpu value="open`,
+		"cpu value=1 yesterday",
+		"cpu value=-1u",
+	} {
+		_, err := ParseLine(line, time.Nanosecond)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParse(t *testing.T) {
+	points, errs := Parse("# header\n\ncpu value=1\ncpu\r\nmem value=2 3\n", time.Second)
+	require.Len(t, points, 2)
+	assert.Equal(t, 3, points[0].Line)
+	assert.Equal(t, 5, points[1].Line)
+	assert.True(t, points[1].Time.Equal(time.Unix(3, 0)))
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, 4, errs[0].Line)
+	assert.EqualError(t, errs[0], "line 4: missing fields")
+}
+
+func TestParsePrecision(t *testing.T) {
+	for s, want := range map[string]time.Duration{"": time.Nanosecond, "us": time.Microsecond, "ms": time.Millisecond, "s": time.Second, "h": time.Hour} {
+		got, err := ParsePrecision(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParsePrecision("d")
+	assert.Error(t, err)
+}
+
+func TestMetrics(t *testing.T) {
+	p, err := ParseLine(`net,iface=eth0 value=0.5,up=true,bytes_total=10i,packets=3u,procs=7i,name="eth0"`, time.Nanosecond)
+	require.NoError(t, err)
+
+	// целые поля без правила — gauge, счетчиками становятся поля на _total
+	ms, err := p.Metrics(Counters{})
+	require.NoError(t, err)
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric(`net{iface="eth0"}`, metrics.MetricTypeGauge, "0.5"),
+		metrics.NewMetric(`net_up{iface="eth0"}`, metrics.MetricTypeGauge, "1"),
+		metrics.NewMetric(`net_bytes_total{iface="eth0"}`, metrics.MetricTypeCounter, "10"),
+		metrics.NewMetric(`net_packets{iface="eth0"}`, metrics.MetricTypeGauge, "3"),
+		metrics.NewMetric(`net_procs{iface="eth0"}`, metrics.MetricTypeGauge, "7"),
+	}, ms)
+
+	counters, err := ParseCounters("net_packets, diskio_*")
+	require.NoError(t, err)
+	ms, err = p.Metrics(counters)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.NewMetric(`net_packets{iface="eth0"}`, metrics.MetricTypeCounter, "3"), ms[3])
+	assert.Equal(t, metrics.MetricTypeGauge, ms[4].MType)
+
+	p, err = ParseLine("net packets=18446744073709551615u", time.Nanosecond)
+	require.NoError(t, err)
+	_, err = p.Metrics(counters)
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	// gauge хранит большое беззнаковое значение без переполнения
+	ms, err = p.Metrics(Counters{})
+	require.NoError(t, err)
+	assert.Equal(t, metrics.MetricTypeGauge, ms[0].MType)
+}
+
+func TestParseCounters(t *testing.T) {
+	c, err := ParseCounters("")
+	require.NoError(t, err)
+	assert.True(t, c.Match("http_requests_total"))
+	assert.False(t, c.Match("system_n_users"))
+
+	c, err = ParseCounters("diskio_*,net_bytes_recv")
+	require.NoError(t, err)
+	assert.True(t, c.Match("diskio_reads"))
+	assert.True(t, c.Match("net_bytes_recv"))
+	assert.False(t, c.Match("net_bytes_sent"))
+
+	_, err = ParseCounters("net_[")
+	assert.Error(t, err)
+}
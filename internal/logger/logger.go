@@ -1,18 +1,42 @@
 // Package logger предоставляет доступ к глобальному логгеру
 package logger
 
-import "go.uber.org/zap"
+import (
+	"sync/atomic"
 
-var state *zap.Logger
+	"go.uber.org/zap"
+)
+
+// state глобальный логгер, его можно заменить во время работы
+var state atomic.Pointer[zap.Logger]
+
+// level уровень логирования, который можно менять во время работы
+var level = zap.NewAtomicLevel()
 
 // Set установить глобальную переменную логгера
 func Set() error {
-	var err error
-	state, err = zap.NewProduction()
-	return err
+	config := zap.NewProductionConfig()
+	config.Level = level
+	l, err := config.Build()
+	if err != nil {
+		return err
+	}
+
+	state.Store(l)
+	return nil
 }
 
 // Get получить глобальную переменную логгера
 func Get() *zap.Logger {
-	return state
+	return state.Load()
+}
+
+// SetLevel меняет уровень логирования, например "debug" или "warn"
+func SetLevel(l string) error {
+	return level.UnmarshalText([]byte(l))
+}
+
+// Level возвращает текущий уровень логирования
+func Level() string {
+	return level.String()
 }
@@ -0,0 +1,21 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLevel(t *testing.T) {
+	assert.NoError(t, Set())
+	assert.NotNil(t, Get())
+
+	assert.NoError(t, SetLevel("debug"))
+	assert.Equal(t, "debug", Level())
+	assert.True(t, Get().Core().Enabled(-1))
+
+	assert.Error(t, SetLevel("wrong"))
+	assert.Equal(t, "debug", Level())
+
+	assert.NoError(t, SetLevel("info"))
+}
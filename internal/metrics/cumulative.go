@@ -0,0 +1,17 @@
+package metrics
+
+// Increment переводит накопленное значение счетчика в приращение. Счетчики сервера прибавляют
+// присланное значение к сохраненному, а многие источники присылают накопленный итог: приращение
+// считается от прошлого итога last, который репозиторий хранит вместе со счетчиком. Если прошлого
+// итога нет или итог меньше прошлого (счетчик сброшен источником), итог прибавляется целиком.
+// Возвращает копию m, в которой итог заменен приращением
+func Increment(m Metrics, last *int64) Metrics {
+	delta := *m.Delta
+	if last != nil && delta >= *last {
+		delta -= *last
+	}
+
+	m = m.Clone()
+	m.Delta = &delta
+	return m
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Метки метрики хранятся в ее имени в виде name{key="value",...}, как в Prometheus: у метрики
+// нет отдельного поля для меток, а хранилища различают метрики только по типу и имени.
+// Метки упорядочены по ключу, поэтому одному набору меток соответствует одно имя
+
+// ErrBadLabels возвращается, если метки в имени метрики записаны с ошибкой
+var ErrBadLabels = errors.New("malformed metric labels")
+
+// FormatID возвращает имя метрики name с метками labels
+func FormatID(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[key]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// ParseID разбирает имя метрики на имя без меток и метки. Имя без меток возвращается как есть
+func ParseID(id string) (string, map[string]string, error) {
+	open := strings.IndexByte(id, '{')
+	if open < 0 {
+		return id, nil, nil
+	}
+	if !strings.HasSuffix(id, "}") {
+		return "", nil, ErrBadLabels
+	}
+
+	name, rest := id[:open], id[open+1:len(id)-1]
+	labels := make(map[string]string)
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, `="`)
+		if !ok || key == "" {
+			return "", nil, ErrBadLabels
+		}
+
+		var b strings.Builder
+		i := 0
+		for ; i < len(value) && value[i] != '"'; i++ {
+			if value[i] != '\\' {
+				b.WriteByte(value[i])
+				continue
+			}
+
+			i++
+			if i == len(value) {
+				return "", nil, ErrBadLabels
+			}
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(value[i])
+			}
+		}
+		if i == len(value) {
+			return "", nil, ErrBadLabels
+		}
+
+		labels[key] = b.String()
+		rest = value[i+1:]
+		if rest != "" {
+			if rest[0] != ',' {
+				return "", nil, ErrBadLabels
+			}
+			rest = rest[1:]
+		}
+	}
+
+	return name, labels, nil
+}
+
+// WithLabel возвращает имя метрики id с добавленной или замененной меткой key. Если метки
+// в id записаны с ошибкой, все id считается именем без меток
+func WithLabel(id, key, value string) string {
+	name, labels, err := ParseID(id)
+	if err != nil {
+		name, labels = id, nil
+	}
+	if labels == nil {
+		labels = make(map[string]string, 1)
+	}
+
+	labels[key] = value
+	return FormatID(name, labels)
+}
@@ -4,6 +4,7 @@ package metrics
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 )
 
@@ -14,6 +15,16 @@ const (
 	MetricTypeCounter = "counter"
 )
 
+// ErrWrongType возвращается при проверке метрики неизвестного типа
+var ErrWrongType = errors.New("wrong metric type")
+
+// ErrNoValue возвращается при проверке метрики без значения ее типа: delta у счетчика, value у gauge
+var ErrNoValue = errors.New("metric value is missing")
+
+// ErrNotFinite возвращается при проверке gauge со значением NaN или бесконечностью, такие значения
+// нельзя закодировать в JSON
+var ErrNotFinite = errors.New("metric value is not finite")
+
 // Metrics представляет структуру метрики
 type Metrics struct {
 	Delta *int64   `json:"delta,omitempty"`
@@ -67,11 +78,37 @@ func (m *Metrics) GetValue() string {
 	return ""
 }
 
-// Validate валидирует метрку, проверяет ее тип
+// Clone возвращает копию метрики, не разделяющую значения с исходной
+func (m Metrics) Clone() Metrics {
+	if m.Delta != nil {
+		delta := *m.Delta
+		m.Delta = &delta
+	}
+
+	if m.Value != nil {
+		value := *m.Value
+		m.Value = &value
+	}
+
+	return m
+}
+
+// Validate валидирует метрку, проверяет ее тип, наличие значения этого типа и что значение gauge конечно
 func (m Metrics) Validate() error {
-	if m.MType != MetricTypeGauge &&
-		m.MType != MetricTypeCounter {
-		return errors.New("wrong metric type")
+	switch m.MType {
+	case MetricTypeGauge:
+		if m.Value == nil {
+			return ErrNoValue
+		}
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return ErrNotFinite
+		}
+	case MetricTypeCounter:
+		if m.Delta == nil {
+			return ErrNoValue
+		}
+	default:
+		return ErrWrongType
 	}
 
 	return nil
@@ -50,4 +50,68 @@ func TestValidate(t *testing.T) {
 	m = NewMetric("test", "wrong", "20")
 	err = m.Validate()
 	assert.Error(t, err, errors.New("wrong metric type"))
+
+	assert.ErrorIs(t, Metrics{ID: "test", MType: MetricTypeGauge}.Validate(), ErrNoValue)
+	assert.ErrorIs(t, Metrics{ID: "test", MType: MetricTypeCounter, Value: new(float64)}.Validate(), ErrNoValue)
+
+	for _, value := range []string{"NaN", "+Inf", "-Inf"} {
+		assert.ErrorIs(t, NewMetric("test", MetricTypeGauge, value).Validate(), ErrNotFinite, value)
+	}
+}
+
+func TestClone(t *testing.T) {
+	gauge := NewMetric("Alloc", MetricTypeGauge, "1.5")
+	clone := gauge.Clone()
+	assert.Equal(t, gauge, clone)
+	*clone.Value = 2
+	assert.Equal(t, "1.5", gauge.GetValue())
+
+	counter := NewMetric("PollCount", MetricTypeCounter, "1")
+	clone = counter.Clone()
+	*clone.Delta = 5
+	assert.Equal(t, "1", counter.GetValue())
+
+	assert.Equal(t, Metrics{ID: "empty"}, Metrics{ID: "empty"}.Clone())
+}
+
+func TestFormatID(t *testing.T) {
+	assert.Equal(t, "Alloc", FormatID("Alloc", nil))
+	assert.Equal(t, `Alloc{host="a",source="b"}`, FormatID("Alloc", map[string]string{"source": "b", "host": "a"}))
+	assert.Equal(t, `Alloc{path="C:\\tmp \"x\"\n"}`, FormatID("Alloc", map[string]string{"path": "C:\\tmp \"x\"\n"}))
+}
+
+func TestParseID(t *testing.T) {
+	labels := map[string]string{"host": "a,b", "path": "C:\\tmp \"x\"\n", "empty": ""}
+	name, parsed, err := ParseID(FormatID("Alloc", labels))
+	assert.NoError(t, err)
+	assert.Equal(t, "Alloc", name)
+	assert.Equal(t, labels, parsed)
+
+	name, parsed, err = ParseID("Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, "Alloc", name)
+	assert.Nil(t, parsed)
+
+	for _, id := range []string{`Alloc{`, `Alloc{host}`, `Alloc{="a"}`, `Alloc{host="a}`, `Alloc{host="a"x}`, `Alloc{host="a\`} {
+		_, _, err = ParseID(id)
+		assert.ErrorIs(t, err, ErrBadLabels, id)
+	}
+}
+
+func TestWithLabel(t *testing.T) {
+	assert.Equal(t, `Alloc{source="a"}`, WithLabel("Alloc", "source", "a"))
+	assert.Equal(t, `Alloc{host="h",source="b"}`, WithLabel(`Alloc{host="h",source="a"}`, "source", "b"))
+	assert.Equal(t, `Alloc{{source="a"}`, WithLabel("Alloc{", "source", "a"))
+}
+
+func TestIncrement(t *testing.T) {
+	// первый итог прибавляется целиком, следующие — приращением, после сброса — целиком
+	var last *int64
+	for _, tt := range []struct{ total, delta string }{{"10", "10"}, {"15", "5"}, {"15", "0"}, {"4", "4"}, {"6", "2"}} {
+		total := NewMetric("bytes", MetricTypeCounter, tt.total)
+		delta := Increment(total, last)
+		assert.Equal(t, tt.delta, delta.GetValue())
+		assert.Equal(t, tt.total, total.GetValue())
+		last = total.Delta
+	}
 }
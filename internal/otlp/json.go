@@ -0,0 +1,73 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// В JSON кодировке OTLP 64-битные целые передаются строками, а перечисления могут
+// передаваться как числом, так и именем значения. Типы ниже принимают оба варианта
+
+// Int64 целое, которое в JSON может быть строкой
+type Int64 int64
+
+// UnmarshalJSON разбирает число или строку с числом
+func (i *Int64) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseInt(string(bytes.Trim(b, `"`)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid integer %s", ErrMalformed, b)
+	}
+	*i = Int64(v)
+	return nil
+}
+
+// Uint64 целое без знака, которое в JSON может быть строкой
+type Uint64 uint64
+
+// UnmarshalJSON разбирает число или строку с числом
+func (i *Uint64) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseUint(string(bytes.Trim(b, `"`)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid integer %s", ErrMalformed, b)
+	}
+	*i = Uint64(v)
+	return nil
+}
+
+// temporalityNames имена значений Temporality в JSON
+var temporalityNames = map[string]Temporality{
+	"AGGREGATION_TEMPORALITY_UNSPECIFIED": TemporalityUnspecified,
+	"AGGREGATION_TEMPORALITY_DELTA":       TemporalityDelta,
+	"AGGREGATION_TEMPORALITY_CUMULATIVE":  TemporalityCumulative,
+}
+
+// UnmarshalJSON разбирает номер или имя способа накопления
+func (t *Temporality) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		v, ok := temporalityNames[name]
+		if !ok {
+			return fmt.Errorf("%w: unknown aggregation temporality %q", ErrMalformed, name)
+		}
+		*t = v
+		return nil
+	}
+
+	var v int32
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("%w: invalid aggregation temporality %s", ErrMalformed, b)
+	}
+	*t = Temporality(v)
+	return nil
+}
+
+// UnmarshalJSON разбирает запрос из JSON
+func UnmarshalJSON(b []byte) (ExportRequest, error) {
+	r := ExportRequest{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,433 @@
+// Package otlp принимает метрики OpenTelemetry в формате OTLP/HTTP.
+//
+// Запрос ExportMetricsServiceRequest разбирается из protobuf или JSON. Gauge становятся gauge,
+// Sum — счетчиками, Histogram и Summary раскладываются на метрики, как в Prometheus:
+// name_bucket{le="..."}, name_count, name_sum и name{quantile="..."}. Сумма значений name_sum
+// сохраняется gauge: счетчики сервера целые, а сумма дробная и часто меньше единицы, например
+// в секундах. Для накопительных гистограмм и сводок gauge содержит итог с начала отсчета,
+// для дельта-гистограмм — сумму за последний интервал. Атрибуты ресурса и точки становятся
+// метками, атрибуты точки важнее
+package otlp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"ya-prac-project1/internal/metrics"
+)
+
+// Temporality способ накопления сумм и гистограмм
+type Temporality int32
+
+const (
+	// TemporalityUnspecified способ не задан, точки с ним отклоняются
+	TemporalityUnspecified Temporality = iota
+	// TemporalityDelta каждая точка содержит изменение с прошлой точки
+	TemporalityDelta
+	// TemporalityCumulative каждая точка содержит итог с начала отсчета
+	TemporalityCumulative
+)
+
+// flagNoRecordedValue отмечает точку без значения: ряд перестал обновляться
+const flagNoRecordedValue = 1
+
+// Метки, которые добавляются к составляющим гистограмм и сводок
+const (
+	BucketLabel   = "le"
+	QuantileLabel = "quantile"
+)
+
+// ErrMalformed возвращается, если запрос не удалось разобрать
+var ErrMalformed = errors.New("malformed otlp message")
+
+// ExportRequest запрос ExportMetricsServiceRequest
+type ExportRequest struct {
+	ResourceMetrics []ResourceMetrics `json:"resourceMetrics"`
+}
+
+// ResourceMetrics метрики одного ресурса: сервиса, процесса, хоста
+type ResourceMetrics struct {
+	Resource     Resource       `json:"resource"`
+	ScopeMetrics []ScopeMetrics `json:"scopeMetrics"`
+}
+
+// Resource ресурс, атрибуты которого относятся ко всем его метрикам
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeMetrics метрики одной библиотеки инструментирования
+type ScopeMetrics struct {
+	Metrics []Metric `json:"metrics"`
+}
+
+// KeyValue атрибут
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue значение атрибута. Массивы, словари и байты не поддерживаются,
+// атрибуты с ними пропускаются
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *Int64   `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Metric метрика. Задано ровно одно из полей с данными
+type Metric struct {
+	Name                 string                `json:"name"`
+	Gauge                *Gauge                `json:"gauge,omitempty"`
+	Sum                  *Sum                  `json:"sum,omitempty"`
+	Histogram            *Histogram            `json:"histogram,omitempty"`
+	ExponentialHistogram *ExponentialHistogram `json:"exponentialHistogram,omitempty"`
+	Summary              *Summary              `json:"summary,omitempty"`
+}
+
+// Gauge текущие значения
+type Gauge struct {
+	DataPoints []NumberDataPoint `json:"dataPoints"`
+}
+
+// Sum суммы: монотонные — счетчики, немонотонные — значения, которые могут и расти, и убывать
+type Sum struct {
+	DataPoints             []NumberDataPoint `json:"dataPoints"`
+	AggregationTemporality Temporality       `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+// Histogram гистограммы с явными границами корзин
+type Histogram struct {
+	DataPoints             []HistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality Temporality          `json:"aggregationTemporality"`
+}
+
+// ExponentialHistogram экспоненциальные гистограммы. Не поддерживаются, их точки отклоняются
+type ExponentialHistogram struct {
+	DataPoints []struct{} `json:"dataPoints"`
+}
+
+// Summary сводки с квантилями, всегда накопительные
+type Summary struct {
+	DataPoints []SummaryDataPoint `json:"dataPoints"`
+}
+
+// NumberDataPoint точка Gauge или Sum, задано AsDouble или AsInt
+type NumberDataPoint struct {
+	Attributes []KeyValue `json:"attributes"`
+	AsDouble   *float64   `json:"asDouble,omitempty"`
+	AsInt      *Int64     `json:"asInt,omitempty"`
+	Flags      uint32     `json:"flags"`
+}
+
+// HistogramDataPoint точка гистограммы. BucketCounts — число значений в каждой корзине,
+// корзин на одну больше, чем границ: последняя не ограничена сверху
+type HistogramDataPoint struct {
+	Attributes     []KeyValue `json:"attributes"`
+	Count          Uint64     `json:"count"`
+	Sum            *float64   `json:"sum,omitempty"`
+	BucketCounts   []Uint64   `json:"bucketCounts"`
+	ExplicitBounds []float64  `json:"explicitBounds"`
+	Flags          uint32     `json:"flags"`
+}
+
+// SummaryDataPoint точка сводки
+type SummaryDataPoint struct {
+	Attributes     []KeyValue        `json:"attributes"`
+	Count          Uint64            `json:"count"`
+	Sum            float64           `json:"sum"`
+	QuantileValues []ValueAtQuantile `json:"quantileValues"`
+	Flags          uint32            `json:"flags"`
+}
+
+// ValueAtQuantile значение квантиля
+type ValueAtQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Result итог преобразования запроса в метрики
+type Result struct {
+	Metrics []metrics.Metrics
+	// Totals накопительные счетчики: значение — итог с начала отсчета, а не приращение.
+	// В приращения их переводит репозиторий по последнему сохраненному итогу
+	Totals []metrics.Metrics
+	// Rejected число отклоненных точек, Err — причина первого отклонения
+	Rejected int64
+	Err      error
+}
+
+func (r *Result) reject(name string, points int, err error) {
+	r.Rejected += int64(points)
+	if r.Err == nil {
+		r.Err = fmt.Errorf("metric %q: %w", name, err)
+	}
+}
+
+// Metrics преобразует запрос в метрики сервера. Накопительные счетчики попадают в Totals,
+// дробные значения счетчиков округляются. Точки без значения пропускаются, точки, которые
+// нельзя сохранить, отклоняются
+func (r ExportRequest) Metrics() Result {
+	res := Result{Metrics: []metrics.Metrics{}, Totals: []metrics.Metrics{}}
+	for _, rm := range r.ResourceMetrics {
+		resource := attributes(nil, rm.Resource.Attributes)
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				convertMetric(&res, resource, m)
+			}
+		}
+	}
+	return res
+}
+
+func convertMetric(res *Result, resource map[string]string, m Metric) {
+	c := converter{res: res, resource: resource, name: m.Name}
+
+	switch {
+	case m.Name == "":
+		res.reject(m.Name, m.points(), errors.New("empty name"))
+	case m.Gauge != nil:
+		for _, p := range m.Gauge.DataPoints {
+			c.gauge(p)
+		}
+	case m.Sum != nil:
+		if m.Sum.AggregationTemporality == TemporalityUnspecified {
+			res.reject(m.Name, len(m.Sum.DataPoints), errors.New("unspecified aggregation temporality"))
+			return
+		}
+		for _, p := range m.Sum.DataPoints {
+			c.sum(p, m.Sum.AggregationTemporality, m.Sum.IsMonotonic)
+		}
+	case m.Histogram != nil:
+		if m.Histogram.AggregationTemporality == TemporalityUnspecified {
+			res.reject(m.Name, len(m.Histogram.DataPoints), errors.New("unspecified aggregation temporality"))
+			return
+		}
+		for _, p := range m.Histogram.DataPoints {
+			c.histogram(p, m.Histogram.AggregationTemporality)
+		}
+	case m.Summary != nil:
+		for _, p := range m.Summary.DataPoints {
+			c.summary(p)
+		}
+	case m.ExponentialHistogram != nil:
+		res.reject(m.Name, len(m.ExponentialHistogram.DataPoints), errors.New("exponential histograms are not supported"))
+	}
+}
+
+// points возвращает число точек метрики
+func (m Metric) points() int {
+	switch {
+	case m.Gauge != nil:
+		return len(m.Gauge.DataPoints)
+	case m.Sum != nil:
+		return len(m.Sum.DataPoints)
+	case m.Histogram != nil:
+		return len(m.Histogram.DataPoints)
+	case m.ExponentialHistogram != nil:
+		return len(m.ExponentialHistogram.DataPoints)
+	case m.Summary != nil:
+		return len(m.Summary.DataPoints)
+	}
+	return 0
+}
+
+// converter добавляет в итог метрики точек одной метрики
+type converter struct {
+	res      *Result
+	resource map[string]string
+	name     string
+}
+
+func (c converter) labels(attrs []KeyValue) map[string]string {
+	labels := make(map[string]string, len(c.resource)+len(attrs))
+	for key, value := range c.resource {
+		labels[key] = value
+	}
+	return attributes(labels, attrs)
+}
+
+func (c converter) addGauge(name string, labels map[string]string, value float64) {
+	c.res.Metrics = append(c.res.Metrics, metrics.Metrics{ID: metrics.FormatID(name, labels), MType: metrics.MetricTypeGauge, Value: &value})
+}
+
+func (c converter) addCounter(name string, labels map[string]string, value int64, temporality Temporality) {
+	m := metrics.Metrics{ID: metrics.FormatID(name, labels), MType: metrics.MetricTypeCounter, Delta: &value}
+	if temporality == TemporalityCumulative {
+		c.res.Totals = append(c.res.Totals, m)
+		return
+	}
+	c.res.Metrics = append(c.res.Metrics, m)
+}
+
+func (c converter) gauge(p NumberDataPoint) {
+	if p.Flags&flagNoRecordedValue != 0 {
+		return
+	}
+
+	value, err := p.value()
+	if err != nil {
+		c.res.reject(c.name, 1, err)
+		return
+	}
+	c.addGauge(c.name, c.labels(p.Attributes), value)
+}
+
+// sum сохраняет монотонную сумму счетчиком. Немонотонная накопительная сумма — это текущее
+// значение, например размер очереди, она сохраняется gauge
+func (c converter) sum(p NumberDataPoint, temporality Temporality, monotonic bool) {
+	if p.Flags&flagNoRecordedValue != 0 {
+		return
+	}
+
+	value, err := p.value()
+	if err != nil {
+		c.res.reject(c.name, 1, err)
+		return
+	}
+
+	if !monotonic && temporality == TemporalityCumulative {
+		c.addGauge(c.name, c.labels(p.Attributes), value)
+		return
+	}
+
+	delta, err := toInt(value)
+	if err != nil {
+		c.res.reject(c.name, 1, err)
+		return
+	}
+	c.addCounter(c.name, c.labels(p.Attributes), delta, temporality)
+}
+
+// histogram сохраняет гистограмму счетчиками корзин и числа значений и gauge суммы значений.
+// Счетчик корзины name_bucket{le="x"}, как в Prometheus, содержит число значений не больше x
+func (c converter) histogram(p HistogramDataPoint, temporality Temporality) {
+	if p.Flags&flagNoRecordedValue != 0 {
+		return
+	}
+
+	if len(p.BucketCounts) != 0 && len(p.BucketCounts) != len(p.ExplicitBounds)+1 {
+		c.res.reject(c.name, 1, fmt.Errorf("want %d bucket counts, got %d", len(p.ExplicitBounds)+1, len(p.BucketCounts)))
+		return
+	}
+	if p.Count > math.MaxInt64 {
+		c.res.reject(c.name, 1, errors.New("count overflows counter"))
+		return
+	}
+
+	if p.Sum != nil && !finite(*p.Sum) {
+		c.res.reject(c.name, 1, errors.New("sum is not a finite number"))
+		return
+	}
+
+	labels := c.labels(p.Attributes)
+	var total uint64
+	for i, count := range p.BucketCounts {
+		total += uint64(count)
+		le := "+Inf"
+		if i < len(p.ExplicitBounds) {
+			le = strconv.FormatFloat(p.ExplicitBounds[i], 'g', -1, 64)
+		}
+		c.addCounter(c.name+"_bucket", withLabel(labels, BucketLabel, le), int64(min(total, math.MaxInt64)), temporality)
+	}
+	c.addCounter(c.name+"_count", labels, int64(p.Count), temporality)
+	if p.Sum != nil {
+		c.addGauge(c.name+"_sum", labels, *p.Sum)
+	}
+}
+
+// summary сохраняет квантили и сумму значений gauge, число значений — накопительным счетчиком
+func (c converter) summary(p SummaryDataPoint) {
+	if p.Flags&flagNoRecordedValue != 0 {
+		return
+	}
+
+	if p.Count > math.MaxInt64 {
+		c.res.reject(c.name, 1, errors.New("count overflows counter"))
+		return
+	}
+	if !finite(p.Sum) {
+		c.res.reject(c.name, 1, errors.New("sum is not a finite number"))
+		return
+	}
+
+	labels := c.labels(p.Attributes)
+	for _, q := range p.QuantileValues {
+		// сводки из Prometheus присылают NaN в квантилях, пока нет наблюдений: такой квантиль
+		// пропускается, а количество и сумма сохраняются
+		if !finite(q.Value) || !finite(q.Quantile) {
+			continue
+		}
+		c.addGauge(c.name, withLabel(labels, QuantileLabel, strconv.FormatFloat(q.Quantile, 'g', -1, 64)), q.Value)
+	}
+	c.addCounter(c.name+"_count", labels, int64(p.Count), TemporalityCumulative)
+	c.addGauge(c.name+"_sum", labels, p.Sum)
+}
+
+func (p NumberDataPoint) value() (float64, error) {
+	switch {
+	case p.AsDouble != nil:
+		if !finite(*p.AsDouble) {
+			return 0, errors.New("not a finite number")
+		}
+		return *p.AsDouble, nil
+	case p.AsInt != nil:
+		return float64(*p.AsInt), nil
+	}
+	return 0, errors.New("missing value")
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// toInt округляет значение счетчика: счетчики сервера целые
+func toInt(v float64) (int64, error) {
+	v = math.Round(v)
+	if math.IsNaN(v) || v >= math.MaxInt64 || v < math.MinInt64 {
+		return 0, errors.New("value overflows counter")
+	}
+	return int64(v), nil
+}
+
+// attributes добавляет в labels атрибуты, которые можно сохранить меткой
+func attributes(labels map[string]string, attrs []KeyValue) map[string]string {
+	for _, kv := range attrs {
+		value, ok := kv.Value.Text()
+		if kv.Key == "" || !ok {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string, len(attrs))
+		}
+		labels[kv.Key] = value
+	}
+	return labels
+}
+
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	res := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		res[k] = v
+	}
+	res[key] = value
+	return res
+}
+
+// Text возвращает значение строкой, false — если значение не простое
+func (v AnyValue) Text() (string, bool) {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue, true
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue), true
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10), true
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64), true
+	}
+	return "", false
+}
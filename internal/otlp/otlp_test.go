@@ -0,0 +1,222 @@
+package otlp
+
+import (
+	"math"
+	"testing"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message собирает сообщение protobuf для тестов
+type message []byte
+
+func (m message) bytes(num protowire.Number, v []byte) message {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, v)
+}
+
+func (m message) str(num protowire.Number, s string) message {
+	return m.bytes(num, []byte(s))
+}
+
+func (m message) varint(num protowire.Number, v uint64) message {
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, v)
+}
+
+func (m message) fixed64(num protowire.Number, v uint64) message {
+	m = protowire.AppendTag(m, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(m, v)
+}
+
+func (m message) double(num protowire.Number, v float64) message {
+	return m.fixed64(num, math.Float64bits(v))
+}
+
+func attr(key, value string) []byte {
+	return message{}.str(1, key).bytes(2, message{}.str(1, value))
+}
+
+// protoRequest запрос с gauge, монотонной накопительной суммой, гистограммой и сводкой
+func protoRequest(total uint64) []byte {
+	resource := message{}.
+		bytes(1, attr("service.name", "api")).
+		bytes(1, message{}.str(1, "pid").bytes(2, message{}.varint(3, 42)))
+
+	gauge := message{}.str(1, "cpu").bytes(5, message{}.
+		bytes(1, message{}.double(4, 0.5).bytes(7, attr("core", "0"))))
+
+	sum := message{}.str(1, "requests").bytes(7, message{}.
+		bytes(1, message{}.fixed64(6, total).bytes(7, attr("service.name", "web"))).
+		varint(2, uint64(TemporalityCumulative)).
+		varint(3, 1))
+
+	var counts, bounds []byte
+	for _, c := range []uint64{1, 2, 3} {
+		counts = protowire.AppendFixed64(counts, c)
+	}
+	for _, b := range []float64{0.1, 1} {
+		bounds = protowire.AppendFixed64(bounds, math.Float64bits(b))
+	}
+	histogram := message{}.str(1, "latency").bytes(9, message{}.
+		bytes(1, message{}.fixed64(4, 6).double(5, 7.6).bytes(6, counts).bytes(7, bounds)).
+		varint(2, uint64(TemporalityDelta)))
+
+	summary := message{}.str(1, "gc").bytes(11, message{}.
+		bytes(1, message{}.fixed64(4, 3).double(5, 1.2).bytes(6, message{}.double(1, 0.5).double(2, 0.25))))
+
+	scope := message{}.bytes(1, message{}.str(1, "meter")).
+		bytes(2, gauge).bytes(2, sum).bytes(2, histogram).bytes(2, summary)
+	return message{}.bytes(1, message{}.bytes(1, resource).bytes(2, scope))
+}
+
+func TestUnmarshal(t *testing.T) {
+	r, err := Unmarshal(protoRequest(10))
+	require.NoError(t, err)
+	require.Len(t, r.ResourceMetrics, 1)
+
+	rm := r.ResourceMetrics[0]
+	require.Len(t, rm.Resource.Attributes, 2)
+	assert.Equal(t, "service.name", rm.Resource.Attributes[0].Key)
+	assert.Equal(t, Int64(42), *rm.Resource.Attributes[1].Value.IntValue)
+
+	ms := rm.ScopeMetrics[0].Metrics
+	require.Len(t, ms, 4)
+	assert.Equal(t, 0.5, *ms[0].Gauge.DataPoints[0].AsDouble)
+	assert.Equal(t, TemporalityCumulative, ms[1].Sum.AggregationTemporality)
+	assert.True(t, ms[1].Sum.IsMonotonic)
+	assert.Equal(t, Int64(10), *ms[1].Sum.DataPoints[0].AsInt)
+	assert.Equal(t, []Uint64{1, 2, 3}, ms[2].Histogram.DataPoints[0].BucketCounts)
+	assert.Equal(t, []float64{0.1, 1}, ms[2].Histogram.DataPoints[0].ExplicitBounds)
+	assert.Equal(t, []ValueAtQuantile{{Quantile: 0.5, Value: 0.25}}, ms[3].Summary.DataPoints[0].QuantileValues)
+
+	_, err = Unmarshal([]byte{0x0a, 0x05, 0x01})
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	r, err := UnmarshalJSON([]byte(`{"resourceMetrics": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+		"scopeMetrics": [{"scope": {"name": "meter"}, "metrics": [
+			{"name": "requests", "unit": "1", "sum": {
+				"aggregationTemporality": "AGGREGATION_TEMPORALITY_DELTA", "isMonotonic": true,
+				"dataPoints": [{"asInt": "5", "timeUnixNano": "1704888000000000000"}]}},
+			{"name": "latency", "histogram": {"aggregationTemporality": 2,
+				"dataPoints": [{"count": "3", "sum": 1.5, "bucketCounts": ["1", 2], "explicitBounds": [1]}]}}
+		]}]
+	}]}`))
+	require.NoError(t, err)
+
+	ms := r.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Equal(t, TemporalityDelta, ms[0].Sum.AggregationTemporality)
+	assert.Equal(t, Int64(5), *ms[0].Sum.DataPoints[0].AsInt)
+	assert.Equal(t, TemporalityCumulative, ms[1].Histogram.AggregationTemporality)
+	assert.Equal(t, []Uint64{1, 2}, ms[1].Histogram.DataPoints[0].BucketCounts)
+
+	for _, body := range []string{`{`, `{"resourceMetrics": [{"scopeMetrics": [{"metrics": [{"sum": {"aggregationTemporality": "MONTHLY"}}]}]}]}`} {
+		_, err = UnmarshalJSON([]byte(body))
+		assert.ErrorIs(t, err, ErrMalformed, body)
+	}
+}
+
+func TestExportRequest_Metrics(t *testing.T) {
+	r, err := Unmarshal(protoRequest(10))
+	require.NoError(t, err)
+	res := r.Metrics()
+	assert.Zero(t, res.Rejected)
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric(`cpu{core="0",pid="42",service.name="api"}`, metrics.MetricTypeGauge, "0.5"),
+		metrics.NewMetric(`latency_bucket{le="0.1",pid="42",service.name="api"}`, metrics.MetricTypeCounter, "1"),
+		metrics.NewMetric(`latency_bucket{le="1",pid="42",service.name="api"}`, metrics.MetricTypeCounter, "3"),
+		metrics.NewMetric(`latency_bucket{le="+Inf",pid="42",service.name="api"}`, metrics.MetricTypeCounter, "6"),
+		metrics.NewMetric(`latency_count{pid="42",service.name="api"}`, metrics.MetricTypeCounter, "6"),
+		metrics.NewMetric(`latency_sum{pid="42",service.name="api"}`, metrics.MetricTypeGauge, "7.6"),
+		metrics.NewMetric(`gc{pid="42",quantile="0.5",service.name="api"}`, metrics.MetricTypeGauge, "0.25"),
+		metrics.NewMetric(`gc_sum{pid="42",service.name="api"}`, metrics.MetricTypeGauge, "1.2"),
+	}, res.Metrics)
+
+	// накопительные счетчики возвращаются итогами, в приращения их переводит репозиторий
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric(`requests{pid="42",service.name="web"}`, metrics.MetricTypeCounter, "10"),
+		metrics.NewMetric(`gc_count{pid="42",service.name="api"}`, metrics.MetricTypeCounter, "3"),
+	}, res.Totals)
+}
+
+// TestExportRequest_Metrics_fractionalSum проверяет, что суммы меньше единицы, например
+// длительности в секундах, не округляются до нуля
+func TestExportRequest_Metrics_fractionalSum(t *testing.T) {
+	sum, nan := 0.35, math.NaN()
+	r := ExportRequest{ResourceMetrics: []ResourceMetrics{{ScopeMetrics: []ScopeMetrics{{Metrics: []Metric{
+		{Name: "rpc", Histogram: &Histogram{AggregationTemporality: TemporalityCumulative, DataPoints: []HistogramDataPoint{
+			{Count: 2, Sum: &sum},
+		}}},
+		{Name: "gc", Summary: &Summary{DataPoints: []SummaryDataPoint{{Count: 4, Sum: 0.004}}}},
+		{Name: "broken", Histogram: &Histogram{AggregationTemporality: TemporalityDelta, DataPoints: []HistogramDataPoint{
+			{Count: 1, Sum: &nan},
+		}}},
+	}}}}}}
+
+	res := r.Metrics()
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric("rpc_sum", metrics.MetricTypeGauge, "0.35"),
+		metrics.NewMetric("gc_sum", metrics.MetricTypeGauge, "0.004"),
+	}, res.Metrics)
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric("rpc_count", metrics.MetricTypeCounter, "2"),
+		metrics.NewMetric("gc_count", metrics.MetricTypeCounter, "4"),
+	}, res.Totals)
+	assert.Equal(t, int64(1), res.Rejected)
+}
+
+func TestExportRequest_Metrics_nanQuantile(t *testing.T) {
+	r := ExportRequest{ResourceMetrics: []ResourceMetrics{{ScopeMetrics: []ScopeMetrics{{Metrics: []Metric{
+		{Name: "gc", Summary: &Summary{DataPoints: []SummaryDataPoint{{
+			QuantileValues: []ValueAtQuantile{{Quantile: 0.5, Value: math.NaN()}, {Quantile: 0.9, Value: math.Inf(1)}, {Quantile: 1, Value: 0.2}},
+		}}}},
+	}}}}}}
+
+	res := r.Metrics()
+	assert.Zero(t, res.Rejected)
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric(`gc{quantile="1"}`, metrics.MetricTypeGauge, "0.2"),
+		metrics.NewMetric("gc_sum", metrics.MetricTypeGauge, "0"),
+	}, res.Metrics)
+	assert.Equal(t, []metrics.Metrics{metrics.NewMetric("gc_count", metrics.MetricTypeCounter, "0")}, res.Totals)
+	for _, m := range res.Metrics {
+		assert.NoError(t, m.Validate())
+	}
+}
+
+func TestExportRequest_Metrics_rejected(t *testing.T) {
+	value, inf := 1.0, math.Inf(1)
+	r := ExportRequest{ResourceMetrics: []ResourceMetrics{{ScopeMetrics: []ScopeMetrics{{Metrics: []Metric{
+		{Name: "queue", Sum: &Sum{AggregationTemporality: TemporalityCumulative, DataPoints: []NumberDataPoint{{AsDouble: &value}}}},
+		{Name: "stale", Gauge: &Gauge{DataPoints: []NumberDataPoint{{Flags: flagNoRecordedValue}}}},
+		{Name: "empty", Gauge: &Gauge{DataPoints: []NumberDataPoint{{}}}},
+		{Name: "inf", Gauge: &Gauge{DataPoints: []NumberDataPoint{{AsDouble: &inf}}}},
+		{Name: "", Gauge: &Gauge{DataPoints: []NumberDataPoint{{AsDouble: &value}}}},
+		{Name: "unspecified", Sum: &Sum{DataPoints: []NumberDataPoint{{AsDouble: &value}, {AsDouble: &value}}}},
+		{Name: "buckets", Histogram: &Histogram{AggregationTemporality: TemporalityDelta, DataPoints: []HistogramDataPoint{
+			{Count: 1, BucketCounts: []Uint64{1}, ExplicitBounds: []float64{1}},
+		}}},
+		{Name: "exp", ExponentialHistogram: &ExponentialHistogram{DataPoints: []struct{}{{}}}},
+	}}}}}}
+
+	res := r.Metrics()
+	// немонотонная накопительная сумма — текущее значение
+	assert.Equal(t, []metrics.Metrics{metrics.NewMetric("queue", metrics.MetricTypeGauge, "1")}, res.Metrics)
+	assert.Equal(t, int64(7), res.Rejected)
+	assert.EqualError(t, res.Err, `metric "empty": missing value`)
+}
+
+func TestResponse_Marshal(t *testing.T) {
+	assert.Empty(t, Response{}.Marshal())
+
+	b := Response{PartialSuccess: &PartialSuccess{RejectedDataPoints: 2, ErrorMessage: "bad"}}.Marshal()
+	assert.Equal(t, []byte(message{}.bytes(1, message{}.varint(1, 2).str(2, "bad"))), b)
+
+	assert.Equal(t, []byte(message{}.varint(1, CodeInvalidArgument).str(2, "bad")), Status{Code: CodeInvalidArgument, Message: "bad"}.Marshal())
+}
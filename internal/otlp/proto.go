@@ -0,0 +1,392 @@
+package otlp
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Сообщения OTLP из opentelemetry-proto кодируются вручную, как и prompb в remotewrite:
+// из схемы используются только поля ниже
+//
+//	message ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+//	message ResourceMetrics    { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+//	message Resource           { repeated KeyValue attributes = 1; }
+//	message ScopeMetrics       { repeated Metric metrics = 2; }
+//	message Metric             { string name = 1; Gauge gauge = 5; Sum sum = 7; Histogram histogram = 9;
+//	                             ExponentialHistogram exponential_histogram = 10; Summary summary = 11; }
+//	message Gauge              { repeated NumberDataPoint data_points = 1; }
+//	message Sum                { repeated NumberDataPoint data_points = 1;
+//	                             AggregationTemporality aggregation_temporality = 2; bool is_monotonic = 3; }
+//	message Histogram          { repeated HistogramDataPoint data_points = 1;
+//	                             AggregationTemporality aggregation_temporality = 2; }
+//	message Summary            { repeated SummaryDataPoint data_points = 1; }
+//	message NumberDataPoint    { double as_double = 4; sfixed64 as_int = 6; repeated KeyValue attributes = 7;
+//	                             uint32 flags = 8; }
+//	message HistogramDataPoint { fixed64 count = 4; optional double sum = 5; repeated fixed64 bucket_counts = 6;
+//	                             repeated double explicit_bounds = 7; repeated KeyValue attributes = 9;
+//	                             uint32 flags = 10; }
+//	message SummaryDataPoint   { fixed64 count = 4; double sum = 5; repeated ValueAtQuantile quantile_values = 6;
+//	                             repeated KeyValue attributes = 7; uint32 flags = 8; }
+//	message ValueAtQuantile    { double quantile = 1; double value = 2; }
+//	message KeyValue           { string key = 1; AnyValue value = 2; }
+//	message AnyValue           { string string_value = 1; bool bool_value = 2; int64 int_value = 3;
+//	                             double double_value = 4; }
+//
+//	message ExportMetricsServiceResponse { ExportMetricsPartialSuccess partial_success = 1; }
+//	message ExportMetricsPartialSuccess  { int64 rejected_data_points = 1; string error_message = 2; }
+//	message Status                       { int32 code = 1; string message = 2; }
+
+// Unmarshal разбирает запрос из protobuf. Неизвестные поля пропускаются
+func Unmarshal(b []byte) (ExportRequest, error) {
+	r := ExportRequest{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		rm, err := unmarshalResourceMetrics(v)
+		if err != nil {
+			return err
+		}
+		r.ResourceMetrics = append(r.ResourceMetrics, rm)
+		return nil
+	})
+	return r, err
+}
+
+func unmarshalResourceMetrics(b []byte) (ResourceMetrics, error) {
+	rm := ResourceMetrics{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+
+		switch num {
+		case 1:
+			return consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				return appendAttribute(&rm.Resource.Attributes, num == 1, typ, v)
+			})
+		case 2:
+			sm := ScopeMetrics{}
+			err := consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 2 || typ != protowire.BytesType {
+					return nil
+				}
+				m, err := unmarshalMetric(v)
+				if err != nil {
+					return err
+				}
+				sm.Metrics = append(sm.Metrics, m)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+		}
+		return nil
+	})
+	return rm, err
+}
+
+func unmarshalMetric(b []byte) (Metric, error) {
+	m := Metric{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+
+		switch num {
+		case 1:
+			m.Name = string(v)
+		case 5:
+			m.Gauge = &Gauge{}
+			return consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 || typ != protowire.BytesType {
+					return nil
+				}
+				p, err := unmarshalNumberDataPoint(v)
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, p)
+				return err
+			})
+		case 7:
+			m.Sum = &Sum{}
+			return consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					p, err := unmarshalNumberDataPoint(v)
+					m.Sum.DataPoints = append(m.Sum.DataPoints, p)
+					return err
+				case num == 2 && typ == protowire.VarintType:
+					x, _ := protowire.ConsumeVarint(v)
+					m.Sum.AggregationTemporality = Temporality(x)
+				case num == 3 && typ == protowire.VarintType:
+					x, _ := protowire.ConsumeVarint(v)
+					m.Sum.IsMonotonic = protowire.DecodeBool(x)
+				}
+				return nil
+			})
+		case 9:
+			m.Histogram = &Histogram{}
+			return consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					p, err := unmarshalHistogramDataPoint(v)
+					m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+					return err
+				case num == 2 && typ == protowire.VarintType:
+					x, _ := protowire.ConsumeVarint(v)
+					m.Histogram.AggregationTemporality = Temporality(x)
+				}
+				return nil
+			})
+		case 10:
+			m.ExponentialHistogram = &ExponentialHistogram{}
+			return consumeFields(v, func(num protowire.Number, typ protowire.Type, _ []byte) error {
+				if num == 1 && typ == protowire.BytesType {
+					m.ExponentialHistogram.DataPoints = append(m.ExponentialHistogram.DataPoints, struct{}{})
+				}
+				return nil
+			})
+		case 11:
+			m.Summary = &Summary{}
+			return consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if num != 1 || typ != protowire.BytesType {
+					return nil
+				}
+				p, err := unmarshalSummaryDataPoint(v)
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+				return err
+			})
+		}
+		return nil
+	})
+	return m, err
+}
+
+func unmarshalNumberDataPoint(b []byte) (NumberDataPoint, error) {
+	p := NumberDataPoint{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 4 && typ == protowire.Fixed64Type:
+			x, _ := protowire.ConsumeFixed64(v)
+			value := math.Float64frombits(x)
+			p.AsDouble = &value
+		case num == 6 && typ == protowire.Fixed64Type:
+			x, _ := protowire.ConsumeFixed64(v)
+			value := Int64(x)
+			p.AsInt = &value
+		case num == 8 && typ == protowire.VarintType:
+			x, _ := protowire.ConsumeVarint(v)
+			p.Flags = uint32(x)
+		default:
+			return appendAttribute(&p.Attributes, num == 7, typ, v)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func unmarshalHistogramDataPoint(b []byte) (HistogramDataPoint, error) {
+	p := HistogramDataPoint{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 4 && typ == protowire.Fixed64Type:
+			x, _ := protowire.ConsumeFixed64(v)
+			p.Count = Uint64(x)
+		case num == 5 && typ == protowire.Fixed64Type:
+			x, _ := protowire.ConsumeFixed64(v)
+			sum := math.Float64frombits(x)
+			p.Sum = &sum
+		case num == 6:
+			return consumeFixed64s(typ, v, func(x uint64) { p.BucketCounts = append(p.BucketCounts, Uint64(x)) })
+		case num == 7:
+			return consumeFixed64s(typ, v, func(x uint64) { p.ExplicitBounds = append(p.ExplicitBounds, math.Float64frombits(x)) })
+		case num == 10 && typ == protowire.VarintType:
+			x, _ := protowire.ConsumeVarint(v)
+			p.Flags = uint32(x)
+		default:
+			return appendAttribute(&p.Attributes, num == 9, typ, v)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func unmarshalSummaryDataPoint(b []byte) (SummaryDataPoint, error) {
+	p := SummaryDataPoint{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 4 && typ == protowire.Fixed64Type:
+			x, _ := protowire.ConsumeFixed64(v)
+			p.Count = Uint64(x)
+		case num == 5 && typ == protowire.Fixed64Type:
+			x, _ := protowire.ConsumeFixed64(v)
+			p.Sum = math.Float64frombits(x)
+		case num == 6 && typ == protowire.BytesType:
+			q := ValueAtQuantile{}
+			err := consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				if typ != protowire.Fixed64Type {
+					return nil
+				}
+				x, _ := protowire.ConsumeFixed64(v)
+				switch num {
+				case 1:
+					q.Quantile = math.Float64frombits(x)
+				case 2:
+					q.Value = math.Float64frombits(x)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			p.QuantileValues = append(p.QuantileValues, q)
+		case num == 8 && typ == protowire.VarintType:
+			x, _ := protowire.ConsumeVarint(v)
+			p.Flags = uint32(x)
+		default:
+			return appendAttribute(&p.Attributes, num == 7, typ, v)
+		}
+		return nil
+	})
+	return p, err
+}
+
+// appendAttribute добавляет в attrs атрибут KeyValue из поля, если это поле атрибутов
+func appendAttribute(attrs *[]KeyValue, isAttribute bool, typ protowire.Type, b []byte) error {
+	if !isAttribute || typ != protowire.BytesType {
+		return nil
+	}
+
+	kv := KeyValue{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			kv.Key = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			return consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					s := string(v)
+					kv.Value.StringValue = &s
+				case num == 2 && typ == protowire.VarintType:
+					x, _ := protowire.ConsumeVarint(v)
+					v := protowire.DecodeBool(x)
+					kv.Value.BoolValue = &v
+				case num == 3 && typ == protowire.VarintType:
+					x, _ := protowire.ConsumeVarint(v)
+					i := Int64(x)
+					kv.Value.IntValue = &i
+				case num == 4 && typ == protowire.Fixed64Type:
+					x, _ := protowire.ConsumeFixed64(v)
+					f := math.Float64frombits(x)
+					kv.Value.DoubleValue = &f
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*attrs = append(*attrs, kv)
+	return nil
+}
+
+// consumeFixed64s разбирает повторяющееся 64-битное поле: упакованное или одиночное значение
+func consumeFixed64s(typ protowire.Type, v []byte, fn func(uint64)) error {
+	switch typ {
+	case protowire.Fixed64Type:
+		x, _ := protowire.ConsumeFixed64(v)
+		fn(x)
+	case protowire.BytesType:
+		if len(v)%8 != 0 {
+			return ErrMalformed
+		}
+		for ; len(v) > 0; v = v[8:] {
+			x, _ := protowire.ConsumeFixed64(v)
+			fn(x)
+		}
+	}
+	return nil
+}
+
+// consumeFields вызывает fn для каждого поля сообщения. Для полей с длиной v — содержимое
+// поля, для остальных — закодированное значение
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+
+		var v []byte
+		if typ == protowire.BytesType {
+			v, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Response ответ ExportMetricsServiceResponse. PartialSuccess задан, если часть точек отклонена
+type Response struct {
+	PartialSuccess *PartialSuccess `json:"partialSuccess,omitempty"`
+}
+
+// PartialSuccess сведения об отклоненных точках
+type PartialSuccess struct {
+	RejectedDataPoints int64  `json:"rejectedDataPoints,string"`
+	ErrorMessage       string `json:"errorMessage,omitempty"`
+}
+
+// Marshal кодирует ответ в protobuf
+func (r Response) Marshal() []byte {
+	var b []byte
+	if r.PartialSuccess != nil {
+		var pb []byte
+		pb = protowire.AppendTag(pb, 1, protowire.VarintType)
+		pb = protowire.AppendVarint(pb, uint64(r.PartialSuccess.RejectedDataPoints))
+		pb = protowire.AppendTag(pb, 2, protowire.BytesType)
+		pb = protowire.AppendString(pb, r.PartialSuccess.ErrorMessage)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, pb)
+	}
+	return b
+}
+
+// Status ответ google.rpc.Status с ошибкой запроса
+type Status struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+}
+
+// CodeInvalidArgument код gRPC для неверного запроса
+const CodeInvalidArgument = 3
+
+// Marshal кодирует ответ в protobuf
+func (s Status) Marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.Code))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, s.Message)
+	return b
+}
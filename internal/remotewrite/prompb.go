@@ -0,0 +1,178 @@
+package remotewrite
+
+import (
+	"errors"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Сообщения remote_write из prometheus/prompb. Кодируются вручную, чтобы не тянуть
+// в проект сгенерированный код: из схемы используются только поля ниже
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+
+// ErrMalformed возвращается, если сообщение не удалось разобрать
+var ErrMalformed = errors.New("malformed remote write message")
+
+// WriteRequest запрос remote_write
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+// TimeSeries ряд: набор меток, включая __name__, и его отсчеты
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label метка ряда
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample отсчет ряда, Timestamp — в миллисекундах Unix
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Marshal кодирует запрос в protobuf
+func (r WriteRequest) Marshal() []byte {
+	var b []byte
+	for _, ts := range r.Timeseries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts.marshal())
+	}
+	return b
+}
+
+func (ts TimeSeries) marshal() []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Value)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, lb)
+	}
+	for _, s := range ts.Samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+	return b
+}
+
+// Unmarshal разбирает запрос из protobuf. Неизвестные поля пропускаются
+func Unmarshal(b []byte) (WriteRequest, error) {
+	r := WriteRequest{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		ts, err := unmarshalTimeSeries(v)
+		if err != nil {
+			return err
+		}
+		r.Timeseries = append(r.Timeseries, ts)
+		return nil
+	})
+	return r, err
+}
+
+func unmarshalTimeSeries(b []byte) (TimeSeries, error) {
+	ts := TimeSeries{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+
+		switch num {
+		case 1:
+			l := Label{}
+			err := consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					l.Name = string(v)
+				case num == 2 && typ == protowire.BytesType:
+					l.Value = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case 2:
+			s := Sample{}
+			err := consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					bits, n := protowire.ConsumeFixed64(v)
+					if n < 0 {
+						return ErrMalformed
+					}
+					s.Value = math.Float64frombits(bits)
+				case num == 2 && typ == protowire.VarintType:
+					x, n := protowire.ConsumeVarint(v)
+					if n < 0 {
+						return ErrMalformed
+					}
+					s.Timestamp = int64(x)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// consumeFields вызывает fn для каждого поля сообщения. Для полей с длиной v — содержимое
+// поля, для остальных — закодированное значение
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+
+		var v []byte
+		if typ == protowire.BytesType {
+			v, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+// Package remotewrite отправляет метрики сервера в приемник Prometheus remote_write.
+//
+// Каждые Interval снимаются значения всех метрик и ставятся в ограниченную очередь отсчетов.
+// Очередь отправляется пачками по BatchSize отсчетов: protobuf WriteRequest, сжатый snappy.
+// Отправка повторяется с нарастающей паузой, если приемник недоступен или отвечает 5xx или 429,
+// пачка, отвергнутая с другой ошибкой 4xx, отбрасывается. Если очередь переполнена, отбрасываются
+// самые старые отсчеты
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/golang/snappy"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultInterval интервал снятия метрик по умолчанию
+	DefaultInterval = 15 * time.Second
+	// DefaultBatchSize число отсчетов в запросе по умолчанию
+	DefaultBatchSize = 500
+	// DefaultQueueSize размер очереди отсчетов по умолчанию
+	DefaultQueueSize = 10000
+	// DefaultMaxRetries число повторов запроса по умолчанию
+	DefaultMaxRetries = 3
+	// DefaultMinBackoff пауза перед первым повтором по умолчанию
+	DefaultMinBackoff = 100 * time.Millisecond
+	// DefaultTimeout время ожидания ответа приемника по умолчанию
+	DefaultTimeout = 10 * time.Second
+
+	// NameLabel метка с именем ряда
+	NameLabel = "__name__"
+	// TypeLabel метка с типом метрики сервера
+	TypeLabel = "type"
+)
+
+// maxBackoff наибольшая пауза между повторами
+const maxBackoff = 5 * time.Second
+
+// ListFunc возвращает все метрики сервера
+type ListFunc func(ctx context.Context) ([]metrics.Metrics, error)
+
+// Options настройки отправки
+type Options struct {
+	// URL адрес приемника remote_write
+	URL string
+	// Interval интервал снятия метрик
+	Interval time.Duration
+	// BatchSize наибольшее число отсчетов в запросе
+	BatchSize int
+	// QueueSize наибольшее число отсчетов, ожидающих отправки
+	QueueSize int
+	// MaxRetries число повторов запроса, после которых отправка откладывается до следующего интервала
+	MaxRetries int
+	// MinBackoff пауза перед первым повтором, каждая следующая вдвое больше
+	MinBackoff time.Duration
+	// Timeout время ожидания ответа приемника
+	Timeout time.Duration
+}
+
+// Exporter отправляет метрики в приемник remote_write
+type Exporter struct {
+	opts  Options
+	http  *http.Client
+	queue []sample
+}
+
+// sample отсчет в очереди
+type sample struct {
+	labels []Label
+	value  float64
+	t      int64
+}
+
+// recoverableError ошибка, после которой запрос можно повторить
+type recoverableError struct {
+	error
+}
+
+// New создает отправку в приемник opts.URL. Незаданные настройки получают значения по умолчанию
+func New(opts Options) (*Exporter, error) {
+	if opts.URL == "" {
+		return nil, errors.New("remote write: url is required")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	return &Exporter{
+		opts: opts,
+		http: &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// Run каждые Interval снимает метрики из list и отправляет очередь, пока не отменен ctx
+func (e *Exporter) Run(ctx context.Context, list ListFunc) {
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.collect(ctx, list, time.Now()); err != nil {
+				logger.Get().Info("remote write collect error", zap.String("error", err.Error()))
+			}
+			e.flush(ctx)
+		}
+	}
+}
+
+// collect ставит в очередь отсчеты всех метрик на момент now
+func (e *Exporter) collect(ctx context.Context, list ListFunc, now time.Time) error {
+	items, err := list(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := now.UnixMilli()
+	for _, m := range items {
+		value, ok := sampleValue(m)
+		if !ok {
+			continue
+		}
+		e.queue = append(e.queue, sample{labels: seriesLabels(m), value: value, t: t})
+	}
+
+	if dropped := len(e.queue) - e.opts.QueueSize; dropped > 0 {
+		e.queue = slices.Delete(e.queue, 0, dropped)
+		logger.Get().Info("remote write queue is full, oldest samples dropped", zap.Int("dropped", dropped))
+	}
+
+	return nil
+}
+
+// flush отправляет очередь пачками. Если приемник недоступен, оставшиеся отсчеты
+// ждут следующего интервала
+func (e *Exporter) flush(ctx context.Context) {
+	for len(e.queue) > 0 && ctx.Err() == nil {
+		n := min(e.opts.BatchSize, len(e.queue))
+		err := e.sendWithRetry(ctx, e.queue[:n])
+
+		var recoverable recoverableError
+		if errors.As(err, &recoverable) {
+			logger.Get().Info("remote write error", zap.Int("queued", len(e.queue)), zap.String("error", err.Error()))
+			return
+		}
+		if err != nil {
+			logger.Get().Info("remote write batch rejected", zap.Int("samples", n), zap.String("error", err.Error()))
+		}
+
+		e.queue = slices.Delete(e.queue, 0, n)
+	}
+}
+
+func (e *Exporter) sendWithRetry(ctx context.Context, batch []sample) error {
+	body := snappy.Encode(nil, encode(batch).Marshal())
+	backoff := e.opts.MinBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := e.send(ctx, body)
+
+		var recoverable recoverableError
+		if err == nil || !errors.As(err, &recoverable) || attempt == e.opts.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return recoverableError{ctx.Err()}
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (e *Exporter) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "ya-prac-project1-server")
+
+	response, err := e.http.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer response.Body.Close()
+
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	if response.StatusCode/100 == 2 {
+		return nil
+	}
+
+	err = fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(message)))
+	if response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// encode собирает запрос из отсчетов, отсчеты одного ряда попадают в один TimeSeries
+func encode(batch []sample) WriteRequest {
+	r := WriteRequest{}
+	index := make(map[string]int, len(batch))
+	for _, s := range batch {
+		key := seriesKey(s.labels)
+		i, ok := index[key]
+		if !ok {
+			i = len(r.Timeseries)
+			index[key] = i
+			r.Timeseries = append(r.Timeseries, TimeSeries{Labels: s.labels})
+		}
+		r.Timeseries[i].Samples = append(r.Timeseries[i].Samples, Sample{Value: s.value, Timestamp: s.t})
+	}
+	return r
+}
+
+func seriesKey(labels []Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// sampleValue возвращает значение метрики как число
+func sampleValue(m metrics.Metrics) (float64, bool) {
+	switch {
+	case m.MType == metrics.MetricTypeGauge && m.Value != nil:
+		return *m.Value, true
+	case m.MType == metrics.MetricTypeCounter && m.Delta != nil:
+		return float64(*m.Delta), true
+	}
+	return 0, false
+}
+
+// seriesLabels возвращает отсортированные метки ряда метрики: имя, тип и метки из имени метрики.
+// Имена приводятся к допустимым в Prometheus
+func seriesLabels(m metrics.Metrics) []Label {
+	name, labels, err := metrics.ParseID(m.ID)
+	if err != nil {
+		name, labels = m.ID, nil
+	}
+
+	result := make([]Label, 0, len(labels)+2)
+	result = append(result, Label{Name: NameLabel, Value: sanitize(name, true)})
+	for key, value := range labels {
+		key = sanitize(key, false)
+		if key == NameLabel || key == TypeLabel {
+			continue
+		}
+		result = append(result, Label{Name: key, Value: value})
+	}
+	result = append(result, Label{Name: TypeLabel, Value: m.MType})
+
+	slices.SortFunc(result, func(a, b Label) int { return strings.Compare(a.Name, b.Name) })
+	return result
+}
+
+// sanitize заменяет недопустимые символы подчеркиванием. В именах рядов допустимо
+// двоеточие, в именах меток — нет
+func sanitize(s string, metricName bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		ok := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(i > 0 && r >= '0' && r <= '9') || (metricName && r == ':')
+		if !ok {
+			if i == 0 && r >= '0' && r <= '9' {
+				b.WriteByte('_')
+				b.WriteRune(r)
+				continue
+			}
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
@@ -0,0 +1,232 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver приемник remote_write, который разбирает запросы и отвечает кодами из statuses
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []WriteRequest
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	status := http.StatusNoContent
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	if status != http.StatusNoContent {
+		http.Error(w, "receiver error", status)
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "wrong headers", http.StatusBadRequest)
+		return
+	}
+
+	compressed, _ := io.ReadAll(r.Body)
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, err := Unmarshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc.requests = append(rc.requests, request)
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) received() []WriteRequest {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]WriteRequest{}, rc.requests...)
+}
+
+func newExporter(t *testing.T, rc *receiver, opts Options) *Exporter {
+	logger.Set()
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	opts.URL = server.URL
+	opts.MinBackoff = time.Millisecond
+	e, err := New(opts)
+	require.NoError(t, err)
+	return e
+}
+
+func list(ms ...metrics.Metrics) ListFunc {
+	return func(context.Context) ([]metrics.Metrics, error) { return ms, nil }
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Options{})
+	assert.Error(t, err)
+
+	e, err := New(Options{URL: "http://localhost:9090/api/v1/write"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultBatchSize, e.opts.BatchSize)
+	assert.Equal(t, DefaultQueueSize, e.opts.QueueSize)
+	assert.Equal(t, DefaultMaxRetries, e.opts.MaxRetries)
+}
+
+func TestMarshal(t *testing.T) {
+	request := WriteRequest{Timeseries: []TimeSeries{
+		{
+			Labels:  []Label{{Name: NameLabel, Value: "Alloc"}, {Name: "host", Value: "a"}},
+			Samples: []Sample{{Value: 1.5, Timestamp: 1704888000000}, {Value: -2, Timestamp: -1}},
+		},
+		{Labels: []Label{{Name: NameLabel, Value: "empty"}}},
+	}}
+
+	decoded, err := Unmarshal(request.Marshal())
+	require.NoError(t, err)
+	assert.Equal(t, request, decoded)
+
+	_, err = Unmarshal([]byte{0x0a, 0x05, 0x01})
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestSeriesLabels(t *testing.T) {
+	m := metrics.NewMetric(`http.requests{code="200",__name__="x",9host="a"}`, metrics.MetricTypeCounter, "3")
+	assert.Equal(t, []Label{
+		{Name: "_9host", Value: "a"},
+		{Name: NameLabel, Value: "http_requests"},
+		{Name: "code", Value: "200"},
+		{Name: TypeLabel, Value: metrics.MetricTypeCounter},
+	}, seriesLabels(m))
+
+	assert.Equal(t, "_1m:rate", sanitize("1m:rate", true))
+	assert.Equal(t, "_1m_rate", sanitize("1m:rate", false))
+	assert.Equal(t, "_", sanitize("", false))
+}
+
+func TestFlush(t *testing.T) {
+	rc := &receiver{}
+	e := newExporter(t, rc, Options{BatchSize: 2})
+	ctx := context.Background()
+
+	items := list(
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "3"),
+		metrics.NewMetric(`Alloc{host="b"}`, metrics.MetricTypeGauge, "2"),
+	)
+	now := time.UnixMilli(1704888000000)
+	require.NoError(t, e.collect(ctx, items, now))
+	require.NoError(t, e.collect(ctx, items, now.Add(time.Second)))
+	e.flush(ctx)
+
+	// шесть отсчетов уходят тремя запросами, отсчеты одного ряда собираются вместе
+	requests := rc.received()
+	require.Len(t, requests, 3)
+	assert.Empty(t, e.queue)
+
+	series := map[string][]Sample{}
+	for _, r := range requests {
+		for _, ts := range r.Timeseries {
+			key := seriesKey(ts.Labels)
+			series[key] = append(series[key], ts.Samples...)
+		}
+	}
+	assert.Len(t, series, 3)
+
+	alloc := seriesKey([]Label{{Name: NameLabel, Value: "Alloc"}, {Name: TypeLabel, Value: metrics.MetricTypeGauge}})
+	assert.Equal(t, []Sample{{Value: 1.5, Timestamp: 1704888000000}, {Value: 1.5, Timestamp: 1704888001000}}, series[alloc])
+}
+
+func TestFlush_retry(t *testing.T) {
+	// две ошибки подряд переживаются повторами
+	rc := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	e := newExporter(t, rc, Options{MaxRetries: 2})
+	ctx := context.Background()
+
+	require.NoError(t, e.collect(ctx, list(metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")), time.Now()))
+	e.flush(ctx)
+	assert.Len(t, rc.received(), 1)
+	assert.Empty(t, e.queue)
+
+	// после исчерпания повторов отсчеты остаются в очереди до следующего интервала
+	rc.statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	require.NoError(t, e.collect(ctx, list(metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "2")), time.Now()))
+	e.flush(ctx)
+	assert.Len(t, e.queue, 1)
+
+	e.flush(ctx)
+	assert.Len(t, rc.received(), 2)
+	assert.Empty(t, e.queue)
+}
+
+func TestFlush_rejected(t *testing.T) {
+	// пачка, отвергнутая с ошибкой клиента, отбрасывается без повторов
+	rc := &receiver{statuses: []int{http.StatusBadRequest}}
+	e := newExporter(t, rc, Options{BatchSize: 1})
+	ctx := context.Background()
+
+	require.NoError(t, e.collect(ctx, list(
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1"),
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1"),
+	), time.Now()))
+	e.flush(ctx)
+
+	requests := rc.received()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "PollCount", requests[0].Timeseries[0].Labels[0].Value)
+	assert.Empty(t, e.queue)
+}
+
+func TestCollect_queueSize(t *testing.T) {
+	e := newExporter(t, &receiver{}, Options{QueueSize: 3})
+	ctx := context.Background()
+
+	items := list(
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1"),
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1"),
+	)
+	require.NoError(t, e.collect(ctx, items, time.UnixMilli(1)))
+	require.NoError(t, e.collect(ctx, items, time.UnixMilli(2)))
+
+	// самый старый отсчет вытеснен
+	require.Len(t, e.queue, 3)
+	assert.Equal(t, int64(1), e.queue[0].t)
+	assert.Equal(t, "PollCount", e.queue[0].labels[0].Value)
+
+	failing := func(context.Context) ([]metrics.Metrics, error) { return nil, errors.New("list error") }
+	assert.Error(t, e.collect(ctx, failing, time.Now()))
+}
+
+func TestRun(t *testing.T) {
+	rc := &receiver{}
+	e := newExporter(t, rc, Options{Interval: 20 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx, list(metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")))
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(rc.received()) >= 2 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+	"ya-prac-project1/internal/metrics"
+)
+
+// UpdateFilter отбирает обновления метрик для подписчика. Пустые поля не ограничивают отбор
+type UpdateFilter struct {
+	// MType тип метрики
+	MType string
+	// Name шаблон имени метрики без меток в синтаксисе path.Match, например cpu_*
+	Name string
+	// Labels метки, которые должны быть у метрики с теми же значениями
+	Labels map[string]string
+}
+
+// Validate проверяет тип и шаблон имени
+func (f UpdateFilter) Validate() error {
+	if f.MType != "" && f.MType != metrics.MetricTypeGauge && f.MType != metrics.MetricTypeCounter {
+		return metrics.ErrWrongType
+	}
+	if _, err := path.Match(f.Name, ""); err != nil {
+		return fmt.Errorf("name pattern %q: %w", f.Name, err)
+	}
+	return nil
+}
+
+// Match возвращает true, если метрика проходит фильтр
+func (f UpdateFilter) Match(m metrics.Metrics) bool {
+	if f.MType != "" && f.MType != m.MType {
+		return false
+	}
+	if f.Name == "" && len(f.Labels) == 0 {
+		return true
+	}
+
+	name, labels, err := metrics.ParseID(m.ID)
+	if err != nil {
+		return false
+	}
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, name); !ok {
+			return false
+		}
+	}
+	for key, value := range f.Labels {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscription подписка на обновления метрик. Обновления, которые подписчик не успел забрать
+// из очереди, отбрасываются, чтобы медленный подписчик не задерживал сохранение метрик
+type Subscription struct {
+	hub     *hub
+	filter  UpdateFilter
+	updates chan metrics.Metrics
+	dropped atomic.Uint64
+}
+
+// Updates возвращает канал обновлений. Канал закрывается после Close
+func (sub *Subscription) Updates() <-chan metrics.Metrics {
+	return sub.updates
+}
+
+// Dropped возвращает число обновлений, отброшенных с начала подписки
+func (sub *Subscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+// Close отменяет подписку
+func (sub *Subscription) Close() {
+	sub.hub.unsubscribe(sub)
+}
+
+// hub рассылает сохраненные метрики подписчикам
+type hub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: map[*Subscription]struct{}{}}
+}
+
+func (h *hub) subscribe(filter UpdateFilter, buffer int) *Subscription {
+	sub := &Subscription{hub: h, filter: filter, updates: make(chan metrics.Metrics, buffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+func (h *hub) unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.updates)
+	}
+}
+
+// publish отправляет метрики подписчикам, не дожидаясь тех, у кого заполнена очередь
+func (h *hub) publish(ms []metrics.Metrics) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs {
+		for _, m := range ms {
+			if !sub.filter.Match(m) {
+				continue
+			}
+			select {
+			case sub.updates <- m.Clone():
+			default:
+				sub.dropped.Add(1)
+			}
+		}
+	}
+}
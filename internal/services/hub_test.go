@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateFilter(t *testing.T) {
+	gauge := metrics.NewMetric(`cpu_usage{host="web1",core="0"}`, metrics.MetricTypeGauge, "0.5")
+	counter := metrics.NewMetric("requests", metrics.MetricTypeCounter, "1")
+
+	tests := []struct {
+		name   string
+		filter UpdateFilter
+		want   []bool
+	}{
+		{name: "all", filter: UpdateFilter{}, want: []bool{true, true}},
+		{name: "type", filter: UpdateFilter{MType: metrics.MetricTypeCounter}, want: []bool{false, true}},
+		{name: "name pattern", filter: UpdateFilter{Name: "cpu_*"}, want: []bool{true, false}},
+		{name: "labels", filter: UpdateFilter{Labels: map[string]string{"host": "web1"}}, want: []bool{true, false}},
+		{name: "other labels", filter: UpdateFilter{Labels: map[string]string{"host": "web2"}}, want: []bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, []bool{tt.filter.Match(gauge), tt.filter.Match(counter)})
+		})
+	}
+
+	assert.ErrorIs(t, UpdateFilter{MType: "histogram"}.Validate(), metrics.ErrWrongType)
+	assert.Error(t, UpdateFilter{Name: "cpu_["}.Validate())
+	assert.NoError(t, UpdateFilter{MType: metrics.MetricTypeGauge, Name: "cpu_*"}.Validate())
+}
+
+func TestSubscribe(t *testing.T) {
+	s := NewMetricSaverService(inmemstorage.NewStorage())
+	gauges := s.Subscribe(UpdateFilter{MType: metrics.MetricTypeGauge}, 10)
+	slow := s.Subscribe(UpdateFilter{}, 1)
+
+	require.NoError(t, s.SaveMetrics(context.Background(), []metrics.Metrics{
+		metrics.NewMetric("cpu", metrics.MetricTypeGauge, "0.5"),
+		metrics.NewMetric("requests", metrics.MetricTypeCounter, "1"),
+	}))
+
+	assert.Equal(t, metrics.NewMetric("cpu", metrics.MetricTypeGauge, "0.5"), <-gauges.Updates())
+	assert.Empty(t, gauges.Updates())
+	assert.Zero(t, gauges.Dropped())
+
+	// медленный подписчик теряет то, что не поместилось в очередь, сохранение не ждет его
+	assert.Equal(t, metrics.NewMetric("cpu", metrics.MetricTypeGauge, "0.5"), <-slow.Updates())
+	assert.Equal(t, uint64(1), slow.Dropped())
+
+	gauges.Close()
+	gauges.Close()
+	_, ok := <-gauges.Updates()
+	assert.False(t, ok)
+
+	require.NoError(t, s.SaveMetric(context.Background(), metrics.NewMetric("mem", metrics.MetricTypeGauge, "1")))
+	assert.Equal(t, metrics.NewMetric("mem", metrics.MetricTypeGauge, "1"), <-slow.Updates())
+	slow.Close()
+}
@@ -2,133 +2,247 @@
 package services
 
 import (
-	"fmt"
+	"context"
+	"slices"
+	"time"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"go.uber.org/zap"
 )
 
 // SaveStorage структура представляющая интерфейс репозитория для работы с сервисом MetricSaverService
 type SaveStorage interface {
-	GetMetrics() []metrics.Metrics
-	CreateMetrics([]metrics.Metrics) error
-	UpdateMetrics([]metrics.Metrics) error
+	// Get возвращает метрику по ключу или storage.ErrNotFound
+	Get(ctx context.Context, key storage.Key) (metrics.Metrics, error)
+	// List возвращает метрики, попадающие под фильтр
+	List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error)
+	// UpsertMetrics атомарно применяет метрики: счетчики прибавляются к сохраненным, gauge заменяются
+	UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error
+	// Delete удаляет метрику по ключу или возвращает storage.ErrNotFound
+	Delete(ctx context.Context, key storage.Key) error
+	// UpsertTotals атомарно применяет метрики ms, как UpsertMetrics, и накопленные итоги счетчиков totals:
+	// к счетчику прибавляется разница с последним сохраненным итогом, итог сохраняется вместе со счетчиком.
+	// Возвращает итоги, переведенные в приращения
+	UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error)
+	// Close освобождает ресурсы репозитория
+	Close() error
+}
+
+// BatchRegistry структура представляющая интерфейс репозитория, который помнит недавно примененные пачки метрик
+type BatchRegistry interface {
+	// UpsertBatch атомарно применяет пачку метрик вместе с отметкой о ней, как UpsertMetrics.
+	// Возвращает false и не применяет метрики, если пачка уже применялась
+	UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error)
+}
+
+// Forwarder структура представляющая интерфейс пересылки принятых метрик на вышестоящий сервер
+type Forwarder interface {
+	// Forward ставит принятые метрики в очередь на пересылку
+	Forward(ctx context.Context, ms []metrics.Metrics) error
 }
 
 // MetricSaverService структура представляющая сервис для хранения метрик
 type MetricSaverService struct {
-	storage SaveStorage
+	storage   SaveStorage
+	batches   BatchRegistry
+	history   *history.History
+	forwarder Forwarder
+	hub       *hub
 }
 
-// NewMetricSaverService создает сервис
-func NewMetricSaverService(storage SaveStorage) *MetricSaverService {
-	return &MetricSaverService{
-		storage: storage,
+// NewMetricSaverService создает сервис. Если репозиторий умеет запоминать пачки метрик,
+// повторно присланные пачки не будут применяться
+func NewMetricSaverService(store SaveStorage) *MetricSaverService {
+	s := &MetricSaverService{
+		storage: store,
+		hub:     newHub(),
 	}
-}
 
-// GetMetric получает метрику по имени и типу. Возвращает ошибку в случае если не находит запрашиваемую метрику
-func (s *MetricSaverService) GetMetric(metricType, name string) (metrics.Metrics, error) {
-	metric := metrics.Metrics{
-		MType: metricType,
-		ID:    name,
-	}
-	metricsMap := s.getMetricsKeyMap()
-	metric, ok := metricsMap[metric.GetKey()]
-	if !ok {
-		return metric, fmt.Errorf("metric not found")
+	if batches, ok := store.(BatchRegistry); ok {
+		s.batches = batches
 	}
 
-	return metric, nil
+	return s
+}
+
+// SetHistory включает запись истории значений сохраняемых метрик
+func (s *MetricSaverService) SetHistory(h *history.History) {
+	s.history = h
+}
+
+// SetForwarder включает пересылку каждой сохраненной пачки метрик
+func (s *MetricSaverService) SetForwarder(f Forwarder) {
+	s.forwarder = f
+}
+
+// Subscribe подписывает на сохраненные метрики, проходящие фильтр. В очереди подписчика
+// помещается buffer метрик, остальные отбрасываются, пока подписчик их не заберет
+func (s *MetricSaverService) Subscribe(filter UpdateFilter, buffer int) *Subscription {
+	return s.hub.subscribe(filter, buffer)
+}
+
+// GetMetric получает метрику по имени и типу. Возвращает storage.ErrNotFound в случае если не находит запрашиваемую метрику
+func (s *MetricSaverService) GetMetric(ctx context.Context, metricType, name string) (metrics.Metrics, error) {
+	return s.storage.Get(ctx, storage.Key{MType: metricType, ID: name})
 }
 
 // GetMetrics отдает все метрики которы есть в репозитории сервиса
-func (s *MetricSaverService) GetMetrics() []metrics.Metrics {
-	return s.storage.GetMetrics()
+func (s *MetricSaverService) GetMetrics(ctx context.Context) ([]metrics.Metrics, error) {
+	return s.storage.List(ctx, storage.Filter{})
+}
+
+// ListMetrics отдает метрики, попадающие под фильтр
+func (s *MetricSaverService) ListMetrics(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	return s.storage.List(ctx, filter)
 }
 
 // SaveMetric сохраняет входную метрику
-func (s *MetricSaverService) SaveMetric(m metrics.Metrics) error {
-	err := m.Validate()
-	if err != nil {
+func (s *MetricSaverService) SaveMetric(ctx context.Context, m metrics.Metrics) error {
+	return s.SaveMetrics(ctx, []metrics.Metrics{m})
+}
+
+// SaveMetrics сохраняет набор входных метрик. Значения счетчиков прибавляются к сохраненным
+// на стороне репозитория, поэтому параллельные обновления не теряются
+func (s *MetricSaverService) SaveMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	if err := validate(ms); err != nil {
 		return err
 	}
 
-	var createMetrics []metrics.Metrics
-	var updateMetrics []metrics.Metrics
+	if len(ms) == 0 {
+		return nil
+	}
 
-	metricsMap := s.getMetricsKeyMap()
-	em, ok := metricsMap[m.GetKey()]
-	if !ok {
-		createMetrics = append(createMetrics, m)
-	} else {
-		err := em.SetValue(m.GetValue())
-		if err != nil {
-			return err
-		}
-		updateMetrics = append(updateMetrics, em)
+	if err := s.storage.UpsertMetrics(ctx, ms); err != nil {
+		return err
 	}
 
-	if len(createMetrics) > 0 {
-		err := s.storage.CreateMetrics(createMetrics)
-		if err != nil {
-			return err
+	s.saved(ctx, ms)
+	return nil
+}
+
+// SaveMetricTotals сохраняет метрики ms и накопленные итоги счетчиков totals, которые присылают
+// источники с кумулятивными счетчиками. Итог переводится в приращение по последнему сохраненному
+// итогу той же метрики, поэтому повтор итога после перезапуска или на другом экземпляре
+// не прибавляется дважды
+func (s *MetricSaverService) SaveMetricTotals(ctx context.Context, ms, totals []metrics.Metrics) error {
+	if err := validate(ms); err != nil {
+		return err
+	}
+	if err := validate(totals); err != nil {
+		return err
+	}
+	for _, m := range totals {
+		if m.MType != metrics.MetricTypeCounter {
+			return metrics.ErrWrongType
 		}
 	}
 
-	if len(updateMetrics) > 0 {
-		err := s.storage.UpdateMetrics(updateMetrics)
-		if err != nil {
-			return err
-		}
+	if len(ms) == 0 && len(totals) == 0 {
+		return nil
+	}
+
+	increments, err := s.storage.UpsertTotals(ctx, ms, totals)
+	if err != nil {
+		return err
 	}
 
+	s.saved(ctx, append(slices.Clip(ms), increments...))
 	return nil
 }
 
-// SaveMetrics сохраняет набор входных метрик
-func (s *MetricSaverService) SaveMetrics(ms []metrics.Metrics) error {
-	var createMetrics []metrics.Metrics
-	var updateMetrics []metrics.Metrics
-	metricsMap := s.getMetricsKeyMap()
+// validate проверяет тип и значение каждой метрики
+func validate(ms []metrics.Metrics) error {
 	for _, m := range ms {
-		err := m.Validate()
-		if err != nil {
+		if err := m.Validate(); err != nil {
 			return err
 		}
-		em, ok := metricsMap[m.GetKey()]
-		if !ok {
-			createMetrics = append(createMetrics, m)
-			metricsMap[m.GetKey()] = m
-		} else {
-			err := em.SetValue(m.GetValue())
+	}
+	return nil
+}
+
+// saved записывает историю, оповещает подписчиков и пересылает метрики после их сохранения
+func (s *MetricSaverService) saved(ctx context.Context, ms []metrics.Metrics) {
+	if s.history != nil {
+		s.recordHistory(ctx, ms)
+	}
+
+	s.hub.publish(ms)
+
+	// метрики уже сохранены, поэтому ошибка пересылки не возвращается клиенту
+	if s.forwarder != nil {
+		if err := s.forwarder.Forward(ctx, ms); err != nil {
+			logger.Get().Info("forward error", zap.String("error", err.Error()))
+		}
+	}
+}
+
+// recordHistory сохраняет в историю значения метрик после обновления: для gauge — присланное
+// значение, для счетчика — накопленное в репозитории. Ошибка истории не отменяет сохранение метрик
+func (s *MetricSaverService) recordHistory(ctx context.Context, ms []metrics.Metrics) {
+	now := time.Now()
+	values := make(map[storage.Key]metrics.Metrics, len(ms))
+	for _, m := range ms {
+		key := storage.KeyOf(m)
+		if m.MType == metrics.MetricTypeCounter {
+			if _, ok := values[key]; ok {
+				continue
+			}
+
+			stored, err := s.storage.Get(ctx, key)
 			if err != nil {
-				return err
+				logger.Get().Info("history read counter error", zap.String("metric", key.String()), zap.String("error", err.Error()))
+				continue
 			}
-			updateMetrics = append(updateMetrics, em)
+			m = stored
 		}
+		values[key] = m
+	}
 
+	recorded := make([]metrics.Metrics, 0, len(values))
+	for _, m := range values {
+		recorded = append(recorded, m)
 	}
 
-	if len(createMetrics) > 0 {
-		err := s.storage.CreateMetrics(createMetrics)
-		if err != nil {
-			return err
-		}
+	if err := s.history.Record(ctx, now, recorded); err != nil {
+		logger.Get().Info("history record error", zap.String("error", err.Error()))
 	}
+}
 
-	if len(updateMetrics) > 0 {
-		err := s.storage.UpdateMetrics(updateMetrics)
-		if err != nil {
-			return err
-		}
+// MetricHistory возвращает историю метрики за [from, to) с шагом не мельче step, уровень
+// истории выбирается автоматически. Если история не ведется, возвращает history.ErrDisabled
+func (s *MetricSaverService) MetricHistory(ctx context.Context, metricType, name string, from, to time.Time, step time.Duration) (history.Result, error) {
+	if s.history == nil {
+		return history.Result{}, history.ErrDisabled
 	}
 
-	return nil
+	return s.history.Query(ctx, storage.Key{MType: metricType, ID: name}, from, to, step)
 }
 
-func (s *MetricSaverService) getMetricsKeyMap() map[string]metrics.Metrics {
-	m := make(map[string]metrics.Metrics)
-	for _, metric := range s.GetMetrics() {
-		m[metric.GetKey()] = metric
+// SaveMetricsBatch сохраняет пачку метрик с идентификатором id. Пачка, которая уже была применена,
+// повторно не сохраняется, в этом случае возвращается false
+func (s *MetricSaverService) SaveMetricsBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	if id == "" || s.batches == nil {
+		return true, s.SaveMetrics(ctx, ms)
+	}
+
+	if err := validate(ms); err != nil {
+		return false, err
+	}
+
+	// отметка о пачке сохраняется вместе с метриками: если сохранение не удалось, повтор ее применит
+	applied, err := s.batches.UpsertBatch(ctx, id, ms)
+	if err != nil || !applied {
+		return false, err
 	}
-	return m
+
+	s.saved(ctx, ms)
+	return true, nil
+}
+
+// DeleteMetric удаляет метрику по имени и типу. Возвращает storage.ErrNotFound в случае если не находит удаляемую метрику
+func (s *MetricSaverService) DeleteMetric(ctx context.Context, metricType, name string) error {
+	return s.storage.Delete(ctx, storage.Key{MType: metricType, ID: name})
 }
@@ -1,13 +1,22 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/metrics"
 	mock "ya-prac-project1/internal/services/mocks"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/inmemstorage"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetMetrics(t *testing.T) {
@@ -19,31 +28,50 @@ func TestGetMetrics(t *testing.T) {
 		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
 	}
 
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
+	store.EXPECT().List(gomock.Any(), storage.Filter{}).Return(ms, nil).AnyTimes()
 	s := NewMetricSaverService(store)
 
-	assert.Equal(t, ms, s.GetMetrics())
+	actual, err := s.GetMetrics(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, ms, actual)
 }
 
-func TestGetMetric(t *testing.T) {
+func TestListMetrics(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	store := mock.NewMockSaveStorage(ctrl)
 
 	ms := []metrics.Metrics{
 		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
 	}
+	filter := storage.Filter{MType: metrics.MetricTypeGauge, Prefix: "test"}
 
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
+	store.EXPECT().List(gomock.Any(), filter).Return(ms, nil).Times(1)
+	store.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, errors.New("list error")).Times(1)
 	s := NewMetricSaverService(store)
 
-	actual, _ := s.GetMetric(metrics.MetricTypeGauge, "test_1")
+	actual, err := s.ListMetrics(context.Background(), filter)
+	require.NoError(t, err)
+	assert.Equal(t, ms, actual)
+
+	_, err = s.ListMetrics(context.Background(), storage.Filter{})
+	assert.EqualError(t, err, "list error")
+}
+
+func TestGetMetric(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	store := mock.NewMockSaveStorage(ctrl)
+
 	expect := metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5")
+	store.EXPECT().Get(gomock.Any(), storage.Key{MType: metrics.MetricTypeGauge, ID: "test_1"}).Return(expect, nil).Times(1)
+	store.EXPECT().Get(gomock.Any(), gomock.Any()).Return(metrics.Metrics{}, storage.ErrNotFound).Times(1)
+	s := NewMetricSaverService(store)
+
+	actual, err := s.GetMetric(context.Background(), metrics.MetricTypeGauge, "test_1")
+	require.NoError(t, err)
 	assert.Equal(t, expect, actual)
 
-	_, err := s.GetMetric(metrics.MetricTypeGauge, "test_3")
-	eErr := errors.New("metric not found")
-	assert.Equal(t, eErr, err)
+	_, err = s.GetMetric(context.Background(), metrics.MetricTypeGauge, "test_3")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
 }
 
 func TestSaveMetrics(t *testing.T) {
@@ -51,142 +79,261 @@ func TestSaveMetrics(t *testing.T) {
 	store := mock.NewMockSaveStorage(ctrl)
 
 	ms := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "2"),
 	}
 
-	cms := []metrics.Metrics{
-		metrics.NewMetric("test_3", metrics.MetricTypeGauge, "3.5"),
+	store.EXPECT().UpsertMetrics(gomock.Any(), ms).Return(nil).Times(1)
+	store.EXPECT().UpsertMetrics(gomock.Any(), gomock.Any()).Return(errors.New("wrong upsert collection")).AnyTimes()
+	s := NewMetricSaverService(store)
+
+	assert.NoError(t, s.SaveMetrics(context.Background(), ms))
+	assert.NoError(t, s.SaveMetrics(context.Background(), []metrics.Metrics{}))
+	assert.EqualError(t, s.SaveMetrics(context.Background(), ms[:1]), "wrong upsert collection")
+}
+
+// TestSaveMetrics_concurrent проверяет, что параллельные обновления счетчика не теряются
+func TestSaveMetrics_concurrent(t *testing.T) {
+	const workers, updates = 16, 200
+	s := NewMetricSaverService(inmemstorage.NewStorage())
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				err := s.SaveMetrics(context.Background(), []metrics.Metrics{
+					metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1"),
+					metrics.NewMetric("Alloc", metrics.MetricTypeGauge, strconv.Itoa(i)),
+				})
+				assert.NoError(t, err)
+			}
+		}()
 	}
+	wg.Wait()
 
-	ums := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
+	m, err := s.GetMetric(context.Background(), metrics.MetricTypeCounter, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers*updates), m.GetValue())
+}
+
+func TestSaveMetricTotals(t *testing.T) {
+	ctx := context.Background()
+	s := NewMetricSaverService(inmemstorage.NewStorage())
+	updates := s.Subscribe(UpdateFilter{MType: metrics.MetricTypeCounter}, 10)
+	defer updates.Close()
+
+	gauge := []metrics.Metrics{metrics.NewMetric("cpu", metrics.MetricTypeGauge, "0.5")}
+	for _, total := range []string{"10", "25"} {
+		require.NoError(t, s.SaveMetricTotals(ctx, gauge, []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, total)}))
 	}
 
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
-	store.EXPECT().CreateMetrics(cms).Return(nil).AnyTimes()
-	store.EXPECT().UpdateMetrics(ums).Return(nil).AnyTimes()
-	store.EXPECT().CreateMetrics(gomock.Any()).Return(errors.New("wrong create collection")).AnyTimes()
-	store.EXPECT().UpdateMetrics(gomock.Any()).Return(errors.New("wrong update collection")).AnyTimes()
-	s := NewMetricSaverService(store)
+	// подписчики получают приращения, а не итоги
+	assert.Equal(t, metrics.NewMetric("bytes", metrics.MetricTypeCounter, "10"), <-updates.Updates())
+	assert.Equal(t, metrics.NewMetric("bytes", metrics.MetricTypeCounter, "15"), <-updates.Updates())
 
-	err := s.SaveMetrics([]metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
-		metrics.NewMetric("test_3", metrics.MetricTypeGauge, "3.5"),
-	})
+	m, err := s.GetMetric(ctx, metrics.MetricTypeCounter, "bytes")
+	require.NoError(t, err)
+	assert.Equal(t, "25", m.GetValue())
 
-	assert.Equal(t, err, nil)
+	// итогом может быть только счетчик
+	err = s.SaveMetricTotals(ctx, nil, gauge)
+	assert.ErrorIs(t, err, metrics.ErrWrongType)
 }
 
 func TestSaveMetrics_wrong(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	store := mock.NewMockSaveStorage(ctrl)
 
-	ms := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
-	}
-
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
 	s := NewMetricSaverService(store)
 
-	err := s.SaveMetrics([]metrics.Metrics{
+	err := s.SaveMetrics(context.Background(), []metrics.Metrics{
 		metrics.NewMetric("test_1", "wrong_type", "10.5"),
 	})
 
-	assert.Equal(t, err, errors.New("wrong metric type"))
+	assert.ErrorIs(t, err, metrics.ErrWrongType)
 }
 
 func TestSaveMetric(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	store := mock.NewMockSaveStorage(ctrl)
 
-	ms := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
-	}
+	m := metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5")
+	store.EXPECT().UpsertMetrics(gomock.Any(), []metrics.Metrics{m}).Return(nil).Times(1)
+	s := NewMetricSaverService(store)
+
+	err := s.SaveMetric(context.Background(), m)
+	assert.NoError(t, err)
+}
 
-	cms := []metrics.Metrics{
-		metrics.NewMetric("test_3", metrics.MetricTypeGauge, "3.5"),
+func TestSaveMetric_wrong(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	store := mock.NewMockSaveStorage(ctrl)
+
+	s := NewMetricSaverService(store)
+
+	err := s.SaveMetric(context.Background(), metrics.NewMetric("test_1", "wrong_type", "10.5"))
+	assert.ErrorIs(t, err, metrics.ErrWrongType)
+}
+
+type batchStorage struct {
+	*mock.MockSaveStorage
+	*mock.MockBatchRegistry
+}
+
+func TestSaveMetricsBatch(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
+	store := batchStorage{
+		MockSaveStorage:   mock.NewMockSaveStorage(ctrl),
+		MockBatchRegistry: mock.NewMockBatchRegistry(ctrl),
 	}
 
-	ums := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+	ms := []metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
 	}
 
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
-	store.EXPECT().CreateMetrics(cms).Return(nil).AnyTimes()
-	store.EXPECT().UpdateMetrics(ums).Return(nil).AnyTimes()
-	store.EXPECT().CreateMetrics(gomock.Any()).Return(errors.New("wrong create collection")).AnyTimes()
-	store.EXPECT().UpdateMetrics(gomock.Any()).Return(errors.New("wrong update collection")).AnyTimes()
+	store.MockBatchRegistry.EXPECT().UpsertBatch(gomock.Any(), "batch_1", ms).Return(true, nil).Times(1)
+	store.MockBatchRegistry.EXPECT().UpsertBatch(gomock.Any(), "batch_1", ms).Return(false, nil).Times(1)
 	s := NewMetricSaverService(store)
 
-	err := s.SaveMetric(metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"))
-	assert.ErrorIs(t, err, nil)
+	applied, err := s.SaveMetricsBatch(context.Background(), "batch_1", ms)
+	assert.NoError(t, err)
+	assert.True(t, applied)
 
-	err = s.SaveMetric(metrics.NewMetric("test_3", metrics.MetricTypeGauge, "3.5"))
-	assert.ErrorIs(t, err, nil)
+	applied, err = s.SaveMetricsBatch(context.Background(), "batch_1", ms)
+	assert.NoError(t, err)
+	assert.False(t, applied)
 }
 
-func TestSaveMetric_wrong(t *testing.T) {
+func TestSaveMetricsBatch_failed(t *testing.T) {
+	logger.Set()
 	ctrl := gomock.NewController(t)
-	store := mock.NewMockSaveStorage(ctrl)
+	store := batchStorage{
+		MockSaveStorage:   mock.NewMockSaveStorage(ctrl),
+		MockBatchRegistry: mock.NewMockBatchRegistry(ctrl),
+	}
 
 	ms := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
 	}
 
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
+	store.MockBatchRegistry.EXPECT().UpsertBatch(gomock.Any(), "batch_1", ms).Return(false, errors.New("wrong upsert collection"))
 	s := NewMetricSaverService(store)
+	sub := s.Subscribe(UpdateFilter{}, 1)
+
+	applied, err := s.SaveMetricsBatch(context.Background(), "batch_1", ms)
+	assert.Error(t, err)
+	assert.False(t, applied)
+	assert.Empty(t, sub.Updates())
 
-	err := s.SaveMetric(metrics.NewMetric("test_1", "wrong_type", "10.5"))
-	assert.Equal(t, err, errors.New("wrong metric type"))
+	// пачка с неверной метрикой не доходит до репозитория
+	applied, err = s.SaveMetricsBatch(context.Background(), "batch_2", []metrics.Metrics{{ID: "test_1", MType: metrics.MetricTypeCounter}})
+	assert.ErrorIs(t, err, metrics.ErrNoValue)
+	assert.False(t, applied)
 }
 
-func TestGetMetricsKeyMap(t *testing.T) {
+func TestSaveMetricsBatch_withoutRegistry(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	store := mock.NewMockSaveStorage(ctrl)
 
 	ms := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
 	}
 
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
+	store.EXPECT().UpsertMetrics(gomock.Any(), ms).Return(nil).Times(2)
 	s := NewMetricSaverService(store)
 
-	actual := s.getMetricsKeyMap()
-	expect := map[string]metrics.Metrics{
-		"gauge_test_1": metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		"gauge_test_2": metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
+	for i := 0; i < 2; i++ {
+		applied, err := s.SaveMetricsBatch(context.Background(), "batch_1", ms)
+		assert.NoError(t, err)
+		assert.True(t, applied)
 	}
-	assert.Equal(t, expect, actual)
 }
 
-func BenchmarkGetMetric(b *testing.B) {
-	ctrl := gomock.NewController(b)
+func TestSaveMetrics_forward(t *testing.T) {
+	logger.Set()
+	ctrl := gomock.NewController(t)
 	store := mock.NewMockSaveStorage(ctrl)
+	forwarder := mock.NewMockForwarder(ctrl)
 
 	ms := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
-		metrics.NewMetric("test_3", metrics.MetricTypeGauge, "3.5"),
-		metrics.NewMetric("test_4", metrics.MetricTypeGauge, "3.5"),
-		metrics.NewMetric("test_5", metrics.MetricTypeGauge, "3.5"),
-		metrics.NewMetric("test_6", metrics.MetricTypeGauge, "3.5"),
-		metrics.NewMetric("test_7", metrics.MetricTypeGauge, "3.5"),
-		metrics.NewMetric("test_8", metrics.MetricTypeGauge, "3.5"),
-		metrics.NewMetric("test_9", metrics.MetricTypeGauge, "3.5"),
-		metrics.NewMetric("test_10", metrics.MetricTypeGauge, "3.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
 	}
 
-	store.EXPECT().GetMetrics().Return(ms).AnyTimes()
+	store.EXPECT().UpsertMetrics(gomock.Any(), ms).Return(nil).Times(2)
+	store.EXPECT().UpsertMetrics(gomock.Any(), ms).Return(errors.New("wrong upsert collection"))
+	forwarder.EXPECT().Forward(gomock.Any(), ms).Return(nil)
+	forwarder.EXPECT().Forward(gomock.Any(), ms).Return(errors.New("buffer is not writable"))
+	s := NewMetricSaverService(store)
+	s.SetForwarder(forwarder)
+
+	// ошибка пересылки не отменяет сохранение, несохраненные метрики не пересылаются
+	assert.NoError(t, s.SaveMetrics(context.Background(), ms))
+	assert.NoError(t, s.SaveMetrics(context.Background(), ms))
+	assert.Error(t, s.SaveMetrics(context.Background(), ms))
+}
+
+func TestDeleteMetric(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	store := mock.NewMockSaveStorage(ctrl)
+
+	store.EXPECT().Delete(gomock.Any(), storage.Key{MType: metrics.MetricTypeGauge, ID: "test_1"}).Return(nil).Times(1)
+	store.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(storage.ErrNotFound).Times(1)
+	s := NewMetricSaverService(store)
+
+	assert.NoError(t, s.DeleteMetric(context.Background(), metrics.MetricTypeGauge, "test_1"))
+	assert.ErrorIs(t, s.DeleteMetric(context.Background(), metrics.MetricTypeGauge, "test_2"), storage.ErrNotFound)
+}
+
+func TestMetricHistory(t *testing.T) {
+	ctx := context.Background()
+	s := NewMetricSaverService(inmemstorage.NewStorage())
+
+	_, err := s.MetricHistory(ctx, metrics.MetricTypeGauge, "Alloc", time.Now().Add(-time.Hour), time.Now(), 0)
+	assert.ErrorIs(t, err, history.ErrDisabled)
+
+	tiers, err := history.ParseTiers("raw:1h,1m:24h")
+	require.NoError(t, err)
+	h, err := history.New(history.NewMemoryStore(), tiers)
+	require.NoError(t, err)
+	s.SetHistory(h)
+
+	from := time.Now().Add(-time.Minute)
+	require.NoError(t, s.SaveMetrics(ctx, []metrics.Metrics{
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "2"),
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "3"),
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "2.5"),
+	}))
+	to := time.Now().Add(time.Second)
+
+	// в истории счетчика — накопленное значение, gauge — последнее присланное
+	result, err := s.MetricHistory(ctx, metrics.MetricTypeCounter, "PollCount", from, to, 0)
+	require.NoError(t, err)
+	assert.Equal(t, history.Raw, result.Resolution)
+	require.Len(t, result.Points, 1)
+	assert.Equal(t, 5.0, result.Points[0].Sum)
+
+	result, err = s.MetricHistory(ctx, metrics.MetricTypeGauge, "Alloc", from, to, 0)
+	require.NoError(t, err)
+	require.Len(t, result.Points, 1)
+	assert.Equal(t, 2.5, result.Points[0].Sum)
+}
+
+func BenchmarkGetMetric(b *testing.B) {
+	store := inmemstorage.NewStorage()
+	ctx := context.Background()
+
+	ms := make([]metrics.Metrics, 0, 10)
+	for i := 1; i <= 10; i++ {
+		ms = append(ms, metrics.NewMetric("test_"+strconv.Itoa(i), metrics.MetricTypeGauge, "3.5"))
+	}
+	store.SetMetrics(ms)
 	s := NewMetricSaverService(store)
 
 	for i := 0; i < b.N; i++ {
-		s.GetMetric(metrics.MetricTypeGauge, "test_10")
+		s.GetMetric(ctx, metrics.MetricTypeGauge, "test_10")
 	}
 }
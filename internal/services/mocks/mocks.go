@@ -5,8 +5,10 @@
 package mock
 
 import (
+	context "context"
 	reflect "reflect"
 	metrics "ya-prac-project1/internal/metrics"
+	storage "ya-prac-project1/internal/storage"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -34,44 +36,164 @@ func (m *MockSaveStorage) EXPECT() *MockSaveStorageMockRecorder {
 	return m.recorder
 }
 
-// CreateMetrics mocks base method.
-func (m *MockSaveStorage) CreateMetrics(arg0 []metrics.Metrics) error {
+// Close mocks base method.
+func (m *MockSaveStorage) Close() error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateMetrics", arg0)
+	ret := m.ctrl.Call(m, "Close")
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// CreateMetrics indicates an expected call of CreateMetrics.
-func (mr *MockSaveStorageMockRecorder) CreateMetrics(arg0 interface{}) *gomock.Call {
+// Close indicates an expected call of Close.
+func (mr *MockSaveStorageMockRecorder) Close() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMetrics", reflect.TypeOf((*MockSaveStorage)(nil).CreateMetrics), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockSaveStorage)(nil).Close))
 }
 
-// GetMetrics mocks base method.
-func (m *MockSaveStorage) GetMetrics() []metrics.Metrics {
+// Delete mocks base method.
+func (m *MockSaveStorage) Delete(ctx context.Context, key storage.Key) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetMetrics")
+	ret := m.ctrl.Call(m, "Delete", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockSaveStorageMockRecorder) Delete(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockSaveStorage)(nil).Delete), ctx, key)
+}
+
+// Get mocks base method.
+func (m *MockSaveStorage) Get(ctx context.Context, key storage.Key) (metrics.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, key)
+	ret0, _ := ret[0].(metrics.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockSaveStorageMockRecorder) Get(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockSaveStorage)(nil).Get), ctx, key)
+}
+
+// List mocks base method.
+func (m *MockSaveStorage) List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, filter)
 	ret0, _ := ret[0].([]metrics.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockSaveStorageMockRecorder) List(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockSaveStorage)(nil).List), ctx, filter)
+}
+
+// UpsertMetrics mocks base method.
+func (m *MockSaveStorage) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertMetrics", ctx, ms)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// GetMetrics indicates an expected call of GetMetrics.
-func (mr *MockSaveStorageMockRecorder) GetMetrics() *gomock.Call {
+// UpsertMetrics indicates an expected call of UpsertMetrics.
+func (mr *MockSaveStorageMockRecorder) UpsertMetrics(ctx, ms interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertMetrics", reflect.TypeOf((*MockSaveStorage)(nil).UpsertMetrics), ctx, ms)
+}
+
+// UpsertTotals mocks base method.
+func (m *MockSaveStorage) UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertTotals", ctx, ms, totals)
+	ret0, _ := ret[0].([]metrics.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpsertTotals indicates an expected call of UpsertTotals.
+func (mr *MockSaveStorageMockRecorder) UpsertTotals(ctx, ms, totals interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertTotals", reflect.TypeOf((*MockSaveStorage)(nil).UpsertTotals), ctx, ms, totals)
+}
+
+// MockBatchRegistry is a mock of BatchRegistry interface.
+type MockBatchRegistry struct {
+	ctrl     *gomock.Controller
+	recorder *MockBatchRegistryMockRecorder
+}
+
+// MockBatchRegistryMockRecorder is the mock recorder for MockBatchRegistry.
+type MockBatchRegistryMockRecorder struct {
+	mock *MockBatchRegistry
+}
+
+// NewMockBatchRegistry creates a new mock instance.
+func NewMockBatchRegistry(ctrl *gomock.Controller) *MockBatchRegistry {
+	mock := &MockBatchRegistry{ctrl: ctrl}
+	mock.recorder = &MockBatchRegistryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBatchRegistry) EXPECT() *MockBatchRegistryMockRecorder {
+	return m.recorder
+}
+
+// UpsertBatch mocks base method.
+func (m *MockBatchRegistry) UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertBatch", ctx, id, ms)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpsertBatch indicates an expected call of UpsertBatch.
+func (mr *MockBatchRegistryMockRecorder) UpsertBatch(ctx, id, ms interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockSaveStorage)(nil).GetMetrics))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertBatch", reflect.TypeOf((*MockBatchRegistry)(nil).UpsertBatch), ctx, id, ms)
+}
+
+// MockForwarder is a mock of Forwarder interface.
+type MockForwarder struct {
+	ctrl     *gomock.Controller
+	recorder *MockForwarderMockRecorder
+}
+
+// MockForwarderMockRecorder is the mock recorder for MockForwarder.
+type MockForwarderMockRecorder struct {
+	mock *MockForwarder
+}
+
+// NewMockForwarder creates a new mock instance.
+func NewMockForwarder(ctrl *gomock.Controller) *MockForwarder {
+	mock := &MockForwarder{ctrl: ctrl}
+	mock.recorder = &MockForwarderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockForwarder) EXPECT() *MockForwarderMockRecorder {
+	return m.recorder
 }
 
-// UpdateMetrics mocks base method.
-func (m *MockSaveStorage) UpdateMetrics(arg0 []metrics.Metrics) error {
+// Forward mocks base method.
+func (m *MockForwarder) Forward(ctx context.Context, ms []metrics.Metrics) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateMetrics", arg0)
+	ret := m.ctrl.Call(m, "Forward", ctx, ms)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateMetrics indicates an expected call of UpdateMetrics.
-func (mr *MockSaveStorageMockRecorder) UpdateMetrics(arg0 interface{}) *gomock.Call {
+// Forward indicates an expected call of Forward.
+func (mr *MockForwarderMockRecorder) Forward(ctx, ms interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMetrics", reflect.TypeOf((*MockSaveStorage)(nil).UpdateMetrics), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Forward", reflect.TypeOf((*MockForwarder)(nil).Forward), ctx, ms)
 }
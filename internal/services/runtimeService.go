@@ -4,21 +4,18 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/hmac"
-	random "crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"log"
+	"maps"
 	"math/rand"
 	"net/http"
-	"os"
 	"runtime"
+	"slices"
+	"sync"
 	"time"
+	"ya-prac-project1/internal/agentconfig"
+	"ya-prac-project1/internal/client"
 	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/metrics"
 
@@ -36,27 +33,68 @@ type Storage interface {
 // RuntimeService структура представляющая сервис для получения рантайм метрик
 type RuntimeService struct {
 	storage Storage
+	options *runtimeOptions
+}
+
+// runtimeOptions настройки сбора метрик, которые можно менять во время работы сервиса
+type runtimeOptions struct {
+	mu           sync.RWMutex
+	poolInterval time.Duration
+	collectors   []string
 }
 
 // NewRuntimeService создает сервис
 func NewRuntimeService(storage Storage) RuntimeService {
-	return RuntimeService{storage: storage}
+	return RuntimeService{
+		storage: storage,
+		options: &runtimeOptions{
+			collectors: agentconfig.Collectors(),
+		},
+	}
 }
 
 // Run запускает работу сервиса
-func (s RuntimeService) Run(ctx context.Context, poolInterval int) {
+func (s RuntimeService) Run(ctx context.Context, poolInterval time.Duration) {
 	go s.updateRuntimeMetrics(ctx, poolInterval)
 }
 
-func (s RuntimeService) updateRuntimeMetrics(ctx context.Context, poolInterval int) {
-	ticker := time.NewTicker(time.Duration(poolInterval) * time.Second)
+// SetPoolInterval меняет интервал сбора метрик, применяется со следующего сбора
+func (s RuntimeService) SetPoolInterval(poolInterval time.Duration) {
+	s.options.mu.Lock()
+	defer s.options.mu.Unlock()
+	s.options.poolInterval = poolInterval
+}
+
+// SetCollectors задает сборщики метрик, которые будут использоваться
+func (s RuntimeService) SetCollectors(collectors []string) {
+	s.options.mu.Lock()
+	defer s.options.mu.Unlock()
+	s.options.collectors = slices.Clone(collectors)
+}
+
+func (s RuntimeService) getOptions() (time.Duration, []string) {
+	s.options.mu.RLock()
+	defer s.options.mu.RUnlock()
+	return s.options.poolInterval, s.options.collectors
+}
+
+func (s RuntimeService) updateRuntimeMetrics(ctx context.Context, poolInterval time.Duration) {
+	s.SetPoolInterval(poolInterval)
+	ticker := time.NewTicker(poolInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Get().Info("updateRuntimeMetrics stopped")
 			return
 		case <-ticker.C:
-			rMetrics := getRuntimeMetrics()
+			interval, collectors := s.getOptions()
+			if interval > 0 && interval != poolInterval {
+				poolInterval = interval
+				ticker.Reset(poolInterval)
+			}
+
+			rMetrics := collectMetrics(collectors)
 
 			pcm, err := getPoolCountMetric()
 			if err != nil {
@@ -122,19 +160,47 @@ func (s *RuntimeService) RunSendRequest(requestCh chan *http.Request, serverEndp
 	}
 
 	if key != "" {
-		h := hmac.New(sha256.New, []byte(key))
-		h.Write(buf.Bytes())
-		sign := hex.EncodeToString(h.Sum(nil))
-		req.Header.Set("HashSHA256", sign)
+		req.Header.Set(client.HashHeader, client.Sign(buf.Bytes(), key))
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(client.BatchIDHeader, client.NewBatchID())
 	requestCh <- req
 }
 
 func getRuntimeMetrics() []metrics.Metrics {
-	// основные метрики
+	return collectMetrics(agentconfig.Collectors())
+}
+
+// collectMetrics собирает метрики выбранными сборщиками
+func collectMetrics(collectors []string) []metrics.Metrics {
+	m := map[string]string{}
+	for _, collector := range collectors {
+		switch collector {
+		case agentconfig.CollectorRuntime:
+			maps.Copy(m, getMemStatsValues())
+		case agentconfig.CollectorSystem:
+			maps.Copy(m, getSystemValues())
+		}
+	}
+
+	items := []metrics.Metrics{}
+	for id, value := range m {
+		metric := metrics.Metrics{ID: id, MType: metrics.MetricTypeGauge}
+		err := metric.SetValue(value)
+		if err != nil {
+			logger.Get().Info("can't set runtime metric value. skip", zap.String("error", err.Error()))
+			continue
+		}
+		items = append(items, metric)
+	}
+
+	return items
+}
+
+// getMemStatsValues собирает основные метрики рантайма
+func getMemStatsValues() map[string]string {
 	stat := runtime.MemStats{}
 	runtime.ReadMemStats(&stat)
 	m := map[string]string{
@@ -167,7 +233,12 @@ func getRuntimeMetrics() []metrics.Metrics {
 		"GCCPUFraction": fmt.Sprint(stat.GCCPUFraction),
 	}
 
-	// дополнительный метрики
+	return m
+}
+
+// getSystemValues собирает дополнительные метрики системы
+func getSystemValues() map[string]string {
+	m := map[string]string{}
 	cpuUtilization, err := cpu.Counts(false)
 	if err != nil {
 		logger.Get().Info("can't get cpuUtilization. skip")
@@ -182,18 +253,7 @@ func getRuntimeMetrics() []metrics.Metrics {
 		}
 	}
 
-	items := []metrics.Metrics{}
-	for id, value := range m {
-		metric := metrics.Metrics{ID: id, MType: metrics.MetricTypeGauge}
-		err := metric.SetValue(value)
-		if err != nil {
-			logger.Get().Info("can't set runtime metric value. skip", zap.String("error", err.Error()))
-			continue
-		}
-		items = append(items, metric)
-	}
-
-	return items
+	return m
 }
 
 func encryptMessage(buf bytes.Buffer, cryptoKey string) bytes.Buffer {
@@ -201,27 +261,9 @@ func encryptMessage(buf bytes.Buffer, cryptoKey string) bytes.Buffer {
 		return buf
 	}
 
-	pubKeyBytes, err := os.ReadFile(cryptoKey)
-	if err != nil {
-		fmt.Printf("can't read public key. Error: %s\n", err)
-		return buf
-	}
-
-	block, _ := pem.Decode(pubKeyBytes)
-	if block == nil || block.Type != "RSA PUBLIC KEY" {
-		fmt.Printf("failed to decode PEM block containing public key")
-		return buf
-	}
-
-	publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
-	if err != nil {
-		fmt.Printf("can't parse public key. Error: %s\n", err)
-		return buf
-	}
-
-	encryptedMessage, err := rsa.EncryptPKCS1v15(random.Reader, publicKey, buf.Bytes())
+	encryptedMessage, err := client.Encrypt(buf.Bytes(), cryptoKey)
 	if err != nil {
-		fmt.Printf("can't encrypt message")
+		fmt.Printf("%s\n", err)
 		return buf
 	}
 
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"testing"
 	"time"
+	"ya-prac-project1/internal/agentconfig"
 	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/metrics"
 	mock "ya-prac-project1/internal/services/mocks"
@@ -20,6 +21,22 @@ func TestGetRuntimeMetrics(t *testing.T) {
 	assert.Equal(t, 30, len(ms))
 }
 
+func TestCollectMetrics(t *testing.T) {
+	logger.Set()
+	assert.Equal(t, 27, len(collectMetrics([]string{agentconfig.CollectorRuntime})))
+	assert.Equal(t, 0, len(collectMetrics([]string{})))
+}
+
+func TestSetOptions(t *testing.T) {
+	s := NewRuntimeService(nil)
+	s.SetPoolInterval(5 * time.Second)
+	s.SetCollectors([]string{agentconfig.CollectorSystem})
+
+	interval, collectors := s.getOptions()
+	assert.Equal(t, 5*time.Second, interval)
+	assert.Equal(t, []string{agentconfig.CollectorSystem}, collectors)
+}
+
 func TestGetPoolCountMetric(t *testing.T) {
 	m, _ := getPoolCountMetric()
 
@@ -42,7 +59,7 @@ func TestUpdateRuntimeMetrics(t *testing.T) {
 
 	ctx, stop := context.WithTimeout(context.Background(), 1*time.Second)
 	s := NewRuntimeService(store)
-	s.Run(ctx, 10)
+	s.Run(ctx, 10*time.Second)
 	<-ctx.Done()
 	stop()
 }
@@ -57,7 +74,7 @@ func TestUpdateRuntimeMetrics2(t *testing.T) {
 	ctx, stop := context.WithTimeout(context.Background(), 2*time.Second)
 
 	s := NewRuntimeService(store)
-	s.updateRuntimeMetrics(ctx, 1)
+	s.updateRuntimeMetrics(ctx, time.Second)
 	<-ctx.Done()
 	stop()
 }
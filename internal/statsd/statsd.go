@@ -0,0 +1,460 @@
+// Package statsd принимает метрики по протоколу StatsD через UDP.
+//
+// Строка пакета имеет вид name:value|type[|@rate][|#tag:value,...], в пакете может быть
+// несколько строк. Значения копятся и раз в FlushInterval сохраняются: счетчики (c) — суммой
+// приращений, gauge (g) — последним значением, таймеры (ms, h, d) — числом значений, минимумом,
+// максимумом, средним и перцентилями, множества (s) — числом разных значений. Теги DogStatsD
+// становятся метками.
+//
+// Счетчики сервера целые, поэтому у счетчика с частотой выборки сохраняется целая часть,
+// а дробный остаток переходит в следующий интервал. Gauge и остатки, которые не менялись
+// дольше IdleTTL, забываются
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+// DefaultFlushInterval интервал сохранения метрик по умолчанию
+const DefaultFlushInterval = 10 * time.Second
+
+// DefaultIdleTTL время хранения gauge и остатков счетчиков без обновлений по умолчанию
+const DefaultIdleTTL = 10 * time.Minute
+
+// DefaultPercentiles перцентили таймеров по умолчанию
+var DefaultPercentiles = []float64{50, 90, 95, 99}
+
+// QuantileLabel метка перцентиля таймера, значение — доля от 0 до 1, как в Prometheus
+const QuantileLabel = "quantile"
+
+// maxPacketSize наибольший размер пакета UDP
+const maxPacketSize = 64 * 1024
+
+// Типы метрик StatsD
+const (
+	typeCounter      = "c"
+	typeGauge        = "g"
+	typeTimer        = "ms"
+	typeHistogram    = "h"
+	typeDistribution = "d"
+	typeSet          = "s"
+)
+
+// Saver сохраняет накопленные метрики
+type Saver interface {
+	SaveMetrics(ctx context.Context, ms []metrics.Metrics) error
+}
+
+// Options настройки приема
+type Options struct {
+	// Address адрес приема по UDP
+	Address string
+	// FlushInterval интервал сохранения накопленных метрик
+	FlushInterval time.Duration
+	// Percentiles перцентили таймеров от 0 до 100
+	Percentiles []float64
+	// IdleTTL время, после которого забываются gauge и дробные остатки счетчиков без обновлений.
+	// Относительное изменение забытого gauge считается от нуля
+	IdleTTL time.Duration
+}
+
+// sample значение из строки пакета
+type sample struct {
+	// id имя метрики с метками
+	id    string
+	mType string
+	value float64
+	// relative значение gauge со знаком, которое прибавляется к прошлому
+	relative bool
+	// set значение множества как есть
+	set  string
+	rate float64
+}
+
+// Server принимает и копит метрики StatsD
+type Server struct {
+	saver Saver
+	opts  Options
+	conn  net.PacketConn
+
+	counters map[string]float64
+	gauges   map[string]*carry
+	// remainders дробные остатки счетчиков, не вошедшие в сохраненные приращения
+	remainders map[string]*carry
+	// updated gauge, измененные с прошлого сохранения
+	updated map[string]struct{}
+	timers  map[string]*timer
+	sets    map[string]map[string]struct{}
+	now     func() time.Time
+}
+
+// carry значение, которое переходит из интервала в интервал, и время его последнего изменения
+type carry struct {
+	value float64
+	seen  time.Time
+}
+
+// timer значения таймера за интервал
+type timer struct {
+	values []float64
+	// count число значений с поправкой на частоту выборки
+	count float64
+}
+
+// New создает прием метрик, сохраняемых через saver
+func New(saver Saver, opts Options) *Server {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.Percentiles == nil {
+		opts.Percentiles = DefaultPercentiles
+	}
+	if opts.IdleTTL <= 0 {
+		opts.IdleTTL = DefaultIdleTTL
+	}
+
+	s := &Server{
+		saver:      saver,
+		opts:       opts,
+		gauges:     map[string]*carry{},
+		remainders: map[string]*carry{},
+		now:        time.Now,
+	}
+	s.reset()
+	return s
+}
+
+// ParsePercentiles разбирает список перцентилей через запятую, например "50,90,99"
+func ParsePercentiles(s string) ([]float64, error) {
+	percentiles := []float64{}
+	if strings.TrimSpace(s) == "" {
+		return percentiles, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("want percentile in (0, 100], got %q", part)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// Listen открывает адрес приема
+func (s *Server) Listen() error {
+	conn, err := net.ListenPacket("udp", s.opts.Address)
+	if err != nil {
+		return fmt.Errorf("statsd: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// Addr возвращает адрес приема
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Run принимает метрики и сохраняет их раз в FlushInterval, пока не отменен ctx.
+// После отмены сохраняет то, что успело накопиться
+func (s *Server) Run(ctx context.Context) error {
+	if s.conn == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+
+	samples := make(chan sample, 1000)
+	go s.read(samples)
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case smp, ok := <-samples:
+			if !ok {
+				s.flush(ctx)
+				return nil
+			}
+			s.add(smp)
+		case <-ticker.C:
+			s.flush(ctx)
+		case <-ctx.Done():
+			s.conn.Close()
+			for smp := range samples {
+				s.add(smp)
+			}
+			// накопленные метрики сохраняются и после отмены ctx
+			s.flush(context.WithoutCancel(ctx))
+			return nil
+		}
+	}
+}
+
+// read читает пакеты, пока соединение не закрыто, и закрывает канал
+func (s *Server) read(samples chan<- sample) {
+	defer close(samples)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Get().Info("statsd read error", zap.String("error", err.Error()))
+			}
+			return
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			smp, err := parseLine(line)
+			if err != nil {
+				logger.Get().Info("statsd line error", zap.String("line", line), zap.String("error", err.Error()))
+				continue
+			}
+			samples <- smp
+		}
+	}
+}
+
+// add копит значение до сохранения
+func (s *Server) add(smp sample) {
+	switch smp.mType {
+	case typeCounter:
+		s.counters[smp.id] += smp.value / smp.rate
+	case typeGauge:
+		g, ok := s.gauges[smp.id]
+		if !ok {
+			g = &carry{}
+			s.gauges[smp.id] = g
+		}
+		if smp.relative {
+			g.value += smp.value
+		} else {
+			g.value = smp.value
+		}
+		g.seen = s.now()
+		s.updated[smp.id] = struct{}{}
+	case typeTimer, typeHistogram, typeDistribution:
+		t, ok := s.timers[smp.id]
+		if !ok {
+			t = &timer{}
+			s.timers[smp.id] = t
+		}
+		t.values = append(t.values, smp.value)
+		t.count += 1 / smp.rate
+	case typeSet:
+		set, ok := s.sets[smp.id]
+		if !ok {
+			set = map[string]struct{}{}
+			s.sets[smp.id] = set
+		}
+		set[smp.set] = struct{}{}
+	}
+}
+
+// flush сохраняет накопленное за интервал. Значения gauge остаются для относительных изменений
+func (s *Server) flush(ctx context.Context) {
+	ms := s.metrics()
+	s.reset()
+	s.expire()
+	if len(ms) == 0 {
+		return
+	}
+
+	if err := s.saver.SaveMetrics(ctx, ms); err != nil {
+		logger.Get().Info("statsd save error", zap.Int("metrics", len(ms)), zap.String("error", err.Error()))
+	}
+}
+
+// expire забывает gauge и остатки счетчиков, которые не менялись дольше IdleTTL
+func (s *Server) expire() {
+	deadline := s.now().Add(-s.opts.IdleTTL)
+	idle := func(_ string, c *carry) bool { return c.seen.Before(deadline) }
+	maps.DeleteFunc(s.gauges, idle)
+	maps.DeleteFunc(s.remainders, idle)
+}
+
+func (s *Server) reset() {
+	s.counters = map[string]float64{}
+	s.updated = map[string]struct{}{}
+	s.timers = map[string]*timer{}
+	s.sets = map[string]map[string]struct{}{}
+}
+
+// metrics возвращает метрики, накопленные за интервал, и запоминает остатки счетчиков.
+// Вызывается один раз на интервал
+func (s *Server) metrics() []metrics.Metrics {
+	ms := []metrics.Metrics{}
+	for id, value := range s.counters {
+		ms = append(ms, s.counter(id, "", value))
+	}
+	for id := range s.updated {
+		ms = append(ms, gauge(id, "", nil, s.gauges[id].value))
+	}
+	for id, set := range s.sets {
+		ms = append(ms, gauge(id, "", nil, float64(len(set))))
+	}
+	for id, t := range s.timers {
+		ms = append(ms, s.counter(id, "_count", t.count))
+		ms = append(ms, t.metrics(id, s.opts.Percentiles)...)
+	}
+	slices.SortFunc(ms, func(a, b metrics.Metrics) int { return strings.Compare(a.ID, b.ID) })
+	return ms
+}
+
+// metrics возвращает минимум, максимум, среднее и перцентили таймера
+func (t *timer) metrics(id string, percentiles []float64) []metrics.Metrics {
+	slices.Sort(t.values)
+	n := len(t.values)
+
+	sum := 0.0
+	for _, v := range t.values {
+		sum += v
+	}
+
+	ms := []metrics.Metrics{
+		gauge(id, "_min", nil, t.values[0]),
+		gauge(id, "_max", nil, t.values[n-1]),
+		gauge(id, "_mean", nil, sum/float64(n)),
+	}
+	for _, p := range percentiles {
+		// перцентиль по ближайшему рангу: наименьшее значение, не меньше которого p% значений
+		rank := int(math.Ceil(p / 100 * float64(n)))
+		value := t.values[max(rank, 1)-1]
+		quantile := strconv.FormatFloat(p/100, 'g', -1, 64)
+		ms = append(ms, gauge(id, "", map[string]string{QuantileLabel: quantile}, value))
+	}
+	return ms
+}
+
+// counter возвращает счетчик с целой частью значения и остатка прошлых интервалов,
+// дробный остаток переходит в следующий интервал: счетчики сервера целые
+func (s *Server) counter(id, suffix string, value float64) metrics.Metrics {
+	id = withSuffix(id, suffix, nil)
+	if r, ok := s.remainders[id]; ok {
+		value += r.value
+	}
+	// погрешность сложения дробей, например 3 * (1 / 0.3), не должна уводить целое в остаток
+	if whole := math.Round(value); math.Abs(value-whole) < 1e-9 {
+		value = whole
+	}
+
+	whole := math.Trunc(value)
+	if rest := value - whole; rest != 0 {
+		s.remainders[id] = &carry{value: rest, seen: s.now()}
+	} else {
+		delete(s.remainders, id)
+	}
+
+	delta := int64(whole)
+	return metrics.Metrics{ID: id, MType: metrics.MetricTypeCounter, Delta: &delta}
+}
+
+func gauge(id, suffix string, labels map[string]string, value float64) metrics.Metrics {
+	return metrics.Metrics{ID: withSuffix(id, suffix, labels), MType: metrics.MetricTypeGauge, Value: &value}
+}
+
+// withSuffix добавляет к имени метрики id суффикс и метки
+func withSuffix(id, suffix string, labels map[string]string) string {
+	if suffix == "" && len(labels) == 0 {
+		return id
+	}
+
+	name, idLabels, err := metrics.ParseID(id)
+	if err != nil {
+		return id + suffix
+	}
+	for key, value := range labels {
+		if idLabels == nil {
+			idLabels = make(map[string]string, len(labels))
+		}
+		idLabels[key] = value
+	}
+	return metrics.FormatID(name+suffix, idLabels)
+}
+
+// parseLine разбирает строку name:value|type[|@rate][|#tag:value,...]
+func parseLine(line string) (sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample{}, errors.New("want name:value|type")
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return sample{}, errors.New("want name:value|type")
+	}
+
+	smp := sample{mType: parts[1], rate: 1}
+	var labels map[string]string
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return sample{}, fmt.Errorf("invalid sample rate %q", part)
+			}
+			smp.rate = rate
+		case strings.HasPrefix(part, "#"):
+			labels = parseTags(part[1:])
+		}
+	}
+	smp.id = metrics.FormatID(name, labels)
+
+	value := parts[0]
+	switch smp.mType {
+	case typeSet:
+		if value == "" {
+			return sample{}, errors.New("empty set value")
+		}
+		smp.set = value
+		return smp, nil
+	case typeGauge:
+		smp.relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	case typeCounter, typeTimer, typeHistogram, typeDistribution:
+	default:
+		return sample{}, fmt.Errorf("unknown type %q", smp.mType)
+	}
+
+	var err error
+	smp.value, err = strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(smp.value) || math.IsInf(smp.value, 0) {
+		return sample{}, fmt.Errorf("invalid value %q", value)
+	}
+	return smp, nil
+}
+
+// parseTags разбирает теги DogStatsD tag:value,tag2:value2. Тег без значения становится
+// меткой со значением true
+func parseTags(s string) map[string]string {
+	labels := map[string]string{}
+	for _, tag := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(tag, ":")
+		if key == "" {
+			continue
+		}
+		if !ok || value == "" {
+			value = "true"
+		}
+		labels[key] = value
+	}
+	return labels
+}
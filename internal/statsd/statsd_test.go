@@ -0,0 +1,211 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSaver запоминает сохраненные пачки
+type fakeSaver struct {
+	mu      sync.Mutex
+	batches [][]metrics.Metrics
+}
+
+func (s *fakeSaver) SaveMetrics(_ context.Context, ms []metrics.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, ms)
+	return nil
+}
+
+func (s *fakeSaver) saved() [][]metrics.Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batches
+}
+
+func TestParseLine(t *testing.T) {
+	smp, err := parseLine("jobs.done:2|c|@0.5|#job:backup,nightly")
+	require.NoError(t, err)
+	assert.Equal(t, sample{id: `jobs.done{job="backup",nightly="true"}`, mType: typeCounter, value: 2, rate: 0.5}, smp)
+
+	smp, err = parseLine("queue:-3|g")
+	require.NoError(t, err)
+	assert.True(t, smp.relative)
+
+	smp, err = parseLine("users:alice|s")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", smp.set)
+
+	for _, line := range []string{"jobs", ":1|c", "jobs:1", "jobs:x|c", "jobs:1|x", "jobs:1|c|@2", "jobs:|s"} {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	p, err := ParsePercentiles("50, 99.9")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{50, 99.9}, p)
+
+	p, err = ParsePercentiles("")
+	require.NoError(t, err)
+	assert.Empty(t, p)
+
+	for _, s := range []string{"0", "101", "x"} {
+		_, err := ParsePercentiles(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestServer_metrics(t *testing.T) {
+	s := New(nil, Options{Percentiles: []float64{50, 90}})
+	for _, line := range []string{
+		"hits:1|c", "hits:2|c|@0.5",
+		"queue:10|g", "queue:+5|g", "queue:-3|g",
+		"users:alice|s", "users:bob|s", "users:alice|s",
+		"took:30|ms", "took:10|ms", "took:20|ms|@0.5",
+	} {
+		smp, err := parseLine(line)
+		require.NoError(t, err, line)
+		s.add(smp)
+	}
+
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric("hits", metrics.MetricTypeCounter, "5"),
+		metrics.NewMetric("queue", metrics.MetricTypeGauge, "12"),
+		metrics.NewMetric("took_count", metrics.MetricTypeCounter, "4"),
+		metrics.NewMetric("took_max", metrics.MetricTypeGauge, "30"),
+		metrics.NewMetric("took_mean", metrics.MetricTypeGauge, "20"),
+		metrics.NewMetric("took_min", metrics.MetricTypeGauge, "10"),
+		metrics.NewMetric(`took{quantile="0.5"}`, metrics.MetricTypeGauge, "20"),
+		metrics.NewMetric(`took{quantile="0.9"}`, metrics.MetricTypeGauge, "30"),
+		metrics.NewMetric("users", metrics.MetricTypeGauge, "2"),
+	}, s.metrics())
+
+	// после сохранения интервал начинается заново, gauge помнит значение для относительных изменений
+	s.reset()
+	assert.Empty(t, s.metrics())
+
+	smp, err := parseLine("queue:+1|g")
+	require.NoError(t, err)
+	s.add(smp)
+	assert.Equal(t, []metrics.Metrics{metrics.NewMetric("queue", metrics.MetricTypeGauge, "13")}, s.metrics())
+}
+
+// TestServer_remainder проверяет, что дробные приращения счетчиков с частотой выборки
+// не теряются между интервалами
+func TestServer_remainder(t *testing.T) {
+	s := New(nil, Options{})
+	var total int64
+	for i := 0; i < 10; i++ {
+		for _, line := range []string{"hits:1|c|@0.3", "took:5|ms|@0.4"} {
+			smp, err := parseLine(line)
+			require.NoError(t, err)
+			s.add(smp)
+		}
+
+		for _, m := range s.metrics() {
+			if m.ID == "hits" {
+				total += *m.Delta
+			}
+		}
+		s.reset()
+	}
+
+	// 10 / 0.3 = 33.3: целые сохранены, остаток ждет следующего интервала
+	assert.Equal(t, int64(33), total)
+	assert.InDelta(t, 1.0/3, s.remainders["hits"].value, 1e-9)
+	// 10 / 0.4 = 25: остатка нет
+	assert.NotContains(t, s.remainders, "took_count")
+}
+
+func TestServer_expire(t *testing.T) {
+	now := time.Now()
+	s := New(&fakeSaver{}, Options{IdleTTL: time.Minute})
+	s.now = func() time.Time { return now }
+
+	for _, line := range []string{"queue:10|g", "hits:1|c|@0.3"} {
+		smp, err := parseLine(line)
+		require.NoError(t, err)
+		s.add(smp)
+	}
+	s.flush(context.Background())
+	require.Contains(t, s.gauges, "queue")
+	require.Contains(t, s.remainders, "hits")
+
+	now = now.Add(2 * time.Minute)
+	smp, err := parseLine("active:1|g")
+	require.NoError(t, err)
+	s.add(smp)
+	s.flush(context.Background())
+
+	// gauge и остатки без обновлений забыты, относительное изменение считается от нуля
+	assert.Len(t, s.gauges, 1)
+	assert.Contains(t, s.gauges, "active")
+	assert.Empty(t, s.remainders)
+
+	smp, err = parseLine("queue:+1|g")
+	require.NoError(t, err)
+	s.add(smp)
+	assert.Equal(t, []metrics.Metrics{metrics.NewMetric("queue", metrics.MetricTypeGauge, "1")}, s.metrics())
+}
+
+func TestServer(t *testing.T) {
+	require.NoError(t, logger.Set())
+
+	saver := &fakeSaver{}
+	s := New(saver, Options{Address: "127.0.0.1:0", FlushInterval: 20 * time.Millisecond})
+	require.NoError(t, s.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hits:1|c\nbroken\nhits:2|c|#host:web1"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(saver.saved()) > 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric("hits", metrics.MetricTypeCounter, "1"),
+		metrics.NewMetric(`hits{host="web1"}`, metrics.MetricTypeCounter, "2"),
+	}, saver.saved()[0])
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server did not stop")
+	}
+}
+
+func TestServer_flushOnShutdown(t *testing.T) {
+	saver := &fakeSaver{}
+	s := New(saver, Options{Address: "127.0.0.1:0", FlushInterval: time.Hour})
+	require.NoError(t, s.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	smp, err := parseLine("took:5|ms")
+	require.NoError(t, err)
+	s.add(smp)
+
+	require.NoError(t, s.Run(ctx))
+	require.Len(t, saver.saved(), 1)
+	assert.Len(t, saver.saved()[0], 8)
+}
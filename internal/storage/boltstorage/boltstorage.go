@@ -0,0 +1,296 @@
+// Package boltstorage предоставляет хранилище во встроенной базе bbolt.
+//
+// База занимает один файл и не требует внешних сервисов. Каждое изменение выполняется
+// в транзакции и сбрасывается на диск до возврата, поэтому после сбоя база
+// содержит все подтвержденные изменения и ни одного частичного
+package boltstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// schemaVersion версия формата данных в файле
+	schemaVersion = 1
+	// batchTTL время, в течение которого помнится примененная пачка метрик
+	batchTTL = time.Hour
+	// openTimeout время ожидания блокировки файла другим процессом
+	openTimeout = time.Second
+)
+
+var (
+	metaBucket    = []byte("meta")
+	metricsBucket = []byte("metrics")
+	batchesBucket = []byte("metric_batches")
+	// batchesByTimeBucket индекс пачек по времени применения: ключ — время и идентификатор пачки
+	batchesByTimeBucket = []byte("metric_batches_by_time")
+	// totalsBucket последние накопленные итоги счетчиков по ключу метрики
+	totalsBucket = []byte("metric_totals")
+	versionKey   = []byte("schema_version")
+)
+
+// record метрика в базе вместе с временем создания и изменения, как в таблице metrics Postgres
+type record struct {
+	metrics.Metrics
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Storage структура представляющая репозиторий
+type Storage struct {
+	db *bolt.DB
+}
+
+// NewStorage открывает файл базы, создавая его при необходимости
+func NewStorage(path string) (*Storage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Storage{db: db}
+	if err = s.prepare(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// prepare создает бакеты и проверяет версию формата файла
+func (s *Storage) prepare() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, metricsBucket, batchesBucket, batchesByTimeBucket, totalsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		meta := tx.Bucket(metaBucket)
+		if raw := meta.Get(versionKey); raw != nil {
+			if version := binary.BigEndian.Uint64(raw); version > schemaVersion {
+				return fmt.Errorf("unsupported file format version %d, latest is %d", version, schemaVersion)
+			}
+			return nil
+		}
+
+		return meta.Put(versionKey, binary.BigEndian.AppendUint64(nil, schemaVersion))
+	})
+}
+
+// metricKey ключ метрики в бакете. Тип и имя разделены нулевым байтом, поэтому
+// метрики одного типа лежат рядом и упорядочены по имени
+func metricKey(key storage.Key) []byte {
+	return []byte(key.MType + "\x00" + key.ID)
+}
+
+// Get возвращает метрику по ключу
+func (s *Storage) Get(_ context.Context, key storage.Key) (metrics.Metrics, error) {
+	var rec record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(metricsBucket).Get(metricKey(key))
+		if raw == nil {
+			return storage.ErrNotFound
+		}
+		return json.Unmarshal(raw, &rec)
+	})
+
+	return rec.Metrics, err
+}
+
+// List возвращает метрики, попадающие под фильтр, упорядоченные по типу и имени
+func (s *Storage) List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	var prefix []byte
+	if filter.MType != "" {
+		prefix = metricKey(storage.Key{MType: filter.MType, ID: filter.Prefix})
+	}
+
+	items := []metrics.Metrics{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(metricsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if filter.Match(rec.Metrics) {
+				items = append(items, rec.Metrics)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// UpsertMetrics атомарно применяет метрики в одной транзакции: счетчики прибавляются
+// к сохраненным, gauge заменяются. Отсутствующие метрики добавляются
+func (s *Storage) UpsertMetrics(_ context.Context, ms []metrics.Metrics) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return upsert(tx, ms, time.Now().UTC())
+	})
+}
+
+func upsert(tx *bolt.Tx, ms []metrics.Metrics, now time.Time) error {
+	b := tx.Bucket(metricsBucket)
+	for _, m := range ms {
+		k := metricKey(storage.KeyOf(m))
+
+		rec := record{Metrics: m.Clone(), CreatedAt: now}
+		if raw := b.Get(k); raw != nil {
+			var stored record
+			if err := json.Unmarshal(raw, &stored); err != nil {
+				return err
+			}
+			rec.Metrics = merge(stored.Metrics, m)
+			rec.CreatedAt = stored.CreatedAt
+		}
+		rec.UpdatedAt = now
+
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err = b.Put(k, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertTotals применяет в одной транзакции метрики ms, как UpsertMetrics, и накопленные итоги
+// счетчиков totals: к счетчику прибавляется разница с прошлым итогом, см. metrics.Increment.
+// Итог запоминается вместе со счетчиком. Возвращает итоги, переведенные в приращения
+func (s *Storage) UpsertTotals(_ context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	now := time.Now().UTC()
+	increments := make([]metrics.Metrics, 0, len(totals))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		increments = increments[:0]
+		if err := upsert(tx, ms, now); err != nil {
+			return err
+		}
+
+		b := tx.Bucket(totalsBucket)
+		for _, m := range totals {
+			k := metricKey(storage.KeyOf(m))
+
+			var last *int64
+			if raw := b.Get(k); raw != nil {
+				total := int64(binary.BigEndian.Uint64(raw))
+				last = &total
+			}
+			increments = append(increments, metrics.Increment(m, last))
+
+			if err := b.Put(k, binary.BigEndian.AppendUint64(nil, uint64(*m.Delta))); err != nil {
+				return err
+			}
+		}
+
+		return upsert(tx, increments, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return increments, nil
+}
+
+// merge возвращает результат применения метрики m к сохраненной метрике stored
+func merge(stored, m metrics.Metrics) metrics.Metrics {
+	if m.MType != metrics.MetricTypeCounter || m.Delta == nil || stored.Delta == nil {
+		return m.Clone()
+	}
+
+	delta := *stored.Delta + *m.Delta
+	stored.Delta = &delta
+	return stored
+}
+
+// Delete удаляет метрику по ключу
+func (s *Storage) Delete(_ context.Context, key storage.Key) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metricsBucket)
+		k := metricKey(key)
+		if b.Get(k) == nil {
+			return storage.ErrNotFound
+		}
+		if err := tx.Bucket(totalsBucket).Delete(k); err != nil {
+			return err
+		}
+		return b.Delete(k)
+	})
+}
+
+// Close закрывает файл базы
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// UpsertBatch применяет пачку метрик id в одной транзакции с отметкой о ней, если пачка еще
+// не применялась. Возвращает false, если пачка уже была применена
+func (s *Storage) UpsertBatch(_ context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	return s.upsertBatch(id, ms, time.Now())
+}
+
+func (s *Storage) upsertBatch(id string, ms []metrics.Metrics, now time.Time) (bool, error) {
+	applied := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := pruneBatches(tx, now); err != nil {
+			return err
+		}
+
+		b := tx.Bucket(batchesBucket)
+		if b.Get([]byte(id)) != nil {
+			return nil
+		}
+
+		if err := upsert(tx, ms, now.UTC()); err != nil {
+			return err
+		}
+
+		appliedAt := binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+		if err := b.Put([]byte(id), appliedAt); err != nil {
+			return err
+		}
+
+		applied = true
+		return tx.Bucket(batchesByTimeBucket).Put(append(appliedAt, id...), nil)
+	})
+
+	return applied, err
+}
+
+// pruneBatches удаляет пачки, примененные раньше batchTTL. Индекс упорядочен по времени,
+// поэтому просматриваются только устаревшие пачки
+func pruneBatches(tx *bolt.Tx, now time.Time) error {
+	batches := tx.Bucket(batchesBucket)
+	c := tx.Bucket(batchesByTimeBucket).Cursor()
+	deadline := uint64(now.Add(-batchTTL).UnixNano())
+
+	for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k[:8]) <= deadline; k, _ = c.First() {
+		if err := batches.Delete(bytes.Clone(k[8:])); err != nil {
+			return err
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
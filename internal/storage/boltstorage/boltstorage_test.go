@@ -0,0 +1,177 @@
+package boltstorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T) (*Storage, string) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	s, err := NewStorage(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestUpsertMetrics(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "2"),
+	}))
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "3"),
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "1"),
+	}))
+
+	ms, err := s.List(ctx, storage.Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "6"),
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+	}, ms)
+}
+
+func TestGetDelete(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+	m := metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5")
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{m}))
+
+	actual, err := s.Get(ctx, storage.KeyOf(m))
+	require.NoError(t, err)
+	assert.Equal(t, m, actual)
+
+	_, err = s.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "test_1"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, s.Delete(ctx, storage.KeyOf(m)))
+	assert.ErrorIs(t, s.Delete(ctx, storage.KeyOf(m)), storage.ErrNotFound)
+}
+
+func TestList(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
+		metrics.NewMetric("other", metrics.MetricTypeGauge, "2.5"),
+	}))
+
+	tests := []struct {
+		name   string
+		filter storage.Filter
+		expect []string
+	}{
+		{name: "all", filter: storage.Filter{}, expect: []string{"counter_test_1", "gauge_other", "gauge_test_1"}},
+		{name: "type", filter: storage.Filter{MType: metrics.MetricTypeGauge}, expect: []string{"gauge_other", "gauge_test_1"}},
+		{name: "type and prefix", filter: storage.Filter{MType: metrics.MetricTypeGauge, Prefix: "te"}, expect: []string{"gauge_test_1"}},
+		{name: "prefix", filter: storage.Filter{Prefix: "test"}, expect: []string{"counter_test_1", "gauge_test_1"}},
+		{name: "empty", filter: storage.Filter{MType: metrics.MetricTypeCounter, Prefix: "other"}, expect: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, err := s.List(ctx, tt.filter)
+			require.NoError(t, err)
+
+			keys := []string{}
+			for _, m := range ms {
+				keys = append(keys, m.GetKey())
+			}
+			assert.Equal(t, tt.expect, keys)
+		})
+	}
+}
+
+func TestReopen(t *testing.T) {
+	s, path := newTestStorage(t)
+	ctx := context.Background()
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "5")}))
+	require.NoError(t, s.Close())
+
+	reopened, err := NewStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	m, err := reopened.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "5", m.GetValue())
+}
+
+func TestUpsertBatch(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+	ms := []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}
+
+	applied, err := s.UpsertBatch(ctx, "batch_1", ms)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	applied, err = s.UpsertBatch(ctx, "batch_1", ms)
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	m, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", m.GetValue())
+}
+
+func TestUpsertBatch_expire(t *testing.T) {
+	s, _ := newTestStorage(t)
+	now := time.Now()
+	ms := []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}
+
+	applied, err := s.upsertBatch("batch_1", ms, now)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	applied, _ = s.upsertBatch("batch_1", ms, now.Add(time.Minute))
+	assert.False(t, applied)
+
+	applied, _ = s.upsertBatch("batch_1", ms, now.Add(batchTTL))
+	assert.True(t, applied)
+}
+
+func TestUpsertTotals(t *testing.T) {
+	s, path := newTestStorage(t)
+	ctx := context.Background()
+	key := storage.Key{MType: metrics.MetricTypeCounter, ID: "bytes"}
+	total := func(v string) []metrics.Metrics {
+		return []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, v)}
+	}
+
+	increments, err := s.UpsertTotals(ctx, nil, total("10"))
+	require.NoError(t, err)
+	assert.Equal(t, total("10"), increments)
+	require.NoError(t, s.Close())
+
+	// после перезапуска итог не прибавляется повторно
+	s, err = NewStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	increments, err = s.UpsertTotals(ctx, []metrics.Metrics{metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")}, total("15"))
+	require.NoError(t, err)
+	assert.Equal(t, total("5"), increments)
+
+	m, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "15", m.GetValue())
+
+	// итог удаляется вместе со счетчиком
+	require.NoError(t, s.Delete(ctx, key))
+	increments, err = s.UpsertTotals(ctx, nil, total("15"))
+	require.NoError(t, err)
+	assert.Equal(t, total("15"), increments)
+}
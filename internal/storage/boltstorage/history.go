@@ -0,0 +1,212 @@
+package boltstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"time"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// History хранит уровни истории метрик в том же файле базы, что и Storage. Каждый уровень —
+// отдельный бакет, ключ точки — длина ключа метрики, ключ метрики и метка времени в миллисекундах,
+// поэтому точки одной метрики лежат рядом и упорядочены по времени
+type History struct {
+	db *bolt.DB
+}
+
+// History возвращает хранилище истории в файле базы репозитория
+func (s *Storage) History() *History {
+	return &History{db: s.db}
+}
+
+// historyBucket возвращает имя бакета уровня resolution
+func historyBucket(resolution time.Duration) []byte {
+	if resolution == history.Raw {
+		return []byte("history_raw")
+	}
+	return []byte("history_" + resolution.String())
+}
+
+// timeLen длина метки времени в ключе точки
+const timeLen = 8
+
+// seriesPrefix возвращает начало ключей точек метрики. Длина в начале не дает началу ключей
+// одной метрики совпасть с началом ключей другой
+func seriesPrefix(key storage.Key) []byte {
+	mk := metricKey(key)
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(mk))), mk...)
+}
+
+// pointKey возвращает ключ точки. Знаковый бит метки инвертируется, чтобы отрицательные
+// метки шли раньше положительных
+func pointKey(prefix []byte, t time.Time) []byte {
+	key := make([]byte, 0, len(prefix)+timeLen)
+	key = append(key, prefix...)
+	return binary.BigEndian.AppendUint64(key, uint64(t.UnixMilli())^1<<63)
+}
+
+// splitPointKey разбирает ключ точки на начало ключей метрики и метку времени
+func splitPointKey(key []byte) ([]byte, time.Time) {
+	n := len(key) - timeLen
+	return key[:n], time.UnixMilli(int64(binary.BigEndian.Uint64(key[n:]) ^ 1<<63))
+}
+
+// encodePoint кодирует точку: для сырого отсчета только значение, для свертки — min, max, sum и count
+func encodePoint(resolution time.Duration, p history.Point) []byte {
+	if resolution == history.Raw {
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(p.Sum))
+	}
+
+	value := make([]byte, 0, 4*8)
+	value = binary.BigEndian.AppendUint64(value, math.Float64bits(p.Min))
+	value = binary.BigEndian.AppendUint64(value, math.Float64bits(p.Max))
+	value = binary.BigEndian.AppendUint64(value, math.Float64bits(p.Sum))
+	return binary.BigEndian.AppendUint64(value, uint64(p.Count))
+}
+
+func decodePoint(t time.Time, value []byte) history.Point {
+	if len(value) == 8 {
+		return history.RawPoint(t, math.Float64frombits(binary.BigEndian.Uint64(value)))
+	}
+
+	return history.Point{
+		T:     t,
+		Min:   math.Float64frombits(binary.BigEndian.Uint64(value[0:])),
+		Max:   math.Float64frombits(binary.BigEndian.Uint64(value[8:])),
+		Sum:   math.Float64frombits(binary.BigEndian.Uint64(value[16:])),
+		Count: int64(binary.BigEndian.Uint64(value[24:])),
+	}
+}
+
+// Append сохраняет сырые отсчеты одной транзакцией
+func (h *History) Append(_ context.Context, samples []history.Sample) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(historyBucket(history.Raw))
+		if err != nil {
+			return err
+		}
+
+		for _, sample := range samples {
+			p := history.RawPoint(sample.T, sample.V)
+			if err = b.Put(pointKey(seriesPrefix(sample.Key), sample.T), encodePoint(history.Raw, p)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Read возвращает точки уровня resolution с T в [from, to)
+func (h *History) Read(_ context.Context, resolution time.Duration, key storage.Key, from, to time.Time) ([]history.Point, error) {
+	points := []history.Point{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket(resolution))
+		if b == nil {
+			return nil
+		}
+
+		points = readRange(b.Cursor(), seriesPrefix(key), from, to)
+		return nil
+	})
+
+	return points, err
+}
+
+// readRange читает точки метрики с началом ключей prefix и T в [from, to)
+func readRange(c *bolt.Cursor, prefix []byte, from, to time.Time) []history.Point {
+	points := []history.Point{}
+	for k, v := c.Seek(pointKey(prefix, from)); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		_, t := splitPointKey(k)
+		if !t.Before(to) {
+			break
+		}
+		points = append(points, decodePoint(t, v))
+	}
+
+	return points
+}
+
+// eachSeries вызывает fn для начала ключей каждой метрики бакета
+func eachSeries(b *bolt.Bucket, fn func(prefix []byte) error) error {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; {
+		prefix, _ := splitPointKey(k)
+		prefix = bytes.Clone(prefix)
+		if err := fn(prefix); err != nil {
+			return err
+		}
+
+		// следующая метрика начинается после последней возможной метки времени текущей
+		last := append(bytes.Clone(prefix), bytes.Repeat([]byte{0xff}, timeLen)...)
+		if k, _ = c.Seek(last); k != nil && bytes.Equal(k, last) {
+			k, _ = c.Next()
+		}
+	}
+
+	return nil
+}
+
+// Rollup сворачивает точки уровня src с T в [from, to) в уровень dst одной транзакцией
+func (h *History) Rollup(_ context.Context, src, dst time.Duration, from, to time.Time) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		srcBucket := tx.Bucket(historyBucket(src))
+		if srcBucket == nil {
+			return nil
+		}
+
+		dstBucket, err := tx.CreateBucketIfNotExists(historyBucket(dst))
+		if err != nil {
+			return err
+		}
+
+		return eachSeries(srcBucket, func(prefix []byte) error {
+			points := readRange(srcBucket.Cursor(), prefix, from, to)
+			for _, p := range history.Downsample(points, dst) {
+				if err := dstBucket.Put(pointKey(prefix, p.T), encodePoint(dst, p)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// DropBefore удаляет точки уровня resolution старше before
+func (h *History) DropBefore(_ context.Context, resolution time.Duration, before time.Time) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket(resolution))
+		if b == nil {
+			return nil
+		}
+
+		// ключи собираются заранее: удаление во время обхода сдвигает курсор
+		expired := [][]byte{}
+		err := eachSeries(b, func(prefix []byte) error {
+			c := b.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				if _, t := splitPointKey(k); !t.Before(before) {
+					break
+				}
+				expired = append(expired, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err = b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package boltstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	s, path := newTestStorage(t)
+	h := s.History()
+	ctx := context.Background()
+
+	alloc := storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"}
+	// точки метрики, имя которой начинается с имени alloc, не должны попадать в его выборки
+	allocMax := storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc\x00Max"}
+	start := time.UnixMilli(1704888000000)
+
+	samples := []history.Sample{}
+	for i := 0; i < 180; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Second)
+		samples = append(samples,
+			history.Sample{Key: alloc, T: ts, V: float64(i)},
+			history.Sample{Key: allocMax, T: ts, V: -1},
+		)
+	}
+	samples = append(samples, history.Sample{Key: alloc, T: time.UnixMilli(-1000), V: 7})
+	require.NoError(t, h.Append(ctx, samples))
+
+	points, err := h.Read(ctx, history.Raw, alloc, start, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, points, 6)
+	assert.Equal(t, history.RawPoint(start.Add(50*time.Second), 5), points[5])
+
+	points, err = h.Read(ctx, history.Raw, alloc, time.UnixMilli(-2000), time.UnixMilli(0))
+	require.NoError(t, err)
+	assert.Equal(t, []history.Point{history.RawPoint(time.UnixMilli(-1000), 7)}, points)
+
+	require.NoError(t, h.Rollup(ctx, history.Raw, time.Minute, start, start.Add(30*time.Minute)))
+	require.NoError(t, h.Rollup(ctx, time.Minute, time.Hour, start, start.Add(time.Hour)))
+
+	points, err = h.Read(ctx, time.Minute, alloc, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, points, 30)
+	assert.Equal(t, history.Point{T: start.Add(time.Minute), Min: 6, Max: 11, Sum: 51, Count: 6}, points[1])
+
+	points, err = h.Read(ctx, time.Hour, allocMax, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []history.Point{{T: start, Min: -1, Max: -1, Sum: -180, Count: 180}}, points)
+
+	require.NoError(t, h.DropBefore(ctx, history.Raw, start.Add(15*time.Minute)))
+	points, err = h.Read(ctx, history.Raw, alloc, time.UnixMilli(-2000), start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, points, 90)
+	assert.Equal(t, start.Add(15*time.Minute), points[0].T)
+
+	// история хранится в файле базы вместе с метриками
+	require.NoError(t, s.Close())
+	s, err = NewStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	points, err = s.History().Read(ctx, time.Minute, alloc, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, points, 30)
+
+	points, err = s.History().Read(ctx, 5*time.Minute, alloc, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, points)
+}
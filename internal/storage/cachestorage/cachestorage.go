@@ -0,0 +1,450 @@
+// Package cachestorage предоставляет кеширующий слой перед любым репозиторием метрик.
+//
+// Все метрики репозитория держатся в памяти, чтение обслуживается из нее. Запись зависит
+// от режима надежности:
+//   - DurabilityWriteThrough — изменение сначала применяется в репозитории, потом в кеше,
+//     подтвержденные изменения не теряются. Записи одной метрики упорядочены блокировкой
+//     ее ключа, записи разных метрик идут в репозиторий параллельно;
+//   - DurabilityWriteBehind — изменение применяется в кеше и копится в очереди, очередь
+//     сбрасывается в репозиторий по интервалу или при достижении MaxPending. Изменения
+//     одной метрики сворачиваются: дельты счетчиков складываются, от gauge остается
+//     последнее значение. При аварийном завершении теряются изменения, не сброшенные
+//     за последний интервал.
+//
+// Состояние очереди и длительность последнего сброса возвращает Stats, в метрики они не попадают
+package cachestorage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"go.uber.org/zap"
+)
+
+// Режимы надежности записи
+const (
+	DurabilityWriteThrough = "write-through"
+	DurabilityWriteBehind  = "write-behind"
+)
+
+// Backend репозиторий, перед которым стоит кеш
+type Backend interface {
+	Get(ctx context.Context, key storage.Key) (metrics.Metrics, error)
+	List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error)
+	UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error
+	Delete(ctx context.Context, key storage.Key) error
+	UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error)
+	Close() error
+}
+
+// batchRegistry репозиторий, который помнит примененные пачки метрик
+type batchRegistry interface {
+	UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error)
+}
+
+// dumpIntervalSetter репозиторий, у которого можно поменять интервал сброса метрик
+type dumpIntervalSetter interface {
+	SetDumpInterval(interval time.Duration)
+}
+
+// Options настройки кеша
+type Options struct {
+	// Durability режим надежности записи
+	Durability string
+	// FlushInterval интервал сброса очереди в режиме DurabilityWriteBehind
+	FlushInterval time.Duration
+	// MaxPending размер очереди, при котором она сбрасывается вне интервала
+	MaxPending int
+}
+
+// Stats состояние очереди записи
+type Stats struct {
+	Pending     int
+	Flushes     uint64
+	FlushErrors uint64
+	LastFlush   time.Duration
+}
+
+// change изменение метрики в очереди: удаление, после которого применяется metric
+type change struct {
+	reset  bool
+	metric *metrics.Metrics
+}
+
+// then возвращает изменение, равное применению c, а затем next
+func (c change) then(next change) change {
+	if next.reset || c.metric == nil && !c.reset {
+		return next
+	}
+	if next.metric == nil {
+		return c
+	}
+
+	m := next.metric.Clone()
+	if m.MType == metrics.MetricTypeCounter && m.Delta != nil && c.metric != nil && c.metric.Delta != nil {
+		delta := *c.metric.Delta + *m.Delta
+		m.Delta = &delta
+	}
+	c.metric = &m
+	return c
+}
+
+// Storage структура представляющая кеширующий репозиторий
+type Storage struct {
+	backend Backend
+	cache   *inmemstorage.Storage
+	opts    Options
+
+	// keys упорядочивает запись одной метрики в репозиторий и кеш, запись в репозиторий
+	// идет без mu, чтобы медленный репозиторий не останавливал остальные записи
+	keys keyLocks
+
+	// mu защищает очередь и статистику, в режиме DurabilityWriteBehind упорядочивает изменения кеша и очереди
+	mu      sync.Mutex
+	pending map[storage.Key]change
+	stats   Stats
+
+	// flushMu не дает сбросам по интервалу, по размеру и при закрытии идти одновременно
+	flushMu sync.Mutex
+	full    chan struct{}
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+// New создает кеш перед репозиторием backend и загружает в него все метрики репозитория
+func New(ctx context.Context, backend Backend, opts Options) (*Storage, error) {
+	if opts.Durability != DurabilityWriteThrough && opts.Durability != DurabilityWriteBehind {
+		return nil, errors.New("unknown cache durability " + opts.Durability)
+	}
+
+	ms, err := backend.List(ctx, storage.Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Storage{
+		backend: backend,
+		cache:   inmemstorage.NewStorage(),
+		opts:    opts,
+		pending: make(map[storage.Key]change),
+		full:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	s.cache.SetMetrics(ms)
+
+	flushCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	s.stop = stop
+	go s.runFlusher(flushCtx)
+
+	return s, nil
+}
+
+// Get возвращает метрику из кеша
+func (s *Storage) Get(ctx context.Context, key storage.Key) (metrics.Metrics, error) {
+	return s.cache.Get(ctx, key)
+}
+
+// List возвращает метрики из кеша, попадающие под фильтр
+func (s *Storage) List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	return s.cache.List(ctx, filter)
+}
+
+// UpsertMetrics применяет метрики в кеше и, в зависимости от режима, в репозитории или очереди
+func (s *Storage) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	if s.opts.Durability == DurabilityWriteThrough {
+		defer s.keys.lock(keysOf(ms))()
+
+		if err := s.backend.UpsertMetrics(ctx, ms); err != nil {
+			return err
+		}
+		return s.cache.UpsertMetrics(ctx, ms)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue(ctx, ms)
+}
+
+// queue применяет метрики в кеше и ставит их в очередь на запись. Вызывается под s.mu
+func (s *Storage) queue(ctx context.Context, ms []metrics.Metrics) error {
+	if err := s.cache.UpsertMetrics(ctx, ms); err != nil {
+		return err
+	}
+	for _, m := range ms {
+		key := storage.KeyOf(m)
+		m = m.Clone()
+		s.pending[key] = s.pending[key].then(change{metric: &m})
+	}
+	s.notifyFull()
+
+	return nil
+}
+
+// UpsertTotals применяет метрики ms, как UpsertMetrics, и накопленные итоги счетчиков totals.
+// Итоги всегда применяются в репозитории сразу: прошлый итог хранится там, и переводить итог
+// в приращение по кешу нельзя. Полученные приращения применяются в кеше без очереди.
+// Возвращает итоги, переведенные в приращения
+func (s *Storage) UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	if s.opts.Durability == DurabilityWriteThrough {
+		defer s.keys.lock(keysOf(ms, totals))()
+
+		increments, err := s.backend.UpsertTotals(ctx, ms, totals)
+		if err != nil {
+			return nil, err
+		}
+		return increments, s.cache.UpsertMetrics(ctx, append(slices.Clip(ms), increments...))
+	}
+
+	increments, err := s.upsertTotals(ctx, totals)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return increments, s.queue(ctx, ms)
+}
+
+// upsertTotals применяет итоги в репозитории и их приращения в кеше под блокировками ключей
+func (s *Storage) upsertTotals(ctx context.Context, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	defer s.keys.lock(keysOf(totals))()
+
+	increments, err := s.backend.UpsertTotals(ctx, nil, totals)
+	if err != nil {
+		return nil, err
+	}
+	return increments, s.cache.UpsertMetrics(ctx, increments)
+}
+
+// Delete удаляет метрику из кеша и, в зависимости от режима, из репозитория или через очередь
+func (s *Storage) Delete(ctx context.Context, key storage.Key) error {
+	if s.opts.Durability == DurabilityWriteThrough {
+		defer s.keys.lock([]storage.Key{key})()
+
+		if err := s.backend.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		return s.cache.Delete(ctx, key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.pending[key] = s.pending[key].then(change{reset: true})
+	s.notifyFull()
+
+	return nil
+}
+
+// Invalidate перечитывает из репозитория метрики keys, пустой список перечитывает все метрики.
+// Нужен, когда репозиторий меняют в обход кеша, например другие экземпляры сервера.
+// Изменения из очереди применяются поверх прочитанных значений, поэтому не теряются
+func (s *Storage) Invalidate(ctx context.Context, keys []storage.Key) error {
+	// сброс не должен идти одновременно: его изменения уже вынуты из очереди, но еще не в репозитории.
+	// Записи перечитываемых метрик ждут: иначе их изменение попадет в кеш дважды
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	if len(keys) == 0 {
+		defer s.keys.lockAll()()
+	} else {
+		defer s.keys.lock(keys)()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(keys) == 0 {
+		return s.reloadAll(ctx)
+	}
+
+	for _, key := range keys {
+		m, err := s.backend.Get(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+
+		// счетчик в кеше прибавляется к сохраненному, поэтому старое значение удаляется
+		s.cache.Delete(ctx, key)
+		if err == nil {
+			s.cache.UpsertMetrics(ctx, []metrics.Metrics{m})
+		}
+		s.applyPending(ctx, key, s.pending[key])
+	}
+
+	return nil
+}
+
+// reloadAll заменяет кеш метриками репозитория. Вызывается под s.mu
+func (s *Storage) reloadAll(ctx context.Context) error {
+	ms, err := s.backend.List(ctx, storage.Filter{})
+	if err != nil {
+		return err
+	}
+
+	s.cache.SetMetrics(ms)
+	for key, c := range s.pending {
+		s.applyPending(ctx, key, c)
+	}
+
+	return nil
+}
+
+// applyPending применяет в кеше изменение метрики key из очереди. Вызывается под s.mu
+func (s *Storage) applyPending(ctx context.Context, key storage.Key, c change) {
+	if c.reset {
+		s.cache.Delete(ctx, key)
+	}
+	if c.metric != nil {
+		s.cache.UpsertMetrics(ctx, []metrics.Metrics{*c.metric})
+	}
+}
+
+// notifyFull будит сброс, если очередь достигла MaxPending. Вызывается под s.mu
+func (s *Storage) notifyFull() {
+	if s.opts.MaxPending <= 0 || len(s.pending) < s.opts.MaxPending {
+		return
+	}
+
+	select {
+	case s.full <- struct{}{}:
+	default:
+	}
+}
+
+// Stats возвращает состояние очереди записи
+func (s *Storage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Pending = len(s.pending)
+	return stats
+}
+
+// Flush сбрасывает очередь в репозиторий. Изменения, которые не удалось сбросить,
+// возвращаются в очередь
+func (s *Storage) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = make(map[storage.Key]change)
+	s.mu.Unlock()
+
+	start := time.Now()
+	err := s.apply(ctx, batch)
+	latency := time.Since(start)
+
+	s.mu.Lock()
+	if err != nil {
+		// изменения из очереди старше пришедших во время сброса
+		for key, c := range batch {
+			s.pending[key] = c.then(s.pending[key])
+		}
+		s.stats.FlushErrors++
+	}
+	s.stats.Flushes++
+	s.stats.LastFlush = latency
+	s.mu.Unlock()
+
+	return err
+}
+
+// apply применяет изменения очереди в репозитории: сначала удаления, потом метрики одной пачкой
+func (s *Storage) apply(ctx context.Context, batch map[storage.Key]change) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ms := make([]metrics.Metrics, 0, len(batch))
+	for key, c := range batch {
+		if c.reset {
+			if err := s.backend.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+		}
+		if c.metric != nil {
+			ms = append(ms, *c.metric)
+		}
+	}
+
+	if len(ms) == 0 {
+		return nil
+	}
+
+	return s.backend.UpsertMetrics(ctx, ms)
+}
+
+func (s *Storage) runFlusher(ctx context.Context) {
+	defer close(s.done)
+	if s.opts.Durability != DurabilityWriteBehind {
+		return
+	}
+
+	var tick <-chan time.Time
+	if s.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(s.opts.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-s.full:
+		}
+
+		if err := s.Flush(ctx); err != nil {
+			logger.Get().Info("cache flush error", zap.String("error", err.Error()))
+		}
+	}
+}
+
+// Close останавливает сброс по интервалу, сбрасывает очередь и закрывает репозиторий
+func (s *Storage) Close() error {
+	s.stop()
+	<-s.done
+
+	err := s.Flush(context.Background())
+	return errors.Join(err, s.backend.Close())
+}
+
+// UpsertBatch применяет пачку метрик id, если она еще не применялась. Возвращает false, если пачка
+// уже была применена. В режиме DurabilityWriteThrough пачка отмечается в репозитории в одной
+// транзакции с метриками, если он это умеет. В режиме DurabilityWriteBehind пачки помнятся в кеше:
+// отметка не может попасть в репозиторий вместе с метриками из очереди
+func (s *Storage) UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	registry, ok := s.backend.(batchRegistry)
+	if !ok || s.opts.Durability != DurabilityWriteThrough {
+		return s.cache.ApplyBatch(ctx, id, func() error {
+			return s.UpsertMetrics(ctx, ms)
+		})
+	}
+
+	defer s.keys.lock(keysOf(ms))()
+
+	applied, err := registry.UpsertBatch(ctx, id, ms)
+	if err != nil || !applied {
+		return applied, err
+	}
+	return true, s.cache.UpsertMetrics(ctx, ms)
+}
+
+// SetDumpInterval передает новый интервал сброса метрик репозиторию, если он его поддерживает
+func (s *Storage) SetDumpInterval(interval time.Duration) {
+	if setter, ok := s.backend.(dumpIntervalSetter); ok {
+		setter.SetDumpInterval(interval)
+	}
+}
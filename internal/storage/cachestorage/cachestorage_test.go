@@ -0,0 +1,390 @@
+package cachestorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingBackend репозиторий в памяти, который запоминает сброшенные в него пачки
+type recordingBackend struct {
+	*inmemstorage.Storage
+
+	mu      sync.Mutex
+	upserts [][]metrics.Metrics
+	fail    error
+}
+
+func newBackend(ms ...metrics.Metrics) *recordingBackend {
+	b := &recordingBackend{Storage: inmemstorage.NewStorage()}
+	b.SetMetrics(ms)
+	return b
+}
+
+func (b *recordingBackend) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail != nil {
+		return b.fail
+	}
+
+	b.upserts = append(b.upserts, ms)
+	return b.Storage.UpsertMetrics(ctx, ms)
+}
+
+func (b *recordingBackend) calls() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.upserts)
+}
+
+func (b *recordingBackend) setFail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fail = err
+}
+
+func counter(name, value string) metrics.Metrics {
+	return metrics.NewMetric(name, metrics.MetricTypeCounter, value)
+}
+
+func gauge(name, value string) metrics.Metrics {
+	return metrics.NewMetric(name, metrics.MetricTypeGauge, value)
+}
+
+func newCache(t *testing.T, backend Backend, opts Options) *Storage {
+	s, err := New(context.Background(), backend, opts)
+	require.NoError(t, err)
+	return s
+}
+
+func TestNew_warm(t *testing.T) {
+	backend := newBackend(gauge("Alloc", "1.5"))
+	s := newCache(t, backend, Options{Durability: DurabilityWriteBehind})
+
+	m, err := s.Get(context.Background(), storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"})
+	require.NoError(t, err)
+	assert.Equal(t, "1.5", m.GetValue())
+
+	_, err = New(context.Background(), backend, Options{Durability: "eventually"})
+	assert.Error(t, err)
+}
+
+func TestWriteBehind_coalesce(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(counter("PollCount", "10"))
+	s := newCache(t, backend, Options{Durability: DurabilityWriteBehind})
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter("PollCount", "1"), gauge("Alloc", "1")}))
+	}
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Alloc", "7")}))
+
+	// чтение видит изменения сразу, репозиторий — только после сброса
+	m, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "15", m.GetValue())
+	assert.Equal(t, 0, backend.calls())
+	assert.Equal(t, 2, s.Stats().Pending)
+
+	require.NoError(t, s.Flush(ctx))
+	assert.Equal(t, 1, backend.calls())
+	assert.ElementsMatch(t, []metrics.Metrics{counter("PollCount", "5"), gauge("Alloc", "7")}, backend.upserts[0])
+
+	m, err = backend.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "15", m.GetValue())
+
+	stats := s.Stats()
+	assert.Equal(t, 0, stats.Pending)
+	assert.Equal(t, uint64(1), stats.Flushes)
+
+	// состояние очереди не попадает в метрики
+	ms, err := s.List(ctx, storage.Filter{})
+	require.NoError(t, err)
+	assert.Len(t, ms, 2)
+}
+
+func TestWriteBehind_deleteThenUpsert(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(counter("PollCount", "10"))
+	s := newCache(t, backend, Options{Durability: DurabilityWriteBehind})
+
+	require.NoError(t, s.Delete(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"}))
+	assert.ErrorIs(t, s.Delete(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"}), storage.ErrNotFound)
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter("PollCount", "2")}))
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter("PollCount", "3")}))
+	require.NoError(t, s.Flush(ctx))
+
+	m, err := backend.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "5", m.GetValue())
+}
+
+func TestWriteBehind_flushError(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend()
+	s := newCache(t, backend, Options{Durability: DurabilityWriteBehind})
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter("PollCount", "1")}))
+	backend.setFail(errors.New("backend is down"))
+	assert.Error(t, s.Flush(ctx))
+	assert.Equal(t, 1, s.Stats().Pending)
+	assert.Equal(t, uint64(1), s.Stats().FlushErrors)
+
+	// изменения, пришедшие после неудачного сброса, складываются с вернувшимися в очередь
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter("PollCount", "2")}))
+	backend.setFail(nil)
+	require.NoError(t, s.Flush(ctx))
+
+	m, err := backend.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "3", m.GetValue())
+}
+
+func TestWriteBehind_maxPending(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend()
+	s := newCache(t, backend, Options{Durability: DurabilityWriteBehind, MaxPending: 2})
+	defer s.Close()
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Alloc", "1"), gauge("HeapAlloc", "2")}))
+	assert.Eventually(t, func() bool { return backend.calls() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWriteBehind_close(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend()
+	s := newCache(t, backend, Options{Durability: DurabilityWriteBehind, FlushInterval: time.Hour})
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Alloc", "1")}))
+	require.NoError(t, s.Close())
+
+	m, err := backend.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", m.GetValue())
+}
+
+func TestWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend()
+	s := newCache(t, backend, Options{Durability: DurabilityWriteThrough})
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Alloc", "1")}))
+	assert.Equal(t, 1, backend.calls())
+
+	backend.setFail(errors.New("backend is down"))
+	assert.Error(t, s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Alloc", "2")}))
+
+	m, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", m.GetValue())
+
+	require.NoError(t, s.Delete(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"}))
+	_, err = backend.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// slowBackend репозиторий, запись метрики slow в который ждет release
+type slowBackend struct {
+	*recordingBackend
+	slow    string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *slowBackend) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	for _, m := range ms {
+		if m.ID == b.slow {
+			b.started <- struct{}{}
+			<-b.release
+		}
+	}
+	return b.recordingBackend.UpsertMetrics(ctx, ms)
+}
+
+// TestWriteThrough_parallel проверяет, что медленная запись одной метрики в репозиторий
+// не останавливает запись других метрик и чтение, а записи той же метрики ждут ее
+func TestWriteThrough_parallel(t *testing.T) {
+	ctx := context.Background()
+	backend := &slowBackend{recordingBackend: newBackend(), slow: "Slow", started: make(chan struct{}, 2), release: make(chan struct{})}
+	s := newCache(t, backend, Options{Durability: DurabilityWriteThrough})
+
+	slowDone := make(chan error, 2)
+	go func() { slowDone <- s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Slow", "1")}) }()
+	<-backend.started
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Fast", "1")}))
+	_, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Fast"})
+	require.NoError(t, err)
+	assert.Zero(t, s.Stats().Pending)
+
+	go func() { slowDone <- s.UpsertMetrics(ctx, []metrics.Metrics{gauge("Slow", "2")}) }()
+	select {
+	case <-backend.started:
+		t.Fatal("write of the same metric did not wait for the previous one")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(backend.release)
+	require.NoError(t, <-slowDone)
+	require.NoError(t, <-slowDone)
+
+	// в кеше и репозитории последнее значение
+	m, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Slow"})
+	require.NoError(t, err)
+	assert.Equal(t, "2", m.GetValue())
+	m, err = backend.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Slow"})
+	require.NoError(t, err)
+	assert.Equal(t, "2", m.GetValue())
+}
+
+func TestInvalidate(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(counter("PollCount", "10"), gauge("Alloc", "1"), gauge("Frees", "1"))
+	s := newCache(t, backend, Options{Durability: DurabilityWriteBehind})
+
+	// другой экземпляр сервера меняет репозиторий в обход кеша
+	require.NoError(t, backend.Storage.UpsertMetrics(ctx, []metrics.Metrics{counter("PollCount", "5"), gauge("Alloc", "2")}))
+	require.NoError(t, backend.Storage.Delete(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Frees"}))
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter("PollCount", "1")}))
+
+	require.NoError(t, s.Invalidate(ctx, []storage.Key{
+		{MType: metrics.MetricTypeCounter, ID: "PollCount"},
+		{MType: metrics.MetricTypeGauge, ID: "Frees"},
+	}))
+
+	// несброшенная дельта применяется поверх прочитанного значения
+	m, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "16", m.GetValue())
+	_, err = s.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Frees"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	m, err = s.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", m.GetValue())
+
+	require.NoError(t, backend.Storage.UpsertMetrics(ctx, []metrics.Metrics{gauge("HeapAlloc", "3")}))
+	require.NoError(t, s.Invalidate(ctx, nil))
+
+	ms, err := s.List(ctx, storage.Filter{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []metrics.Metrics{counter("PollCount", "16"), gauge("Alloc", "2"), gauge("HeapAlloc", "3")}, ms)
+
+	require.NoError(t, s.Flush(ctx))
+	m, err = backend.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "16", m.GetValue())
+}
+
+func TestUpsertBatch(t *testing.T) {
+	ctx := context.Background()
+	ms := []metrics.Metrics{counter("PollCount", "1")}
+
+	backend := newBackend()
+	s := newCache(t, backend, Options{Durability: DurabilityWriteThrough})
+
+	applied, err := s.UpsertBatch(ctx, "batch_1", ms)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	// в режиме write-through пачки отмечаются в репозитории вместе с метриками
+	applied, err = backend.UpsertBatch(ctx, "batch_1", ms)
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	applied, err = s.UpsertBatch(ctx, "batch_1", ms)
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	m, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", m.GetValue())
+
+	// в режиме write-behind пачки помнятся в кеше
+	backend = newBackend()
+	s = newCache(t, backend, Options{Durability: DurabilityWriteBehind})
+
+	applied, err = s.UpsertBatch(ctx, "batch_1", ms)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	applied, err = s.UpsertBatch(ctx, "batch_1", ms)
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	applied, err = backend.UpsertBatch(ctx, "batch_1", nil)
+	require.NoError(t, err)
+	assert.True(t, applied)
+}
+
+func TestUpsertTotals(t *testing.T) {
+	ctx := context.Background()
+	key := storage.Key{MType: metrics.MetricTypeCounter, ID: "bytes"}
+
+	for _, durability := range []string{DurabilityWriteThrough, DurabilityWriteBehind} {
+		t.Run(durability, func(t *testing.T) {
+			backend := newBackend()
+			s := newCache(t, backend, Options{Durability: durability})
+
+			for _, total := range []string{"10", "15"} {
+				_, err := s.UpsertTotals(ctx, []metrics.Metrics{gauge("Alloc", "1")}, []metrics.Metrics{counter("bytes", total)})
+				require.NoError(t, err)
+			}
+
+			// итоги переводятся в приращения репозиторием сразу, в том числе в режиме write-behind
+			m, err := backend.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, "15", m.GetValue())
+
+			m, err = s.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, "15", m.GetValue())
+
+			require.NoError(t, s.Flush(ctx))
+			m, err = backend.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, "15", m.GetValue())
+
+			m, err = backend.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"})
+			require.NoError(t, err)
+			assert.Equal(t, "1", m.GetValue())
+		})
+	}
+}
+
+func TestChangeThen(t *testing.T) {
+	one, two := counter("PollCount", "1"), counter("PollCount", "2")
+
+	tests := []struct {
+		name   string
+		first  change
+		next   change
+		expect change
+	}{
+		{name: "empty", first: change{}, next: change{metric: &one}, expect: change{metric: &one}},
+		{name: "sum", first: change{metric: &one}, next: change{metric: &two}, expect: change{metric: &metrics.Metrics{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: ptr(int64(3))}}},
+		{name: "reset", first: change{metric: &one}, next: change{reset: true}, expect: change{reset: true}},
+		{name: "reset then upsert", first: change{reset: true}, next: change{metric: &two}, expect: change{reset: true, metric: &two}},
+		{name: "nothing new", first: change{metric: &one}, next: change{}, expect: change{metric: &one}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, tt.first.then(tt.next))
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
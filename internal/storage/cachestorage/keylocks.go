@@ -0,0 +1,69 @@
+package cachestorage
+
+import (
+	"hash/fnv"
+	"slices"
+	"sync"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+)
+
+// keyLockStripes число блокировок, по которым распределяются ключи метрик
+const keyLockStripes = 64
+
+// keyLocks упорядочивает запись одной метрики в репозиторий и кеш. Ключи распределены
+// по keyLockStripes блокировкам, поэтому записи разных метрик идут параллельно
+type keyLocks struct {
+	stripes [keyLockStripes]sync.Mutex
+}
+
+// lock берет блокировки ключей keys в порядке номеров, чтобы встречные записи
+// не взаимоблокировались, и возвращает функцию, которая их снимает
+func (l *keyLocks) lock(keys []storage.Key) func() {
+	idx := make([]int, 0, len(keys))
+	for _, key := range keys {
+		idx = append(idx, stripe(key))
+	}
+	slices.Sort(idx)
+	idx = slices.Compact(idx)
+
+	for _, i := range idx {
+		l.stripes[i].Lock()
+	}
+	return func() {
+		for _, i := range idx {
+			l.stripes[i].Unlock()
+		}
+	}
+}
+
+// lockAll берет блокировки всех ключей
+func (l *keyLocks) lockAll() func() {
+	for i := range l.stripes {
+		l.stripes[i].Lock()
+	}
+	return func() {
+		for i := range l.stripes {
+			l.stripes[i].Unlock()
+		}
+	}
+}
+
+func stripe(key storage.Key) int {
+	h := fnv.New32a()
+	h.Write([]byte(key.MType))
+	h.Write([]byte{0})
+	h.Write([]byte(key.ID))
+	return int(h.Sum32() % keyLockStripes)
+}
+
+// keysOf возвращает ключи метрик
+func keysOf(ms ...[]metrics.Metrics) []storage.Key {
+	var keys []storage.Key
+	for _, list := range ms {
+		for _, m := range list {
+			keys = append(keys, storage.KeyOf(m))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,246 @@
+package databasestorage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+)
+
+// copyThreshold размер пачки, начиная с которого метрики загружаются через COPY
+// во временную таблицу, меньшие пачки отправляются одним pgx batch
+const copyThreshold = 500
+
+// stagingColumns колонки временной таблицы для COPY
+var stagingColumns = []string{"seq", "type", "name", "value", "delta"}
+
+// UpsertMetrics атомарно применяет метрики: счетчики прибавляются к сохраненным, gauge заменяются.
+// Отсутствующие метрики добавляются. Пачка применяется в одной транзакции за один обмен с базой
+func (s *Storage) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	if len(ms) == 0 {
+		return nil
+	}
+
+	return s.inTx(ctx, func(tx pgx.Tx) error {
+		return upsert(ctx, tx, ms)
+	})
+}
+
+// UpsertBatch применяет пачку метрик id в одной транзакции с отметкой о ней, если пачка еще
+// не применялась. Возвращает false, если пачка уже была применена. Повтор пачки, которую
+// сейчас применяет другой запрос, ждет на вставке отметки окончания той транзакции
+func (s *Storage) UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	_, err := s.stmts.expireBatch.ExecContext(ctx, batchTTLSeconds)
+	if err != nil {
+		logger.Get().Info("delete expired batches error", zap.String("error", err.Error()))
+	}
+
+	applied := false
+	err = s.inTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, getInsertBatchSQL(), id)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+
+		if err = upsert(ctx, tx, ms); err != nil {
+			return err
+		}
+
+		applied = true
+		return nil
+	})
+
+	return applied && err == nil, err
+}
+
+// UpsertTotals применяет в одной транзакции метрики ms, как UpsertMetrics, и накопленные итоги
+// счетчиков totals: к счетчику прибавляется разница с прошлым итогом, см. metrics.Increment.
+// Итог хранится в строке счетчика, которая блокируется до конца транзакции, поэтому экземпляры,
+// получившие итоги одного источника, не прибавят их дважды. Возвращает итоги, переведенные в приращения
+func (s *Storage) UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	// строки блокируются в порядке имен, чтобы встречные транзакции не взаимоблокировались
+	sorted := slices.Clone(totals)
+	slices.SortStableFunc(sorted, func(a, b metrics.Metrics) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	var increments []metrics.Metrics
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		increments = make([]metrics.Metrics, 0, len(sorted))
+		for _, m := range sorted {
+			increment, err := upsertTotal(ctx, tx, m)
+			if err != nil {
+				return err
+			}
+			increments = append(increments, increment)
+		}
+
+		return upsert(ctx, tx, ms)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return increments, nil
+}
+
+// upsertTotal блокирует строку счетчика, прибавляет к нему приращение итога m и запоминает итог
+func upsertTotal(ctx context.Context, tx pgx.Tx, m metrics.Metrics) (metrics.Metrics, error) {
+	if _, err := tx.Exec(ctx, getInsertCounterSQL(), m.ID); err != nil {
+		return metrics.Metrics{}, err
+	}
+
+	var last *int64
+	if err := tx.QueryRow(ctx, getSelectTotalSQL(), m.ID).Scan(&last); err != nil {
+		return metrics.Metrics{}, err
+	}
+
+	increment := metrics.Increment(m, last)
+	_, err := tx.Exec(ctx, getUpdateTotalSQL(), m.ID, *increment.Delta, *m.Delta)
+	return increment, err
+}
+
+// inTx выполняет fn в транзакции на соединении pgx
+func (s *Storage) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("batch upsert requires pgx driver")
+		}
+
+		return pgx.BeginFunc(ctx, c.Conn(), fn)
+	})
+}
+
+// upsert применяет метрики в транзакции: большие пачки через COPY, остальные одним pgx batch.
+// Строки блокируются в порядке метрик, поэтому метрики сворачиваются и сортируются по типу и имени:
+// иначе встречные пачки с общими метриками в разном порядке взаимоблокируются
+func upsert(ctx context.Context, tx pgx.Tx, ms []metrics.Metrics) error {
+	ms = coalesce(ms)
+	switch {
+	case len(ms) == 0:
+		return nil
+	case len(ms) >= copyThreshold:
+		return copyUpsert(ctx, tx, ms)
+	default:
+		return batchUpsert(ctx, tx, ms)
+	}
+}
+
+// batchUpsert отправляет запросы пачки одним pgx batch, запрос подготавливается драйвером один раз
+func batchUpsert(ctx context.Context, tx pgx.Tx, ms []metrics.Metrics) error {
+	batch := &pgx.Batch{}
+	for _, m := range ms {
+		batch.Queue(getUpsertMetricSQL(), m.MType, m.ID, m.Value, m.Delta)
+	}
+
+	return tx.SendBatch(ctx, batch).Close()
+}
+
+// copyUpsert загружает пачку через COPY во временную таблицу и сливает ее с metrics одним запросом
+func copyUpsert(ctx context.Context, tx pgx.Tx, ms []metrics.Metrics) error {
+	if _, err := tx.Exec(ctx, getCreateStagingSQL()); err != nil {
+		return err
+	}
+
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"metrics_staging"}, stagingColumns,
+		pgx.CopyFromSlice(len(ms), func(i int) ([]any, error) {
+			return []any{i, ms[i].MType, ms[i].ID, ms[i].Value, ms[i].Delta}, nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, getMergeStagingSQL())
+	return err
+}
+
+// coalesce сворачивает метрики с одним ключом, как getMergeStagingSQL: дельты счетчиков
+// складываются, для gauge берется последнее значение. Результат отсортирован по типу и имени
+func coalesce(ms []metrics.Metrics) []metrics.Metrics {
+	index := make(map[storage.Key]int, len(ms))
+	result := make([]metrics.Metrics, 0, len(ms))
+	for _, m := range ms {
+		key := storage.KeyOf(m)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(result)
+			result = append(result, m)
+			continue
+		}
+
+		prev := result[i]
+		switch {
+		case m.Delta == nil:
+			m.Delta = prev.Delta
+		case prev.Delta != nil:
+			delta := *prev.Delta + *m.Delta
+			m.Delta = &delta
+		}
+		if m.Value == nil {
+			m.Value = prev.Value
+		}
+		result[i] = m
+	}
+
+	slices.SortFunc(result, func(a, b metrics.Metrics) int {
+		return cmp.Or(strings.Compare(a.MType, b.MType), strings.Compare(a.ID, b.ID))
+	})
+	return result
+}
+
+// getInsertCounterSQL добавляет пустой счетчик, если его нет, чтобы его строку можно было заблокировать
+func getInsertCounterSQL() string {
+	return `INSERT INTO metrics (type, name, delta) VALUES ('counter', $1, 0)
+	ON CONFLICT (type, name) DO NOTHING`
+}
+
+func getSelectTotalSQL() string {
+	return "SELECT total FROM metrics WHERE type = 'counter' AND name = $1 FOR UPDATE"
+}
+
+func getUpdateTotalSQL() string {
+	return `UPDATE metrics SET delta = COALESCE(delta, 0) + $2, total = $3, updated_at = now()
+	WHERE type = 'counter' AND name = $1`
+}
+
+// getCreateStagingSQL создает временную таблицу сессии, строки удаляются при завершении транзакции
+func getCreateStagingSQL() string {
+	return `CREATE TEMP TABLE IF NOT EXISTS metrics_staging(
+		seq    integer,
+		type    varchar(40),
+		name    varchar(255),
+		value    double precision,
+		delta    bigint
+	) ON COMMIT DELETE ROWS`
+}
+
+// getMergeStagingSQL сливает временную таблицу с metrics в порядке ключей. Одна строка не может
+// обновляться дважды в одном INSERT, поэтому метрики с одним ключом сначала сворачиваются:
+// дельты счетчиков складываются, для gauge берется последнее значение
+func getMergeStagingSQL() string {
+	return `INSERT INTO metrics (type, name, value, delta)
+	SELECT type, name, (array_agg(value ORDER BY seq DESC))[1], SUM(delta)::bigint
+	FROM metrics_staging
+	GROUP BY type, name
+	ORDER BY type, name
+	ON CONFLICT (type, name) DO UPDATE SET
+		value = EXCLUDED.value,
+		updated_at = now(),
+		delta = CASE WHEN EXCLUDED.delta IS NULL THEN metrics.delta ELSE COALESCE(metrics.delta, 0) + EXCLUDED.delta END`
+}
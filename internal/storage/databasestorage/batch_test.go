@@ -0,0 +1,100 @@
+package databasestorage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMergeStagingSQL(t *testing.T) {
+	sql := getMergeStagingSQL()
+	assert.Contains(t, sql, "GROUP BY type, name")
+	assert.Contains(t, sql, "ORDER BY type, name")
+	assert.Contains(t, sql, "ON CONFLICT (type, name) DO UPDATE")
+}
+
+func TestCoalesce(t *testing.T) {
+	ms := []metrics.Metrics{
+		metrics.NewMetric("b", metrics.MetricTypeGauge, "1"),
+		metrics.NewMetric("b", metrics.MetricTypeCounter, "2"),
+		metrics.NewMetric("a", metrics.MetricTypeGauge, "3"),
+		metrics.NewMetric("b", metrics.MetricTypeCounter, "5"),
+		metrics.NewMetric("b", metrics.MetricTypeGauge, "4"),
+	}
+
+	assert.Equal(t, []metrics.Metrics{
+		metrics.NewMetric("b", metrics.MetricTypeCounter, "7"),
+		metrics.NewMetric("a", metrics.MetricTypeGauge, "3"),
+		metrics.NewMetric("b", metrics.MetricTypeGauge, "4"),
+	}, coalesce(ms))
+
+	// исходные метрики не меняются
+	assert.Equal(t, "2", ms[1].GetValue())
+	assert.Empty(t, coalesce(nil))
+}
+
+// newBatch возвращает пачку из n gauge с префиксом prefix и счетчиком, который повторяется в каждой метрике пачки
+func newBatch(prefix string, n int) []metrics.Metrics {
+	ms := make([]metrics.Metrics, 0, 2*n)
+	for i := 0; i < n; i++ {
+		ms = append(ms,
+			metrics.NewMetric(fmt.Sprintf("%s%d", prefix, i), metrics.MetricTypeGauge, strconv.Itoa(i)),
+			metrics.NewMetric(prefix+"counter", metrics.MetricTypeCounter, "1"),
+		)
+	}
+	return ms
+}
+
+// cleanup удаляет метрики теста с префиксом prefix
+func cleanup(t testing.TB, s *Storage, prefix string) {
+	ctx := context.Background()
+	ms, err := s.List(ctx, storage.Filter{Prefix: prefix})
+	require.NoError(t, err)
+	for _, m := range ms {
+		s.Delete(ctx, storage.KeyOf(m))
+	}
+}
+
+func TestUpsertMetrics_copy(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	const prefix = "test_copy_"
+	cleanup(t, s, prefix)
+	t.Cleanup(func() { cleanup(t, s, prefix) })
+
+	for _, size := range []int{10, copyThreshold} {
+		require.NoError(t, s.UpsertMetrics(ctx, newBatch(prefix, size)))
+	}
+
+	counter, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: prefix + "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10+copyThreshold), *counter.Delta)
+
+	gauge, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: prefix + "5"})
+	require.NoError(t, err)
+	assert.Equal(t, "5", gauge.GetValue())
+}
+
+func BenchmarkUpsertMetrics(b *testing.B) {
+	s := newTestStorage(b)
+	ctx := context.Background()
+	const prefix = "bench_upsert_"
+	b.Cleanup(func() { cleanup(b, s, prefix) })
+
+	for _, size := range []int{100, 1000, 5000} {
+		ms := newBatch(prefix, size/2)
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := s.UpsertMetrics(ctx, ms); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
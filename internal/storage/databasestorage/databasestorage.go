@@ -2,21 +2,31 @@
 package databasestorage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
-	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
 
 	"github.com/jackc/pgerrcode"
 	pgx "github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"go.uber.org/zap"
 )
 
+// batchTTLSeconds время, в течение которого помнится примененная пачка метрик
+const batchTTLSeconds = 3600
+
 // Storage структура представляющая репозиторий
 type Storage struct {
 	DB *sql.DB
+
+	stmts statements
+}
+
+// statements подготовленные запросы, которые выполняются на каждый запрос к серверу
+type statements struct {
+	get, delete, expireBatch *sql.Stmt
 }
 
 // NewStorage создает репозиторий
@@ -38,105 +48,111 @@ func NewStorage(db *sql.DB) (*Storage, error) {
 		return nil, err
 	}
 
+	err = storage.prepareStatements(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
 	return &storage, nil
 }
 
-// GetMetrics возвращает все метрики в репозитории
-func (s *Storage) GetMetrics() []metrics.Metrics {
-	items := []metrics.Metrics{}
+// Get возвращает метрику по ключу
+func (s *Storage) Get(ctx context.Context, key storage.Key) (metrics.Metrics, error) {
+	metric := metrics.Metrics{}
+	err := s.stmts.get.QueryRowContext(ctx, key.MType, key.ID).
+		Scan(&metric.MType, &metric.ID, &metric.Value, &metric.Delta)
+	if errors.Is(err, sql.ErrNoRows) {
+		return metric, storage.ErrNotFound
+	}
 
+	return metric, err
+}
+
+// List возвращает метрики, попадающие под фильтр
+func (s *Storage) List(ctx context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
 	retry := getRetryFunc(3, 2)
 
 	var err error
 	var rows *sql.Rows
 	for retry(err) {
-		rows, err = s.DB.Query("SELECT type,name,value,delta FROM metrics")
+		rows, err = s.DB.QueryContext(ctx, getSelectMetricsSQL(), filter.MType, filter.Prefix)
 	}
 
 	if err != nil {
-		logger.Get().Info(
-			"get metrics error",
-			zap.String("error", err.Error()),
-		)
+		return nil, err
 	}
-
 	defer rows.Close()
+
+	items := []metrics.Metrics{}
 	for rows.Next() {
 		var metric metrics.Metrics
-		err := rows.Scan(&metric.MType, &metric.ID, &metric.Value, &metric.Delta)
-		if err != nil {
-			logger.Get().Info(
-				"parse metric error",
-				zap.String("error", err.Error()),
-			)
+		if err := rows.Scan(&metric.MType, &metric.ID, &metric.Value, &metric.Delta); err != nil {
+			return nil, err
 		}
 		items = append(items, metric)
 	}
 
-	if rows.Err() != nil {
-		logger.Get().Info(
-			"rows error",
-			zap.String("error", rows.Err().Error()),
-		)
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return items
+	return items, nil
 }
 
-// CreateMetrics добавляет полученные метрики в репозиторий
-func (s *Storage) CreateMetrics(ms []metrics.Metrics) error {
-	tx, err := s.DB.Begin()
+// Delete удаляет метрику по ключу
+func (s *Storage) Delete(ctx context.Context, key storage.Key) error {
+	result, err := s.stmts.delete.ExecContext(ctx, key.MType, key.ID)
 	if err != nil {
 		return err
 	}
 
-	for _, metric := range ms {
-		m := metric
-		_, err := tx.Exec(getInsertMetricSQL(), m.MType, m.ID, m.Value, m.Delta)
-		if err != nil {
-			logger.Get().Info("tx insert metric error", zap.String("error", err.Error()))
-			tx.Rollback()
-			return err
-		}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
 
+// Close закрывает подготовленные запросы и подключение к базе
+func (s *Storage) Close() error {
+	for _, stmt := range []*sql.Stmt{s.stmts.get, s.stmts.delete, s.stmts.expireBatch} {
+		if stmt != nil {
+			stmt.Close()
+		}
 	}
 
-	return tx.Commit()
+	return s.DB.Close()
 }
 
-// UpdateMetrics обновляет полученные метрики в репозитории
-func (s *Storage) UpdateMetrics(ms []metrics.Metrics) error {
-	tx, err := s.DB.Begin()
-	if err != nil {
-		return err
+// prepareDB применяет непримененные миграции схемы
+func (s *Storage) prepareDB() error {
+	return MigrateUp(context.Background(), s.DB)
+}
+
+// prepareStatements подготавливает частые запросы, чтобы база не разбирала их каждый раз
+func (s *Storage) prepareStatements(ctx context.Context) error {
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.stmts.get, getSelectMetricSQL()},
+		{&s.stmts.delete, getDeleteMetricSQL()},
+		{&s.stmts.expireBatch, getDeleteExpiredBatchesSQL()},
 	}
 
-	for _, metric := range ms {
-		m := metric
-		_, err := tx.Exec(getUpdateMetricSQL(), m.Value, m.Delta, m.MType, m.ID)
+	for _, q := range queries {
+		stmt, err := s.DB.PrepareContext(ctx, q.query)
 		if err != nil {
-			logger.Get().Info("tx update metric error", zap.String("error", err.Error()))
-			tx.Rollback()
 			return err
 		}
-
+		*q.stmt = stmt
 	}
 
-	return tx.Commit()
-}
-
-func (s *Storage) prepareDB() error {
-	sql := `CREATE TABLE IF NOT EXISTS metrics(
-    	name    varchar(255) PRIMARY KEY,
-    	type    varchar(40),
-    	value    double precision default null,
-    	delta    bigint default null
-	);`
-
-	_, err := s.DB.Exec(sql)
-	if err != nil {
-		return err
-	}
 	return nil
 }
 
@@ -169,10 +185,35 @@ func getRetryFunc(attempts, waitDelta int) func(err error) bool {
 
 }
 
-func getUpdateMetricSQL() string {
-	return "UPDATE metrics SET value = $1, delta = $2 WHERE type = $3 AND name = $4"
+func getSelectMetricSQL() string {
+	return "SELECT type, name, value, delta FROM metrics WHERE type = $1 AND name = $2"
+}
+
+// getSelectMetricsSQL выбирает метрики по фильтру, пустые параметры не ограничивают выборку
+func getSelectMetricsSQL() string {
+	return `SELECT type, name, value, delta FROM metrics
+	WHERE ($1 = '' OR type = $1) AND starts_with(name, $2)
+	ORDER BY type, name`
+}
+
+// getUpsertMetricSQL добавляет метрику или обновляет существующую: delta счетчика прибавляется
+// к сохраненной в одном запросе, поэтому параллельные обновления не теряются
+func getUpsertMetricSQL() string {
+	return `INSERT INTO metrics (type, name, value, delta) VALUES ($1,$2,$3,$4)
+	ON CONFLICT (type, name) DO UPDATE SET
+		value = EXCLUDED.value,
+		updated_at = now(),
+		delta = CASE WHEN EXCLUDED.delta IS NULL THEN metrics.delta ELSE COALESCE(metrics.delta, 0) + EXCLUDED.delta END`
+}
+
+func getDeleteMetricSQL() string {
+	return "DELETE FROM metrics WHERE type = $1 AND name = $2"
+}
+
+func getInsertBatchSQL() string {
+	return "INSERT INTO metric_batches (id) VALUES ($1) ON CONFLICT (id) DO NOTHING"
 }
 
-func getInsertMetricSQL() string {
-	return "INSERT INTO metrics (type, name, value, delta) VALUES ($1,$2,$3,$4)"
+func getDeleteExpiredBatchesSQL() string {
+	return "DELETE FROM metric_batches WHERE applied_at < now() - make_interval(secs => $1)"
 }
@@ -8,12 +8,22 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGetUpdateMetricSQL(t *testing.T) {
-	getUpdateMetricSQL()
+func TestGetSelectMetricSQL(t *testing.T) {
+	assert.Contains(t, getSelectMetricSQL(), "WHERE type = $1 AND name = $2")
 }
 
-func TestGetInsertMetricSQL(t *testing.T) {
-	getInsertMetricSQL()
+func TestGetSelectMetricsSQL(t *testing.T) {
+	assert.Contains(t, getSelectMetricsSQL(), "starts_with(name, $2)")
+}
+
+func TestGetDeleteMetricSQL(t *testing.T) {
+	assert.Contains(t, getDeleteMetricSQL(), "DELETE FROM metrics")
+}
+
+func TestGetUpsertMetricSQL(t *testing.T) {
+	sql := getUpsertMetricSQL()
+	assert.Contains(t, sql, "ON CONFLICT (type, name) DO UPDATE")
+	assert.Contains(t, sql, "metrics.delta")
 }
 
 func TestGetRetryFunc(t *testing.T) {
@@ -21,3 +31,8 @@ func TestGetRetryFunc(t *testing.T) {
 	assert.True(t, f(nil))
 	assert.False(t, f(nil))
 }
+
+func TestGetBatchSQL(t *testing.T) {
+	assert.Contains(t, getInsertBatchSQL(), "ON CONFLICT")
+	getDeleteExpiredBatchesSQL()
+}
@@ -0,0 +1,279 @@
+package databasestorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/storage"
+)
+
+// samplesPartitionLayout суффикс имени суточной секции metric_samples
+const samplesPartitionLayout = "20060102"
+
+// History хранит уровни истории метрик в секционированных таблицах metric_samples и metric_rollups.
+// Сырые отсчеты разбиты на суточные секции, поэтому срок хранения соблюдается удалением секций
+// целиком, без долгих DELETE
+type History struct {
+	db *sql.DB
+
+	// partitions созданные секции, чтобы не обращаться к каталогу базы на каждую запись
+	partitions sync.Map
+}
+
+// History возвращает хранилище истории в базе репозитория
+func (s *Storage) History() *History {
+	return &History{db: s.DB}
+}
+
+// Append сохраняет сырые отсчеты одним запросом, недостающие суточные секции создаются заранее
+func (h *History) Append(ctx context.Context, samples []history.Sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	types := make([]string, 0, len(samples))
+	names := make([]string, 0, len(samples))
+	stamps := make([]time.Time, 0, len(samples))
+	values := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		if err := h.ensureSamplesPartition(ctx, sample.T); err != nil {
+			return err
+		}
+
+		types = append(types, sample.Key.MType)
+		names = append(names, sample.Key.ID)
+		stamps = append(stamps, sample.T)
+		values = append(values, sample.V)
+	}
+
+	_, err := h.db.ExecContext(ctx, getInsertSamplesSQL(), types, names, stamps, values)
+	return err
+}
+
+// Read возвращает точки уровня resolution с T в [from, to)
+func (h *History) Read(ctx context.Context, resolution time.Duration, key storage.Key, from, to time.Time) ([]history.Point, error) {
+	var rows *sql.Rows
+	var err error
+	if resolution == history.Raw {
+		rows, err = h.db.QueryContext(ctx, getSelectSamplesSQL(), key.MType, key.ID, from, to)
+	} else {
+		var seconds int
+		if seconds, err = resolutionSeconds(resolution); err != nil {
+			return nil, err
+		}
+		rows, err = h.db.QueryContext(ctx, getSelectRollupsSQL(), seconds, key.MType, key.ID, from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []history.Point{}
+	for rows.Next() {
+		p := history.Point{}
+		if resolution == history.Raw {
+			var v float64
+			if err = rows.Scan(&p.T, &v); err != nil {
+				return nil, err
+			}
+			p = history.RawPoint(p.T, v)
+		} else if err = rows.Scan(&p.T, &p.Min, &p.Max, &p.Sum, &p.Count); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
+
+// Rollup сворачивает точки уровня src с T в [from, to) в уровень dst одним запросом на стороне базы
+func (h *History) Rollup(ctx context.Context, src, dst time.Duration, from, to time.Time) error {
+	dstSeconds, err := resolutionSeconds(dst)
+	if err != nil {
+		return err
+	}
+	if err = h.ensureRollupsPartition(ctx, dstSeconds); err != nil {
+		return err
+	}
+
+	if src == history.Raw {
+		_, err = h.db.ExecContext(ctx, getRollupSamplesSQL(), dstSeconds, from, to)
+		return err
+	}
+
+	srcSeconds, err := resolutionSeconds(src)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, getRollupRollupsSQL(), dstSeconds, from, to, srcSeconds)
+	return err
+}
+
+// DropBefore удаляет точки уровня resolution старше before. Суточные секции сырых отсчетов,
+// которые целиком старше before, удаляются, в оставшихся удаляются отдельные строки
+func (h *History) DropBefore(ctx context.Context, resolution time.Duration, before time.Time) error {
+	if resolution != history.Raw {
+		seconds, err := resolutionSeconds(resolution)
+		if err != nil {
+			return err
+		}
+		_, err = h.db.ExecContext(ctx, getDeleteRollupsSQL(), seconds, before)
+		return err
+	}
+
+	partitions, err := h.samplesPartitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range partitions {
+		day, err := time.Parse(samplesPartitionLayout, strings.TrimPrefix(name, "metric_samples_"))
+		if err != nil || day.AddDate(0, 0, 1).After(before) {
+			continue
+		}
+
+		if _, err = h.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return err
+		}
+		h.partitions.Delete(name)
+	}
+
+	_, err = h.db.ExecContext(ctx, getDeleteSamplesSQL(), before)
+	return err
+}
+
+// ensureSamplesPartition создает суточную секцию metric_samples для момента t
+func (h *History) ensureSamplesPartition(ctx context.Context, t time.Time) error {
+	day := t.UTC().Truncate(24 * time.Hour)
+	name := "metric_samples_" + day.Format(samplesPartitionLayout)
+	if _, ok := h.partitions.Load(name); ok {
+		return nil
+	}
+
+	_, err := h.db.ExecContext(ctx, getCreateSamplesPartitionSQL(name, day))
+	if err != nil {
+		return err
+	}
+
+	h.partitions.Store(name, struct{}{})
+	return nil
+}
+
+// ensureRollupsPartition создает секцию metric_rollups для шага seconds
+func (h *History) ensureRollupsPartition(ctx context.Context, seconds int) error {
+	name := fmt.Sprintf("metric_rollups_%d", seconds)
+	if _, ok := h.partitions.Load(name); ok {
+		return nil
+	}
+
+	_, err := h.db.ExecContext(ctx, getCreateRollupsPartitionSQL(name, seconds))
+	if err != nil {
+		return err
+	}
+
+	h.partitions.Store(name, struct{}{})
+	return nil
+}
+
+// samplesPartitions возвращает имена секций metric_samples
+func (h *History) samplesPartitions(ctx context.Context) ([]string, error) {
+	rows, err := h.db.QueryContext(ctx, getSelectSamplesPartitionsSQL())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// resolutionSeconds возвращает шаг свертки в секундах, в которых он хранится в metric_rollups
+func resolutionSeconds(resolution time.Duration) (int, error) {
+	if resolution < time.Second || resolution%time.Second != 0 {
+		return 0, fmt.Errorf("resolution %s is not a whole number of seconds", resolution)
+	}
+	return int(resolution / time.Second), nil
+}
+
+// getCreateSamplesPartitionSQL создает секцию name для суток day. Имя и границы формируются
+// из даты, поэтому подставляются в текст запроса: DDL не принимает параметров
+func getCreateSamplesPartitionSQL(name string, day time.Time) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF metric_samples
+	FOR VALUES FROM ('%s') TO ('%s')`,
+		name, day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339))
+}
+
+func getCreateRollupsPartitionSQL(name string, seconds int) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF metric_rollups FOR VALUES IN (%d)", name, seconds)
+}
+
+func getSelectSamplesPartitionsSQL() string {
+	return `SELECT c.relname FROM pg_inherits i
+	JOIN pg_class c ON c.oid = i.inhrelid
+	JOIN pg_class p ON p.oid = i.inhparent
+	WHERE p.relname = 'metric_samples'`
+}
+
+func getInsertSamplesSQL() string {
+	return `INSERT INTO metric_samples (type, name, ts, value)
+	SELECT * FROM unnest($1::varchar[], $2::varchar[], $3::timestamptz[], $4::double precision[])`
+}
+
+func getSelectSamplesSQL() string {
+	return `SELECT ts, value FROM metric_samples
+	WHERE type = $1 AND name = $2 AND ts >= $3 AND ts < $4
+	ORDER BY ts`
+}
+
+func getSelectRollupsSQL() string {
+	return `SELECT bucket, min, max, sum, count FROM metric_rollups
+	WHERE resolution = $1 AND type = $2 AND name = $3 AND bucket >= $4 AND bucket < $5
+	ORDER BY bucket`
+}
+
+// getRollupSamplesSQL сворачивает сырые отсчеты [$2, $3) в интервалы по $1 секунд от начала эпохи,
+// повторная свертка интервала заменяет прежнюю
+func getRollupSamplesSQL() string {
+	return `INSERT INTO metric_rollups (resolution, type, name, bucket, min, max, sum, count)
+	SELECT $1::integer, type, name,
+		to_timestamp((floor(extract(epoch FROM ts) / $1::integer) * $1::integer)::double precision),
+		min(value), max(value), sum(value), count(*)
+	FROM metric_samples
+	WHERE ts >= $2 AND ts < $3
+	GROUP BY 2, 3, 4
+	ON CONFLICT (resolution, type, name, bucket) DO UPDATE SET
+		min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, count = EXCLUDED.count`
+}
+
+// getRollupRollupsSQL сворачивает свертки с шагом $4 секунд в интервалы по $1 секунд
+func getRollupRollupsSQL() string {
+	return `INSERT INTO metric_rollups (resolution, type, name, bucket, min, max, sum, count)
+	SELECT $1::integer, type, name,
+		to_timestamp((floor(extract(epoch FROM bucket) / $1::integer) * $1::integer)::double precision),
+		min(min), max(max), sum(sum), sum(count)::bigint
+	FROM metric_rollups
+	WHERE resolution = $4 AND bucket >= $2 AND bucket < $3
+	GROUP BY 2, 3, 4
+	ON CONFLICT (resolution, type, name, bucket) DO UPDATE SET
+		min = EXCLUDED.min, max = EXCLUDED.max, sum = EXCLUDED.sum, count = EXCLUDED.count`
+}
+
+func getDeleteSamplesSQL() string {
+	return "DELETE FROM metric_samples WHERE ts < $1"
+}
+
+func getDeleteRollupsSQL() string {
+	return "DELETE FROM metric_rollups WHERE resolution = $1 AND bucket < $2"
+}
@@ -0,0 +1,84 @@
+package databasestorage
+
+import (
+	"context"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/history"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHistorySQL(t *testing.T) {
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS metric_samples_20240110 PARTITION OF metric_samples
+	FOR VALUES FROM ('2024-01-10T00:00:00Z') TO ('2024-01-11T00:00:00Z')`, getCreateSamplesPartitionSQL("metric_samples_20240110", day))
+	assert.Contains(t, getCreateRollupsPartitionSQL("metric_rollups_60", 60), "FOR VALUES IN (60)")
+	assert.Contains(t, getInsertSamplesSQL(), "unnest(")
+	assert.Contains(t, getRollupSamplesSQL(), "ON CONFLICT (resolution, type, name, bucket) DO UPDATE")
+	assert.Contains(t, getRollupRollupsSQL(), "sum(count)")
+}
+
+func TestResolutionSeconds(t *testing.T) {
+	seconds, err := resolutionSeconds(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3600, seconds)
+
+	_, err = resolutionSeconds(1500 * time.Millisecond)
+	assert.Error(t, err)
+	_, err = resolutionSeconds(history.Raw)
+	assert.Error(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	s := newTestStorage(t)
+	h := s.History()
+	ctx := context.Background()
+
+	key := storage.Key{MType: metrics.MetricTypeGauge, ID: "test_history_gauge"}
+	// начало за два дня до полуночи, отсчеты попадают в разные суточные секции
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -2).Add(-30 * time.Minute)
+	t.Cleanup(func() {
+		s.DB.Exec("DELETE FROM metric_samples WHERE name = $1", key.ID)
+		s.DB.Exec("DELETE FROM metric_rollups WHERE name = $1", key.ID)
+	})
+
+	samples := []history.Sample{}
+	for i := 0; i < 360; i++ {
+		samples = append(samples, history.Sample{Key: key, T: start.Add(time.Duration(i) * 10 * time.Second), V: float64(i)})
+	}
+	require.NoError(t, h.Append(ctx, samples))
+
+	points, err := h.Read(ctx, history.Raw, key, start, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, points, 6)
+	assert.True(t, points[5].T.Equal(start.Add(50*time.Second)))
+
+	require.NoError(t, h.Rollup(ctx, history.Raw, time.Minute, start, start.Add(time.Hour)))
+	require.NoError(t, h.Rollup(ctx, time.Minute, time.Hour, start, start.Add(time.Hour)))
+
+	points, err = h.Read(ctx, time.Minute, key, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, points, 60)
+	assert.Equal(t, int64(6), points[1].Count)
+	assert.Equal(t, 51.0, points[1].Sum)
+
+	points, err = h.Read(ctx, time.Hour, key, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, int64(360), points[0].Count)
+
+	// сутки до полуночи удаляются целой секцией, после — построчно
+	require.NoError(t, h.DropBefore(ctx, history.Raw, start.Add(45*time.Minute)))
+	points, err = h.Read(ctx, history.Raw, key, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, points, 90)
+
+	require.NoError(t, h.DropBefore(ctx, time.Minute, start.Add(time.Hour)))
+	points, err = h.Read(ctx, time.Minute, key, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, points)
+}
@@ -0,0 +1,249 @@
+package databasestorage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"ya-prac-project1/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// migrationsLockID ключ advisory lock, под которым миграции применяются только одним сервером
+const migrationsLockID = 7305158
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// Migration версия схемы базы: скрипты перехода на нее и отката с нее
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations возвращает встроенные миграции по возрастанию версий.
+// Файлы называются <версия>_<имя>.up.sql и <версия>_<имя>.down.sql
+func Migrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(f.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want <version>_<name>.up.sql or .down.sql", f.Name())
+		}
+
+		rawVersion, title, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: wrong version %q", f.Name(), rawVersion)
+		}
+
+		data, err := migrationsFS.ReadFile("migrations/" + f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	ms := make([]Migration, 0, len(byVersion))
+	for version := 1; version <= len(byVersion); version++ {
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d is missing", version)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d: both up and down scripts are required", version)
+		}
+		ms = append(ms, *m)
+	}
+
+	return ms, nil
+}
+
+// LatestVersion возвращает последнюю версию схемы из встроенных миграций
+func LatestVersion() (int, error) {
+	ms, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ms), nil
+}
+
+// migrationStep миграция и направление, в котором ее нужно применить
+type migrationStep struct {
+	Migration
+	up bool
+}
+
+// planMigrations возвращает шаги перехода с версии current на версию target
+func planMigrations(ms []Migration, current, target int) ([]migrationStep, error) {
+	if target < 0 || target > len(ms) {
+		return nil, fmt.Errorf("unknown schema version %d, latest is %d", target, len(ms))
+	}
+	if current > len(ms) {
+		return nil, fmt.Errorf("database schema version %d is newer than latest known %d", current, len(ms))
+	}
+
+	steps := []migrationStep{}
+	for v := current + 1; v <= target; v++ {
+		steps = append(steps, migrationStep{Migration: ms[v-1], up: true})
+	}
+	for v := current; v > target; v-- {
+		steps = append(steps, migrationStep{Migration: ms[v-1], up: false})
+	}
+
+	return steps, nil
+}
+
+// Migrate приводит схему базы к версии target, применяя скрипты up или down.
+// Каждая миграция выполняется в своей транзакции. Параллельно запущенные серверы
+// ждут друг друга на advisory lock, поэтому миграция применяется один раз
+func Migrate(ctx context.Context, db *sql.DB, target int) error {
+	ms, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// блокировка сессионная, поэтому все запросы идут через одно соединение
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationsLockID)
+
+	if _, err = conn.ExecContext(ctx, getCreateMigrationsTableSQL()); err != nil {
+		return err
+	}
+
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	steps, err := planMigrations(ms, current, target)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if err = applyMigration(ctx, conn, step); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateUp применяет все непримененные миграции
+func MigrateUp(ctx context.Context, db *sql.DB) error {
+	latest, err := LatestVersion()
+	if err != nil {
+		return err
+	}
+
+	return Migrate(ctx, db, latest)
+}
+
+// SchemaVersion возвращает текущую версию схемы базы, 0 если миграции не применялись
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return schemaVersion(ctx, db)
+}
+
+// rowQuerier общий для *sql.DB и *sql.Conn метод выборки одной строки
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, q rowQuerier) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, getSchemaVersionSQL()).Scan(&version)
+	return version, err
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, step migrationStep) error {
+	direction, script := "up", step.Up
+	if !step.up {
+		direction, script = "down", step.Down
+	}
+
+	logger.Get().Info("apply migration",
+		zap.Int("version", step.Version),
+		zap.String("name", step.Name),
+		zap.String("direction", direction),
+	)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d %s: %w", step.Version, direction, err)
+	}
+
+	if step.up {
+		_, err = tx.ExecContext(ctx, getInsertMigrationSQL(), step.Version, step.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, getDeleteMigrationSQL(), step.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getCreateMigrationsTableSQL() string {
+	return `CREATE TABLE IF NOT EXISTS schema_migrations(
+		version    integer PRIMARY KEY,
+		name    varchar(255) NOT NULL,
+		applied_at    timestamptz NOT NULL default now()
+	)`
+}
+
+func getSchemaVersionSQL() string {
+	return "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+}
+
+func getInsertMigrationSQL() string {
+	return "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)"
+}
+
+func getDeleteMigrationSQL() string {
+	return "DELETE FROM schema_migrations WHERE version = $1"
+}
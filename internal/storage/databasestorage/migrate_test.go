@@ -0,0 +1,92 @@
+package databasestorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	ms, err := Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, ms)
+
+	for i, m := range ms {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+
+	latest, err := LatestVersion()
+	require.NoError(t, err)
+	assert.Equal(t, len(ms), latest)
+	assert.Contains(t, ms[1].Up, "PRIMARY KEY (type, name)")
+}
+
+func TestPlanMigrations(t *testing.T) {
+	ms := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+
+	versions := func(steps []migrationStep) []int {
+		vs := []int{}
+		for _, s := range steps {
+			v := s.Version
+			if !s.up {
+				v = -v
+			}
+			vs = append(vs, v)
+		}
+		return vs
+	}
+
+	tests := []struct {
+		name            string
+		current, target int
+		expect          []int
+		wantErr         bool
+	}{
+		{name: "up from empty", current: 0, target: 3, expect: []int{1, 2, 3}},
+		{name: "up partial", current: 1, target: 2, expect: []int{2}},
+		{name: "nothing", current: 2, target: 2, expect: []int{}},
+		{name: "down", current: 3, target: 1, expect: []int{-3, -2}},
+		{name: "unknown target", current: 0, target: 4, wantErr: true},
+		{name: "newer database", current: 5, target: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := planMigrations(ms, tt.current, tt.target)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, versions(steps))
+		})
+	}
+}
+
+// TestMigrate откатывает схему и применяет ее заново, тест меняет базу из TEST_DATABASE_DSN
+func TestMigrate(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	latest, err := LatestVersion()
+	require.NoError(t, err)
+
+	version, err := SchemaVersion(ctx, s.DB)
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+
+	require.NoError(t, Migrate(ctx, s.DB, 1))
+	version, err = SchemaVersion(ctx, s.DB)
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	require.NoError(t, MigrateUp(ctx, s.DB))
+	version, err = SchemaVersion(ctx, s.DB)
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+}
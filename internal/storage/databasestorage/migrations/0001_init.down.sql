@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS metric_batches;
+DROP TABLE IF EXISTS metrics;
@@ -0,0 +1,13 @@
+-- исходная схема, IF NOT EXISTS позволяет принять под управление базы,
+-- созданные до появления миграций
+CREATE TABLE IF NOT EXISTS metrics(
+	name    varchar(255) PRIMARY KEY,
+	type    varchar(40),
+	value    double precision default null,
+	delta    bigint default null
+);
+CREATE UNIQUE INDEX IF NOT EXISTS metrics_type_name_idx ON metrics (type, name);
+CREATE TABLE IF NOT EXISTS metric_batches(
+	id    varchar(64) PRIMARY KEY,
+	applied_at    timestamptz NOT NULL default now()
+);
@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS metric_batches_applied_at_idx;
+DROP INDEX IF EXISTS metrics_updated_at_idx;
+DROP INDEX IF EXISTS metrics_name_idx;
+
+ALTER TABLE metrics
+	DROP COLUMN IF EXISTS updated_at,
+	DROP COLUMN IF EXISTS created_at;
+
+-- в старой схеме имя уникально, из метрик с одинаковым именем остается одна
+DELETE FROM metrics a USING metrics b WHERE a.name = b.name AND a.type > b.type;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ALTER COLUMN type DROP NOT NULL;
+ALTER TABLE metrics ADD CONSTRAINT metrics_pkey PRIMARY KEY (name);
+CREATE UNIQUE INDEX IF NOT EXISTS metrics_type_name_idx ON metrics (type, name);
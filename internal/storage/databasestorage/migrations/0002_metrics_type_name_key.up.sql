@@ -0,0 +1,15 @@
+-- метрика идентифицируется типом и именем, gauge и counter с одним именем не должны конфликтовать
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+DROP INDEX IF EXISTS metrics_type_name_idx;
+ALTER TABLE metrics ALTER COLUMN type SET NOT NULL;
+ALTER TABLE metrics ADD CONSTRAINT metrics_pkey PRIMARY KEY (type, name);
+
+ALTER TABLE metrics
+	ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL default now(),
+	ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL default now();
+
+-- выборка по префиксу имени без учета типа
+CREATE INDEX IF NOT EXISTS metrics_name_idx ON metrics (name text_pattern_ops);
+CREATE INDEX IF NOT EXISTS metrics_updated_at_idx ON metrics (updated_at);
+-- удаление устаревших пачек
+CREATE INDEX IF NOT EXISTS metric_batches_applied_at_idx ON metric_batches (applied_at);
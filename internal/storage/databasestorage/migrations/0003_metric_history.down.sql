@@ -0,0 +1,3 @@
+-- секции удаляются вместе с родительскими таблицами
+DROP TABLE IF EXISTS metric_rollups;
+DROP TABLE IF EXISTS metric_samples;
@@ -0,0 +1,22 @@
+-- сырые отсчеты истории, секции по суткам создаются при записи
+-- и удаляются целиком по сроку хранения
+CREATE TABLE IF NOT EXISTS metric_samples(
+	type    varchar(40) NOT NULL,
+	name    varchar(255) NOT NULL,
+	ts    timestamptz NOT NULL,
+	value    double precision NOT NULL
+) PARTITION BY RANGE (ts);
+CREATE INDEX IF NOT EXISTS metric_samples_type_name_ts_idx ON metric_samples (type, name, ts);
+
+-- свертки истории, секция на каждый шаг (в секундах) создается при первой свертке
+CREATE TABLE IF NOT EXISTS metric_rollups(
+	resolution    integer NOT NULL,
+	type    varchar(40) NOT NULL,
+	name    varchar(255) NOT NULL,
+	bucket    timestamptz NOT NULL,
+	min    double precision NOT NULL,
+	max    double precision NOT NULL,
+	sum    double precision NOT NULL,
+	count    bigint NOT NULL,
+	PRIMARY KEY (resolution, type, name, bucket)
+) PARTITION BY LIST (resolution);
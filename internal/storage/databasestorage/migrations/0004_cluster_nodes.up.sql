@@ -0,0 +1,10 @@
+-- экземпляры сервера, работающие с одной базой: каждый периодически обновляет last_seen,
+-- лидер, удерживающий advisory lock, отмечает себя в leader
+CREATE TABLE IF NOT EXISTS cluster_nodes(
+	id    varchar(255) PRIMARY KEY,
+	address    varchar(255) NOT NULL,
+	started_at    timestamptz NOT NULL,
+	last_seen    timestamptz NOT NULL default now(),
+	leader    boolean NOT NULL default false
+);
+CREATE INDEX IF NOT EXISTS cluster_nodes_last_seen_idx ON cluster_nodes (last_seen);
@@ -0,0 +1,2 @@
+-- последний накопленный итог источника счетчика, по нему итог переводится в приращение
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS total bigint default null;
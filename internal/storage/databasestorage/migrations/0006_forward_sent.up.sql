@@ -0,0 +1,8 @@
+-- последние значения счетчиков, отправленные пересылкой снимков на вышестоящий сервер target:
+-- общие для экземпляров кластера, чтобы новый лидер продолжил с того же места
+CREATE TABLE IF NOT EXISTS forward_sent(
+	target    text NOT NULL,
+	key    text NOT NULL,
+	delta    bigint NOT NULL,
+	PRIMARY KEY (target, key)
+);
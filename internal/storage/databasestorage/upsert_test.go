@@ -0,0 +1,183 @@
+package databasestorage
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStorage подключается к базе из TEST_DATABASE_DSN, без нее тест пропускается
+func newTestStorage(t testing.TB) *Storage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	logger.Set()
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	s, err := NewStorage(db)
+	require.NoError(t, err)
+	return s
+}
+
+// TestUpsertMetrics_concurrent проверяет, что параллельные обновления счетчика не теряются
+func TestUpsertMetrics_concurrent(t *testing.T) {
+	const workers, updates = 8, 50
+	s := newTestStorage(t)
+
+	counter := metrics.NewMetric("test_upsert_counter", metrics.MetricTypeCounter, "1")
+	ctx := context.Background()
+	s.Delete(ctx, storage.KeyOf(counter))
+	t.Cleanup(func() { s.Delete(ctx, storage.KeyOf(counter)) })
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				assert.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	m, err := s.Get(ctx, storage.KeyOf(counter))
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*updates), *m.Delta)
+}
+
+// TestUpsertMetrics_crossOrder проверяет, что пачки с общими метриками в разном порядке
+// не взаимоблокируются
+func TestUpsertMetrics_crossOrder(t *testing.T) {
+	const workers, updates = 8, 50
+	s := newTestStorage(t)
+
+	a := metrics.NewMetric("test_order_a", metrics.MetricTypeCounter, "1")
+	b := metrics.NewMetric("test_order_b", metrics.MetricTypeCounter, "1")
+	ctx := context.Background()
+	for _, m := range []metrics.Metrics{a, b} {
+		s.Delete(ctx, storage.KeyOf(m))
+		t.Cleanup(func() { s.Delete(ctx, storage.KeyOf(m)) })
+	}
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		batch := []metrics.Metrics{a, b, a}
+		if w%2 == 1 {
+			batch = []metrics.Metrics{b, a, b}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				assert.NoError(t, s.UpsertMetrics(ctx, batch))
+			}
+		}()
+	}
+	wg.Wait()
+
+	m, err := s.Get(ctx, storage.KeyOf(a))
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers/2*updates*3), *m.Delta)
+}
+
+func TestGetListDelete(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	gauge := metrics.NewMetric("test_list_gauge", metrics.MetricTypeGauge, "1.5")
+	s.Delete(ctx, storage.KeyOf(gauge))
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{gauge}))
+
+	m, err := s.Get(ctx, storage.KeyOf(gauge))
+	require.NoError(t, err)
+	assert.Equal(t, gauge, m)
+
+	ms, err := s.List(ctx, storage.Filter{MType: metrics.MetricTypeGauge, Prefix: "test_list_"})
+	require.NoError(t, err)
+	assert.Equal(t, []metrics.Metrics{gauge}, ms)
+
+	require.NoError(t, s.Delete(ctx, storage.KeyOf(gauge)))
+	assert.ErrorIs(t, s.Delete(ctx, storage.KeyOf(gauge)), storage.ErrNotFound)
+
+	_, err = s.Get(ctx, storage.KeyOf(gauge))
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// TestUpsertBatch_concurrent проверяет, что одновременные повторы пачки применяют ее один раз
+func TestUpsertBatch_concurrent(t *testing.T) {
+	const workers = 8
+	s := newTestStorage(t)
+
+	counter := metrics.NewMetric("test_batch_counter", metrics.MetricTypeCounter, "1")
+	id := "test_batch_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	ctx := context.Background()
+	s.Delete(ctx, storage.KeyOf(counter))
+	t.Cleanup(func() { s.Delete(ctx, storage.KeyOf(counter)) })
+
+	var applied atomic.Int32
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.UpsertBatch(ctx, id, []metrics.Metrics{counter})
+			assert.NoError(t, err)
+			if ok {
+				applied.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), applied.Load())
+	m, err := s.Get(ctx, storage.KeyOf(counter))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *m.Delta)
+}
+
+// TestUpsertTotals_concurrent проверяет, что один итог, пришедший одновременно нескольким
+// экземплярам, прибавляется к счетчику один раз
+func TestUpsertTotals_concurrent(t *testing.T) {
+	const workers = 8
+	s := newTestStorage(t)
+
+	total := metrics.NewMetric("test_total_counter", metrics.MetricTypeCounter, "10")
+	ctx := context.Background()
+	s.Delete(ctx, storage.KeyOf(total))
+	t.Cleanup(func() { s.Delete(ctx, storage.KeyOf(total)) })
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.UpsertTotals(ctx, nil, []metrics.Metrics{total})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	m, err := s.Get(ctx, storage.KeyOf(total))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *m.Delta)
+
+	increments, err := s.UpsertTotals(ctx, nil, []metrics.Metrics{metrics.NewMetric("test_total_counter", metrics.MetricTypeCounter, "25")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), *increments[0].Delta)
+}
@@ -1,108 +1,368 @@
-// Package filestorage предоставляет хранилище в файле
+// Package filestorage предоставляет хранилище в файле.
+//
+// Метрики хранятся в памяти, а на диске — снимок и журнал изменений. Каждое изменение
+// дописывается в журнал до применения в памяти. Периодически и при закрытии снимок
+// записывается во временный файл и атомарно переименовывается, после чего журналы,
+// вошедшие в снимок, удаляются. Журнал также сжимается в снимок, когда вырастает
+// больше compactLogSize. Каждая запись снимка и журнала снабжена контрольной суммой,
+// поврежденные записи при восстановлении пропускаются и попадают в RestoreReport.
+//
+// При нулевом интервале записи снимка репозиторий работает синхронно: изменение возвращается
+// только после того, как журнал сброшен на диск. Изменения, пришедшие, пока идет сброс,
+// сбрасываются следующим одним вызовом fsync, поэтому под нагрузкой сброс выполняется
+// не на каждое изменение, а на группу
 package filestorage
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 	"ya-prac-project1/internal/logger"
 	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
 	"ya-prac-project1/internal/storage/inmemstorage"
+
+	"go.uber.org/zap"
 )
 
+// ErrReadOnly возвращается при изменении репозитория, открытого только для чтения
+var ErrReadOnly = errors.New("file storage is opened read-only")
+
+// compactLogSize размер журнала, после которого он сжимается в снимок вне расписания
+const compactLogSize = 4 << 20
+
 // Storage структура представляющая репозиторий
 type Storage struct {
 	*inmemstorage.Storage
 	FilePath string
+	// readOnly репозиторий открыт Open: файлы не меняются
+	readOnly bool
+	// RestoreReport итог восстановления метрик при создании репозитория
+	RestoreReport RestoreReport
+
+	dumpInterval    atomic.Int64
+	intervalChanged chan struct{}
+	compact         chan struct{}
+
+	// mu связывает запись в журнал с изменением метрик в памяти и переключением журнала,
+	// поэтому снимок содержит ровно изменения из журналов своего поколения
+	mu         sync.Mutex
+	log        *os.File
+	logSize    int64
+	generation uint64
+	closed     bool
+	// appended порядковый номер последней записи журнала
+	appended uint64
+
+	// syncMu захватывается на время сброса журнала на диск, пока его держит один писатель,
+	// остальные копят записи для следующего сброса. Захватывается до mu
+	syncMu sync.Mutex
+	// synced номер последней записи журнала, сброшенной на диск
+	synced uint64
+	// snapshotMu не дает снимкам по интервалу, по размеру журнала и при закрытии писать файл одновременно
+	snapshotMu sync.Mutex
 }
 
-// NewStorage создает репозиторий
-func NewStorage(ctx context.Context, path string, restore bool, dumpInterval int64) (*Storage, error) {
+// NewStorage создает репозиторий. Если restore установлен, метрики восстанавливаются из снимка
+// и журналов, иначе сохраненные метрики заменяются пустым снимком
+func NewStorage(ctx context.Context, path string, restore bool, dumpInterval time.Duration) (*Storage, error) {
 	store := Storage{
-		Storage:  inmemstorage.NewStorage(),
-		FilePath: path,
+		Storage:         inmemstorage.NewStorage(),
+		FilePath:        path,
+		intervalChanged: make(chan struct{}, 1),
+		compact:         make(chan struct{}, 1),
 	}
 
 	if restore {
 		if err := store.restore(); err != nil {
 			return nil, err
 		}
+	} else if err := store.skipGenerations(); err != nil {
+		return nil, err
+	}
+
+	// снимок при запуске переводит файлы старого формата в новый и сжимает прочитанные журналы
+	if err := store.snapshot(); err != nil {
+		return nil, err
 	}
 
 	store.runIntervalDumper(ctx, dumpInterval)
-	store.runDumper(ctx)
 
 	return &store, nil
 }
 
-func (s *Storage) restore() error {
-	file, err := os.OpenFile(s.FilePath, os.O_RDONLY|os.O_CREATE, 0666)
+// Open открывает репозиторий только для чтения: метрики восстанавливаются из снимка и журналов,
+// но снимок не переписывается, а журналы не сжимаются и не удаляются. Изменения возвращают
+// ErrReadOnly, Close ничего не записывает. Подходит для чтения файлов остановленного сервера
+func Open(path string) (*Storage, error) {
+	store := Storage{
+		Storage:  inmemstorage.NewStorage(),
+		FilePath: path,
+		readOnly: true,
+	}
+
+	if err := store.restore(); err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+// skipGenerations продолжает нумерацию журналов после существующих, чтобы они не применились
+// при следующем восстановлении
+func (s *Storage) skipGenerations() error {
+	generations, err := s.logGenerations()
+	if err != nil || len(generations) == 0 {
+		return err
+	}
+
+	s.generation = generations[len(generations)-1]
+	return nil
+}
+
+// UpsertMetrics записывает метрики в журнал и применяет их: счетчики прибавляются к сохраненным,
+// gauge заменяются
+func (s *Storage) UpsertMetrics(ctx context.Context, ms []metrics.Metrics) error {
+	s.mu.Lock()
+	if err := s.appendLog(logEntry{Op: opUpsert, Metrics: ms}); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	err := s.Storage.UpsertMetrics(ctx, ms)
+	seq := s.appended
+	s.mu.Unlock()
+
 	if err != nil {
 		return err
 	}
 
-	buf := bufio.NewScanner(file)
-	items := []metrics.Metrics{}
+	return s.commit(seq)
+}
 
-	for {
-		if !buf.Scan() {
-			break
-		}
-		data := buf.Bytes()
-		item := metrics.Metrics{}
-		err := json.Unmarshal(data, &item)
-		if err != nil {
-			logger.Get().Info(err.Error())
-			continue
-		}
-		items = append(items, item)
+// UpsertBatch записывает в журнал и применяет пачку метрик id, если она еще не применялась.
+// Возвращает false, если пачка уже была применена. Примененные пачки помнятся только в памяти
+func (s *Storage) UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	return s.Storage.ApplyBatch(ctx, id, func() error {
+		return s.UpsertMetrics(ctx, ms)
+	})
+}
+
+// UpsertTotals записывает в журнал и применяет метрики и накопленные итоги счетчиков,
+// как inmemstorage.Storage.UpsertTotals. Итоги сохраняются в снимке вместе с метриками
+func (s *Storage) UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	s.mu.Lock()
+	if err := s.appendLog(logEntry{Op: opTotals, Metrics: ms, Totals: totals}); err != nil {
+		s.mu.Unlock()
+		return nil, err
 	}
+	increments, err := s.Storage.UpsertTotals(ctx, ms, totals)
+	seq := s.appended
+	s.mu.Unlock()
 
-	s.Storage.SetMetrics(items)
-	return nil
+	if err != nil {
+		return nil, err
+	}
+
+	return increments, s.commit(seq)
 }
 
-func (s *Storage) dump() error {
-	file, err := os.OpenFile(s.FilePath, os.O_RDWR|os.O_CREATE, 0666)
+// Delete записывает удаление в журнал и удаляет метрику по ключу
+func (s *Storage) Delete(ctx context.Context, key storage.Key) error {
+	s.mu.Lock()
+	if _, err := s.Storage.Get(ctx, key); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if err := s.appendLog(logEntry{Op: opDelete, MType: key.MType, ID: key.ID}); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	err := s.Storage.Delete(ctx, key)
+	seq := s.appended
+	s.mu.Unlock()
+
 	if err != nil {
 		return err
 	}
 
+	return s.commit(seq)
+}
+
+// commit в синхронном режиме ждет, пока запись журнала seq будет сброшена на диск
+func (s *Storage) commit(seq uint64) error {
+	if s.dumpInterval.Load() > 0 {
+		return nil
+	}
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	// запись уже сброшена вместе с записями других писателей
+	if s.synced >= seq {
+		return nil
+	}
+
+	s.mu.Lock()
+	file, last := s.log, s.appended
+	s.mu.Unlock()
+	if file == nil {
+		return os.ErrClosed
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	s.synced = last
+	return nil
+}
+
+// snapshot записывает метрики в снимок и удаляет вошедшие в него журналы
+func (s *Storage) snapshot() error {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	// журнал нельзя закрывать, пока его сбрасывает писатель
+	s.syncMu.Lock()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		s.syncMu.Unlock()
+		return os.ErrClosed
+	}
 	items := s.Storage.GetMetrics()
+	header := snapshotHeader{Generation: s.generation, Totals: s.Storage.Totals()}
+	err := s.rotateLog()
+	s.mu.Unlock()
+	s.syncMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = s.writeSnapshot(header, items); err != nil {
+		return err
+	}
+
+	return s.removeLogs(header.Generation)
+}
+
+// writeSnapshot пишет снимок во временный файл и атомарно заменяет им прежний
+func (s *Storage) writeSnapshot(header snapshotHeader, items []metrics.Metrics) error {
+	dir := filepath.Dir(s.FilePath)
+	file, err := os.CreateTemp(dir, filepath.Base(s.FilePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+
+	err = writeRecords(file, header, items)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, s.FilePath); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func writeRecords(file *os.File, header snapshotHeader, items []metrics.Metrics) error {
+	line, err := encodeRecord(header)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(line); err != nil {
+		return err
+	}
+
 	for _, item := range items {
-		row, err := json.Marshal(item)
+		line, err := encodeRecord(item)
 		if err != nil {
 			return err
 		}
-
-		file.Write(row)
-		file.WriteString("\n")
+		if _, err = file.Write(line); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (s *Storage) runDumper(ctx context.Context) {
-	go func(ctx context.Context) {
-		<-ctx.Done()
-		s.dump()
-	}(ctx)
+// syncDir сбрасывает на диск директорию, чтобы переименование файла пережило сбой питания
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Close записывает снимок и закрывает журнал. После закрытия изменения возвращают ошибку
+func (s *Storage) Close() error {
+	if s.readOnly {
+		return nil
+	}
+
+	err := s.snapshot()
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.log != nil {
+		err = errors.Join(err, s.log.Close())
+		s.log = nil
+	}
+
+	return err
 }
 
-func (s *Storage) runIntervalDumper(ctx context.Context, interval int64) {
-	if interval == 0 {
-		return
+// SetDumpInterval меняет интервал записи снимка, 0 отключает запись по интервалу
+// и включает синхронный режим
+func (s *Storage) SetDumpInterval(interval time.Duration) {
+	s.dumpInterval.Store(int64(interval))
+	select {
+	case s.intervalChanged <- struct{}{}:
+	default:
 	}
+}
+
+func (s *Storage) runIntervalDumper(ctx context.Context, interval time.Duration) {
+	s.dumpInterval.Store(int64(interval))
 
 	go func(ctx context.Context) {
 		for {
+			var tick <-chan time.Time
+			if interval := s.dumpInterval.Load(); interval > 0 {
+				tick = time.After(time.Duration(interval))
+			}
+
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(time.Duration(interval) * time.Second):
-				s.dump()
+			case <-s.intervalChanged:
+				continue
+			case <-tick:
+			case <-s.compact:
+			}
+
+			if err := s.snapshot(); err != nil {
+				logger.Get().Info("snapshot error", zap.String("error", err.Error()))
 			}
 		}
 	}(ctx)
@@ -2,18 +2,42 @@ package filestorage
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// copyTestFile копирует файл с метриками во временную директорию, чтобы тесты не меняли его
+func copyTestFile(t *testing.T) string {
+	data, err := os.ReadFile("test")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "test")
+	require.NoError(t, os.WriteFile(path, data, 0666))
+	return path
+}
+
+func TestMain(m *testing.M) {
+	logger.Set()
+	os.Exit(m.Run())
+}
+
 func TestNewStorage(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	s, _ := NewStorage(ctx, "test", false, 1)
+	path := copyTestFile(t)
+	s, _ := NewStorage(ctx, path, false, time.Second)
 
-	assert.Equal(t, "test", s.FilePath)
+	assert.Equal(t, path, s.FilePath)
 	// чтобы воркеры внутри успели запуститься
 	time.Sleep(time.Millisecond * 100)
 }
@@ -21,9 +45,303 @@ func TestNewStorage(t *testing.T) {
 func TestNewStorage2(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	s, _ := NewStorage(ctx, "test", true, 1)
+	path := copyTestFile(t)
+	s, _ := NewStorage(ctx, path, true, time.Second)
 
-	assert.Equal(t, "test", s.FilePath)
+	assert.Equal(t, path, s.FilePath)
+	assert.Len(t, s.GetMetrics(), 1)
 	// чтобы воркеры внутри успели запуститься
 	time.Sleep(time.Millisecond * 100)
 }
+
+func TestSetDumpInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, _ := NewStorage(ctx, copyTestFile(t), false, 0)
+
+	s.SetDumpInterval(5 * time.Second)
+	assert.Equal(t, int64(5*time.Second), s.dumpInterval.Load())
+	s.SetDumpInterval(0)
+	assert.Equal(t, int64(0), s.dumpInterval.Load())
+}
+
+func TestDump_deleted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := copyTestFile(t)
+	s, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+
+	m := metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{m}))
+	require.NoError(t, s.snapshot())
+	require.NoError(t, s.Delete(ctx, storage.KeyOf(m)))
+	require.NoError(t, s.snapshot())
+
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, s.GetMetrics(), restored.GetMetrics())
+}
+
+func TestClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := copyTestFile(t)
+	s, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "3")}))
+	require.NoError(t, s.Close())
+
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	m, err := restored.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "3", m.GetValue())
+}
+
+func TestRestore_log(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := copyTestFile(t)
+	s, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+
+	counter := metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "2")
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter}))
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{counter}))
+	require.NoError(t, s.Delete(ctx, storage.Key{MType: metrics.MetricTypeGauge, ID: "GetSet196"}))
+
+	// репозиторий не закрывается, как при аварийном завершении: метрики восстанавливаются из журнала
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored.RestoreReport.Snapshot)
+	assert.Equal(t, 3, restored.RestoreReport.LogEntries)
+	assert.Empty(t, restored.RestoreReport.Corrupt)
+	assert.Equal(t, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "4")}, restored.GetMetrics())
+}
+
+func TestOpen_readOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := copyTestFile(t)
+	s, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "2")}))
+
+	files := func() map[string][]byte {
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		contents := make(map[string][]byte, len(entries))
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(path), e.Name()))
+			require.NoError(t, err)
+			contents[e.Name()] = data
+		}
+		return contents
+	}
+	before := files()
+
+	// снимок и журнал читаются, но не переписываются
+	ro, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ro.RestoreReport.LogEntries)
+	m, err := ro.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "2", m.GetValue())
+
+	assert.ErrorIs(t, ro.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}), ErrReadOnly)
+	assert.ErrorIs(t, ro.Delete(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"}), ErrReadOnly)
+	require.NoError(t, ro.Close())
+
+	assert.Equal(t, before, files())
+}
+
+func TestUpsertBatch_log(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "metrics")
+	s, err := NewStorage(ctx, path, false, 0)
+	require.NoError(t, err)
+
+	counter := metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "2")
+	for i := 0; i < 2; i++ {
+		_, err = s.UpsertBatch(ctx, "batch_1", []metrics.Metrics{counter})
+		require.NoError(t, err)
+	}
+
+	// пачка пишется в журнал, как и остальные изменения
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored.RestoreReport.LogEntries)
+	assert.Equal(t, []metrics.Metrics{counter}, restored.GetMetrics())
+}
+
+func TestUpsertTotals_restore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "metrics")
+	s, err := NewStorage(ctx, path, false, 0)
+	require.NoError(t, err)
+
+	upsert := func(s *Storage, total, increment string) {
+		t.Helper()
+		increments, err := s.UpsertTotals(ctx, nil, []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, total)})
+		require.NoError(t, err)
+		assert.Equal(t, []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, increment)}, increments)
+	}
+	upsert(s, "10", "10")
+
+	// итог восстанавливается из журнала
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	upsert(restored, "15", "5")
+
+	// и из снимка
+	require.NoError(t, restored.Close())
+	restored, err = NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	upsert(restored, "18", "3")
+	assert.Equal(t, []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, "18")}, restored.GetMetrics())
+}
+
+func TestRestore_corrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "metrics")
+	s, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}))
+
+	// запись с неверной контрольной суммой, затем целая запись и оборванная запись в конце
+	valid, err := encodeRecord(logEntry{Op: opUpsert, Metrics: []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "5")}})
+	require.NoError(t, err)
+	s.log.WriteString("00000000 {\"op\":\"upsert\"}\n")
+	s.log.Write(valid)
+	s.log.Write(valid[:len(valid)/2])
+
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored.RestoreReport.LogEntries)
+	require.Len(t, restored.RestoreReport.Corrupt, 2)
+	assert.Equal(t, 2, restored.RestoreReport.Corrupt[0].Line)
+	assert.Equal(t, errChecksum.Error(), restored.RestoreReport.Corrupt[0].Reason)
+	assert.Equal(t, 4, restored.RestoreReport.Corrupt[1].Line)
+
+	m, err := restored.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "6", m.GetValue())
+}
+
+func TestSnapshot_compaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "metrics")
+	s, err := NewStorage(ctx, path, false, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}))
+	before, err := s.logGenerations()
+	require.NoError(t, err)
+
+	require.NoError(t, s.snapshot())
+	after, err := s.logGenerations()
+	require.NoError(t, err)
+
+	// журнал, вошедший в снимок, удален, открыт журнал следующего поколения
+	require.Len(t, before, 1)
+	assert.Equal(t, []uint64{before[0] + 1}, after)
+
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored.RestoreReport.Snapshot)
+	assert.Equal(t, 0, restored.RestoreReport.LogEntries)
+}
+
+func TestNewStorage_withoutRestore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := copyTestFile(t)
+	s, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}))
+
+	_, err = NewStorage(ctx, path, false, 0)
+	require.NoError(t, err)
+
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	assert.Empty(t, restored.GetMetrics())
+}
+
+func TestClose_closed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := NewStorage(ctx, filepath.Join(t.TempDir(), "metrics"), false, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}), os.ErrClosed)
+}
+
+func TestUpsertMetrics_sync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := NewStorage(ctx, filepath.Join(t.TempDir(), "metrics"), false, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}))
+	assert.Equal(t, s.appended, s.synced)
+
+	// при записи снимка по интервалу изменения не ждут сброса журнала
+	s.SetDumpInterval(time.Hour)
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}))
+	assert.Equal(t, s.appended-1, s.synced)
+}
+
+// TestUpsertMetrics_groupCommit проверяет, что параллельные синхронные изменения не теряются
+// и все сброшены на диск к моменту возврата
+func TestUpsertMetrics_groupCommit(t *testing.T) {
+	const workers, updates = 16, 50
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "metrics")
+	s, err := NewStorage(ctx, path, false, 0)
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				assert.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(workers*updates), s.synced)
+
+	restored, err := NewStorage(ctx, path, true, 0)
+	require.NoError(t, err)
+	m, err := restored.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers*updates), m.GetValue())
+}
+
+func BenchmarkUpsertMetrics_sync(b *testing.B) {
+	logger.Set()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := NewStorage(ctx, filepath.Join(b.TempDir(), "metrics"), false, 0)
+	require.NoError(b, err)
+	counter := []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.UpsertMetrics(ctx, counter)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package filestorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"ya-prac-project1/internal/metrics"
+)
+
+// Операции журнала
+const (
+	opUpsert = "upsert"
+	opDelete = "delete"
+	// opTotals изменение с накопленными итогами счетчиков, см. UpsertTotals
+	opTotals = "totals"
+)
+
+// logEntry запись журнала — одно изменение репозитория
+type logEntry struct {
+	Op      string            `json:"op"`
+	Metrics []metrics.Metrics `json:"metrics,omitempty"`
+	Totals  []metrics.Metrics `json:"totals,omitempty"`
+	MType   string            `json:"type,omitempty"`
+	ID      string            `json:"id,omitempty"`
+}
+
+// snapshotHeader первая запись снимка: номер последнего журнала, изменения которого вошли в снимок,
+// и последние накопленные итоги счетчиков по ключу метрики
+type snapshotHeader struct {
+	Generation uint64           `json:"generation"`
+	Totals     map[string]int64 `json:"totals,omitempty"`
+}
+
+// logPath возвращает путь к журналу поколения generation
+func (s *Storage) logPath(generation uint64) string {
+	return fmt.Sprintf("%s.wal.%d", s.FilePath, generation)
+}
+
+// logGenerations возвращает поколения журналов, лежащих рядом со снимком, по возрастанию
+func (s *Storage) logGenerations() ([]uint64, error) {
+	paths, err := filepath.Glob(s.FilePath + ".wal.*")
+	if err != nil {
+		return nil, err
+	}
+
+	generations := []uint64{}
+	for _, path := range paths {
+		generation, err := strconv.ParseUint(strings.TrimPrefix(path, s.FilePath+".wal."), 10, 64)
+		if err != nil {
+			continue
+		}
+		generations = append(generations, generation)
+	}
+	slices.Sort(generations)
+
+	return generations, nil
+}
+
+// appendLog дописывает запись в текущий журнал. Вызывается под s.mu
+func (s *Storage) appendLog(entry logEntry) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.log == nil {
+		return os.ErrClosed
+	}
+
+	line, err := encodeRecord(entry)
+	if err != nil {
+		return err
+	}
+
+	n, err := s.log.Write(line)
+	s.logSize += int64(n)
+	if err != nil {
+		return err
+	}
+	s.appended++
+
+	if s.logSize >= compactLogSize {
+		select {
+		case s.compact <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// rotateLog сбрасывает на диск и закрывает текущий журнал и открывает журнал следующего поколения.
+// Вызывается под s.syncMu и s.mu
+func (s *Storage) rotateLog() error {
+	if s.log != nil {
+		if err := s.log.Sync(); err != nil {
+			return err
+		}
+		s.synced = s.appended
+		if err := s.log.Close(); err != nil {
+			return err
+		}
+		s.log = nil
+	}
+
+	file, err := os.OpenFile(s.logPath(s.generation+1), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	s.generation++
+	s.log = file
+	s.logSize = 0
+	return nil
+}
+
+// removeLogs удаляет журналы, изменения которых вошли в снимок поколения generation
+func (s *Storage) removeLogs(generation uint64) error {
+	generations, err := s.logGenerations()
+	if err != nil {
+		return err
+	}
+
+	for _, g := range generations {
+		if g > generation {
+			break
+		}
+		if err = os.Remove(s.logPath(g)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+// Записи снимка и журнала хранятся по одной в строке: контрольная сумма CRC-32 в hex,
+// пробел и JSON записи. Строки, которые начинаются с '{', считаются записями старого
+// формата без контрольной суммы
+const checksumLen = 8
+
+var errChecksum = errors.New("checksum mismatch")
+
+// encodeRecord кодирует запись в строку с контрольной суммой
+func encodeRecord(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, 0, checksumLen+len(data)+2)
+	line = fmt.Appendf(line, "%08x ", crc32.ChecksumIEEE(data))
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+// decodeRecord проверяет контрольную сумму строки и декодирует запись в v
+func decodeRecord(line []byte, v any) error {
+	if len(line) > 0 && line[0] == '{' {
+		return json.Unmarshal(line, v)
+	}
+
+	if len(line) < checksumLen+1 || line[checksumLen] != ' ' {
+		return errors.New("malformed record")
+	}
+
+	sum, err := strconv.ParseUint(string(line[:checksumLen]), 16, 32)
+	if err != nil {
+		return fmt.Errorf("malformed checksum: %w", err)
+	}
+
+	data := line[checksumLen+1:]
+	if crc32.ChecksumIEEE(data) != uint32(sum) {
+		return errChecksum
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// readRecords читает строки r и передает их в fn вместе с номером строки.
+// Последняя строка без перевода строки — оборванная при сбое запись, она передается с ошибкой
+func readRecords(r io.Reader, fn func(line int, data []byte, err error)) error {
+	buf := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		data, err := buf.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(data)) > 0 {
+				fn(n, nil, errors.New("truncated record"))
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		data = bytes.TrimRight(data, "\r\n")
+		if len(data) == 0 {
+			continue
+		}
+		fn(n, data, nil)
+	}
+}
@@ -0,0 +1,47 @@
+package filestorage
+
+import (
+	"strings"
+	"testing"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	m := metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5")
+	line, err := encodeRecord(m)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(line), "\n"))
+
+	actual := metrics.Metrics{}
+	require.NoError(t, decodeRecord(line[:len(line)-1], &actual))
+	assert.Equal(t, m, actual)
+
+	line[len(line)-3] = '7'
+	assert.ErrorIs(t, decodeRecord(line[:len(line)-1], &actual), errChecksum)
+}
+
+func TestRecord_legacy(t *testing.T) {
+	actual := metrics.Metrics{}
+	require.NoError(t, decodeRecord([]byte(`{"value":1.5,"id":"Alloc","type":"gauge"}`), &actual))
+	assert.Equal(t, metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"), actual)
+
+	assert.Error(t, decodeRecord([]byte("garbage"), &actual))
+	assert.Error(t, decodeRecord([]byte("zzzzzzzz {}"), &actual))
+}
+
+func TestReadRecords(t *testing.T) {
+	lines := []int{}
+	errs := 0
+	err := readRecords(strings.NewReader("a\n\nb\nc"), func(line int, data []byte, err error) {
+		lines = append(lines, line)
+		if err != nil {
+			errs++
+		}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 4}, lines)
+	assert.Equal(t, 1, errs)
+}
@@ -0,0 +1,176 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"ya-prac-project1/internal/logger"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// CorruptRecord запись снимка или журнала, которую не удалось прочитать при восстановлении
+type CorruptRecord struct {
+	File   string
+	Line   int
+	Reason string
+}
+
+// RestoreReport итог восстановления метрик из снимка и журналов
+type RestoreReport struct {
+	// Snapshot количество метрик, прочитанных из снимка
+	Snapshot int
+	// LogEntries количество примененных записей журналов
+	LogEntries int
+	// Corrupt пропущенные поврежденные записи
+	Corrupt []CorruptRecord
+}
+
+// restore загружает снимок и применяет к нему журналы поколений новее снимка
+func (s *Storage) restore() error {
+	ctx := context.Background()
+	header, items, err := s.readSnapshot()
+	if err != nil {
+		return err
+	}
+
+	s.Storage.SetMetrics(items)
+	s.Storage.SetTotals(header.Totals)
+	s.RestoreReport.Snapshot = len(items)
+	s.generation = header.Generation
+
+	generations, err := s.logGenerations()
+	if err != nil {
+		return err
+	}
+
+	for _, g := range generations {
+		if g <= header.Generation {
+			continue
+		}
+
+		if err = s.replayLog(ctx, g); err != nil {
+			return err
+		}
+		s.generation = g
+	}
+
+	for _, c := range s.RestoreReport.Corrupt {
+		logger.Get().Info("skip corrupt record",
+			zap.String("file", c.File),
+			zap.Int("line", c.Line),
+			zap.String("reason", c.Reason),
+		)
+	}
+
+	logger.Get().Info("metrics restored",
+		zap.Int("snapshot", s.RestoreReport.Snapshot),
+		zap.Int("log_entries", s.RestoreReport.LogEntries),
+		zap.Int("corrupt", len(s.RestoreReport.Corrupt)),
+	)
+
+	return nil
+}
+
+// readSnapshot читает снимок. Снимок старого формата не содержит заголовка и считается поколением 0
+func (s *Storage) readSnapshot() (snapshotHeader, []metrics.Metrics, error) {
+	header := snapshotHeader{}
+	file, err := os.Open(s.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return header, []metrics.Metrics{}, nil
+	}
+	if err != nil {
+		return header, nil, err
+	}
+	defer file.Close()
+
+	items := []metrics.Metrics{}
+	err = readRecords(file, func(line int, data []byte, err error) {
+		if err == nil && line == 1 && data[0] != '{' {
+			if err = decodeRecord(data, &header); err == nil {
+				return
+			}
+		}
+
+		item := metrics.Metrics{}
+		if err == nil {
+			err = decodeRecord(data, &item)
+		}
+		if err == nil {
+			err = item.Validate()
+		}
+		if err != nil {
+			s.corrupt(s.FilePath, line, err)
+			return
+		}
+
+		items = append(items, item)
+	})
+
+	return header, items, err
+}
+
+// replayLog применяет записи журнала поколения generation
+func (s *Storage) replayLog(ctx context.Context, generation uint64) error {
+	path := s.logPath(generation)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readRecords(file, func(line int, data []byte, err error) {
+		entry := logEntry{}
+		if err == nil {
+			err = decodeRecord(data, &entry)
+		}
+		if err == nil {
+			err = s.applyEntry(ctx, entry)
+		}
+		if err != nil {
+			s.corrupt(path, line, err)
+			return
+		}
+
+		s.RestoreReport.LogEntries++
+	})
+}
+
+// applyEntry применяет запись журнала к метрикам в памяти
+func (s *Storage) applyEntry(ctx context.Context, entry logEntry) error {
+	switch entry.Op {
+	case opUpsert:
+		for _, m := range entry.Metrics {
+			if err := m.Validate(); err != nil {
+				return err
+			}
+		}
+		return s.Storage.UpsertMetrics(ctx, entry.Metrics)
+	case opTotals:
+		for _, m := range append(entry.Metrics, entry.Totals...) {
+			if err := m.Validate(); err != nil {
+				return err
+			}
+		}
+		_, err := s.Storage.UpsertTotals(ctx, entry.Metrics, entry.Totals)
+		return err
+	case opDelete:
+		err := s.Storage.Delete(ctx, storage.Key{MType: entry.MType, ID: entry.ID})
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return errors.New("unknown operation " + entry.Op)
+}
+
+func (s *Storage) corrupt(file string, line int, err error) {
+	s.RestoreReport.Corrupt = append(s.RestoreReport.Corrupt, CorruptRecord{
+		File:   file,
+		Line:   line,
+		Reason: err.Error(),
+	})
+}
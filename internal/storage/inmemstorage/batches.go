@@ -0,0 +1,123 @@
+package inmemstorage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/metrics"
+)
+
+const (
+	batchTTL      = time.Hour
+	batchCapacity = 10000
+)
+
+// batches хранит идентификаторы недавно примененных пачек метрик и пачек, которые применяются сейчас
+type batches struct {
+	mu sync.Mutex
+	// applied элементы order по идентификатору пачки
+	applied map[string]*list.Element
+	// order примененные пачки в порядке применения, вытесняются с начала
+	order *list.List
+	// applying пачки, которые применяются сейчас, канал закрывается по окончании применения
+	applying map[string]chan struct{}
+}
+
+// appliedBatch примененная пачка
+type appliedBatch struct {
+	id string
+	at time.Time
+}
+
+func newBatches() *batches {
+	return &batches{
+		applied:  make(map[string]*list.Element),
+		order:    list.New(),
+		applying: make(map[string]chan struct{}),
+	}
+}
+
+// begin начинает применение пачки. Возвращает false, если пачка уже применена. Если пачку
+// сейчас применяет другой запрос, ждет окончания, чтобы повтор не ответил раньше, чем пачка сохранится
+func (b *batches) begin(ctx context.Context, id string, now time.Time) (bool, error) {
+	for {
+		b.mu.Lock()
+		if e, ok := b.applied[id]; ok && now.Sub(e.Value.(appliedBatch).at) < batchTTL {
+			b.mu.Unlock()
+			return false, nil
+		}
+
+		done, ok := b.applying[id]
+		if !ok {
+			b.applying[id] = make(chan struct{})
+			b.mu.Unlock()
+			return true, nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// end завершает применение пачки и запоминает ее, если она применена
+func (b *batches) end(id string, now time.Time, applied bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if done, ok := b.applying[id]; ok {
+		close(done)
+		delete(b.applying, id)
+	}
+
+	if !applied {
+		return
+	}
+
+	// устаревшая отметка той же пачки заменяется новой в конце очереди
+	if e, ok := b.applied[id]; ok {
+		b.order.Remove(e)
+	}
+	b.prune(now)
+	b.applied[id] = b.order.PushBack(appliedBatch{id: id, at: now})
+}
+
+// prune удаляет с начала очереди устаревшие пачки и самые старые сверх batchCapacity-1,
+// чтобы осталось место для новой. Пачки в очереди идут в порядке применения, поэтому
+// каждая удаляется за O(1)
+func (b *batches) prune(now time.Time) {
+	for e := b.order.Front(); e != nil; e = b.order.Front() {
+		oldest := e.Value.(appliedBatch)
+		if now.Sub(oldest.at) < batchTTL && b.order.Len() < batchCapacity {
+			return
+		}
+		b.order.Remove(e)
+		delete(b.applied, oldest.id)
+	}
+}
+
+// ApplyBatch вызывает apply, если пачка метрик id еще не применялась, и запоминает пачку, если apply
+// завершился без ошибки. Повтор пачки, которая применяется сейчас, ждет итога применения.
+// Возвращает false, если пачка уже была применена
+func (s *Storage) ApplyBatch(ctx context.Context, id string, apply func() error) (bool, error) {
+	ok, err := s.batches.begin(ctx, id, time.Now())
+	if !ok || err != nil {
+		return false, err
+	}
+
+	err = apply()
+	s.batches.end(id, time.Now(), err == nil)
+	return err == nil, err
+}
+
+// UpsertBatch применяет пачку метрик id, если она еще не применялась. Возвращает false,
+// если пачка уже была применена
+func (s *Storage) UpsertBatch(ctx context.Context, id string, ms []metrics.Metrics) (bool, error) {
+	return s.ApplyBatch(ctx, id, func() error {
+		return s.UpsertMetrics(ctx, ms)
+	})
+}
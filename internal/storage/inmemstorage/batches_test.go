@@ -0,0 +1,144 @@
+package inmemstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertBatch(t *testing.T) {
+	s := NewStorage()
+	ctx := context.Background()
+	ms := []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}
+
+	applied, err := s.UpsertBatch(ctx, "batch_1", ms)
+	assert.NoError(t, err)
+	assert.True(t, applied)
+
+	applied, _ = s.UpsertBatch(ctx, "batch_1", ms)
+	assert.False(t, applied)
+
+	m, err := s.Get(ctx, storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", m.GetValue())
+}
+
+func TestApplyBatch_failed(t *testing.T) {
+	s := NewStorage()
+	ctx := context.Background()
+
+	applied, err := s.ApplyBatch(ctx, "batch_1", func() error { return errors.New("disk full") })
+	assert.Error(t, err)
+	assert.False(t, applied)
+
+	// пачка, которую не удалось применить, применяется при повторе
+	applied, err = s.ApplyBatch(ctx, "batch_1", func() error { return nil })
+	assert.NoError(t, err)
+	assert.True(t, applied)
+}
+
+func TestApplyBatch_inFlight(t *testing.T) {
+	s := NewStorage()
+	ctx := context.Background()
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	go s.ApplyBatch(ctx, "batch_1", func() error {
+		close(started)
+		<-finish
+		return errors.New("disk full")
+	})
+	<-started
+
+	// повтор, пришедший во время применения, не считается дублем, пока применение не закончится
+	retried := make(chan bool)
+	go func() {
+		applied, _ := s.ApplyBatch(ctx, "batch_1", func() error { return nil })
+		retried <- applied
+	}()
+
+	select {
+	case <-retried:
+		t.Fatal("retry did not wait for the batch in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(finish)
+	assert.True(t, <-retried)
+
+	// повтор с отмененным контекстом не ждет
+	started, finish = make(chan struct{}), make(chan struct{})
+	go s.ApplyBatch(ctx, "batch_2", func() error {
+		close(started)
+		<-finish
+		return nil
+	})
+	<-started
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := s.ApplyBatch(canceled, "batch_2", func() error { return nil })
+	assert.ErrorIs(t, err, context.Canceled)
+	close(finish)
+}
+
+func TestBatchesExpire(t *testing.T) {
+	b := newBatches()
+	ctx := context.Background()
+	now := time.Now()
+
+	ok, _ := b.begin(ctx, "batch_1", now)
+	assert.True(t, ok)
+	b.end("batch_1", now, true)
+
+	ok, _ = b.begin(ctx, "batch_1", now.Add(time.Minute))
+	assert.False(t, ok)
+
+	ok, _ = b.begin(ctx, "batch_1", now.Add(batchTTL))
+	assert.True(t, ok)
+}
+
+func TestBatchesCapacity(t *testing.T) {
+	b := newBatches()
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < batchCapacity+10; i++ {
+		id, at := fmt.Sprintf("batch_%d", i), now.Add(time.Duration(i)*time.Millisecond)
+		b.begin(ctx, id, at)
+		b.end(id, at, true)
+	}
+
+	assert.Equal(t, batchCapacity, len(b.applied))
+	ok, _ := b.begin(ctx, "batch_0", now.Add(time.Second*20))
+	assert.True(t, ok)
+}
+
+func TestBatchesPrune(t *testing.T) {
+	b := newBatches()
+	ctx := context.Background()
+	now := time.Now()
+
+	for i, at := range []time.Time{now, now.Add(batchTTL / 2), now.Add(batchTTL)} {
+		id := fmt.Sprintf("batch_%d", i)
+		b.begin(ctx, id, at)
+		b.end(id, at, true)
+	}
+
+	// устаревшая пачка удалена при записи новой
+	assert.NotContains(t, b.applied, "batch_0")
+	assert.Equal(t, 2, b.order.Len())
+
+	// повтор устаревшей пачки заменяет ее отметку, а не добавляет вторую
+	later := now.Add(2 * batchTTL)
+	ok, _ := b.begin(ctx, "batch_2", later)
+	assert.True(t, ok)
+	b.end("batch_2", later, true)
+	assert.Equal(t, 1, b.order.Len())
+	assert.Len(t, b.applied, 1)
+}
@@ -0,0 +1,136 @@
+package inmemstorage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const benchmarkMetrics = 50000
+
+func newTestMetrics(n int) []metrics.Metrics {
+	ms := make([]metrics.Metrics, 0, n)
+	for i := 0; i < n; i++ {
+		ms = append(ms, metrics.NewMetric(fmt.Sprintf("metric_%d", i), metrics.MetricTypeGauge, strconv.Itoa(i)))
+	}
+	return ms
+}
+
+// TestConcurrentAccess запускает параллельные чтения и изменения, чтобы их проверил race detector
+func TestConcurrentAccess(t *testing.T) {
+	s := NewStorage()
+	s.SetMetrics(newTestMetrics(100))
+	ctx := context.Background()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				m := metrics.NewMetric(fmt.Sprintf("metric_%d", (w*200+i)%150), metrics.MetricTypeGauge, strconv.Itoa(i))
+				switch i % 5 {
+				case 0:
+					s.UpsertMetrics(ctx, []metrics.Metrics{m})
+				case 1:
+					s.Delete(ctx, storage.KeyOf(m))
+				case 2:
+					ms, _ := s.List(ctx, storage.Filter{MType: metrics.MetricTypeGauge})
+					for _, item := range ms {
+						item.SetValue("1")
+					}
+				case 3:
+					s.Get(ctx, storage.KeyOf(m))
+				case 4:
+					s.UpsertMetrics(ctx, []metrics.Metrics{m, metrics.NewMetric("counter", metrics.MetricTypeCounter, "1")})
+				}
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			s.SetMetrics(newTestMetrics(100))
+		}
+	}()
+
+	wg.Wait()
+	assert.LessOrEqual(t, len(s.GetMetrics()), 151)
+}
+
+func TestGetMetrics_copy(t *testing.T) {
+	s := NewStorage()
+	s.SetMetrics([]metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")})
+
+	ms := s.GetMetrics()
+	*ms[0].Delta = 10
+
+	m, err := s.Get(context.Background(), storage.KeyOf(ms[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", m.GetValue())
+}
+
+func BenchmarkUpsertMetrics_gauges(b *testing.B) {
+	ms := newTestMetrics(benchmarkMetrics)
+	s := NewStorage()
+	s.SetMetrics(ms)
+
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.UpsertMetrics(ctx, ms[:100])
+	}
+}
+
+func BenchmarkGetMetrics(b *testing.B) {
+	s := NewStorage()
+	s.SetMetrics(newTestMetrics(benchmarkMetrics))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetMetrics()
+	}
+}
+
+func BenchmarkParallelAccess(b *testing.B) {
+	ms := newTestMetrics(benchmarkMetrics)
+	s := NewStorage()
+	s.SetMetrics(ms)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m := ms[i%len(ms)]
+			if i%4 == 0 {
+				s.UpsertMetrics(context.Background(), []metrics.Metrics{m})
+			} else {
+				s.Get(context.Background(), storage.KeyOf(m))
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkUpsertMetrics(b *testing.B) {
+	ms := newTestMetrics(benchmarkMetrics)
+	s := NewStorage()
+	s.SetMetrics(ms)
+	counter := []metrics.Metrics{metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "1")}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.UpsertMetrics(context.Background(), counter)
+		}
+	})
+}
@@ -1,53 +1,253 @@
-// Package inmemstorage предоставляет хранилище в памяти
+// Package inmemstorage предоставляет хранилище в памяти.
+//
+// Метрики хранятся в шардах — картах по ключу метрики, каждая под своей блокировкой,
+// поэтому хранилище можно одновременно читать и менять из разных горутин.
+// Метрики отдаются копиями и возвращаются в порядке добавления
 package inmemstorage
 
 import (
+	"cmp"
+	"context"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
 )
 
+// shardCount количество шардов хранилища
+const shardCount = 32
+
+// entry метрика в шарде и ее порядковый номер добавления
+type entry struct {
+	metric metrics.Metrics
+	seq    uint64
+	// total последний накопленный итог счетчика, присланный источником
+	total *int64
+}
+
+type shard struct {
+	mu    sync.RWMutex
+	items map[string]entry
+}
+
 // Storage структура представляющая репозиторий
 type Storage struct {
-	Metrics []metrics.Metrics
+	shards [shardCount]*shard
+	// seq последний выданный порядковый номер метрики
+	seq     atomic.Uint64
+	batches *batches
 }
 
 // NewStorage создает репозиторий
 func NewStorage() *Storage {
-	return &Storage{
-		Metrics: make([]metrics.Metrics, 0),
+	s := &Storage{
+		batches: newBatches(),
 	}
+
+	for i := range s.shards {
+		s.shards[i] = &shard{items: make(map[string]entry)}
+	}
+
+	return s
 }
 
-// GetMetrics возвращает все метрики в репозитории
+func (s *Storage) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%shardCount]
+}
+
+// GetMetrics возвращает копии всех метрик в репозитории
 func (s *Storage) GetMetrics() []metrics.Metrics {
-	return s.Metrics
+	size := 0
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		size += len(sh.items)
+		sh.mu.RUnlock()
+	}
+
+	entries := make([]entry, 0, size)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, e := range sh.items {
+			entries = append(entries, entry{metric: e.metric.Clone(), seq: e.seq})
+		}
+		sh.mu.RUnlock()
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	items := make([]metrics.Metrics, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, e.metric)
+	}
+
+	return items
 }
 
-// CreateMetrics добавляет полученные метрики в репозиторий
-func (s *Storage) CreateMetrics(ms []metrics.Metrics) error {
-	ems := s.GetMetrics()
-	ems = append(ems, ms...)
+// Get возвращает копию метрики по ключу
+func (s *Storage) Get(_ context.Context, key storage.Key) (metrics.Metrics, error) {
+	k := key.String()
+	sh := s.shard(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	s.SetMetrics(ems)
-	return nil
+	e, ok := sh.items[k]
+	if !ok {
+		return metrics.Metrics{}, storage.ErrNotFound
+	}
+
+	return e.metric.Clone(), nil
+}
+
+// List возвращает копии метрик, попадающих под фильтр, в порядке добавления
+func (s *Storage) List(_ context.Context, filter storage.Filter) ([]metrics.Metrics, error) {
+	items := []metrics.Metrics{}
+	for _, m := range s.GetMetrics() {
+		if filter.Match(m) {
+			items = append(items, m)
+		}
+	}
+
+	return items, nil
 }
 
-// UpdateMetrics обновляет полученные метрики в репозитории
-func (s *Storage) UpdateMetrics(ms []metrics.Metrics) error {
-	ems := s.GetMetrics()
+// UpsertMetrics атомарно применяет метрики: счетчики прибавляются к сохраненным, gauge заменяются.
+// Отсутствующие метрики добавляются
+func (s *Storage) UpsertMetrics(_ context.Context, ms []metrics.Metrics) error {
 	for _, m := range ms {
-		for k := range ems {
-			metric := ems[k]
-			if m.GetKey() != metric.GetKey() {
-				continue
+		key := m.GetKey()
+		sh := s.shard(key)
+
+		sh.mu.Lock()
+		e, ok := sh.items[key]
+		if !ok {
+			e.seq = s.seq.Add(1)
+		}
+		e.metric = merge(e.metric, m, ok)
+		sh.items[key] = e
+		sh.mu.Unlock()
+	}
+
+	return nil
+}
+
+// UpsertTotals применяет метрики ms, как UpsertMetrics, и накопленные итоги счетчиков totals:
+// к счетчику прибавляется разница с прошлым итогом, первый итог и итог меньше прошлого
+// (счетчик источника сброшен) прибавляются целиком. Итог запоминается вместе со счетчиком.
+// Возвращает итоги, переведенные в приращения
+func (s *Storage) UpsertTotals(ctx context.Context, ms, totals []metrics.Metrics) ([]metrics.Metrics, error) {
+	if err := s.UpsertMetrics(ctx, ms); err != nil {
+		return nil, err
+	}
+
+	increments := make([]metrics.Metrics, 0, len(totals))
+	for _, m := range totals {
+		key := m.GetKey()
+		sh := s.shard(key)
+
+		sh.mu.Lock()
+		e, ok := sh.items[key]
+		if !ok {
+			e.seq = s.seq.Add(1)
+		}
+		increment := metrics.Increment(m, e.total)
+		e.metric = merge(e.metric, increment, ok)
+		total := *m.Delta
+		e.total = &total
+		sh.items[key] = e
+		sh.mu.Unlock()
+
+		increments = append(increments, increment)
+	}
+
+	return increments, nil
+}
+
+// Totals возвращает последние накопленные итоги счетчиков по ключу метрики
+func (s *Storage) Totals() map[string]int64 {
+	totals := map[string]int64{}
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for key, e := range sh.items {
+			if e.total != nil {
+				totals[key] = *e.total
 			}
-			ems[k] = m
 		}
+		sh.mu.RUnlock()
+	}
+
+	return totals
+}
+
+// SetTotals запоминает накопленные итоги счетчиков, которые есть в репозитории
+func (s *Storage) SetTotals(totals map[string]int64) {
+	for key, total := range totals {
+		sh := s.shard(key)
+		sh.mu.Lock()
+		if e, ok := sh.items[key]; ok {
+			e.total = &total
+			sh.items[key] = e
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// merge возвращает результат применения метрики m к сохраненной метрике stored
+func merge(stored, m metrics.Metrics, exists bool) metrics.Metrics {
+	if !exists || m.MType != metrics.MetricTypeCounter || m.Delta == nil || stored.Delta == nil {
+		return m.Clone()
 	}
-	s.SetMetrics(ems)
+
+	delta := *stored.Delta + *m.Delta
+	stored.Delta = &delta
+	return stored
+}
+
+// Delete удаляет метрику по ключу
+func (s *Storage) Delete(_ context.Context, key storage.Key) error {
+	k := key.String()
+	sh := s.shard(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, ok := sh.items[k]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(sh.items, k)
+	return nil
+}
+
+// Close ничего не делает, метрики в памяти не требуют освобождения ресурсов
+func (s *Storage) Close() error {
 	return nil
 }
 
-// SetMetrics заменяет метркии в репозитории на полученные
+// SetMetrics заменяет метрики в репозитории на полученные
 func (s *Storage) SetMetrics(ms []metrics.Metrics) {
-	s.Metrics = ms
+	// блокируются все шарды, чтобы замена не смешалась с параллельными изменениями
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		clear(sh.items)
+	}
+
+	for _, m := range ms {
+		key := m.GetKey()
+		sh := s.shard(key)
+		e, ok := sh.items[key]
+		if !ok {
+			e.seq = s.seq.Add(1)
+		}
+		e.metric = m.Clone()
+		sh.items[key] = e
+	}
+
+	for _, sh := range s.shards {
+		sh.mu.Unlock()
+	}
 }
@@ -1,28 +1,31 @@
 package inmemstorage
 
 import (
+	"context"
 	"testing"
 	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSetMetrics(t *testing.T) {
 	s := NewStorage()
 
-	assert.Equal(t, s.Metrics, []metrics.Metrics{})
+	assert.Equal(t, s.GetMetrics(), []metrics.Metrics{})
 
 	s.SetMetrics([]metrics.Metrics{
 		metrics.NewMetric("id", "type", "20"),
 	})
-	assert.Equal(t, s.Metrics, []metrics.Metrics{
+	assert.Equal(t, s.GetMetrics(), []metrics.Metrics{
 		metrics.NewMetric("id", "type", "20"),
 	})
 
 	s.SetMetrics([]metrics.Metrics{
 		metrics.NewMetric("id1", "type1", "30"),
 	})
-	assert.Equal(t, s.Metrics, []metrics.Metrics{
+	assert.Equal(t, s.GetMetrics(), []metrics.Metrics{
 		metrics.NewMetric("id1", "type1", "30"),
 	})
 }
@@ -38,47 +41,139 @@ func TestGetMetrics(t *testing.T) {
 	assert.Equal(t, ms, s.GetMetrics())
 }
 
-func TestUpdateMetrics(t *testing.T) {
-	ms := []metrics.Metrics{
+func TestGet(t *testing.T) {
+	s := NewStorage()
+	s.SetMetrics([]metrics.Metrics{
 		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
+	})
+
+	m, err := s.Get(context.Background(), storage.Key{MType: metrics.MetricTypeGauge, ID: "test_1"})
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"), m)
+
+	_, err = s.Get(context.Background(), storage.Key{MType: metrics.MetricTypeCounter, ID: "test_1"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestList(t *testing.T) {
+	s := NewStorage()
+	s.SetMetrics([]metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
+		metrics.NewMetric("other", metrics.MetricTypeGauge, "2.5"),
+	})
+
+	tests := []struct {
+		name   string
+		filter storage.Filter
+		expect []metrics.Metrics
+	}{
+		{
+			name:   "all",
+			filter: storage.Filter{},
+			expect: s.GetMetrics(),
+		},
+		{
+			name:   "type",
+			filter: storage.Filter{MType: metrics.MetricTypeGauge},
+			expect: []metrics.Metrics{
+				metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
+				metrics.NewMetric("other", metrics.MetricTypeGauge, "2.5"),
+			},
+		},
+		{
+			name:   "prefix",
+			filter: storage.Filter{Prefix: "test"},
+			expect: []metrics.Metrics{
+				metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
+				metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
+			},
+		},
+		{
+			name:   "empty",
+			filter: storage.Filter{MType: metrics.MetricTypeCounter, Prefix: "other"},
+			expect: []metrics.Metrics{},
+		},
 	}
 
-	ums := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, err := s.List(context.Background(), tt.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, ms)
+		})
 	}
+}
 
+func TestDelete(t *testing.T) {
 	s := NewStorage()
-	s.SetMetrics(ms)
+	s.SetMetrics([]metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
+		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
+	})
+
+	err := s.Delete(context.Background(), storage.Key{MType: metrics.MetricTypeGauge, ID: "test_1"})
+	assert.NoError(t, err)
 
-	s.UpdateMetrics(ums)
+	err = s.Delete(context.Background(), storage.Key{MType: metrics.MetricTypeGauge, ID: "test_1"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
 
 	expect := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+		metrics.NewMetric("test_1", metrics.MetricTypeCounter, "1"),
 		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
 	}
 	assert.Equal(t, expect, s.GetMetrics())
 }
 
-func TestCreateMetrics(t *testing.T) {
-	ms := []metrics.Metrics{
+func TestUpsertMetrics(t *testing.T) {
+	s := NewStorage()
+	s.SetMetrics([]metrics.Metrics{
 		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
-	}
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "2"),
+	})
 
-	cms := []metrics.Metrics{
-		metrics.NewMetric("test_3", metrics.MetricTypeGauge, "3.5"),
+	err := s.UpsertMetrics(context.Background(), []metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "3"),
+		metrics.NewMetric("test_3", metrics.MetricTypeCounter, "1"),
+		metrics.NewMetric("test_3", metrics.MetricTypeCounter, "1"),
+	})
+	assert.NoError(t, err)
+
+	expect := []metrics.Metrics{
+		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "10.5"),
+		metrics.NewMetric("test_2", metrics.MetricTypeCounter, "5"),
+		metrics.NewMetric("test_3", metrics.MetricTypeCounter, "2"),
 	}
+	assert.Equal(t, expect, s.GetMetrics())
+}
 
+func TestUpsertTotals(t *testing.T) {
 	s := NewStorage()
-	s.SetMetrics(ms)
+	ctx := context.Background()
+	key := storage.Key{MType: metrics.MetricTypeCounter, ID: "bytes"}
+
+	// счетчик уже копит приращения от агента, итоги источника прибавляются разницей
+	require.NoError(t, s.UpsertMetrics(ctx, []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, "100")}))
+	for _, tt := range []struct{ total, increment, stored string }{{"10", "10", "110"}, {"15", "5", "115"}, {"4", "4", "119"}} {
+		gauge := metrics.NewMetric("Alloc", metrics.MetricTypeGauge, tt.total)
+		increments, err := s.UpsertTotals(ctx, []metrics.Metrics{gauge}, []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, tt.total)})
+		require.NoError(t, err)
+		assert.Equal(t, []metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, tt.increment)}, increments)
+
+		m, err := s.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, tt.stored, m.GetValue())
+	}
+	assert.Equal(t, map[string]int64{"counter_bytes": 4}, s.Totals())
 
-	s.CreateMetrics(cms)
+	// итог удаляется вместе со счетчиком
+	require.NoError(t, s.Delete(ctx, key))
+	assert.Empty(t, s.Totals())
 
-	expect := []metrics.Metrics{
-		metrics.NewMetric("test_1", metrics.MetricTypeGauge, "1.5"),
-		metrics.NewMetric("test_2", metrics.MetricTypeGauge, "2.5"),
-		metrics.NewMetric("test_3", metrics.MetricTypeGauge, "3.5"),
-	}
-	assert.Equal(t, expect, s.GetMetrics())
+	restored := NewStorage()
+	restored.SetMetrics([]metrics.Metrics{metrics.NewMetric("bytes", metrics.MetricTypeCounter, "119")})
+	restored.SetTotals(map[string]int64{"counter_bytes": 4, "counter_unknown": 1})
+	assert.Equal(t, map[string]int64{"counter_bytes": 4}, restored.Totals())
 }
@@ -0,0 +1,197 @@
+// Package snapshot предоставляет переносимый снимок метрик для резервного копирования
+// и переноса метрик между репозиториями.
+//
+// Снимок — текстовый файл NDJSON: первая строка — заголовок Header, далее по одной метрике
+// в строке в формате api сервера. Счетчики хранятся абсолютным значением. Снимок может быть
+// сжат gzip, при чтении сжатие определяется по содержимому
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+)
+
+// Format значение поля format заголовка снимка
+const Format = "metrics-snapshot"
+
+// Version версия формата снимка
+const Version = 1
+
+// gzipMagic первые байты потока gzip
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Header первая строка снимка
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Source вид репозитория, из которого снят снимок
+	Source string `json:"source,omitempty"`
+	// Count количество метрик в снимке, по нему обнаруживается обрезанный файл
+	Count int `json:"count"`
+}
+
+// NewHeader возвращает заголовок снимка из source с count метриками
+func NewHeader(source string, count int) Header {
+	return Header{
+		Format:    Format,
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Source:    source,
+		Count:     count,
+	}
+}
+
+// Write пишет снимок метрик ms в w, сжимая его gzip, если установлен compress.
+// Поле Count заголовка заполняется по ms
+func Write(w io.Writer, header Header, ms []metrics.Metrics, compress bool) error {
+	header.Count = len(ms)
+
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	if gz != nil {
+		return gz.Close()
+	}
+
+	return nil
+}
+
+// Read читает снимок из r. Метрики проверяются, ошибка содержит номер строки
+func Read(r io.Reader) (Header, []metrics.Metrics, error) {
+	header := Header{}
+
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return header, nil, err
+	}
+	if bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return header, nil, err
+		}
+		defer gz.Close()
+		buf = bufio.NewReader(gz)
+	}
+
+	dec := json.NewDecoder(buf)
+	if err = dec.Decode(&header); err != nil {
+		return header, nil, fmt.Errorf("line 1: %w", err)
+	}
+	if header.Format != Format {
+		return header, nil, fmt.Errorf("line 1: unknown format %q", header.Format)
+	}
+	if header.Version != Version {
+		return header, nil, fmt.Errorf("line 1: unsupported version %d", header.Version)
+	}
+
+	ms := make([]metrics.Metrics, 0, header.Count)
+	for line := 2; ; line++ {
+		m := metrics.Metrics{}
+		err = dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			err = m.Validate()
+		}
+		if errors.Is(err, metrics.ErrNoValue) {
+			err = fmt.Errorf("metric %s has no value", m.GetKey())
+		}
+		if err != nil {
+			return header, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		ms = append(ms, m)
+	}
+
+	if len(ms) != header.Count {
+		return header, nil, fmt.Errorf("snapshot truncated: header declares %d metrics, read %d", header.Count, len(ms))
+	}
+
+	return header, ms, nil
+}
+
+// Difference расхождение метрики между двумя наборами
+type Difference struct {
+	Key storage.Key
+	// Want значение в первом наборе, пустое, если метрики в нем нет
+	Want string
+	// Got значение во втором наборе, пустое, если метрики в нем нет
+	Got string
+}
+
+// String возвращает расхождение в виде "- тип имя значение" для метрики только из первого набора,
+// "+ тип имя значение" для метрики только из второго и "~ тип имя было -> стало" для разных значений
+func (d Difference) String() string {
+	switch {
+	case d.Got == "":
+		return fmt.Sprintf("- %s %s %s", d.Key.MType, d.Key.ID, d.Want)
+	case d.Want == "":
+		return fmt.Sprintf("+ %s %s %s", d.Key.MType, d.Key.ID, d.Got)
+	}
+
+	return fmt.Sprintf("~ %s %s %s -> %s", d.Key.MType, d.Key.ID, d.Want, d.Got)
+}
+
+// Diff сравнивает наборы метрик want и got и возвращает расхождения, упорядоченные по типу и имени
+func Diff(want, got []metrics.Metrics) []Difference {
+	values := make(map[storage.Key]string, len(got))
+	for _, m := range got {
+		values[storage.KeyOf(m)] = m.GetValue()
+	}
+
+	diffs := []Difference{}
+	for _, m := range want {
+		key := storage.KeyOf(m)
+		value, ok := values[key]
+		delete(values, key)
+
+		if want := m.GetValue(); !ok || value != want {
+			diffs = append(diffs, Difference{Key: key, Want: want, Got: value})
+		}
+	}
+
+	for key, value := range values {
+		diffs = append(diffs, Difference{Key: key, Got: value})
+	}
+
+	slices.SortFunc(diffs, func(a, b Difference) int {
+		if c := strings.Compare(a.Key.MType, b.Key.MType); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key.ID, b.Key.ID)
+	})
+
+	return diffs
+}
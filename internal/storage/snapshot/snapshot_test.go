@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"bytes"
+	"testing"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMetrics() []metrics.Metrics {
+	return []metrics.Metrics{
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "9007199254740993"),
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "0.1"),
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, Write(buf, NewHeader("file", 0), testMetrics(), compress))
+		assert.Equal(t, compress, bytes.HasPrefix(buf.Bytes(), gzipMagic))
+
+		header, ms, err := Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "file", header.Source)
+		assert.Equal(t, 2, header.Count)
+		assert.Equal(t, testMetrics(), ms)
+	}
+}
+
+func TestRead_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{name: "empty", data: "", err: "line 1"},
+		{name: "format", data: `{"format":"csv","version":1}`, err: `unknown format "csv"`},
+		{name: "version", data: `{"format":"metrics-snapshot","version":2}`, err: "unsupported version 2"},
+		{name: "type", data: `{"format":"metrics-snapshot","version":1,"count":1}` + "\n" + `{"id":"a","type":"histogram","value":1}`, err: "line 2: wrong metric type"},
+		{name: "value", data: `{"format":"metrics-snapshot","version":1,"count":1}` + "\n" + `{"id":"a","type":"gauge"}`, err: "line 2: metric gauge_a has no value"},
+		{name: "truncated", data: `{"format":"metrics-snapshot","version":1,"count":2}` + "\n" + `{"id":"a","type":"gauge","value":1}`, err: "snapshot truncated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Read(bytes.NewBufferString(tt.data))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := []metrics.Metrics{
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "5"),
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("Sys", metrics.MetricTypeGauge, "2"),
+	}
+	got := []metrics.Metrics{
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "6"),
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+		metrics.NewMetric("HeapAlloc", metrics.MetricTypeGauge, "3"),
+	}
+
+	diffs := Diff(want, got)
+	assert.Equal(t, []Difference{
+		{Key: storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"}, Want: "5", Got: "6"},
+		{Key: storage.Key{MType: metrics.MetricTypeGauge, ID: "HeapAlloc"}, Got: "3"},
+		{Key: storage.Key{MType: metrics.MetricTypeGauge, ID: "Sys"}, Want: "2"},
+	}, diffs)
+
+	assert.Equal(t, "~ counter PollCount 5 -> 6", diffs[0].String())
+	assert.Equal(t, "+ gauge HeapAlloc 3", diffs[1].String())
+	assert.Equal(t, "- gauge Sys 2", diffs[2].String())
+
+	assert.Empty(t, Diff(want, want))
+}
@@ -0,0 +1,45 @@
+// Package storage предоставляет общие для всех репозиториев метрик типы: ключ метрики,
+// фильтр выборки и ошибки
+package storage
+
+import (
+	"errors"
+	"strings"
+	"ya-prac-project1/internal/metrics"
+)
+
+// ErrNotFound возвращается, если метрики с запрошенным ключом нет в репозитории
+var ErrNotFound = errors.New("metric not found")
+
+// Key идентифицирует метрику в репозитории
+type Key struct {
+	MType string
+	ID    string
+}
+
+// KeyOf возвращает ключ метрики
+func KeyOf(m metrics.Metrics) Key {
+	return Key{MType: m.MType, ID: m.ID}
+}
+
+// String возвращает ключ в том же виде, что и metrics.Metrics.GetKey
+func (k Key) String() string {
+	return metrics.Metrics{MType: k.MType, ID: k.ID}.GetKey()
+}
+
+// Filter описывает выборку метрик. Пустые поля не ограничивают выборку
+type Filter struct {
+	// MType тип метрик
+	MType string
+	// Prefix начало имени метрик
+	Prefix string
+}
+
+// Match проверяет, попадает ли метрика в выборку
+func (f Filter) Match(m metrics.Metrics) bool {
+	if f.MType != "" && m.MType != f.MType {
+		return false
+	}
+
+	return strings.HasPrefix(m.ID, f.Prefix)
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"testing"
+	"ya-prac-project1/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyString(t *testing.T) {
+	m := metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")
+	assert.Equal(t, m.GetKey(), KeyOf(m).String())
+}
+
+func TestFilterMatch(t *testing.T) {
+	m := metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1")
+
+	assert.True(t, Filter{}.Match(m))
+	assert.True(t, Filter{MType: metrics.MetricTypeGauge, Prefix: "All"}.Match(m))
+	assert.False(t, Filter{MType: metrics.MetricTypeCounter}.Match(m))
+	assert.False(t, Filter{Prefix: "Heap"}.Match(m))
+}
@@ -0,0 +1,82 @@
+package tsdb
+
+import "io"
+
+// bstream поток битов, в который пишутся сжатые отсчеты чанка. Биты заполняют байты
+// от старшего к младшему
+type bstream struct {
+	data []byte
+	// free количество незанятых битов в последнем байте
+	free uint8
+}
+
+func (b *bstream) writeBit(bit bool) {
+	if b.free == 0 {
+		b.data = append(b.data, 0)
+		b.free = 8
+	}
+
+	if bit {
+		b.data[len(b.data)-1] |= 1 << (b.free - 1)
+	}
+	b.free--
+}
+
+// writeBits пишет младшие n битов v, начиная со старшего из них
+func (b *bstream) writeBits(v uint64, n int) {
+	for n > 0 {
+		if b.free == 0 {
+			b.data = append(b.data, 0)
+			b.free = 8
+		}
+
+		// сколько битов помещается в текущий байт
+		k := min(n, int(b.free))
+		chunk := byte(v>>(n-k)) & (1<<k - 1)
+		b.data[len(b.data)-1] |= chunk << (int(b.free) - k)
+		b.free -= uint8(k)
+		n -= k
+	}
+}
+
+// bitReader читает биты из данных bstream
+type bitReader struct {
+	data []byte
+	// pos номер следующего бита
+	pos int
+	// end количество записанных битов
+	end int
+}
+
+func newBitReader(b *bstream) bitReader {
+	return bitReader{data: b.data, end: len(b.data)*8 - int(b.free)}
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= r.end {
+		return false, io.ErrUnexpectedEOF
+	}
+
+	bit := r.data[r.pos/8]&(1<<(7-r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+// readBits читает n битов, первый прочитанный бит становится старшим
+func (r *bitReader) readBits(n int) (uint64, error) {
+	if r.pos+n > r.end {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var v uint64
+	for n > 0 {
+		offset := r.pos % 8
+		k := min(n, 8-offset)
+		bits := uint64(r.data[r.pos/8]>>(8-offset-k)) & (1<<k - 1)
+		v = v<<k | bits
+		r.pos += k
+		n -= k
+	}
+
+	return v, nil
+}
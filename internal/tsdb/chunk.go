@@ -0,0 +1,279 @@
+package tsdb
+
+import (
+	"math"
+	"math/bits"
+)
+
+// chunkSamples количество отсчетов, после которого чанк закрывается и начинается новый
+const chunkSamples = 120
+
+// leadingUnset значение leading до первого значения с отличающимися битами
+const leadingUnset = 0xff
+
+// chunk хранит до chunkSamples отсчетов одного ряда, сжатых по схеме Gorilla.
+//
+// Первый отсчет пишется как есть: метка времени и значение по 64 бита. Для следующих
+// пишется разность между текущим и предыдущим интервалом меток времени (delta-of-delta)
+// префиксным кодом, а значение — как XOR с предыдущим, от которого сохраняются только
+// значащие биты. Для рядов с постоянным интервалом и медленно меняющимися значениями
+// отсчет занимает 1–2 байта вместо 16
+type chunk struct {
+	b     bstream
+	count int
+	minT  int64
+	maxT  int64
+
+	// состояние кодировщика
+	tDelta   int64
+	v        float64
+	leading  uint8
+	trailing uint8
+}
+
+func newChunk() *chunk {
+	return &chunk{leading: leadingUnset}
+}
+
+// append дописывает отсчет. Метка времени должна быть больше maxT
+func (c *chunk) append(t int64, v float64) {
+	switch c.count {
+	case 0:
+		c.b.writeBits(uint64(t), 64)
+		c.b.writeBits(math.Float64bits(v), 64)
+		c.minT = t
+	default:
+		delta := t - c.maxT
+		writeDod(&c.b, delta-c.tDelta)
+		c.leading, c.trailing = writeXOR(&c.b, c.v, v, c.leading, c.trailing)
+		c.tDelta = delta
+	}
+
+	c.maxT = t
+	c.v = v
+	c.count++
+}
+
+// full сообщает, что в чанк больше не пишутся отсчеты
+func (c *chunk) full() bool {
+	return c.count >= chunkSamples
+}
+
+// seal освобождает запас емкости закрытого чанка
+func (c *chunk) seal() {
+	data := make([]byte, len(c.b.data))
+	copy(data, c.b.data)
+	c.b.data = data
+}
+
+// size возвращает занятую чанком память в байтах
+func (c *chunk) size() int {
+	const header = 8 * 8
+	return header + cap(c.b.data)
+}
+
+// snapshot возвращает копию чанка, которую можно читать, пока в исходный дописываются отсчеты
+func (c *chunk) snapshot() *chunk {
+	cp := *c
+	cp.b.data = make([]byte, len(c.b.data))
+	copy(cp.b.data, c.b.data)
+	return &cp
+}
+
+// Диапазоны delta-of-delta и их префиксы. Интервалы до ±8 секунд от предыдущего
+// кодируются 16 битами, при постоянном интервале — одним
+var dodBuckets = []struct {
+	prefix     uint64
+	prefixBits int
+	bits       int
+}{
+	{prefix: 0b10, prefixBits: 2, bits: 14},
+	{prefix: 0b110, prefixBits: 3, bits: 17},
+	{prefix: 0b1110, prefixBits: 4, bits: 20},
+}
+
+// fitsBits проверяет, что x помещается в n битов со знаком
+func fitsBits(x int64, n int) bool {
+	return -(1<<(n-1)-1) <= x && x <= 1<<(n-1)
+}
+
+func writeDod(b *bstream, dod int64) {
+	if dod == 0 {
+		b.writeBit(false)
+		return
+	}
+
+	for _, bucket := range dodBuckets {
+		if fitsBits(dod, bucket.bits) {
+			b.writeBits(bucket.prefix, bucket.prefixBits)
+			b.writeBits(uint64(dod), bucket.bits)
+			return
+		}
+	}
+
+	b.writeBits(0b1111, 4)
+	b.writeBits(uint64(dod), 64)
+}
+
+func readDod(r *bitReader) (int64, error) {
+	// количество единиц префикса до нуля, не больше 4
+	prefix := 0
+	for ; prefix < 4; prefix++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+	}
+
+	if prefix == 0 {
+		return 0, nil
+	}
+	if prefix == 4 {
+		v, err := r.readBits(64)
+		return int64(v), err
+	}
+
+	n := dodBuckets[prefix-1].bits
+	v, err := r.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+
+	// восстановление знака
+	if v > 1<<(n-1) {
+		return int64(v) - 1<<n, nil
+	}
+	return int64(v), nil
+}
+
+// writeXOR пишет значение v как XOR с предыдущим prev и возвращает новое окно значащих битов.
+// Если значащие биты помещаются в окно предыдущего значения, пишутся только они, иначе
+// сначала количество ведущих нулей (5 битов) и длина значащей части (6 битов)
+func writeXOR(b *bstream, prev, v float64, leading, trailing uint8) (uint8, uint8) {
+	xor := math.Float64bits(v) ^ math.Float64bits(prev)
+	if xor == 0 {
+		b.writeBit(false)
+		return leading, trailing
+	}
+	b.writeBit(true)
+
+	newLeading := uint8(min(bits.LeadingZeros64(xor), 31))
+	newTrailing := uint8(bits.TrailingZeros64(xor))
+
+	if leading != leadingUnset && newLeading >= leading && newTrailing >= trailing {
+		b.writeBit(false)
+		b.writeBits(xor>>trailing, 64-int(leading)-int(trailing))
+		return leading, trailing
+	}
+
+	b.writeBit(true)
+	sigbits := 64 - int(newLeading) - int(newTrailing)
+	b.writeBits(uint64(newLeading), 5)
+	// длина 64 не помещается в 6 битов и пишется как 0
+	b.writeBits(uint64(sigbits), 6)
+	b.writeBits(xor>>newTrailing, sigbits)
+	return newLeading, newTrailing
+}
+
+func readXOR(r *bitReader, prev float64, leading, trailing uint8) (float64, uint8, uint8, error) {
+	bit, err := r.readBit()
+	if err != nil || !bit {
+		return prev, leading, trailing, err
+	}
+
+	if bit, err = r.readBit(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	if bit {
+		l, err := r.readBits(5)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		sigbits, err := r.readBits(6)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if sigbits == 0 {
+			sigbits = 64
+		}
+		leading, trailing = uint8(l), uint8(64-l-sigbits)
+	}
+
+	sigbits := 64 - int(leading) - int(trailing)
+	xor, err := r.readBits(sigbits)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	v := math.Float64frombits(math.Float64bits(prev) ^ xor<<trailing)
+	return v, leading, trailing, nil
+}
+
+// chunkIterator читает отсчеты чанка по порядку
+type chunkIterator struct {
+	r     bitReader
+	count int
+	read  int
+
+	t        int64
+	v        float64
+	tDelta   int64
+	leading  uint8
+	trailing uint8
+	err      error
+}
+
+func newChunkIterator(c *chunk) *chunkIterator {
+	return &chunkIterator{r: newBitReader(&c.b), count: c.count}
+}
+
+func (it *chunkIterator) Next() bool {
+	if it.err != nil || it.read >= it.count {
+		return false
+	}
+
+	if it.read == 0 {
+		t, err := it.r.readBits(64)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		v, err := it.r.readBits(64)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.t, it.v = int64(t), math.Float64frombits(v)
+		it.read++
+		return true
+	}
+
+	dod, err := readDod(&it.r)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.tDelta += dod
+	it.t += it.tDelta
+
+	it.v, it.leading, it.trailing, it.err = readXOR(&it.r, it.v, it.leading, it.trailing)
+	if it.err != nil {
+		return false
+	}
+
+	it.read++
+	return true
+}
+
+func (it *chunkIterator) At() (int64, float64) {
+	return it.t, it.v
+}
+
+func (it *chunkIterator) Err() error {
+	return it.err
+}
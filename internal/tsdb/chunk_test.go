@@ -0,0 +1,108 @@
+package tsdb
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBstream(t *testing.T) {
+	b := bstream{}
+	b.writeBit(true)
+	b.writeBits(0b101, 3)
+	b.writeBits(math.MaxUint64, 64)
+	b.writeBit(false)
+	b.writeBits(0x1234, 13)
+
+	r := newBitReader(&b)
+	bit, err := r.readBit()
+	require.NoError(t, err)
+	assert.True(t, bit)
+
+	v, err := r.readBits(3)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0b101), v)
+
+	v, err = r.readBits(64)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), v)
+
+	bit, err = r.readBit()
+	require.NoError(t, err)
+	assert.False(t, bit)
+
+	v, err = r.readBits(13)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x1234), v)
+
+	_, err = r.readBit()
+	assert.Error(t, err)
+}
+
+func TestChunk_roundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name  string
+		step  func(i int) int64
+		value func(i int) float64
+	}{
+		{name: "regular", step: func(int) int64 { return 10000 }, value: func(i int) float64 { return 1.5 }},
+		{name: "jitter", step: func(int) int64 { return 10000 + rnd.Int63n(200) - 100 }, value: func(i int) float64 { return float64(i) * 1.1 }},
+		{name: "big gaps", step: func(i int) int64 { return 1 + rnd.Int63n(1<<40) }, value: func(int) float64 { return rnd.NormFloat64() }},
+		{name: "special values", step: func(int) int64 { return 1 }, value: func(i int) float64 {
+			return []float64{0, math.Inf(1), math.Inf(-1), math.NaN(), -0.0, math.MaxFloat64, math.SmallestNonzeroFloat64}[i%7]
+		}},
+		{name: "counter", step: func(int) int64 { return 2000 }, value: func(i int) float64 { return float64(i * i) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChunk()
+			want := []Sample{}
+			ts := int64(-1000)
+			for i := 0; i < chunkSamples; i++ {
+				ts += tt.step(i)
+				v := tt.value(i)
+				c.append(ts, v)
+				want = append(want, Sample{T: ts, V: v})
+			}
+			assert.True(t, c.full())
+
+			it := newChunkIterator(c)
+			for i := 0; it.Next(); i++ {
+				ts, v := it.At()
+				assert.Equal(t, want[i].T, ts, "sample %d", i)
+				assert.Equal(t, math.Float64bits(want[i].V), math.Float64bits(v), "sample %d", i)
+			}
+			require.NoError(t, it.Err())
+		})
+	}
+}
+
+func TestChunk_compression(t *testing.T) {
+	c := newChunk()
+	for i := 0; i < chunkSamples; i++ {
+		c.append(int64(i)*10000, 100)
+	}
+
+	// первый отсчет занимает 128 битов, второй — интервал 10000 в 20 битах и бит значения,
+	// остальные при постоянных интервале и значении — по два бита
+	bits := 128 + 21 + 2*(chunkSamples-2)
+	assert.Equal(t, (bits+7)/8, len(c.b.data))
+}
+
+func TestReadDod(t *testing.T) {
+	for _, dod := range []int64{0, 1, -1, 8192, -8191, 8193, 65536, -65535, 524288, -524287, 524289, math.MinInt64, math.MaxInt64} {
+		b := bstream{}
+		writeDod(&b, dod)
+
+		r := newBitReader(&b)
+		got, err := readDod(&r)
+		require.NoError(t, err)
+		assert.Equal(t, dod, got)
+	}
+}
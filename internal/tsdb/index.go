@@ -0,0 +1,186 @@
+package tsdb
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"ya-prac-project1/internal/storage"
+)
+
+// Префиксы термов индекса
+const (
+	termType  = "type="
+	termName  = "name="
+	termToken = "token="
+)
+
+// Selector выбирает ряды. Заданные поля объединяются по И, пустой Selector выбирает все ряды
+type Selector struct {
+	// MType тип метрики
+	MType string
+	// Name точное имя метрики
+	Name string
+	// Prefix начало имени метрики
+	Prefix string
+	// Tokens слова имени, например "heap" и "alloc" для HeapAlloc. Регистр не учитывается
+	Tokens []string
+}
+
+// index инвертированный индекс: для каждого терма — отсортированный список номеров рядов
+type index struct {
+	postings map[string][]uint32
+	// names отсортированные имена метрик для поиска по префиксу
+	names []string
+}
+
+func newIndex() *index {
+	return &index{postings: make(map[string][]uint32)}
+}
+
+// add добавляет ряд с номером ref. Номера выдаются по возрастанию, поэтому списки
+// остаются отсортированными
+func (ix *index) add(ref uint32, key storage.Key) {
+	for _, term := range terms(key) {
+		ix.postings[term] = append(ix.postings[term], ref)
+	}
+
+	if i, found := slices.BinarySearch(ix.names, key.ID); !found {
+		ix.names = slices.Insert(ix.names, i, key.ID)
+	}
+}
+
+// remove удаляет ряд с номером ref
+func (ix *index) remove(ref uint32, key storage.Key) {
+	for _, term := range terms(key) {
+		list := ix.postings[term]
+		if i, found := slices.BinarySearch(list, ref); found {
+			list = slices.Delete(list, i, i+1)
+		}
+
+		if len(list) == 0 {
+			delete(ix.postings, term)
+		} else {
+			ix.postings[term] = list
+		}
+	}
+
+	if _, ok := ix.postings[termName+key.ID]; !ok {
+		if i, found := slices.BinarySearch(ix.names, key.ID); found {
+			ix.names = slices.Delete(ix.names, i, i+1)
+		}
+	}
+}
+
+// selectRefs возвращает отсортированные номера рядов, подходящих под sel. Если sel не задает
+// ни одного условия, возвращает false
+func (ix *index) selectRefs(sel Selector) ([]uint32, bool) {
+	lists := [][]uint32{}
+	if sel.MType != "" {
+		lists = append(lists, ix.postings[termType+sel.MType])
+	}
+	if sel.Name != "" {
+		lists = append(lists, ix.postings[termName+sel.Name])
+	}
+	for _, token := range sel.Tokens {
+		lists = append(lists, ix.postings[termToken+strings.ToLower(token)])
+	}
+	if sel.Prefix != "" {
+		lists = append(lists, ix.prefixRefs(sel.Prefix))
+	}
+
+	if len(lists) == 0 {
+		return nil, false
+	}
+
+	// пересечение начинается с самого короткого списка
+	slices.SortFunc(lists, func(a, b []uint32) int { return len(a) - len(b) })
+	refs := append([]uint32{}, lists[0]...)
+	for _, list := range lists[1:] {
+		refs = intersect(refs, list)
+	}
+
+	return refs, true
+}
+
+// prefixRefs возвращает отсортированные номера рядов, имена которых начинаются с prefix
+func (ix *index) prefixRefs(prefix string) []uint32 {
+	refs := []uint32{}
+	i, _ := slices.BinarySearch(ix.names, prefix)
+	for ; i < len(ix.names) && strings.HasPrefix(ix.names[i], prefix); i++ {
+		refs = append(refs, ix.postings[termName+ix.names[i]]...)
+	}
+
+	slices.Sort(refs)
+	return refs
+}
+
+// intersect пересекает отсортированные списки, результат пишется поверх a
+func intersect(a, b []uint32) []uint32 {
+	out := a[:0]
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+
+	return out
+}
+
+// terms возвращает термы индекса для ряда
+func terms(key storage.Key) []string {
+	ts := []string{termType + key.MType, termName + key.ID}
+	for _, token := range tokenize(key.ID) {
+		ts = append(ts, termToken+token)
+	}
+
+	return ts
+}
+
+// tokenize разбивает имя метрики на слова по разделителям и границам camelCase:
+// "HeapAlloc" — heap, alloc; "GCCPUFraction" — gccpu, fraction; "http_requests_total" —
+// http, requests, total. Слова приводятся к нижнему регистру и не повторяются
+func tokenize(name string) []string {
+	runes := []rune(name)
+	tokens := []string{}
+	start := -1
+
+	flush := func(end int) {
+		if start >= 0 && end > start {
+			token := strings.ToLower(string(runes[start:end]))
+			if !slices.Contains(tokens, token) {
+				tokens = append(tokens, token)
+			}
+		}
+		start = -1
+	}
+
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush(i)
+			continue
+		}
+
+		if start >= 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// граница: aB или конец аббревиатуры ABc
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && nextLower {
+				flush(i)
+			}
+		}
+
+		if start < 0 {
+			start = i
+		}
+	}
+	flush(len(runes))
+
+	return tokens
+}
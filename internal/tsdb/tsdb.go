@@ -0,0 +1,341 @@
+// Package tsdb предоставляет хранилище истории метрик в памяти.
+//
+// Каждая метрика — ряд отсчетов (метка времени в миллисекундах, значение). Отсчеты ряда
+// хранятся в чанках по chunkSamples штук, сжатых по схеме Gorilla: метки времени —
+// delta-of-delta, значения — XOR с предыдущим. По именам метрик строится
+// инвертированный индекс, ряды выбираются Selector по типу, имени, префиксу и словам имени.
+//
+// Отсчеты ряда дописываются только по возрастанию времени. Значения хранятся как float64,
+// поэтому счетчики больше 2^53 теряют точность
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+)
+
+// ErrOutOfOrder возвращается при добавлении отсчета не новее последнего отсчета ряда
+var ErrOutOfOrder = errors.New("out of order sample")
+
+// Sample отсчет ряда
+type Sample struct {
+	// T метка времени в миллисекундах Unix
+	T int64
+	V float64
+}
+
+// Series ряд с отсчетами из запрошенного диапазона
+type Series struct {
+	Key     storage.Key
+	Samples []Sample
+}
+
+// Iterator перебирает отсчеты по возрастанию времени
+type Iterator interface {
+	Next() bool
+	At() (int64, float64)
+	Err() error
+}
+
+// Stats размер хранилища
+type Stats struct {
+	Series  int
+	Chunks  int
+	Samples int
+	// Bytes память, занятая чанками
+	Bytes int
+}
+
+// BytesPerSample возвращает среднюю память на отсчет
+func (s Stats) BytesPerSample() float64 {
+	if s.Samples == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.Samples)
+}
+
+// series ряд отсчетов одной метрики. Последний чанк открыт для записи, остальные неизменны
+type series struct {
+	ref uint32
+	key storage.Key
+
+	mu     sync.Mutex
+	chunks []*chunk
+	// removed устанавливается, когда ряд удален из хранилища
+	removed bool
+}
+
+// DB хранилище рядов
+type DB struct {
+	// mu защищает набор рядов и индекс, отсчеты ряда защищает series.mu
+	mu      sync.RWMutex
+	series  map[storage.Key]*series
+	refs    map[uint32]*series
+	nextRef uint32
+	index   *index
+}
+
+// New создает пустое хранилище
+func New() *DB {
+	return &DB{
+		series: make(map[storage.Key]*series),
+		refs:   make(map[uint32]*series),
+		index:  newIndex(),
+	}
+}
+
+// Append добавляет отсчет ряда key. t — метка времени в миллисекундах Unix
+func (db *DB) Append(key storage.Key, t int64, v float64) error {
+	s := db.getOrCreate(key)
+	s.mu.Lock()
+	// ряд удалили между поиском и захватом, отсчет пишется в новый
+	for s.removed {
+		s.mu.Unlock()
+		s = db.getOrCreate(key)
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+
+	var head *chunk
+	if len(s.chunks) > 0 {
+		head = s.chunks[len(s.chunks)-1]
+		if t <= head.maxT {
+			return fmt.Errorf("%w: %s at %d, last at %d", ErrOutOfOrder, key, t, head.maxT)
+		}
+	}
+
+	if head == nil || head.full() {
+		if head != nil {
+			head.seal()
+		}
+		head = newChunk()
+		s.chunks = append(s.chunks, head)
+	}
+
+	head.append(t, v)
+	return nil
+}
+
+// AppendMetrics добавляет значения метрик ms с меткой времени t. Ошибки отдельных метрик
+// не мешают добавить остальные и возвращаются вместе
+func (db *DB) AppendMetrics(t time.Time, ms []metrics.Metrics) error {
+	var errs []error
+	for _, m := range ms {
+		var v float64
+		switch {
+		case m.MType == metrics.MetricTypeGauge && m.Value != nil:
+			v = *m.Value
+		case m.MType == metrics.MetricTypeCounter && m.Delta != nil:
+			v = float64(*m.Delta)
+		default:
+			errs = append(errs, fmt.Errorf("metric %s: %w", m.GetKey(), metrics.ErrWrongType))
+			continue
+		}
+
+		if err := db.Append(storage.KeyOf(m), t.UnixMilli(), v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (db *DB) getOrCreate(key storage.Key) *series {
+	db.mu.RLock()
+	s, ok := db.series[key]
+	db.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if s, ok = db.series[key]; ok {
+		return s
+	}
+
+	s = &series{ref: db.nextRef, key: key}
+	db.nextRef++
+	db.series[key] = s
+	db.refs[s.ref] = s
+	db.index.add(s.ref, key)
+
+	return s
+}
+
+// Select возвращает ключи рядов, подходящих под sel, упорядоченные по типу и имени
+func (db *DB) Select(sel Selector) []storage.Key {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	refs, ok := db.index.selectRefs(sel)
+	if !ok {
+		refs = make([]uint32, 0, len(db.refs))
+		for ref := range db.refs {
+			refs = append(refs, ref)
+		}
+	}
+
+	keys := make([]storage.Key, 0, len(refs))
+	for _, ref := range refs {
+		keys = append(keys, db.refs[ref].key)
+	}
+
+	slices.SortFunc(keys, func(a, b storage.Key) int {
+		if c := strings.Compare(a.MType, b.MType); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return keys
+}
+
+// Iterator возвращает итератор по отсчетам ряда key с метками времени в [mint, maxt].
+// Итератор видит отсчеты, добавленные до его создания
+func (db *DB) Iterator(key storage.Key, mint, maxt int64) Iterator {
+	db.mu.RLock()
+	s, ok := db.series[key]
+	db.mu.RUnlock()
+	if !ok {
+		return &seriesIterator{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chunks := make([]*chunk, 0, len(s.chunks))
+	for i, c := range s.chunks {
+		if c.maxT < mint || c.minT > maxt {
+			continue
+		}
+		// открытый чанк меняется при записи, итератор читает его копию
+		if i == len(s.chunks)-1 {
+			c = c.snapshot()
+		}
+		chunks = append(chunks, c)
+	}
+
+	return &seriesIterator{chunks: chunks, mint: mint, maxt: maxt}
+}
+
+// Query возвращает отсчеты с метками времени в [mint, maxt] рядов, подходящих под sel.
+// Ряды без отсчетов в диапазоне не возвращаются
+func (db *DB) Query(sel Selector, mint, maxt int64) ([]Series, error) {
+	result := []Series{}
+	for _, key := range db.Select(sel) {
+		it := db.Iterator(key, mint, maxt)
+		samples := []Sample{}
+		for it.Next() {
+			t, v := it.At()
+			samples = append(samples, Sample{T: t, V: v})
+		}
+		if err := it.Err(); err != nil {
+			return nil, fmt.Errorf("read %s: %w", key, err)
+		}
+
+		if len(samples) > 0 {
+			result = append(result, Series{Key: key, Samples: samples})
+		}
+	}
+
+	return result, nil
+}
+
+// DropBefore удаляет чанки, все отсчеты которых старше mint, и ряды, в которых не осталось
+// отсчетов. Возвращает количество удаленных отсчетов
+func (db *DB) DropBefore(mint int64) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	dropped := 0
+	for key, s := range db.series {
+		s.mu.Lock()
+		i := 0
+		for ; i < len(s.chunks) && s.chunks[i].maxT < mint; i++ {
+			dropped += s.chunks[i].count
+		}
+		s.chunks = slices.Delete(s.chunks, 0, i)
+		empty := len(s.chunks) == 0
+		s.removed = empty
+		s.mu.Unlock()
+
+		if empty {
+			delete(db.series, key)
+			delete(db.refs, s.ref)
+			db.index.remove(s.ref, key)
+		}
+	}
+
+	return dropped
+}
+
+// Stats возвращает размер хранилища
+func (db *DB) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := Stats{Series: len(db.series)}
+	for _, s := range db.series {
+		s.mu.Lock()
+		for _, c := range s.chunks {
+			stats.Chunks++
+			stats.Samples += c.count
+			stats.Bytes += c.size()
+		}
+		s.mu.Unlock()
+	}
+
+	return stats
+}
+
+// seriesIterator перебирает отсчеты чанков ряда в диапазоне [mint, maxt]
+type seriesIterator struct {
+	chunks     []*chunk
+	mint, maxt int64
+
+	cur *chunkIterator
+	err error
+}
+
+func (it *seriesIterator) Next() bool {
+	for it.err == nil {
+		if it.cur == nil {
+			if len(it.chunks) == 0 {
+				return false
+			}
+			it.cur = newChunkIterator(it.chunks[0])
+			it.chunks = it.chunks[1:]
+		}
+
+		for it.cur.Next() {
+			t, _ := it.cur.At()
+			if t > it.maxt {
+				it.chunks = nil
+				return false
+			}
+			if t >= it.mint {
+				return true
+			}
+		}
+
+		it.err = it.cur.Err()
+		it.cur = nil
+	}
+
+	return false
+}
+
+func (it *seriesIterator) At() (int64, float64) {
+	return it.cur.At()
+}
+
+func (it *seriesIterator) Err() error {
+	return it.err
+}
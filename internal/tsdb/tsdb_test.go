@@ -0,0 +1,217 @@
+package tsdb
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+	"ya-prac-project1/internal/metrics"
+	"ya-prac-project1/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alloc     = storage.Key{MType: metrics.MetricTypeGauge, ID: "Alloc"}
+	heapAlloc = storage.Key{MType: metrics.MetricTypeGauge, ID: "HeapAlloc"}
+	pollCount = storage.Key{MType: metrics.MetricTypeCounter, ID: "PollCount"}
+)
+
+func TestAppendQuery(t *testing.T) {
+	db := New()
+	for i := 0; i < 3*chunkSamples; i++ {
+		require.NoError(t, db.Append(alloc, int64(i)*1000, float64(i)))
+	}
+
+	assert.ErrorIs(t, db.Append(alloc, 1000, 1), ErrOutOfOrder)
+	assert.ErrorIs(t, db.Append(alloc, int64(3*chunkSamples-1)*1000, 1), ErrOutOfOrder)
+
+	series, err := db.Query(Selector{Name: "Alloc"}, 100*1000, 250*1000)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, alloc, series[0].Key)
+	require.Len(t, series[0].Samples, 151)
+	assert.Equal(t, Sample{T: 100 * 1000, V: 100}, series[0].Samples[0])
+	assert.Equal(t, Sample{T: 250 * 1000, V: 250}, series[0].Samples[150])
+
+	series, err = db.Query(Selector{}, 1000*1000, 2000*1000)
+	require.NoError(t, err)
+	assert.Empty(t, series)
+
+	it := db.Iterator(pollCount, 0, 1000)
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Err())
+
+	stats := db.Stats()
+	assert.Equal(t, Stats{Series: 1, Chunks: 3, Samples: 3 * chunkSamples, Bytes: stats.Bytes}, stats)
+}
+
+func TestAppendMetrics(t *testing.T) {
+	db := New()
+	ts := time.UnixMilli(5000)
+
+	err := db.AppendMetrics(ts, []metrics.Metrics{
+		metrics.NewMetric("PollCount", metrics.MetricTypeCounter, "7"),
+		metrics.NewMetric("Alloc", metrics.MetricTypeGauge, "1.5"),
+		{ID: "Broken", MType: metrics.MetricTypeGauge},
+	})
+	assert.ErrorIs(t, err, metrics.ErrWrongType)
+
+	series, err := db.Query(Selector{}, 0, 10000)
+	require.NoError(t, err)
+	assert.Equal(t, []Series{
+		{Key: pollCount, Samples: []Sample{{T: 5000, V: 7}}},
+		{Key: alloc, Samples: []Sample{{T: 5000, V: 1.5}}},
+	}, series)
+}
+
+func TestSelect(t *testing.T) {
+	db := New()
+	keys := []storage.Key{
+		alloc, heapAlloc, pollCount,
+		{MType: metrics.MetricTypeGauge, ID: "HeapInuse"},
+		{MType: metrics.MetricTypeGauge, ID: "GCCPUFraction"},
+		{MType: metrics.MetricTypeCounter, ID: "http_requests_total"},
+	}
+	for _, key := range keys {
+		require.NoError(t, db.Append(key, 1, 1))
+	}
+
+	tests := []struct {
+		name string
+		sel  Selector
+		want []string
+	}{
+		{name: "all", sel: Selector{}, want: []string{"PollCount", "http_requests_total", "Alloc", "GCCPUFraction", "HeapAlloc", "HeapInuse"}},
+		{name: "type", sel: Selector{MType: metrics.MetricTypeCounter}, want: []string{"PollCount", "http_requests_total"}},
+		{name: "name", sel: Selector{Name: "HeapAlloc"}, want: []string{"HeapAlloc"}},
+		{name: "prefix", sel: Selector{Prefix: "Heap"}, want: []string{"HeapAlloc", "HeapInuse"}},
+		{name: "token", sel: Selector{Tokens: []string{"alloc"}}, want: []string{"Alloc", "HeapAlloc"}},
+		{name: "tokens", sel: Selector{Tokens: []string{"Heap", "alloc"}}, want: []string{"HeapAlloc"}},
+		{name: "snake case", sel: Selector{Tokens: []string{"requests"}}, want: []string{"http_requests_total"}},
+		{name: "type and prefix", sel: Selector{MType: metrics.MetricTypeCounter, Prefix: "Heap"}, want: []string{}},
+		{name: "unknown", sel: Selector{Name: "Missing"}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := []string{}
+			for _, key := range db.Select(tt.sel) {
+				names = append(names, key.ID)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"heap", "alloc"}, tokenize("HeapAlloc"))
+	assert.Equal(t, []string{"gccpu", "fraction"}, tokenize("GCCPUFraction"))
+	assert.Equal(t, []string{"http", "requests", "total"}, tokenize("http_requests_total"))
+	assert.Equal(t, []string{"cpu", "utilization1"}, tokenize("CPUUtilization1"))
+	assert.Equal(t, []string{"mallocs"}, tokenize("Mallocs.mallocs"))
+	assert.Empty(t, tokenize("__"))
+}
+
+func TestDropBefore(t *testing.T) {
+	db := New()
+	for i := 0; i < 2*chunkSamples; i++ {
+		require.NoError(t, db.Append(alloc, int64(i), 1))
+	}
+	require.NoError(t, db.Append(heapAlloc, 10, 1))
+
+	assert.Equal(t, chunkSamples+1, db.DropBefore(chunkSamples))
+	assert.Equal(t, []storage.Key{alloc}, db.Select(Selector{}))
+	assert.Empty(t, db.Select(Selector{Tokens: []string{"heap"}}))
+	assert.Equal(t, chunkSamples, db.Stats().Samples)
+
+	// удаленный ряд создается заново
+	require.NoError(t, db.Append(heapAlloc, 5, 1))
+	assert.Equal(t, []storage.Key{alloc, heapAlloc}, db.Select(Selector{Prefix: ""}))
+}
+
+func TestConcurrent(t *testing.T) {
+	db := New()
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			key := storage.Key{MType: metrics.MetricTypeGauge, ID: fmt.Sprintf("Worker%d", w)}
+			for i := 0; i < 1000; i++ {
+				assert.NoError(t, db.Append(key, int64(i), float64(i)))
+				if i%100 == 0 {
+					_, err := db.Query(Selector{Tokens: []string{"worker0"}}, 0, int64(i))
+					assert.NoError(t, err)
+					db.DropBefore(int64(i) - 500)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 4, db.Stats().Series)
+}
+
+func TestMemoryPerSample(t *testing.T) {
+	db := New()
+	appendRuntimeLike(db, 30, 10*chunkSamples)
+
+	// отсчет в срезе metrics.Metrics занимает десятки байтов, в чанках — единицы
+	stats := db.Stats()
+	assert.Less(t, stats.BytesPerSample(), 4.0)
+}
+
+// appendRuntimeLike добавляет n отсчетов series рядов, похожих на метрики runtime агента:
+// интервал 2 секунды с дрожанием, медленно растущие gauge и счетчики
+func appendRuntimeLike(db *DB, series, n int) {
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([]storage.Key, series)
+	values := make([]float64, series)
+	for i := range keys {
+		keys[i] = storage.Key{MType: metrics.MetricTypeGauge, ID: fmt.Sprintf("Runtime%d", i)}
+		if i%3 == 0 {
+			keys[i].MType = metrics.MetricTypeCounter
+		}
+		values[i] = float64(rnd.Intn(1 << 20))
+	}
+
+	ts := time.Now().UnixMilli()
+	for j := 0; j < n; j++ {
+		ts += 2000 + rnd.Int63n(5)
+		for i, key := range keys {
+			if rnd.Intn(4) == 0 {
+				values[i] += float64(rnd.Intn(1024))
+			}
+			db.Append(key, ts, values[i])
+		}
+	}
+}
+
+func BenchmarkAppend(b *testing.B) {
+	db := New()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	appendRuntimeLike(db, 100, b.N/100+1)
+
+	b.StopTimer()
+	stats := db.Stats()
+	b.ReportMetric(stats.BytesPerSample(), "bytes/sample")
+	b.ReportMetric(float64(stats.Samples)/b.Elapsed().Seconds(), "samples/s")
+}
+
+func BenchmarkQuery(b *testing.B) {
+	db := New()
+	appendRuntimeLike(db, 100, 10*chunkSamples)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Query(Selector{MType: metrics.MetricTypeGauge}, 0, time.Now().Add(time.Hour).UnixMilli()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}